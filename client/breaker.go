@@ -0,0 +1,200 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/logger"
+)
+
+type BreakerState int32
+
+const (
+	StateClosed BreakerState = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerConfig configures the per-host circuit breakers. The error rate is
+// computed over a rolling window split into Buckets buckets.
+type BreakerConfig struct {
+	Window             time.Duration
+	Buckets            int
+	MinRequests        int
+	ErrorRateThreshold float64
+	OpenTimeout        time.Duration
+	HalfOpenRequests   int
+}
+
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		Window:             10 * time.Second,
+		Buckets:            10,
+		MinRequests:        20,
+		ErrorRateThreshold: 0.5,
+		OpenTimeout:        30 * time.Second,
+		HalfOpenRequests:   1,
+	}
+}
+
+type breakerBucket struct {
+	start     time.Time
+	successes int
+	failures  int
+}
+
+type circuitBreaker struct {
+	mu               sync.Mutex
+	name             string
+	config           BreakerConfig
+	state            BreakerState
+	generation       uint64
+	openedAt         time.Time
+	halfOpenInFlight int
+	buckets          []breakerBucket
+}
+
+// breakerTicket records the state that admitted a request, so a result
+// arriving after a transition does not count for the new state.
+type breakerTicket struct {
+	generation uint64
+	probe      bool
+}
+
+func newCircuitBreaker(name string, config BreakerConfig) *circuitBreaker {
+	if config.Buckets <= 0 {
+		config.Buckets = 1
+	}
+	if config.HalfOpenRequests <= 0 {
+		config.HalfOpenRequests = 1
+	}
+	return &circuitBreaker{
+		name:    name,
+		config:  config,
+		state:   StateClosed,
+		buckets: make([]breakerBucket, config.Buckets),
+	}
+}
+
+func (cb *circuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.refreshLocked(time.Now())
+	return cb.state
+}
+
+// allow reports whether a request may go through. When it does, the ticket
+// must be passed to record with the result.
+func (cb *circuitBreaker) allow() (breakerTicket, bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.refreshLocked(time.Now())
+	var ticket breakerTicket = breakerTicket{generation: cb.generation}
+	switch cb.state {
+	case StateOpen:
+		return ticket, false
+	case StateHalfOpen:
+		if cb.halfOpenInFlight >= cb.config.HalfOpenRequests {
+			return ticket, false
+		}
+		cb.halfOpenInFlight++
+		ticket.probe = true
+	}
+	return ticket, true
+}
+
+// record counts the result of a request. Only the probes of the current
+// half-open state decide its transition; results of requests admitted
+// before the last transition are ignored.
+func (cb *circuitBreaker) record(ticket breakerTicket, success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if ticket.generation != cb.generation {
+		return
+	}
+	var now time.Time = time.Now()
+	if ticket.probe {
+		cb.halfOpenInFlight--
+		if success {
+			cb.transitionLocked(StateClosed, now)
+		} else {
+			cb.transitionLocked(StateOpen, now)
+		}
+		return
+	}
+
+	var bucket *breakerBucket = cb.currentBucketLocked(now)
+	if success {
+		bucket.successes++
+	} else {
+		bucket.failures++
+	}
+
+	if cb.state != StateClosed {
+		return
+	}
+	var successes, failures int
+	for _, b := range cb.buckets {
+		if now.Sub(b.start) < cb.config.Window {
+			successes += b.successes
+			failures += b.failures
+		}
+	}
+	var total int = successes + failures
+	if total >= cb.config.MinRequests && total > 0 && float64(failures)/float64(total) >= cb.config.ErrorRateThreshold {
+		cb.transitionLocked(StateOpen, now)
+	}
+}
+
+func (cb *circuitBreaker) refreshLocked(now time.Time) {
+	if cb.state == StateOpen && now.Sub(cb.openedAt) >= cb.config.OpenTimeout {
+		cb.transitionLocked(StateHalfOpen, now)
+	}
+}
+
+func (cb *circuitBreaker) transitionLocked(state BreakerState, now time.Time) {
+	if cb.state == state {
+		return
+	}
+	logger.GetConsoleLogger().Warn("Circuit breaker %s: %s -> %s", cb.name, cb.state, state)
+	cb.state = state
+	cb.generation++
+	cb.halfOpenInFlight = 0
+	switch state {
+	case StateOpen:
+		cb.openedAt = now
+	case StateClosed:
+		for i := range cb.buckets {
+			cb.buckets[i] = breakerBucket{}
+		}
+	}
+}
+
+func (cb *circuitBreaker) currentBucketLocked(now time.Time) *breakerBucket {
+	var bucketWidth time.Duration = cb.config.Window / time.Duration(len(cb.buckets))
+	if bucketWidth <= 0 {
+		bucketWidth = time.Millisecond
+	}
+	var slot int64 = now.UnixNano() / int64(bucketWidth)
+	var bucket *breakerBucket = &cb.buckets[slot%int64(len(cb.buckets))]
+	var start time.Time = time.Unix(0, slot*int64(bucketWidth))
+	if !bucket.start.Equal(start) {
+		*bucket = breakerBucket{start: start}
+	}
+	return bucket
+}
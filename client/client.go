@@ -0,0 +1,302 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCircuitOpen  = errors.New("circuit breaker is open")
+	ErrBulkheadFull = errors.New("bulkhead is full")
+)
+
+type RetryConfig struct {
+	MaxRetries      int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	RetryableStatus []int
+	// RetryNonIdempotent also retries POST and PATCH requests. They are
+	// always retried when the request carries an Idempotency-Key header.
+	RetryNonIdempotent bool
+}
+
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		MaxRetries: 3,
+		BaseDelay:  100 * time.Millisecond,
+		MaxDelay:   10 * time.Second,
+		RetryableStatus: []int{
+			http.StatusTooManyRequests,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+type transportOption func(*Transport)
+
+// Transport is an http.RoundTripper adding per-host circuit breakers,
+// retries with exponential backoff and jitter, per-host bulkheads and request
+// ID / trace header propagation on top of a base RoundTripper.
+type Transport struct {
+	base          http.RoundTripper
+	retry         RetryConfig
+	breaker       *BreakerConfig
+	maxConcurrent int
+	maxWait       time.Duration
+
+	mu        sync.Mutex
+	breakers  map[string]*circuitBreaker
+	bulkheads map[string]chan struct{}
+}
+
+func TransportWithBase(base http.RoundTripper) transportOption {
+	return func(t *Transport) {
+		t.base = base
+	}
+}
+
+func TransportWithRetry(config RetryConfig) transportOption {
+	return func(t *Transport) {
+		t.retry = config
+	}
+}
+
+func TransportWithCircuitBreaker(config BreakerConfig) transportOption {
+	return func(t *Transport) {
+		t.breaker = &config
+	}
+}
+
+// TransportWithBulkhead limits concurrent requests per host. Requests wait up
+// to maxWait for a free slot and then fail with ErrBulkheadFull.
+func TransportWithBulkhead(maxConcurrent int, maxWait time.Duration) transportOption {
+	return func(t *Transport) {
+		t.maxConcurrent = maxConcurrent
+		t.maxWait = maxWait
+	}
+}
+
+func NewTransport(options ...transportOption) *Transport {
+	var transport *Transport = &Transport{
+		base:      http.DefaultTransport,
+		retry:     DefaultRetryConfig(),
+		breakers:  make(map[string]*circuitBreaker),
+		bulkheads: make(map[string]chan struct{}),
+	}
+	for _, option := range options {
+		option(transport)
+	}
+	return transport
+}
+
+// NewClient returns an http.Client using a Transport built with options.
+func NewClient(timeout time.Duration, options ...transportOption) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: NewTransport(options...)}
+}
+
+// BreakerState returns the circuit breaker state for a host ("host:port" as
+// in the request URL).
+func (t *Transport) BreakerState(host string) BreakerState {
+	t.mu.Lock()
+	cb, exists := t.breakers[host]
+	t.mu.Unlock()
+	if !exists {
+		return StateClosed
+	}
+	return cb.State()
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var host string = req.URL.Host
+	var ctx context.Context = req.Context()
+
+	if headers := propagatedHeaders(ctx); headers != nil {
+		req = req.Clone(ctx)
+		for name, values := range headers {
+			if req.Header.Get(name) == "" && len(values) > 0 {
+				req.Header.Set(name, values[0])
+			}
+		}
+	}
+
+	var attempts int = 1
+	if t.canRetry(req) {
+		attempts += max(t.retry.MaxRetries, 0)
+	}
+
+	var resp *http.Response
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if req, err = rewindRequest(req); err != nil {
+				return nil, err
+			}
+		}
+
+		// The bulkhead slot is held for one attempt only, not during the
+		// backoff between attempts.
+		release, acquireErr := t.acquire(ctx, host)
+		if acquireErr != nil {
+			return nil, acquireErr
+		}
+		var cb *circuitBreaker = t.circuitBreaker(host)
+		var ticket breakerTicket
+		if cb != nil {
+			var allowed bool
+			if ticket, allowed = cb.allow(); !allowed {
+				release()
+				return nil, fmt.Errorf("%w for host %s", ErrCircuitOpen, host)
+			}
+		}
+
+		resp, err = t.base.RoundTrip(req)
+		release()
+		var retryable bool = err != nil || t.isRetryableStatus(resp.StatusCode)
+		if cb != nil {
+			cb.record(ticket, err == nil && resp.StatusCode < http.StatusInternalServerError)
+		}
+
+		if !retryable || attempt == attempts-1 || ctx.Err() != nil {
+			return resp, err
+		}
+
+		var delay time.Duration = t.backoff(attempt)
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				delay = retryAfter
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		if t.retry.MaxDelay > 0 && delay > t.retry.MaxDelay {
+			delay = t.retry.MaxDelay
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+	return resp, err
+}
+
+func (t *Transport) circuitBreaker(host string) *circuitBreaker {
+	if t.breaker == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cb, exists := t.breakers[host]
+	if !exists {
+		cb = newCircuitBreaker(host, *t.breaker)
+		t.breakers[host] = cb
+	}
+	return cb
+}
+
+func (t *Transport) acquire(ctx context.Context, host string) (func(), error) {
+	if t.maxConcurrent <= 0 {
+		return func() {}, nil
+	}
+	t.mu.Lock()
+	slots, exists := t.bulkheads[host]
+	if !exists {
+		slots = make(chan struct{}, t.maxConcurrent)
+		t.bulkheads[host] = slots
+	}
+	t.mu.Unlock()
+
+	var release = func() { <-slots }
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if t.maxWait <= 0 {
+		return nil, fmt.Errorf("%w for host %s", ErrBulkheadFull, host)
+	}
+
+	var timer *time.Timer = time.NewTimer(t.maxWait)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("%w for host %s", ErrBulkheadFull, host)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (t *Transport) canRetry(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return t.retry.RetryNonIdempotent || req.Header.Get("Idempotency-Key") != ""
+}
+
+func (t *Transport) isRetryableStatus(status int) bool {
+	for _, retryable := range t.retry.RetryableStatus {
+		if status == retryable {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns an exponential delay with full jitter.
+func (t *Transport) backoff(attempt int) time.Duration {
+	var delay time.Duration = t.retry.BaseDelay << attempt
+	if delay <= 0 || (t.retry.MaxDelay > 0 && delay > t.retry.MaxDelay) {
+		delay = t.retry.MaxDelay
+	}
+	return time.Duration(rand.Int64N(int64(delay) + 1))
+}
+
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewind request body: %w", err)
+	}
+	var clone *http.Request = req.Clone(req.Context())
+	clone.Body = body
+	return clone, nil
+}
+
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	var timer *time.Timer = time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+const (
+	RequestIDHeader   = "X-Request-ID"
+	TraceParentHeader = "traceparent"
+	TraceStateHeader  = "tracestate"
+)
+
+// PropagatedHeaders are copied from the incoming request to every outbound
+// request made with a context returned by ContextFromRequest.
+var PropagatedHeaders = []string{RequestIDHeader, TraceParentHeader, TraceStateHeader}
+
+type propagationKey struct{}
+
+// ContextFromRequest returns the request context enriched with the request
+// ID and trace headers of the incoming request, ready to be used for outbound
+// calls.
+func ContextFromRequest(r *http.Request) context.Context {
+	var headers http.Header = http.Header{}
+	for _, name := range PropagatedHeaders {
+		if value := r.Header.Get(name); value != "" {
+			headers.Set(name, value)
+		}
+	}
+	return context.WithValue(r.Context(), propagationKey{}, headers)
+}
+
+// WithRequestID stores a request ID to be sent with outbound requests.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	var headers http.Header = propagatedHeaders(ctx).Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	headers.Set(RequestIDHeader, requestID)
+	return context.WithValue(ctx, propagationKey{}, headers)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	return propagatedHeaders(ctx).Get(RequestIDHeader)
+}
+
+func propagatedHeaders(ctx context.Context) http.Header {
+	headers, _ := ctx.Value(propagationKey{}).(http.Header)
+	return headers
+}
@@ -0,0 +1,277 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/router/helpers"
+	"gopkg.in/yaml.v3"
+)
+
+type Reason string
+
+const (
+	ReasonNotFound Reason = "not_found"
+	ReasonDisabled Reason = "disabled"
+	ReasonRule     Reason = "rule"
+	ReasonDefault  Reason = "default"
+)
+
+// Evaluation describes why a variant was served. Rule is the index of the
+// matching rule, or -1.
+type Evaluation struct {
+	Flag    string
+	Variant string
+	Reason  Reason
+	Rule    int
+}
+
+func (e Evaluation) Enabled() bool {
+	return e.Variant != "" && e.Variant != VariantOff
+}
+
+type definitionFile struct {
+	Flags []Flag `json:"flags" yaml:"flags"`
+}
+
+type Engine struct {
+	mu             sync.RWMutex
+	flags          map[string]*Flag
+	file           string
+	reloadInterval time.Duration
+	watcher        *helpers.FileWatcher
+	trustedProxies []netip.Prefix
+	userContextKey any
+	tenantClaim    string
+	err            error
+}
+
+var defaultEngine atomic.Pointer[Engine]
+
+func NewEngine(options ...func(*Engine)) (*Engine, error) {
+	var engine *Engine = &Engine{
+		flags:          make(map[string]*Flag),
+		reloadInterval: helpers.DefaultFileWatchInterval,
+		trustedProxies: []netip.Prefix{},
+	}
+
+	for _, option := range options {
+		option(engine)
+	}
+	if engine.err != nil {
+		return nil, engine.err
+	}
+
+	if engine.file != "" {
+		if err := engine.Reload(); err != nil {
+			return nil, err
+		}
+		watcher, err := helpers.WatchFile(engine.file, engine.reloadInterval, engine.Reload)
+		if err != nil {
+			return nil, err
+		}
+		engine.watcher = watcher
+	}
+	return engine, nil
+}
+
+// SetDefault makes the engine the one used by the package level functions
+// when the context was not prepared by the engine middleware.
+func SetDefault(engine *Engine) {
+	defaultEngine.Store(engine)
+}
+
+func Default() *Engine {
+	return defaultEngine.Load()
+}
+
+// WithFile loads the flags from a JSON or YAML file (by extension) and
+// reloads it when it changes.
+func WithFile(path string, reloadInterval time.Duration) func(*Engine) {
+	return func(e *Engine) {
+		e.file = path
+		if reloadInterval > 0 {
+			e.reloadInterval = reloadInterval
+		}
+	}
+}
+
+// WithFlags defines flags in code, e.g. for tests. Flags loaded from a file
+// replace them.
+func WithFlags(flags []Flag) func(*Engine) {
+	return func(e *Engine) {
+		definitions, err := prepareFlags(flags)
+		if err != nil {
+			e.err = err
+			return
+		}
+		e.flags = definitions
+	}
+}
+
+func WithTrustedProxies(cidrs []string) func(*Engine) {
+	return func(e *Engine) {
+		prefixes, err := helpers.ParsePrefixes(cidrs)
+		if err != nil {
+			e.err = err
+			return
+		}
+		e.trustedProxies = prefixes
+	}
+}
+
+// WithUserContextKey reads the claims from a context key set by custom code
+// instead of the user stored by the auth middleware.
+func WithUserContextKey(key any) func(*Engine) {
+	return func(e *Engine) {
+		e.userContextKey = key
+	}
+}
+
+// WithTenantClaim reads the tenant from another claim. By default it is
+// the tenant of the auth.Principal: GetTenant, or the "tenant" claim.
+func WithTenantClaim(claim string) func(*Engine) {
+	return func(e *Engine) {
+		e.tenantClaim = claim
+	}
+}
+
+// Reload parses the flag file again. On error the previous flags are kept.
+func (e *Engine) Reload() error {
+	if e.file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(e.file)
+	if err != nil {
+		return fmt.Errorf("failed to read flag file: %w", err)
+	}
+
+	var definitions definitionFile
+	switch strings.ToLower(filepath.Ext(e.file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &definitions)
+	default:
+		err = json.Unmarshal(data, &definitions)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse flag file: %w", err)
+	}
+
+	flags, err := prepareFlags(definitions.Flags)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.flags = flags
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *Engine) Close() {
+	e.watcher.Stop()
+}
+
+func prepareFlags(flags []Flag) (map[string]*Flag, error) {
+	var definitions map[string]*Flag = make(map[string]*Flag, len(flags))
+	for i := range flags {
+		var flag Flag = flags[i]
+		flag.Rules = append([]Rule(nil), flag.Rules...)
+		if err := flag.prepare(); err != nil {
+			return nil, err
+		}
+		if _, exists := definitions[flag.Name]; exists {
+			return nil, fmt.Errorf("duplicated flag '%s'", flag.Name)
+		}
+		definitions[flag.Name] = &flag
+	}
+	return definitions, nil
+}
+
+// Flags returns the current definitions.
+func (e *Engine) Flags() []Flag {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	var flags []Flag = make([]Flag, 0, len(e.flags))
+	for _, flag := range e.flags {
+		flags = append(flags, *flag)
+	}
+	return flags
+}
+
+// ContextFromRequest returns the request context carrying the client IP and
+// headers used by the rules. Claims are read from the context at evaluation
+// time, so it may run before the auth middleware.
+func (e *Engine) ContextFromRequest(r *http.Request) context.Context {
+	return context.WithValue(r.Context(), requestKey{}, requestAttributes{
+		engine:  e,
+		ip:      helpers.ClientIP(r, e.trustedProxies),
+		headers: r.Header,
+	})
+}
+
+func (e *Engine) Evaluate(ctx context.Context, name string) Evaluation {
+	e.mu.RLock()
+	flag, exists := e.flags[name]
+	e.mu.RUnlock()
+
+	if !exists {
+		return Evaluation{Flag: name, Variant: VariantOff, Reason: ReasonNotFound, Rule: -1}
+	}
+	if !flag.Enabled {
+		return Evaluation{Flag: name, Variant: flag.DefaultVariant, Reason: ReasonDisabled, Rule: -1}
+	}
+
+	var target *evalTarget = e.resolveTarget(ctx)
+	for i := range flag.Rules {
+		var rule *Rule = &flag.Rules[i]
+		if !rule.matches(target) {
+			continue
+		}
+		if variant, ok := rule.variant(name, target); ok {
+			return Evaluation{Flag: name, Variant: variant, Reason: ReasonRule, Rule: i}
+		}
+	}
+	return Evaluation{Flag: name, Variant: flag.DefaultVariant, Reason: ReasonDefault, Rule: -1}
+}
+
+func (e *Engine) IsEnabled(ctx context.Context, name string) bool {
+	return e.Evaluate(ctx, name).Enabled()
+}
+
+func (e *Engine) Variant(ctx context.Context, name string) string {
+	return e.Evaluate(ctx, name).Variant
+}
+
+func engineFrom(ctx context.Context) *Engine {
+	if attributes, ok := ctx.Value(requestKey{}).(requestAttributes); ok && attributes.engine != nil {
+		return attributes.engine
+	}
+	return Default()
+}
+
+// Evaluate uses the engine that prepared the request context, or the
+// default engine. Unknown flags and a missing engine evaluate to "off".
+func Evaluate(ctx context.Context, name string) Evaluation {
+	var engine *Engine = engineFrom(ctx)
+	if engine == nil {
+		return Evaluation{Flag: name, Variant: VariantOff, Reason: ReasonNotFound, Rule: -1}
+	}
+	return engine.Evaluate(ctx, name)
+}
+
+func IsEnabled(ctx context.Context, name string) bool {
+	return Evaluate(ctx, name).Enabled()
+}
+
+func Variant(ctx context.Context, name string) string {
+	return Evaluate(ctx, name).Variant
+}
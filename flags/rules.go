@@ -0,0 +1,196 @@
+package flags
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+
+	"github.com/angelbarreiros/Penguin/router/helpers"
+)
+
+const (
+	VariantOn  = "on"
+	VariantOff = "off"
+
+	rolloutBuckets = 10000
+)
+
+// Flag is a feature flag definition. When Enabled is false the flag is
+// switched off for everybody and serves DefaultVariant. Otherwise the rules
+// are evaluated in order and the first matching rule decides the variant;
+// when no rule matches DefaultVariant is served.
+type Flag struct {
+	Name           string   `json:"name" yaml:"name"`
+	Description    string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Enabled        bool     `json:"enabled" yaml:"enabled"`
+	Variants       []string `json:"variants,omitempty" yaml:"variants,omitempty"`
+	DefaultVariant string   `json:"defaultVariant,omitempty" yaml:"defaultVariant,omitempty"`
+	Rules          []Rule   `json:"rules,omitempty" yaml:"rules,omitempty"`
+}
+
+// Rule targets a population. Every non empty criterion must match, and a
+// criterion matches when any of its values does. Percentage and Split roll
+// the rule out to a deterministic share of subjects.
+type Rule struct {
+	Subjects   []string            `json:"subjects,omitempty" yaml:"subjects,omitempty"`
+	Tenants    []string            `json:"tenants,omitempty" yaml:"tenants,omitempty"`
+	Roles      []string            `json:"roles,omitempty" yaml:"roles,omitempty"`
+	IPRanges   []string            `json:"ipRanges,omitempty" yaml:"ipRanges,omitempty"`
+	Headers    map[string][]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Claims     map[string][]string `json:"claims,omitempty" yaml:"claims,omitempty"`
+	Percentage *float64            `json:"percentage,omitempty" yaml:"percentage,omitempty"`
+	Variant    string              `json:"variant,omitempty" yaml:"variant,omitempty"`
+	Split      []WeightedVariant   `json:"split,omitempty" yaml:"split,omitempty"`
+
+	prefixes []netip.Prefix
+}
+
+// WeightedVariant is a share of a split rollout, e.g. 50/50 between two
+// variants of an experiment.
+type WeightedVariant struct {
+	Variant string  `json:"variant" yaml:"variant"`
+	Weight  float64 `json:"weight" yaml:"weight"`
+}
+
+func (f *Flag) prepare() error {
+	if f.Name == "" {
+		return fmt.Errorf("flag name is required")
+	}
+	if len(f.Variants) == 0 {
+		f.Variants = []string{VariantOn, VariantOff}
+	}
+	if f.DefaultVariant == "" {
+		f.DefaultVariant = VariantOff
+	}
+	if !slices.Contains(f.Variants, f.DefaultVariant) {
+		return fmt.Errorf("flag '%s': unknown default variant '%s'", f.Name, f.DefaultVariant)
+	}
+
+	for i := range f.Rules {
+		var rule *Rule = &f.Rules[i]
+		if rule.Variant == "" && len(rule.Split) == 0 {
+			rule.Variant = VariantOn
+		}
+		if rule.Variant != "" && !slices.Contains(f.Variants, rule.Variant) {
+			return fmt.Errorf("flag '%s' rule %d: unknown variant '%s'", f.Name, i, rule.Variant)
+		}
+		for _, share := range rule.Split {
+			if !slices.Contains(f.Variants, share.Variant) {
+				return fmt.Errorf("flag '%s' rule %d: unknown variant '%s'", f.Name, i, share.Variant)
+			}
+			if share.Weight < 0 {
+				return fmt.Errorf("flag '%s' rule %d: negative weight", f.Name, i)
+			}
+		}
+		if rule.Percentage != nil && (*rule.Percentage < 0 || *rule.Percentage > 100) {
+			return fmt.Errorf("flag '%s' rule %d: percentage must be between 0 and 100", f.Name, i)
+		}
+		prefixes, err := helpers.ParsePrefixes(rule.IPRanges)
+		if err != nil {
+			return fmt.Errorf("flag '%s' rule %d: %w", f.Name, i, err)
+		}
+		rule.prefixes = prefixes
+		var headers map[string][]string = make(map[string][]string, len(rule.Headers))
+		for name, values := range rule.Headers {
+			headers[http.CanonicalHeaderKey(name)] = values
+		}
+		rule.Headers = headers
+	}
+	return nil
+}
+
+func (r *Rule) matches(t *evalTarget) bool {
+	if len(r.Subjects) > 0 && !slices.Contains(r.Subjects, t.Subject) {
+		return false
+	}
+	if len(r.Tenants) > 0 && !slices.Contains(r.Tenants, t.Tenant) {
+		return false
+	}
+	if len(r.Roles) > 0 && !slices.ContainsFunc(r.Roles, func(role string) bool { return slices.Contains(t.Roles, role) }) {
+		return false
+	}
+	if len(r.prefixes) > 0 {
+		if !t.IP.IsValid() || !slices.ContainsFunc(r.prefixes, func(p netip.Prefix) bool { return p.Contains(t.IP.Unmap()) }) {
+			return false
+		}
+	}
+	for name, values := range r.Headers {
+		if !slices.ContainsFunc(t.Headers.Values(name), func(v string) bool { return slices.Contains(values, v) }) {
+			return false
+		}
+	}
+	if len(r.Claims) > 0 {
+		for name, values := range r.Claims {
+			if !claimMatches(t.Claims[name], values) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// variant returns the variant served by a matching rule, or false when the
+// subject falls outside the rollout.
+func (r *Rule) variant(flag string, t *evalTarget) (string, bool) {
+	if r.Percentage == nil && len(r.Split) == 0 {
+		return r.Variant, true
+	}
+	var key string = t.rolloutKey()
+	if key == "" {
+		return "", false
+	}
+	var bucket uint64 = rolloutBucket(flag, key)
+	if r.Percentage != nil && float64(bucket) >= *r.Percentage*rolloutBuckets/100 {
+		return "", false
+	}
+	if len(r.Split) == 0 {
+		return r.Variant, true
+	}
+
+	var total float64
+	for _, share := range r.Split {
+		total += share.Weight
+	}
+	if total == 0 {
+		return "", false
+	}
+	// A second hash keeps the split independent from the percentage gate.
+	var point float64 = float64(rolloutBucket(flag+"#split", key)) / rolloutBuckets * total
+	var cumulative float64
+	for _, share := range r.Split {
+		cumulative += share.Weight
+		if point < cumulative {
+			return share.Variant, true
+		}
+	}
+	return r.Split[len(r.Split)-1].Variant, true
+}
+
+// rolloutBucket hashes the flag name and the subject into [0, 10000). The
+// flag name salts the hash so a subject is not always in the first
+// percentage of every flag.
+func rolloutBucket(flag string, key string) uint64 {
+	var sum [32]byte = sha256.Sum256([]byte(flag + ":" + key))
+	return binary.BigEndian.Uint64(sum[:8]) % rolloutBuckets
+}
+
+func claimMatches(claim any, values []string) bool {
+	switch v := claim.(type) {
+	case nil:
+		return false
+	case string:
+		return slices.Contains(values, v)
+	case []any:
+		for _, item := range v {
+			if claimMatches(item, values) {
+				return true
+			}
+		}
+		return false
+	default:
+		return slices.Contains(values, fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,101 @@
+package flags
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+
+	"github.com/angelbarreiros/Penguin/router/auth"
+)
+
+// Target holds the attributes flags are evaluated against. Inside handlers
+// it is built from the request and the authenticated claims; in scheduler
+// jobs set it explicitly with WithTarget.
+type Target struct {
+	Subject string
+	Tenant  string
+	Roles   []string
+	IP      netip.Addr
+	Headers http.Header
+	Claims  map[string]any
+}
+
+type targetKey struct{}
+type requestKey struct{}
+
+type requestAttributes struct {
+	engine  *Engine
+	ip      netip.Addr
+	headers http.Header
+}
+
+// WithTarget stores an explicit target in the context. Its non empty fields
+// take precedence over the attributes derived from the request and claims.
+func WithTarget(ctx context.Context, target Target) context.Context {
+	return context.WithValue(ctx, targetKey{}, target)
+}
+
+func TargetFromContext(ctx context.Context) (Target, bool) {
+	target, ok := ctx.Value(targetKey{}).(Target)
+	return target, ok
+}
+
+// evalTarget is the target of one evaluation: the principal of the user,
+// overridden by the explicit target.
+type evalTarget struct {
+	Target
+}
+
+func (e *Engine) resolveTarget(ctx context.Context) *evalTarget {
+	var t *evalTarget = &evalTarget{}
+	if attributes, ok := ctx.Value(requestKey{}).(requestAttributes); ok {
+		t.IP = attributes.ip
+		t.Headers = attributes.headers
+	}
+	if principal, ok := auth.PrincipalFromContext(ctx, e.userContextKey); ok {
+		t.Subject = principal.Subject
+		t.Roles = principal.Roles
+		t.Claims = principal.Claims
+		t.Tenant = principal.Tenant
+		if e.tenantClaim != "" {
+			t.Tenant, _ = principal.Claims[e.tenantClaim].(string)
+		}
+	}
+
+	if explicit, ok := TargetFromContext(ctx); ok {
+		if explicit.Subject != "" {
+			t.Subject = explicit.Subject
+		}
+		if explicit.Tenant != "" {
+			t.Tenant = explicit.Tenant
+		}
+		if explicit.Roles != nil {
+			t.Roles = explicit.Roles
+		}
+		if explicit.IP.IsValid() {
+			t.IP = explicit.IP
+		}
+		if explicit.Headers != nil {
+			t.Headers = explicit.Headers
+		}
+		if explicit.Claims != nil {
+			t.Claims = explicit.Claims
+		}
+	}
+	if t.Headers == nil {
+		t.Headers = http.Header{}
+	}
+	return t
+}
+
+// rolloutKey is the value hashed for percentage rollouts: the subject, or
+// the tenant for anonymous requests of a known tenant.
+func (t *evalTarget) rolloutKey() string {
+	if t.Subject != "" {
+		return t.Subject
+	}
+	if t.Tenant != "" {
+		return "tenant:" + t.Tenant
+	}
+	return ""
+}
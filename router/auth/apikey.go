@@ -0,0 +1,405 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/logger"
+)
+
+const (
+	DefaultAPIKeyHeader           = "X-API-Key"
+	DefaultAPIKeyLastUsedInterval = time.Minute
+	apiKeyLookupBytes             = 5
+	apiKeySecretBytes             = 20
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	apiKeyEncoding    = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// RateLimit overrides the default limits of WithRateLimiting for one
+// principal.
+type RateLimit struct {
+	Burst     int32   `json:"burst"`
+	PerSecond float64 `json:"perSecond"`
+}
+
+// RateLimitedPrincipal is implemented by authenticated users that carry
+// their own rate limit bucket, e.g. API keys.
+type RateLimitedPrincipal interface {
+	RateLimitKey() string
+	RateLimitOverride() (RateLimit, bool)
+}
+
+// APIKey is the stored form of an API key. The plain key has the shape
+// <namespace>_<lookup>_<secret>; Prefix is <namespace>_<lookup> and is used
+// to find the key, Hash is the SHA-256 of the whole plain key.
+type APIKey struct {
+	Prefix     string     `json:"prefix"`
+	Hash       []byte     `json:"hash,omitempty"`
+	Name       string     `json:"name"`
+	Subject    string     `json:"subject"`
+	Scopes     []string   `json:"scopes"`
+	Roles      []string   `json:"roles"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt,omitzero"`
+	LastUsedAt time.Time  `json:"lastUsedAt,omitzero"`
+	RateLimit  *RateLimit `json:"rateLimit,omitempty"`
+}
+
+func (k *APIKey) GetSubject() (string, error) {
+	return k.Subject, nil
+}
+
+// GetRoles returns the roles of the key. Scopes are checked separately with
+// HasScope or middlewares.RequireScopes, never by RBAC.
+func (k *APIKey) GetRoles() []string {
+	return k.Roles
+}
+
+func (k *APIKey) GetScopes() []string {
+	return k.Scopes
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+func (k *APIKey) RateLimitKey() string {
+	return "apikey:" + k.Prefix
+}
+
+func (k *APIKey) RateLimitOverride() (RateLimit, bool) {
+	if k.RateLimit == nil {
+		return RateLimit{}, false
+	}
+	return *k.RateLimit, true
+}
+
+type apiKeyOptionsFunc func(*APIKey)
+
+func APIKeyWithName(name string) apiKeyOptionsFunc {
+	return func(k *APIKey) {
+		k.Name = name
+	}
+}
+
+func APIKeyWithSubject(subject string) apiKeyOptionsFunc {
+	return func(k *APIKey) {
+		k.Subject = subject
+	}
+}
+
+func APIKeyWithScopes(scopes ...string) apiKeyOptionsFunc {
+	return func(k *APIKey) {
+		k.Scopes = scopes
+	}
+}
+
+func APIKeyWithRoles(roles ...string) apiKeyOptionsFunc {
+	return func(k *APIKey) {
+		k.Roles = roles
+	}
+}
+
+func APIKeyWithExpiry(expiresAt time.Time) apiKeyOptionsFunc {
+	return func(k *APIKey) {
+		k.ExpiresAt = expiresAt
+	}
+}
+
+func APIKeyWithRateLimit(burst int32, perSecond float64) apiKeyOptionsFunc {
+	return func(k *APIKey) {
+		k.RateLimit = &RateLimit{Burst: burst, PerSecond: perSecond}
+	}
+}
+
+// GenerateAPIKey creates a key in namespace, e.g. "pk". The plain key is
+// returned once and must be handed to the client; only the stored key
+// should be saved.
+func GenerateAPIKey(namespace string, options ...apiKeyOptionsFunc) (string, APIKey, error) {
+	if namespace == "" || strings.ContainsAny(namespace, "_ ") {
+		return "", APIKey{}, fmt.Errorf("api key namespace must be a non-empty word without underscores")
+	}
+	lookup, err := randomAPIKeyPart(apiKeyLookupBytes)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	secret, err := randomAPIKeyPart(apiKeySecretBytes)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	var prefix string = namespace + "_" + lookup
+	var plain string = prefix + "_" + secret
+	var key APIKey = APIKey{Prefix: prefix, Hash: hashAPIKey(plain), CreatedAt: time.Now()}
+	for _, option := range options {
+		option(&key)
+	}
+	return plain, key, nil
+}
+
+func randomAPIKeyPart(size int) (string, error) {
+	var b []byte = make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return strings.ToLower(apiKeyEncoding.EncodeToString(b)), nil
+}
+
+func hashAPIKey(plain string) []byte {
+	var sum [32]byte = sha256.Sum256([]byte(plain))
+	return sum[:]
+}
+
+// apiKeyPrefix returns the <namespace>_<lookup> part of a plain key.
+func apiKeyPrefix(plain string) (string, bool) {
+	var parts []string = strings.Split(plain, "_")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+	return parts[0] + "_" + parts[1], true
+}
+
+// APIKeyStore persists API keys by prefix.
+type APIKeyStore interface {
+	Find(prefix string) (APIKey, error)
+	Save(key APIKey) error
+	Delete(prefix string) error
+	TouchLastUsed(prefix string, at time.Time) error
+}
+
+type MemoryAPIKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]APIKey
+}
+
+func NewMemoryAPIKeyStore(keys ...APIKey) *MemoryAPIKeyStore {
+	var store *MemoryAPIKeyStore = &MemoryAPIKeyStore{keys: make(map[string]APIKey)}
+	for _, key := range keys {
+		store.keys[key.Prefix] = key
+	}
+	return store
+}
+
+func (m *MemoryAPIKeyStore) Find(prefix string) (APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	key, exists := m.keys[prefix]
+	if !exists {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	return key, nil
+}
+
+func (m *MemoryAPIKeyStore) Save(key APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[key.Prefix] = key
+	return nil
+}
+
+func (m *MemoryAPIKeyStore) Delete(prefix string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.keys, prefix)
+	return nil
+}
+
+func (m *MemoryAPIKeyStore) TouchLastUsed(prefix string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, exists := m.keys[prefix]
+	if !exists {
+		return ErrAPIKeyNotFound
+	}
+	key.LastUsedAt = at
+	m.keys[prefix] = key
+	return nil
+}
+
+// APIKeyCarrier extracts the plain key of a request, or "" when absent.
+type APIKeyCarrier func(r *http.Request) string
+
+func APIKeyFromHeader(name string) APIKeyCarrier {
+	return func(r *http.Request) string {
+		return strings.TrimSpace(r.Header.Get(name))
+	}
+}
+
+// APIKeyFromQuery reads the key from a query parameter. Query strings end
+// up in access logs, so prefer a header when the client allows it.
+func APIKeyFromQuery(parameter string) APIKeyCarrier {
+	return func(r *http.Request) string {
+		return r.URL.Query().Get(parameter)
+	}
+}
+
+// APIKeyFromBasicAuth reads the key from the password of the Basic
+// Authorization header; the username is ignored.
+func APIKeyFromBasicAuth() APIKeyCarrier {
+	return func(r *http.Request) string {
+		_, password, ok := r.BasicAuth()
+		if !ok {
+			return ""
+		}
+		return password
+	}
+}
+
+type apiKeyAuthOptionsFunc func(*APIKeyAuth)
+
+// APIKeyAuth authenticates machine clients with API keys. Authenticate
+// returns a *APIKey without its hash, which works with RBAC, the flags
+// targeting and RateLimitOptPerPrincipal.
+type APIKeyAuth struct {
+	store            APIKeyStore
+	carriers         []APIKeyCarrier
+	timeout          time.Duration
+	contextKey       any
+	realm            string
+	lastUsedInterval time.Duration
+	hierarchy        RoleHierarchy
+	now              func() time.Time
+}
+
+func NewAPIKeyAuth(store APIKeyStore, options ...apiKeyAuthOptionsFunc) *APIKeyAuth {
+	var apiKeyAuth *APIKeyAuth = &APIKeyAuth{
+		store:            store,
+		carriers:         []APIKeyCarrier{APIKeyFromHeader(DefaultAPIKeyHeader)},
+		timeout:          time.Duration(DefaultContextTimeout) * time.Second,
+		lastUsedInterval: DefaultAPIKeyLastUsedInterval,
+		now:              time.Now,
+	}
+	for _, option := range options {
+		option(apiKeyAuth)
+	}
+	return apiKeyAuth
+}
+
+// APIKeyAuthWithCarriers sets where the key is read from. The first carrier
+// returning a value wins.
+func APIKeyAuthWithCarriers(carriers ...APIKeyCarrier) apiKeyAuthOptionsFunc {
+	return func(a *APIKeyAuth) {
+		a.carriers = carriers
+	}
+}
+
+func APIKeyAuthWithCustomTimeout(timeout time.Duration) apiKeyAuthOptionsFunc {
+	return func(a *APIKeyAuth) {
+		a.timeout = timeout
+	}
+}
+
+func APIKeyAuthWithCustomContextKey(key any) apiKeyAuthOptionsFunc {
+	return func(a *APIKeyAuth) {
+		a.contextKey = key
+	}
+}
+
+func APIKeyAuthWithRealm(realm string) apiKeyAuthOptionsFunc {
+	return func(a *APIKeyAuth) {
+		a.realm = realm
+	}
+}
+
+// APIKeyAuthWithRoleHierarchy makes RBAC accept roles that inherit the
+// allowed ones, e.g. admin on a route for editors.
+func APIKeyAuthWithRoleHierarchy(hierarchy RoleHierarchy) apiKeyAuthOptionsFunc {
+	return func(a *APIKeyAuth) {
+		a.hierarchy = hierarchy
+	}
+}
+
+// APIKeyAuthWithLastUsedInterval limits how often LastUsedAt is written for
+// a key, 0 writes it on every request.
+func APIKeyAuthWithLastUsedInterval(interval time.Duration) apiKeyAuthOptionsFunc {
+	return func(a *APIKeyAuth) {
+		a.lastUsedInterval = interval
+	}
+}
+
+func (a *APIKeyAuth) Authenticate(r *http.Request) (any, error) {
+	var plain string
+	for _, carrier := range a.carriers {
+		if plain = carrier(r); plain != "" {
+			break
+		}
+	}
+	if plain == "" {
+		return nil, newTokenError(ReasonMissingToken, "api key is missing")
+	}
+	prefix, ok := apiKeyPrefix(plain)
+	if !ok {
+		return nil, newTokenError(ReasonMalformedToken, "api key is malformed")
+	}
+	key, err := a.store.Find(prefix)
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return nil, newTokenError(ReasonInvalidToken, "api key is invalid")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(key.Hash, hashAPIKey(plain)) != 1 {
+		return nil, newTokenError(ReasonInvalidToken, "api key is invalid")
+	}
+
+	var now time.Time = a.now()
+	if !key.ExpiresAt.IsZero() && !now.Before(key.ExpiresAt) {
+		return nil, newTokenError(ReasonExpired, "api key has expired")
+	}
+	if now.Sub(key.LastUsedAt) >= a.lastUsedInterval {
+		if err := a.store.TouchLastUsed(prefix, now); err != nil {
+			logger.GetConsoleLogger().Warn("Failed to record last use of api key %s: %v", prefix, err)
+		}
+		key.LastUsedAt = now
+	}
+	key.Hash = nil
+	return &key, nil
+}
+
+// RBAC reports whether the key has one of the allowed roles. Scopes are
+// not roles: check them with RequireScopes.
+func (a *APIKeyAuth) RBAC(user any, allowedRoles []string) bool {
+	key, ok := user.(*APIKey)
+	if !ok {
+		return false
+	}
+	if a.hierarchy != nil {
+		return slices.ContainsFunc(allowedRoles, func(role string) bool {
+			return a.hierarchy.HasRole(key.GetRoles(), role)
+		})
+	}
+	for _, role := range key.GetRoles() {
+		if slices.Contains(allowedRoles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Challenge is the WWW-Authenticate value sent with 401 responses.
+func (a *APIKeyAuth) Challenge(err error) string {
+	if a.realm == "" {
+		return "ApiKey"
+	}
+	return `ApiKey realm="` + quoteChallenge(a.realm) + `"`
+}
+
+func (a *APIKeyAuth) GetTimeout() time.Duration {
+	return a.timeout
+}
+
+func (a *APIKeyAuth) GetContextKey() any {
+	return a.contextKey
+}
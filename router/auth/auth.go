@@ -6,19 +6,21 @@ import (
 	"encoding/pem"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
 const (
-	DefaultContextTimeout int    = 5
-	DefaultContextKey     string = "user"
+	DefaultContextTimeout int = 5
 )
 
+// PlainAuthInterface authenticates a request. Implementations must not keep
+// per-request state: Authenticate extracts and validates the credentials of
+// the given request and returns a fresh user value every time.
 type PlainAuthInterface interface {
-	Authorize(r *http.Request) (bool, error)
-	GetUser(r *http.Request) (any, error)
+	Authenticate(r *http.Request) (any, error)
 	GetTimeout() time.Duration
 	GetContextKey() any
 }
@@ -41,12 +43,76 @@ func (c *RBACClaims) GetRoles() []string {
 	return c.Roles
 }
 
+// NewPlainClaims and NewRBACClaims are claims factories for NewJwtAuth and
+// NewJwtAuthWithRbac.
+func NewPlainClaims() *PlainClaims {
+	return &PlainClaims{}
+}
+
+func NewRBACClaims() *RBACClaims {
+	return &RBACClaims{}
+}
+
+// RBACAuthInterface adds role checks on the user returned by Authenticate.
 type RBACAuthInterface interface {
-	Authorize(r *http.Request) (bool, error)
-	GetUser(r *http.Request) (any, error)
-	RBAC(allowedRoles []string) bool
-	GetTimeout() time.Duration
-	GetContextKey() any
+	PlainAuthInterface
+	RBAC(user any, allowedRoles []string) bool
+}
+
+// RoleHierarchy resolves inherited roles, e.g. rbac.Authorizer: HasRole
+// reports whether any of the roles is the role or inherits it.
+type RoleHierarchy interface {
+	HasRole(roles []string, role string) bool
+}
+
+// ChallengeAuthInterface is implemented by providers that do not use the
+// Bearer scheme. Challenge returns the WWW-Authenticate value for a 401.
+type ChallengeAuthInterface interface {
+	Challenge(err error) string
+}
+
+// ExtractBearerToken returns the bearer token of the Authorization header.
+func ExtractBearerToken(r *http.Request) (string, error) {
+	var jwtTokenString string = r.Header.Get("Authorization")
+	if strings.TrimSpace(jwtTokenString) == "" {
+		return "", newTokenError(ReasonMissingToken, "authorization header is missing")
+	}
+	if !strings.HasPrefix(jwtTokenString, "Bearer ") {
+		return "", newTokenError(ReasonMalformedRequest, "authorization header must start with 'bearer '")
+	}
+
+	var tokenString string = strings.TrimSpace(strings.TrimPrefix(jwtTokenString, "Bearer "))
+	if tokenString == "" {
+		return "", newTokenError(ReasonMalformedRequest, "bearer token is missing or malformed")
+	}
+	return tokenString, nil
+}
+
+// parseClaims parses the token into claims, which must be a fresh instance
+// owned by the caller, and validates it against the policy. The key is
+// resolved from the kid and alg headers; algorithms restricts the accepted
+// alg values when not empty.
+func parseClaims(tokenString string, claims jwt.Claims, keys KeyResolver, algorithms []string, policy ValidationPolicy) (jwt.Claims, error) {
+	jwtToken, err := jwt.ParseWithClaims(tokenString, claims, keyfunc(keys), policy.parserOptions(algorithms)...)
+	if err != nil {
+		return nil, classifyParseError(err)
+	}
+	if jwtToken == nil || !jwtToken.Valid {
+		return nil, newTokenError(ReasonInvalidToken, "token is invalid")
+	}
+	if err := policy.validateExtra(jwtToken); err != nil {
+		return nil, err
+	}
+	return jwtToken.Claims, nil
+}
+
+// verificationKeys builds a key set holding only the public part of a
+// legacy ES512 key.
+func verificationKeys(secret *ecdsa.PrivateKey) KeyResolver {
+	if secret == nil {
+		return &KeySet{keys: map[string]Key{}}
+	}
+	return &KeySet{keys: map[string]Key{"": {Algorithm: "ES512", PublicKey: &secret.PublicKey}}}
 }
 
 func LoadPrivateKeyFromFile(keyPem []byte) (*ecdsa.PrivateKey, error) {
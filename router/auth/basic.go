@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/logger"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const DefaultBasicRealm = "Restricted"
+
+var ErrUnsupportedPasswordHash = errors.New("unsupported password hash")
+
+var (
+	dummyPasswordHash []byte
+	dummyPasswordOnce sync.Once
+)
+
+// verifyDummyPassword is run for unknown users so that their response time
+// matches the one of a wrong password.
+func verifyDummyPassword(password string) {
+	dummyPasswordOnce.Do(func() {
+		dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("penguin-dummy-password"), bcrypt.DefaultCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+}
+
+// PasswordUser is the user returned by the Basic and Digest providers.
+type PasswordUser struct {
+	Username string
+}
+
+func (u *PasswordUser) GetSubject() (string, error) {
+	return u.Username, nil
+}
+
+// CredentialStore returns the password hash of a user.
+type CredentialStore interface {
+	PasswordHash(username string) (string, bool)
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func isArgon2Hash(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$") || strings.HasPrefix(hash, "$argon2i$")
+}
+
+func isSupportedPasswordHash(hash string) bool {
+	return isBcryptHash(hash) || isArgon2Hash(hash)
+}
+
+// VerifyPasswordHash checks a password against a bcrypt ($2a$, $2b$, $2y$)
+// or argon2 PHC ($argon2id$v=19$m=...,t=...,p=...$salt$hash) hash.
+func VerifyPasswordHash(hash string, password string) (bool, error) {
+	switch {
+	case isBcryptHash(hash):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	case isArgon2Hash(hash):
+		return verifyArgon2(hash, password)
+	default:
+		return false, ErrUnsupportedPasswordHash
+	}
+}
+
+func verifyArgon2(hash string, password string) (bool, error) {
+	var parts []string = strings.Split(hash, "$")
+	if len(parts) != 6 || parts[2] != "v=19" {
+		return false, fmt.Errorf("malformed argon2 hash")
+	}
+	var memory, iterations uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false, fmt.Errorf("malformed argon2 parameters: %w", err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, fmt.Errorf("malformed argon2 salt: %w", err)
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, fmt.Errorf("malformed argon2 hash: %w", err)
+	}
+	var actual []byte
+	if parts[1] == "argon2id" {
+		actual = argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, uint32(len(expected)))
+	} else {
+		actual = argon2.Key([]byte(password), salt, iterations, memory, parallelism, uint32(len(expected)))
+	}
+	return subtle.ConstantTimeCompare(actual, expected) == 1, nil
+}
+
+// HtpasswdStore holds the users of an htpasswd file. Only bcrypt and
+// argon2 entries are accepted; entries with other hashes are skipped.
+type HtpasswdStore struct {
+	mu      sync.RWMutex
+	users   map[string]string
+	watcher *helpers.FileWatcher
+}
+
+// ParseHtpasswd reads "user:hash" lines; blank lines and lines starting
+// with "#" are ignored.
+func ParseHtpasswd(reader io.Reader) (*HtpasswdStore, error) {
+	users, err := parseHtpasswd(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &HtpasswdStore{users: users}, nil
+}
+
+// LoadHtpasswdFile loads an htpasswd file and, when reloadInterval is
+// positive, reloads it when it changes.
+func LoadHtpasswdFile(path string, reloadInterval time.Duration) (*HtpasswdStore, error) {
+	var store *HtpasswdStore = &HtpasswdStore{}
+	if err := store.loadFile(path); err != nil {
+		return nil, err
+	}
+	if reloadInterval > 0 {
+		watcher, err := helpers.WatchFile(path, reloadInterval, func() error { return store.loadFile(path) })
+		if err != nil {
+			return nil, err
+		}
+		store.watcher = watcher
+	}
+	return store, nil
+}
+
+func (s *HtpasswdStore) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open htpasswd file: %w", err)
+	}
+	defer file.Close()
+	users, err := parseHtpasswd(file)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.users = users
+	s.mu.Unlock()
+	return nil
+}
+
+func parseHtpasswd(reader io.Reader) (map[string]string, error) {
+	var users map[string]string = make(map[string]string)
+	var scanner *bufio.Scanner = bufio.NewScanner(reader)
+	var line int
+	for scanner.Scan() {
+		line++
+		var text string = strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		username, hash, found := strings.Cut(text, ":")
+		if !found || username == "" || hash == "" {
+			return nil, fmt.Errorf("htpasswd line %d: expected user:hash", line)
+		}
+		if !isSupportedPasswordHash(hash) {
+			logger.GetConsoleLogger().Warn("Skipping htpasswd user '%s': only bcrypt and argon2 hashes are supported", username)
+			continue
+		}
+		users[username] = hash
+	}
+	return users, scanner.Err()
+}
+
+func (s *HtpasswdStore) PasswordHash(username string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hash, exists := s.users[username]
+	return hash, exists
+}
+
+// Close stops reloading the file.
+func (s *HtpasswdStore) Close() {
+	s.watcher.Stop()
+}
+
+type basicAuthOptionsFunc func(*BasicAuth)
+
+// BasicAuth implements HTTP Basic authentication (RFC 7617) against a
+// credential store. Always use it over TLS.
+type BasicAuth struct {
+	store      CredentialStore
+	realm      string
+	timeout    time.Duration
+	contextKey any
+}
+
+func NewBasicAuth(store CredentialStore, options ...basicAuthOptionsFunc) *BasicAuth {
+	var basicAuth *BasicAuth = &BasicAuth{
+		store:   store,
+		realm:   DefaultBasicRealm,
+		timeout: time.Duration(DefaultContextTimeout) * time.Second,
+	}
+	for _, option := range options {
+		option(basicAuth)
+	}
+	return basicAuth
+}
+
+func BasicAuthWithRealm(realm string) basicAuthOptionsFunc {
+	return func(b *BasicAuth) {
+		b.realm = realm
+	}
+}
+
+func BasicAuthWithCustomTimeout(timeout time.Duration) basicAuthOptionsFunc {
+	return func(b *BasicAuth) {
+		b.timeout = timeout
+	}
+}
+
+func BasicAuthWithCustomContextKey(key any) basicAuthOptionsFunc {
+	return func(b *BasicAuth) {
+		b.contextKey = key
+	}
+}
+
+func (b *BasicAuth) Authenticate(r *http.Request) (any, error) {
+	var header string = r.Header.Get("Authorization")
+	if strings.TrimSpace(header) == "" {
+		return nil, newTokenError(ReasonMissingToken, "authorization header is missing")
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, newTokenError(ReasonMalformedRequest, "authorization header must use the basic scheme")
+	}
+
+	hash, exists := b.store.PasswordHash(username)
+	if !exists {
+		verifyDummyPassword(password)
+		return nil, newTokenError(ReasonInvalidToken, "invalid username or password")
+	}
+	valid, err := VerifyPasswordHash(hash, password)
+	if err != nil {
+		logger.GetConsoleLogger().Error("Failed to verify the password of '%s': %v", username, err)
+	}
+	if !valid {
+		return nil, newTokenError(ReasonInvalidToken, "invalid username or password")
+	}
+	return &PasswordUser{Username: username}, nil
+}
+
+// Challenge returns the RFC 7617 challenge.
+func (b *BasicAuth) Challenge(err error) string {
+	return `Basic realm="` + quoteChallenge(b.realm) + `", charset="UTF-8"`
+}
+
+func (b *BasicAuth) GetTimeout() time.Duration {
+	return b.timeout
+}
+
+func (b *BasicAuth) GetContextKey() any {
+	return b.contextKey
+}
@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"cmp"
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+type ChainMode int
+
+const (
+	// ChainFirstSuccess authenticates with the first provider that accepts
+	// the request.
+	ChainFirstSuccess ChainMode = iota
+	// ChainAllMustPass requires every provider to accept the request, e.g.
+	// a client certificate and a user token.
+	ChainAllMustPass
+)
+
+// ChainProvider is a named provider of a chain. The name is recorded in
+// the ChainResult of the requests it authenticates.
+type ChainProvider struct {
+	Name     string
+	Provider PlainAuthInterface
+}
+
+func ChainWith(name string, provider PlainAuthInterface) ChainProvider {
+	return ChainProvider{Name: name, Provider: provider}
+}
+
+// ChainResult is the user returned by ChainAuth. Provider is the name of
+// the first provider that authenticated the request and User its user;
+// Users holds the user of every provider that did, and Roles the union of
+// their roles.
+type ChainResult struct {
+	Provider  string
+	User      any
+	Providers []string
+	Users     map[string]any
+	Roles     []string
+}
+
+func (c *ChainResult) GetSubject() (string, error) {
+	if subject, ok := c.User.(interface{ GetSubject() (string, error) }); ok {
+		return subject.GetSubject()
+	}
+	return "", nil
+}
+
+func (c *ChainResult) GetRoles() []string {
+	return c.Roles
+}
+
+// GetScopes returns the scopes granted by any of the users.
+func (c *ChainResult) GetScopes() []string {
+	var scopes []string
+	for _, name := range c.Providers {
+		for _, scope := range Scopes(c.Users[name]) {
+			if !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return scopes
+}
+
+// RateLimitKey and RateLimitOverride use the first user that carries a
+// rate limit, e.g. an API key.
+func (c *ChainResult) RateLimitKey() string {
+	if principal, ok := c.rateLimited(); ok {
+		return principal.RateLimitKey()
+	}
+	subject, _ := c.GetSubject()
+	return c.Provider + ":" + subject
+}
+
+func (c *ChainResult) RateLimitOverride() (RateLimit, bool) {
+	if principal, ok := c.rateLimited(); ok {
+		return principal.RateLimitOverride()
+	}
+	return RateLimit{}, false
+}
+
+func (c *ChainResult) rateLimited() (RateLimitedPrincipal, bool) {
+	for _, name := range c.Providers {
+		if principal, ok := c.Users[name].(RateLimitedPrincipal); ok {
+			return principal, true
+		}
+	}
+	return nil, false
+}
+
+type chainAuthOptionsFunc func(*ChainAuth)
+
+// ChainAuth tries several providers on a request. It implements
+// RBACAuthInterface, RevocableAuthInterface and ChallengeAuthInterface by
+// delegating to the providers of the chain.
+type ChainAuth struct {
+	mode       ChainMode
+	providers  []ChainProvider
+	timeout    time.Duration
+	contextKey any
+}
+
+func NewChainAuth(mode ChainMode, providers []ChainProvider, options ...chainAuthOptionsFunc) *ChainAuth {
+	var chain *ChainAuth = &ChainAuth{
+		mode:      mode,
+		providers: providers,
+		timeout:   time.Duration(DefaultContextTimeout) * time.Second,
+	}
+	for _, option := range options {
+		option(chain)
+	}
+	return chain
+}
+
+func ChainAuthWithCustomTimeout(timeout time.Duration) chainAuthOptionsFunc {
+	return func(c *ChainAuth) {
+		c.timeout = timeout
+	}
+}
+
+func ChainAuthWithCustomContextKey(key any) chainAuthOptionsFunc {
+	return func(c *ChainAuth) {
+		c.contextKey = key
+	}
+}
+
+// Authenticate runs the providers in order. In first-success mode missing
+// credentials are skipped, and when no provider accepts the request the
+// error of the first provider that found credentials is returned.
+func (c *ChainAuth) Authenticate(r *http.Request) (any, error) {
+	var result *ChainResult = &ChainResult{Users: make(map[string]any)}
+	var firstErr, missingErr error
+	for _, provider := range c.providers {
+		user, err := provider.Provider.Authenticate(r)
+		if err != nil {
+			if c.mode == ChainAllMustPass {
+				return nil, err
+			}
+			if reason, _ := TokenErrorReasonOf(err); reason == ReasonMissingToken {
+				missingErr = cmp.Or(missingErr, err)
+			} else {
+				firstErr = cmp.Or(firstErr, err)
+			}
+			continue
+		}
+		result.add(provider.Name, user)
+		if c.mode == ChainFirstSuccess {
+			return result, nil
+		}
+	}
+	if len(result.Providers) > 0 {
+		return result, nil
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if missingErr != nil {
+		return nil, missingErr
+	}
+	return nil, newTokenError(ReasonMissingToken, "no authentication provider configured")
+}
+
+func (c *ChainResult) add(name string, user any) {
+	if c.Provider == "" {
+		c.Provider = name
+		c.User = user
+	}
+	c.Providers = append(c.Providers, name)
+	c.Users[name] = user
+	if roles, ok := user.(interface{ GetRoles() []string }); ok {
+		for _, role := range roles.GetRoles() {
+			if !slices.Contains(c.Roles, role) {
+				c.Roles = append(c.Roles, role)
+			}
+		}
+	}
+}
+
+// RBAC accepts the request when any authenticated provider grants one of
+// the allowed roles, i.e. it checks the union of their roles.
+func (c *ChainAuth) RBAC(user any, allowedRoles []string) bool {
+	result, ok := user.(*ChainResult)
+	if !ok {
+		return false
+	}
+	for _, role := range result.Roles {
+		if slices.Contains(allowedRoles, role) {
+			return true
+		}
+	}
+	for _, provider := range c.providers {
+		rbac, ok := provider.Provider.(RBACAuthInterface)
+		if !ok {
+			continue
+		}
+		if providerUser, exists := result.Users[provider.Name]; exists && rbac.RBAC(providerUser, allowedRoles) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRevoked reports the result as revoked when any of its users is.
+func (c *ChainAuth) IsRevoked(user any) (bool, error) {
+	result, ok := user.(*ChainResult)
+	if !ok {
+		return false, nil
+	}
+	for _, provider := range c.providers {
+		providerUser, exists := result.Users[provider.Name]
+		if !exists {
+			continue
+		}
+		revocable, ok := provider.Provider.(RevocableAuthInterface)
+		if !ok {
+			continue
+		}
+		if revoked, err := revocable.IsRevoked(providerUser); err != nil || revoked {
+			return revoked, err
+		}
+	}
+	return false, nil
+}
+
+// Challenge lists the challenge of every provider of the chain.
+func (c *ChainAuth) Challenge(err error) string {
+	var challenges []string
+	for _, provider := range c.providers {
+		var challenge string
+		if challenger, ok := provider.Provider.(ChallengeAuthInterface); ok {
+			challenge = challenger.Challenge(err)
+		} else {
+			challenge = BearerChallenge("", err)
+		}
+		if challenge != "" && !slices.Contains(challenges, challenge) {
+			challenges = append(challenges, challenge)
+		}
+	}
+	return strings.Join(challenges, ", ")
+}
+
+func (c *ChainAuth) GetTimeout() time.Duration {
+	return c.timeout
+}
+
+func (c *ChainAuth) GetContextKey() any {
+	return c.contextKey
+}
+
+// Result returns the chain result stored by the auth middleware.
+func (c *ChainAuth) Result(ctx context.Context) (*ChainResult, bool) {
+	return ClaimsFrom[*ChainResult](ctx)
+}
@@ -0,0 +1,376 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/router/helpers"
+)
+
+const (
+	DigestMD5        = "MD5"
+	DigestMD5Sess    = "MD5-sess"
+	DigestSHA256     = "SHA-256"
+	DigestSHA256Sess = "SHA-256-sess"
+
+	DefaultDigestNonceTTL = 5 * time.Minute
+)
+
+// DigestCredentialStore returns H(username:realm:password) of a user, hex
+// encoded and computed with the hash of algorithm (MD5 or SHA-256).
+type DigestCredentialStore interface {
+	DigestHA1(username string, realm string, algorithm string) (string, bool)
+}
+
+func digestHash(algorithm string) func() hash.Hash {
+	if strings.HasPrefix(algorithm, DigestSHA256) {
+		return sha256.New
+	}
+	return md5.New
+}
+
+func digestHex(newHash func() hash.Hash, values ...string) string {
+	var h hash.Hash = newHash()
+	h.Write([]byte(strings.Join(values, ":")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// DigestPasswordStore computes HA1 from plain passwords, for every realm
+// and algorithm.
+type DigestPasswordStore struct {
+	passwords map[string]string
+}
+
+func NewDigestPasswordStore(passwords map[string]string) *DigestPasswordStore {
+	return &DigestPasswordStore{passwords: passwords}
+}
+
+func (s *DigestPasswordStore) DigestHA1(username string, realm string, algorithm string) (string, bool) {
+	password, exists := s.passwords[username]
+	if !exists {
+		return "", false
+	}
+	return digestHex(digestHash(algorithm), username, realm, password), true
+}
+
+// HtdigestStore holds the users of an htdigest file ("user:realm:HA1"
+// lines). htdigest files store MD5 hashes, so they only serve MD5.
+type HtdigestStore struct {
+	users map[string]string
+}
+
+func LoadHtdigestFile(path string) (*HtdigestStore, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open htdigest file: %w", err)
+	}
+	defer file.Close()
+
+	var store *HtdigestStore = &HtdigestStore{users: make(map[string]string)}
+	var scanner *bufio.Scanner = bufio.NewScanner(file)
+	var line int
+	for scanner.Scan() {
+		line++
+		var text string = strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var parts []string = strings.Split(text, ":")
+		if len(parts) != 3 || len(parts[2]) != md5.Size*2 {
+			return nil, fmt.Errorf("htdigest line %d: expected user:realm:md5", line)
+		}
+		store.users[parts[0]+":"+parts[1]] = strings.ToLower(parts[2])
+	}
+	return store, scanner.Err()
+}
+
+func (s *HtdigestStore) DigestHA1(username string, realm string, algorithm string) (string, bool) {
+	if strings.HasPrefix(algorithm, DigestSHA256) {
+		return "", false
+	}
+	ha1, exists := s.users[username+":"+realm]
+	return ha1, exists
+}
+
+// digestNonce tracks the highest nonce count seen, to reject replays. It is
+// only stored once a nonce was used in a valid response.
+type digestNonce struct {
+	mu    sync.Mutex
+	count uint64
+}
+
+type digestAuthOptionsFunc func(*DigestAuth)
+
+// DigestAuth implements HTTP Digest authentication (RFC 7616) with
+// qop=auth. Nonces carry their issue time and an HMAC, so challenges store
+// nothing; they expire after the nonce TTL and a request with an expired
+// nonce but a valid response gets a stale=true challenge so the client
+// retries without asking the user.
+type DigestAuth struct {
+	store      DigestCredentialStore
+	realm      string
+	algorithm  string
+	opaque     string
+	nonceKey   []byte
+	noncesMu   sync.Mutex
+	nonces     helpers.StringCache[*digestNonce]
+	nonceTTL   time.Duration
+	timeout    time.Duration
+	contextKey any
+}
+
+func NewDigestAuth(store DigestCredentialStore, options ...digestAuthOptionsFunc) *DigestAuth {
+	var digestAuth *DigestAuth = &DigestAuth{
+		store:     store,
+		realm:     DefaultBasicRealm,
+		algorithm: DigestSHA256,
+		opaque:    randomDigestValue(),
+		nonceKey:  randomDigestKey(),
+		nonces:    helpers.NewStringCache[*digestNonce](),
+		nonceTTL:  DefaultDigestNonceTTL,
+		timeout:   time.Duration(DefaultContextTimeout) * time.Second,
+	}
+	for _, option := range options {
+		option(digestAuth)
+	}
+	return digestAuth
+}
+
+func DigestAuthWithRealm(realm string) digestAuthOptionsFunc {
+	return func(d *DigestAuth) {
+		d.realm = realm
+	}
+}
+
+// DigestAuthWithAlgorithm selects MD5, MD5-sess, SHA-256 (default) or
+// SHA-256-sess.
+func DigestAuthWithAlgorithm(algorithm string) digestAuthOptionsFunc {
+	return func(d *DigestAuth) {
+		d.algorithm = algorithm
+	}
+}
+
+func DigestAuthWithNonceTTL(ttl time.Duration) digestAuthOptionsFunc {
+	return func(d *DigestAuth) {
+		if ttl > 0 {
+			d.nonceTTL = ttl
+		}
+	}
+}
+
+func DigestAuthWithCustomTimeout(timeout time.Duration) digestAuthOptionsFunc {
+	return func(d *DigestAuth) {
+		d.timeout = timeout
+	}
+}
+
+func DigestAuthWithCustomContextKey(key any) digestAuthOptionsFunc {
+	return func(d *DigestAuth) {
+		d.contextKey = key
+	}
+}
+
+func (d *DigestAuth) Authenticate(r *http.Request) (any, error) {
+	var header string = r.Header.Get("Authorization")
+	if strings.TrimSpace(header) == "" {
+		return nil, newTokenError(ReasonMissingToken, "authorization header is missing")
+	}
+	scheme, rest, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "Digest") {
+		return nil, newTokenError(ReasonMalformedRequest, "authorization header must use the digest scheme")
+	}
+	params, err := parseAuthParams(rest)
+	if err != nil {
+		return nil, newTokenError(ReasonMalformedRequest, "malformed digest credentials: %v", err)
+	}
+	for _, name := range []string{"username", "realm", "nonce", "uri", "response", "qop", "nc", "cnonce"} {
+		if params[name] == "" {
+			return nil, newTokenError(ReasonMalformedRequest, "digest credentials are missing %s", name)
+		}
+	}
+	var algorithm string = params["algorithm"]
+	if algorithm == "" {
+		algorithm = DigestMD5
+	}
+	switch {
+	case params["userhash"] == "true":
+		return nil, newTokenError(ReasonMalformedRequest, "userhash is not supported")
+	case params["qop"] != "auth":
+		return nil, newTokenError(ReasonMalformedRequest, "qop must be auth")
+	case !strings.EqualFold(algorithm, d.algorithm):
+		return nil, newTokenError(ReasonInvalidToken, "digest algorithm must be %s", d.algorithm)
+	case params["realm"] != d.realm:
+		return nil, newTokenError(ReasonInvalidToken, "digest realm does not match")
+	case params["opaque"] != "" && params["opaque"] != d.opaque:
+		return nil, newTokenError(ReasonInvalidToken, "digest opaque does not match")
+	case params["uri"] != r.URL.RequestURI():
+		return nil, newTokenError(ReasonInvalidToken, "digest uri does not match the request")
+	}
+	count, err := strconv.ParseUint(params["nc"], 16, 64)
+	if err != nil || len(params["nc"]) != 8 {
+		return nil, newTokenError(ReasonMalformedRequest, "digest nc must be 8 hex digits")
+	}
+
+	var username string = params["username"]
+	ha1, exists := d.store.DigestHA1(username, d.realm, d.algorithm)
+	if !exists {
+		ha1 = digestHex(digestHash(d.algorithm), randomDigestValue())
+	}
+	var expected string = d.expectedResponse(ha1, r.Method, params)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(params["response"]))) != 1 || !exists {
+		return nil, newTokenError(ReasonInvalidToken, "invalid username or password")
+	}
+
+	issuedAt, valid := d.verifyNonce(params["nonce"])
+	if !valid {
+		return nil, newTokenError(ReasonInvalidToken, "digest nonce is invalid")
+	}
+	var remaining time.Duration = time.Until(issuedAt.Add(d.nonceTTL))
+	if remaining <= 0 {
+		return nil, newTokenError(ReasonExpired, "digest nonce is stale")
+	}
+
+	d.noncesMu.Lock()
+	nonce, known := d.nonces.Load(params["nonce"])
+	if !known {
+		nonce = &digestNonce{}
+		d.nonces.Store(params["nonce"], nonce, remaining)
+	}
+	d.noncesMu.Unlock()
+	nonce.mu.Lock()
+	defer nonce.mu.Unlock()
+	if count <= nonce.count {
+		return nil, newTokenError(ReasonInvalidToken, "digest nonce count was already used")
+	}
+	nonce.count = count
+	return &PasswordUser{Username: username}, nil
+}
+
+func (d *DigestAuth) expectedResponse(ha1 string, method string, params map[string]string) string {
+	var newHash func() hash.Hash = digestHash(d.algorithm)
+	if strings.HasSuffix(d.algorithm, "-sess") {
+		ha1 = digestHex(newHash, ha1, params["nonce"], params["cnonce"])
+	}
+	var ha2 string = digestHex(newHash, method, params["uri"])
+	return digestHex(newHash, ha1, params["nonce"], params["nc"], params["cnonce"], params["qop"], ha2)
+}
+
+// Challenge issues a new nonce. Stale nonces get stale=true.
+func (d *DigestAuth) Challenge(err error) string {
+	var nonce string = d.newNonce(time.Now())
+	var challenge string = fmt.Sprintf(`Digest realm="%s", qop="auth", algorithm=%s, nonce="%s", opaque="%s"`,
+		quoteChallenge(d.realm), d.algorithm, nonce, d.opaque)
+	if reason, _ := TokenErrorReasonOf(err); reason == ReasonExpired {
+		challenge += ", stale=true"
+	}
+	return challenge
+}
+
+func (d *DigestAuth) GetTimeout() time.Duration {
+	return d.timeout
+}
+
+func (d *DigestAuth) GetContextKey() any {
+	return d.contextKey
+}
+
+// newNonce returns base64url(issued unix time | random | HMAC of both), so
+// a nonce can be verified without remembering it.
+func (d *DigestAuth) newNonce(issuedAt time.Time) string {
+	var payload []byte = make([]byte, 16)
+	binary.BigEndian.PutUint64(payload, uint64(issuedAt.Unix()))
+	rand.Read(payload[8:])
+	return base64.RawURLEncoding.EncodeToString(append(payload, d.nonceMAC(payload)...))
+}
+
+func (d *DigestAuth) verifyNonce(nonce string) (time.Time, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(raw) != 16+sha256.Size/2 {
+		return time.Time{}, false
+	}
+	if !hmac.Equal(raw[16:], d.nonceMAC(raw[:16])) {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(binary.BigEndian.Uint64(raw[:8])), 0), true
+}
+
+func (d *DigestAuth) nonceMAC(payload []byte) []byte {
+	var mac hash.Hash = hmac.New(sha256.New, d.nonceKey)
+	mac.Write(payload)
+	return mac.Sum(nil)[:sha256.Size/2]
+}
+
+func randomDigestKey() []byte {
+	var key []byte = make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+func randomDigestValue() string {
+	var b []byte = make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// parseAuthParams parses the comma separated name=value pairs of an
+// Authorization header; values may be quoted strings.
+func parseAuthParams(value string) (map[string]string, error) {
+	var params map[string]string = make(map[string]string)
+	var reader *strings.Reader = strings.NewReader(value)
+	for {
+		var name strings.Builder
+		var r rune
+		var err error
+		for r, _, err = reader.ReadRune(); err == nil && r != '='; r, _, err = reader.ReadRune() {
+			if r != ' ' && r != ',' && r != '\t' {
+				name.WriteRune(r)
+			}
+		}
+		if err != nil {
+			if name.Len() > 0 {
+				return nil, fmt.Errorf("parameter %s has no value", name.String())
+			}
+			return params, nil
+		}
+
+		var builder strings.Builder
+		r, _, err = reader.ReadRune()
+		if err == nil && r == '"' {
+			var closed bool
+			for r, _, err = reader.ReadRune(); err == nil; r, _, err = reader.ReadRune() {
+				if r == '\\' {
+					if r, _, err = reader.ReadRune(); err != nil {
+						break
+					}
+				} else if r == '"' {
+					closed = true
+					break
+				}
+				builder.WriteRune(r)
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated quoted value of %s", name.String())
+			}
+		} else {
+			for ; err == nil && r != ','; r, _, err = reader.ReadRune() {
+				builder.WriteRune(r)
+			}
+		}
+		params[strings.ToLower(name.String())] = strings.TrimSpace(builder.String())
+	}
+}
@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/logger"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	DefaultIntrospectionCacheTTL  = time.Minute
+	DefaultIntrospectionCacheSize = 10000
+	maxIntrospectionBytes         = 1 << 20
+)
+
+// ErrIntrospectionUnavailable is returned when the introspection endpoint
+// cannot be reached or fails; the auth middlewares answer 503.
+var ErrIntrospectionUnavailable = errors.New("token introspection unavailable")
+
+// IntrospectionResult is an RFC 7662 introspection response. Members that
+// are not defined by the RFC are kept in Extra.
+type IntrospectionResult struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	jwt.RegisteredClaims
+	Extra map[string]any `json:"-"`
+}
+
+func (i *IntrospectionResult) GetScopes() []string {
+	return strings.Fields(i.Scope)
+}
+
+// GetClaims returns the members of the response, including Extra.
+func (i *IntrospectionResult) GetClaims() map[string]any {
+	var claims map[string]any = make(map[string]any)
+	if data, err := json.Marshal(i); err == nil {
+		_ = json.Unmarshal(data, &claims)
+	}
+	for name, value := range i.Extra {
+		claims[name] = value
+	}
+	return claims
+}
+
+// GetRoles returns the non-standard "roles" member. Scopes are checked
+// separately with GetScopes, never by RBAC.
+func (i *IntrospectionResult) GetRoles() []string {
+	var roles []string
+	if values, ok := i.Extra["roles"].([]any); ok {
+		for _, value := range values {
+			if role, ok := value.(string); ok {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
+
+type introspectionAuthOptionsFunc func(*IntrospectionAuth)
+
+// IntrospectionAuth authenticates opaque bearer tokens with an RFC 7662
+// introspection endpoint. Active responses are cached by the SHA-256 of the
+// token for the cache TTL, and never beyond the expiry of the token.
+// Concurrent requests with the same token share one endpoint call.
+type IntrospectionAuth struct {
+	endpoint     string
+	client       *http.Client
+	clientID     string
+	clientSecret string
+	audiences    []string
+	cacheTTL     time.Duration
+	cacheSize    int
+	cache        helpers.StringCache[*IntrospectionResult]
+	callsMu      sync.Mutex
+	calls        map[string]*introspectionCall
+	realm        string
+	timeout      time.Duration
+	contextKey   any
+}
+
+// introspectionCall is an endpoint call shared by the requests that present
+// the same token while it runs.
+type introspectionCall struct {
+	done   chan struct{}
+	result *IntrospectionResult
+	err    error
+}
+
+func NewIntrospectionAuth(endpoint string, options ...introspectionAuthOptionsFunc) *IntrospectionAuth {
+	var introspection *IntrospectionAuth = &IntrospectionAuth{
+		endpoint:  endpoint,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		cacheTTL:  DefaultIntrospectionCacheTTL,
+		cacheSize: DefaultIntrospectionCacheSize,
+		cache:     helpers.NewStringCache[*IntrospectionResult](),
+		calls:     make(map[string]*introspectionCall),
+		timeout:   time.Duration(DefaultContextTimeout) * time.Second,
+	}
+	for _, option := range options {
+		option(introspection)
+	}
+	return introspection
+}
+
+// IntrospectionAuthWithClientCredentials authenticates the resource server
+// to the endpoint with HTTP Basic.
+func IntrospectionAuthWithClientCredentials(clientID string, clientSecret string) introspectionAuthOptionsFunc {
+	return func(i *IntrospectionAuth) {
+		i.clientID = clientID
+		i.clientSecret = clientSecret
+	}
+}
+
+// IntrospectionAuthWithClient sets the HTTP client, e.g. one built with the
+// client package.
+func IntrospectionAuthWithClient(client *http.Client) introspectionAuthOptionsFunc {
+	return func(i *IntrospectionAuth) {
+		i.client = client
+	}
+}
+
+// IntrospectionAuthWithAudience rejects tokens that were not issued for one
+// of the audiences.
+func IntrospectionAuthWithAudience(audiences ...string) introspectionAuthOptionsFunc {
+	return func(i *IntrospectionAuth) {
+		i.audiences = audiences
+	}
+}
+
+// IntrospectionAuthWithCacheTTL sets how long responses are cached. Zero
+// disables the cache.
+func IntrospectionAuthWithCacheTTL(ttl time.Duration) introspectionAuthOptionsFunc {
+	return func(i *IntrospectionAuth) {
+		i.cacheTTL = ttl
+	}
+}
+
+// IntrospectionAuthWithCacheSize caps the number of cached responses. Once
+// the cache is full, responses are not cached until entries expire.
+func IntrospectionAuthWithCacheSize(size int) introspectionAuthOptionsFunc {
+	return func(i *IntrospectionAuth) {
+		i.cacheSize = size
+	}
+}
+
+func IntrospectionAuthWithRealm(realm string) introspectionAuthOptionsFunc {
+	return func(i *IntrospectionAuth) {
+		i.realm = realm
+	}
+}
+
+func IntrospectionAuthWithCustomTimeout(timeout time.Duration) introspectionAuthOptionsFunc {
+	return func(i *IntrospectionAuth) {
+		i.timeout = timeout
+	}
+}
+
+func IntrospectionAuthWithCustomContextKey(key any) introspectionAuthOptionsFunc {
+	return func(i *IntrospectionAuth) {
+		i.contextKey = key
+	}
+}
+
+// Authenticate returns the *IntrospectionResult of an active token.
+func (i *IntrospectionAuth) Authenticate(r *http.Request) (any, error) {
+	token, err := ExtractBearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+	result, err := i.Introspect(token)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Active {
+		return nil, newTokenError(ReasonInvalidToken, "token is not active")
+	}
+	var now time.Time = time.Now()
+	if result.ExpiresAt != nil && !now.Before(result.ExpiresAt.Time) {
+		return nil, newTokenError(ReasonExpired, "token has expired")
+	}
+	if result.NotBefore != nil && now.Before(result.NotBefore.Time) {
+		return nil, newTokenError(ReasonNotYetValid, "token is not valid yet")
+	}
+	if len(i.audiences) > 0 && !slices.ContainsFunc(result.Audience, func(audience string) bool { return slices.Contains(i.audiences, audience) }) {
+		return nil, newTokenError(ReasonWrongAudience, "token has an invalid audience")
+	}
+	return result, nil
+}
+
+// Introspect returns the cached response for the token, or asks the
+// endpoint. Inactive responses are not cached, so unknown tokens cannot
+// fill the cache.
+func (i *IntrospectionAuth) Introspect(token string) (*IntrospectionResult, error) {
+	var sum [32]byte = sha256.Sum256([]byte(token))
+	var key string = hex.EncodeToString(sum[:])
+	if cached, ok := i.cache.Load(key); ok {
+		return cached, nil
+	}
+
+	i.callsMu.Lock()
+	if call, exists := i.calls[key]; exists {
+		i.callsMu.Unlock()
+		<-call.done
+		return call.result, call.err
+	}
+	var call *introspectionCall = &introspectionCall{done: make(chan struct{})}
+	i.calls[key] = call
+	i.callsMu.Unlock()
+
+	call.result, call.err = i.introspect(token, key)
+	i.callsMu.Lock()
+	delete(i.calls, key)
+	i.callsMu.Unlock()
+	close(call.done)
+	return call.result, call.err
+}
+
+func (i *IntrospectionAuth) introspect(token string, key string) (*IntrospectionResult, error) {
+	result, err := i.fetch(token)
+	if err != nil {
+		logger.GetConsoleLogger().Error("Failed to introspect token at %s: %v", i.endpoint, err)
+		return nil, errors.Join(ErrIntrospectionUnavailable, err)
+	}
+	if !result.Active {
+		return result, nil
+	}
+	var ttl time.Duration = i.cacheTTL
+	if result.ExpiresAt != nil {
+		ttl = min(ttl, time.Until(result.ExpiresAt.Time))
+	}
+	if ttl > 0 && i.cache.Len() < i.cacheSize {
+		i.cache.Store(key, result, ttl)
+	}
+	return result, nil
+}
+
+func (i *IntrospectionAuth) fetch(token string) (*IntrospectionResult, error) {
+	var form url.Values = url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	request, err := http.NewRequest(http.MethodPost, i.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if i.clientID != "" {
+		request.SetBasicAuth(url.QueryEscape(i.clientID), url.QueryEscape(i.clientSecret))
+	}
+
+	response, err := i.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxIntrospectionBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	var result *IntrospectionResult = &IntrospectionResult{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, fmt.Errorf("invalid introspection response: %w", err)
+	}
+	if err := json.Unmarshal(data, &result.Extra); err != nil {
+		return nil, fmt.Errorf("invalid introspection response: %w", err)
+	}
+	for _, member := range []string{"active", "scope", "client_id", "username", "token_type", "exp", "iat", "nbf", "sub", "aud", "iss", "jti"} {
+		delete(result.Extra, member)
+	}
+	return result, nil
+}
+
+// RBAC accepts tokens with one of the allowed roles.
+func (i *IntrospectionAuth) RBAC(user any, allowedRoles []string) bool {
+	result, ok := user.(*IntrospectionResult)
+	if !ok {
+		return false
+	}
+	return slices.ContainsFunc(result.GetRoles(), func(role string) bool { return slices.Contains(allowedRoles, role) })
+}
+
+func (i *IntrospectionAuth) Challenge(err error) string {
+	return BearerChallenge(i.realm, err)
+}
+
+func (i *IntrospectionAuth) GetTimeout() time.Duration {
+	return i.timeout
+}
+
+func (i *IntrospectionAuth) GetContextKey() any {
+	return i.contextKey
+}
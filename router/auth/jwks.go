@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/logger"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+)
+
+const (
+	JWKSPath          = "/.well-known/jwks.json"
+	DefaultJWKSMaxAge = 15 * time.Minute
+)
+
+// JWK is a JSON Web Key (RFC 7517) holding a public key.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var curveNames = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// JWK returns the public JWK of the key. Symmetric keys are never published.
+func (k Key) JWK() (JWK, error) {
+	var jwk JWK = JWK{KeyID: k.ID, Use: "sig", Algorithm: k.Algorithm}
+	switch publicKey := k.PublicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case *ecdsa.PublicKey:
+		var size int = (publicKey.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = publicKey.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(publicKey.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(publicKey.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+	default:
+		return JWK{}, fmt.Errorf("key '%s' has no publishable public key", k.ID)
+	}
+	return jwk, nil
+}
+
+// ParseJWK converts a JWK into a verification key. RSA keys without "alg"
+// are assumed to be RS256; EC and OKP keys take the algorithm of their curve.
+func ParseJWK(jwk JWK) (Key, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return Key{}, fmt.Errorf("key '%s': invalid modulus: %w", jwk.KeyID, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return Key{}, fmt.Errorf("key '%s': invalid exponent", jwk.KeyID)
+		}
+		var algorithm string = jwk.Algorithm
+		if algorithm == "" {
+			algorithm = "RS256"
+		}
+		var publicKey *rsa.PublicKey = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return NewVerificationKey(jwk.KeyID, algorithm, publicKey)
+	case "EC":
+		curve, ok := curveNames[jwk.Curve]
+		if !ok {
+			return Key{}, fmt.Errorf("key '%s': unsupported curve '%s'", jwk.KeyID, jwk.Curve)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+		y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if errX != nil || errY != nil {
+			return Key{}, fmt.Errorf("key '%s': invalid coordinates", jwk.KeyID)
+		}
+		var publicKey *ecdsa.PublicKey = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return Key{}, fmt.Errorf("key '%s': point is not on the curve", jwk.KeyID)
+		}
+		var algorithm string = map[string]string{"P-256": "ES256", "P-384": "ES384", "P-521": "ES512"}[jwk.Curve]
+		if jwk.Algorithm != "" && jwk.Algorithm != algorithm {
+			return Key{}, fmt.Errorf("key '%s': algorithm '%s' does not match curve '%s'", jwk.KeyID, jwk.Algorithm, jwk.Curve)
+		}
+		return NewVerificationKey(jwk.KeyID, algorithm, publicKey)
+	case "OKP":
+		if jwk.Curve != "Ed25519" {
+			return Key{}, fmt.Errorf("key '%s': unsupported curve '%s'", jwk.KeyID, jwk.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return Key{}, fmt.Errorf("key '%s': invalid Ed25519 key", jwk.KeyID)
+		}
+		return NewVerificationKey(jwk.KeyID, "EdDSA", ed25519.PublicKey(x))
+	default:
+		return Key{}, fmt.Errorf("key '%s': unsupported key type '%s'", jwk.KeyID, jwk.KeyType)
+	}
+}
+
+// JWKS returns the public keys of the set. Symmetric keys are left out.
+func (s *KeySet) JWKS() JWKS {
+	var jwks JWKS = JWKS{Keys: []JWK{}}
+	for _, key := range s.Keys() {
+		if key.IsSymmetric() {
+			continue
+		}
+		jwk, err := key.JWK()
+		if err != nil {
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
+
+// ParseJWKS converts a JWKS document into a key set. Keys that are not
+// signature keys or use unsupported types are skipped.
+func ParseJWKS(data []byte) (*KeySet, error) {
+	var jwks JWKS
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+	var keySet *KeySet = &KeySet{keys: make(map[string]Key)}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := ParseJWK(jwk)
+		if err != nil {
+			logger.GetConsoleLogger().Warn("Skipping JWKS key: %v", err)
+			continue
+		}
+		if err := keySet.Add(key); err != nil {
+			logger.GetConsoleLogger().Warn("Skipping JWKS key: %v", err)
+		}
+	}
+	return keySet, nil
+}
+
+// JWKSHandler publishes the public keys of the set. Keys added to or removed
+// from the set are reflected on the next request.
+func JWKSHandler(keys *KeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(DefaultJWKSMaxAge.Seconds())))
+		helpers.SendSuccessResponse(w, keys.JWKS())
+	}
+}
@@ -0,0 +1,384 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/router/helpers"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const minRSAKeyBits = 2048
+
+// SupportedAlgorithms are the JWS algorithms accepted by keys and verifiers.
+var SupportedAlgorithms = []string{
+	"HS256", "HS384", "HS512",
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// Key is a signing or verification key identified by its kid. Verification
+// keys only hold the public key; HMAC keys hold the shared secret. NotBefore
+// and NotAfter bound the period the key is accepted, so a new key can be
+// published before it signs and an old one kept until its tokens expire.
+type Key struct {
+	ID         string
+	Algorithm  string
+	PublicKey  crypto.PublicKey
+	PrivateKey crypto.Signer
+	Secret     []byte
+	NotBefore  time.Time
+	NotAfter   time.Time
+}
+
+// NewVerificationKey creates a key that can only verify tokens.
+func NewVerificationKey(kid string, algorithm string, publicKey crypto.PublicKey) (Key, error) {
+	var key Key = Key{ID: kid, Algorithm: algorithm, PublicKey: publicKey}
+	return key, key.validate()
+}
+
+// NewSigningKey creates a key able to mint tokens. Its public part is used
+// for verification.
+func NewSigningKey(kid string, algorithm string, privateKey crypto.Signer) (Key, error) {
+	if privateKey == nil {
+		return Key{}, fmt.Errorf("private key is required")
+	}
+	var key Key = Key{ID: kid, Algorithm: algorithm, PrivateKey: privateKey, PublicKey: privateKey.Public()}
+	return key, key.validate()
+}
+
+// NewHMACKey creates a shared secret key. The secret must be at least as
+// long as the hash output (RFC 7518 section 3.2).
+func NewHMACKey(kid string, algorithm string, secret []byte) (Key, error) {
+	var key Key = Key{ID: kid, Algorithm: algorithm, Secret: secret}
+	return key, key.validate()
+}
+
+// ValidBetween returns a copy of the key restricted to the given period.
+// Zero times leave the bound open.
+func (k Key) ValidBetween(notBefore time.Time, notAfter time.Time) Key {
+	k.NotBefore = notBefore
+	k.NotAfter = notAfter
+	return k
+}
+
+// Public returns the key without private material.
+func (k Key) Public() Key {
+	k.PrivateKey = nil
+	return k
+}
+
+func (k Key) IsSymmetric() bool {
+	return len(k.Secret) > 0
+}
+
+func (k Key) CanSign() bool {
+	return k.PrivateKey != nil || k.IsSymmetric()
+}
+
+func (k Key) activeAt(now time.Time) bool {
+	if !k.NotBefore.IsZero() && now.Before(k.NotBefore) {
+		return false
+	}
+	if !k.NotAfter.IsZero() && !now.Before(k.NotAfter) {
+		return false
+	}
+	return true
+}
+
+func (k Key) verificationKey() any {
+	if k.IsSymmetric() {
+		return k.Secret
+	}
+	return k.PublicKey
+}
+
+func (k Key) signingKey() any {
+	if k.IsSymmetric() {
+		return k.Secret
+	}
+	return k.PrivateKey
+}
+
+func (k Key) validate() error {
+	switch k.Algorithm {
+	case "HS256", "HS384", "HS512":
+		var minLength int = map[string]int{"HS256": 32, "HS384": 48, "HS512": 64}[k.Algorithm]
+		if len(k.Secret) < minLength {
+			return fmt.Errorf("key '%s': %s requires a secret of at least %d bytes", k.ID, k.Algorithm, minLength)
+		}
+		return nil
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		publicKey, ok := k.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key '%s': %s requires an RSA key", k.ID, k.Algorithm)
+		}
+		if publicKey.N.BitLen() < minRSAKeyBits {
+			return fmt.Errorf("key '%s': RSA keys must be at least %d bits", k.ID, minRSAKeyBits)
+		}
+		return nil
+	case "ES256", "ES384", "ES512":
+		publicKey, ok := k.PublicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key '%s': %s requires an ECDSA key", k.ID, k.Algorithm)
+		}
+		var curve elliptic.Curve = map[string]elliptic.Curve{"ES256": elliptic.P256(), "ES384": elliptic.P384(), "ES512": elliptic.P521()}[k.Algorithm]
+		if publicKey.Curve != curve {
+			return fmt.Errorf("key '%s': %s requires the %s curve", k.ID, k.Algorithm, curve.Params().Name)
+		}
+		return nil
+	case "EdDSA":
+		if _, ok := k.PublicKey.(ed25519.PublicKey); !ok {
+			return fmt.Errorf("key '%s': EdDSA requires an Ed25519 key", k.ID)
+		}
+		return nil
+	default:
+		return fmt.Errorf("key '%s': unsupported algorithm '%s'", k.ID, k.Algorithm)
+	}
+}
+
+// KeyResolver returns the key that must verify a token with the given kid
+// and alg header. KeySet resolves local keys; remote key sets implement it
+// too.
+type KeyResolver interface {
+	ResolveKey(kid string, algorithm string) (Key, error)
+}
+
+// KeySet holds the keys of a service indexed by kid.
+type KeySet struct {
+	mu         sync.RWMutex
+	keys       map[string]Key
+	signingKID string
+}
+
+func NewKeySet(keys ...Key) (*KeySet, error) {
+	var keySet *KeySet = &KeySet{keys: make(map[string]Key)}
+	for _, key := range keys {
+		if err := keySet.Add(key); err != nil {
+			return nil, err
+		}
+	}
+	return keySet, nil
+}
+
+func (s *KeySet) Add(key Key) error {
+	if err := key.validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.keys[key.ID]; exists {
+		return fmt.Errorf("duplicated key id '%s'", key.ID)
+	}
+	s.keys[key.ID] = key
+	return nil
+}
+
+func (s *KeySet) Remove(kid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, kid)
+	if s.signingKID == kid {
+		s.signingKID = ""
+	}
+}
+
+// SetSigningKey pins the key used by Sign. Without it the active signing key
+// with the latest NotBefore is used.
+func (s *KeySet) SetSigningKey(kid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, exists := s.keys[kid]
+	if !exists {
+		return fmt.Errorf("unknown key id '%s'", kid)
+	}
+	if !key.CanSign() {
+		return fmt.Errorf("key '%s' has no private key", kid)
+	}
+	s.signingKID = kid
+	return nil
+}
+
+func (s *KeySet) Keys() []Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys []Key = make([]Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys
+}
+
+// Public returns a key set without private keys, to be handed to services
+// that only verify tokens. Symmetric keys are left out.
+func (s *KeySet) Public() *KeySet {
+	var public *KeySet = &KeySet{keys: make(map[string]Key)}
+	for _, key := range s.Keys() {
+		if !key.IsSymmetric() {
+			public.keys[key.ID] = key.Public()
+		}
+	}
+	return public
+}
+
+// Algorithms returns the algorithms of the keys in the set.
+func (s *KeySet) Algorithms() []string {
+	var algorithms []string
+	for _, key := range s.Keys() {
+		if !slices.Contains(algorithms, key.Algorithm) {
+			algorithms = append(algorithms, key.Algorithm)
+		}
+	}
+	return algorithms
+}
+
+// ResolveKey returns the active key with the given kid. Tokens without kid
+// are accepted only when a single active key uses their algorithm.
+func (s *KeySet) ResolveKey(kid string, algorithm string) (Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var now time.Time = time.Now()
+
+	if kid == "" {
+		var candidates []Key
+		for _, key := range s.keys {
+			if key.Algorithm == algorithm && key.activeAt(now) {
+				candidates = append(candidates, key)
+			}
+		}
+		if len(candidates) != 1 {
+			return Key{}, fmt.Errorf("token has no key id")
+		}
+		return candidates[0], nil
+	}
+
+	key, exists := s.keys[kid]
+	if !exists {
+		return Key{}, fmt.Errorf("unknown key id '%s'", kid)
+	}
+	if key.Algorithm != algorithm {
+		return Key{}, fmt.Errorf("key '%s' does not use algorithm '%s'", kid, algorithm)
+	}
+	if !key.activeAt(now) {
+		return Key{}, fmt.Errorf("key '%s' is not active", kid)
+	}
+	return key, nil
+}
+
+// SigningKey returns the key used to mint new tokens.
+func (s *KeySet) SigningKey() (Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.signingKID != "" {
+		return s.keys[s.signingKID], nil
+	}
+
+	var now time.Time = time.Now()
+	var best Key
+	var found bool
+	for _, key := range s.keys {
+		if !key.CanSign() || !key.activeAt(now) {
+			continue
+		}
+		if !found || key.NotBefore.After(best.NotBefore) || (key.NotBefore.Equal(best.NotBefore) && key.ID > best.ID) {
+			best = key
+			found = true
+		}
+	}
+	if !found {
+		return Key{}, fmt.Errorf("no active signing key")
+	}
+	return best, nil
+}
+
+// Sign mints a token with the signing key, setting its kid header.
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key, err := s.SigningKey()
+	if err != nil {
+		return "", err
+	}
+	return SignWithKey(claims, key)
+}
+
+func SignWithKey(claims jwt.Claims, key Key) (string, error) {
+	if !key.CanSign() {
+		return "", fmt.Errorf("key '%s' has no private key", key.ID)
+	}
+	return helpers.GenerateJwtTokenWithAlgorithm(claims, key.Algorithm, key.signingKey(), key.ID)
+}
+
+// keyfunc resolves the verification key of a token.
+func keyfunc(resolver KeyResolver) jwt.Keyfunc {
+	return func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := resolver.ResolveKey(kid, t.Method.Alg())
+		if err != nil {
+			return nil, err
+		}
+		return key.verificationKey(), nil
+	}
+}
+
+// LoadPublicKeyFromPEM parses a PKIX or PKCS#1 public key or the public key
+// of a certificate.
+func LoadPublicKeyFromPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block containing public key")
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return certificate.PublicKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type '%s'", block.Type)
+	}
+}
+
+// LoadSigningKeyFromPEM parses a PKCS#8, PKCS#1 or SEC 1 private key.
+func LoadSigningKeyFromPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block containing private key")
+	}
+	var privateKey any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type '%s'", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+	}
+	return signer, nil
+}
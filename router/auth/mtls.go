@@ -0,0 +1,379 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/logger"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+)
+
+// CertificatePrincipal is the user returned by MTLSAuth. Identity is the
+// SPIFFE ID of the certificate when it has one, else its common name, else
+// its first DNS name.
+type CertificatePrincipal struct {
+	Identity    string
+	SPIFFEID    string
+	TrustDomain string
+	CommonName  string
+	DNSNames    []string
+	Roles       []string
+	Certificate *x509.Certificate
+}
+
+func (p *CertificatePrincipal) GetSubject() (string, error) {
+	return p.Identity, nil
+}
+
+func (p *CertificatePrincipal) GetRoles() []string {
+	return p.Roles
+}
+
+// LoadCertPool reads the PEM certificates of the files into a pool.
+func LoadCertPool(paths ...string) (*x509.CertPool, error) {
+	var pool *x509.CertPool = x509.NewCertPool()
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %s", path)
+		}
+	}
+	return pool, nil
+}
+
+// crlSet holds the revoked serial numbers of each issuer, loaded from CRL
+// files.
+type crlSet struct {
+	mu       sync.RWMutex
+	lists    map[string]*x509.RevocationList
+	verified map[*x509.RevocationList]bool
+	watchers []*helpers.FileWatcher
+}
+
+func (c *crlSet) load(paths []string) error {
+	var lists map[string]*x509.RevocationList = make(map[string]*x509.RevocationList)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read CRL file: %w", err)
+		}
+		for len(data) > 0 {
+			var der []byte = data
+			block, rest := pem.Decode(data)
+			if block != nil {
+				if block.Type != "X509 CRL" {
+					data = rest
+					continue
+				}
+				der, data = block.Bytes, rest
+			} else {
+				data = nil
+			}
+			list, err := x509.ParseRevocationList(der)
+			if err != nil {
+				return fmt.Errorf("failed to parse CRL %s: %w", path, err)
+			}
+			lists[string(list.RawIssuer)] = list
+		}
+	}
+	c.mu.Lock()
+	c.lists = lists
+	c.verified = make(map[*x509.RevocationList]bool)
+	c.mu.Unlock()
+	return nil
+}
+
+// revoked reports whether cert, issued by issuer, is in the CRL of issuer.
+// A CRL whose signature does not verify against issuer is ignored.
+func (c *crlSet) revoked(cert *x509.Certificate, issuer *x509.Certificate) bool {
+	c.mu.RLock()
+	list, exists := c.lists[string(cert.RawIssuer)]
+	verified, checked := c.verified[list]
+	c.mu.RUnlock()
+	if !exists {
+		return false
+	}
+	if !checked {
+		if err := list.CheckSignatureFrom(issuer); err != nil {
+			logger.GetConsoleLogger().Warn("Ignoring CRL of %s: %v", issuer.Subject, err)
+		} else {
+			verified = true
+		}
+		c.mu.Lock()
+		c.verified[list] = verified
+		c.mu.Unlock()
+	}
+	if !verified {
+		return false
+	}
+	if !list.NextUpdate.IsZero() && time.Now().After(list.NextUpdate) {
+		logger.GetConsoleLogger().Warn("CRL of %s is past its next update", issuer.Subject)
+	}
+	for _, entry := range list.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type mtlsAuthOptionsFunc func(*MTLSAuth)
+
+// MTLSAuth authenticates clients by their TLS certificate. The chain is
+// verified against the CA pool for client authentication, whatever the TLS
+// server config did, and checked against the loaded CRLs.
+type MTLSAuth struct {
+	roots        *x509.CertPool
+	roles        map[string][]string
+	trustDomains []string
+	mapper       func(cert *x509.Certificate, principal *CertificatePrincipal) error
+	crls         *crlSet
+	crlErr       error
+	timeout      time.Duration
+	contextKey   any
+}
+
+func NewMTLSAuth(roots *x509.CertPool, options ...mtlsAuthOptionsFunc) (*MTLSAuth, error) {
+	var mtlsAuth *MTLSAuth = &MTLSAuth{
+		roots:   roots,
+		roles:   make(map[string][]string),
+		crls:    &crlSet{lists: map[string]*x509.RevocationList{}, verified: map[*x509.RevocationList]bool{}},
+		timeout: time.Duration(DefaultContextTimeout) * time.Second,
+	}
+	for _, option := range options {
+		option(mtlsAuth)
+	}
+	if mtlsAuth.crlErr != nil {
+		return nil, mtlsAuth.crlErr
+	}
+	return mtlsAuth, nil
+}
+
+// MTLSAuthWithRoles maps identities to roles. A key ending in "*" matches
+// every identity with that prefix, e.g. "spiffe://cluster.local/ns/billing/*".
+func MTLSAuthWithRoles(roles map[string][]string) mtlsAuthOptionsFunc {
+	return func(m *MTLSAuth) {
+		m.roles = roles
+	}
+}
+
+// MTLSAuthWithTrustDomains only accepts SPIFFE IDs of the trust domains.
+// Certificates without a SPIFFE ID are rejected.
+func MTLSAuthWithTrustDomains(domains ...string) mtlsAuthOptionsFunc {
+	return func(m *MTLSAuth) {
+		m.trustDomains = domains
+	}
+}
+
+// MTLSAuthWithMapper customizes the principal after the default mapping,
+// e.g. to read roles from an OU. Returning an error rejects the request.
+func MTLSAuthWithMapper(mapper func(cert *x509.Certificate, principal *CertificatePrincipal) error) mtlsAuthOptionsFunc {
+	return func(m *MTLSAuth) {
+		m.mapper = mapper
+	}
+}
+
+// MTLSAuthWithCRLFiles loads DER or PEM CRLs and, when reloadInterval is
+// positive, reloads them when they change.
+func MTLSAuthWithCRLFiles(reloadInterval time.Duration, paths ...string) mtlsAuthOptionsFunc {
+	return func(m *MTLSAuth) {
+		if err := m.crls.load(paths); err != nil {
+			m.crlErr = err
+			return
+		}
+		if reloadInterval <= 0 {
+			return
+		}
+		for _, path := range paths {
+			watcher, err := helpers.WatchFile(path, reloadInterval, func() error { return m.crls.load(paths) })
+			if err != nil {
+				m.crlErr = err
+				return
+			}
+			m.crls.watchers = append(m.crls.watchers, watcher)
+		}
+	}
+}
+
+func MTLSAuthWithCustomTimeout(timeout time.Duration) mtlsAuthOptionsFunc {
+	return func(m *MTLSAuth) {
+		m.timeout = timeout
+	}
+}
+
+func MTLSAuthWithCustomContextKey(key any) mtlsAuthOptionsFunc {
+	return func(m *MTLSAuth) {
+		m.contextKey = key
+	}
+}
+
+// TLSConfig returns a server config trusting the CA pool for client
+// certificates, for Router.StartTLSServer. With required false, clients
+// without a certificate can still use other providers of a chain.
+func (m *MTLSAuth) TLSConfig(required bool) *tls.Config {
+	var clientAuth tls.ClientAuthType = tls.VerifyClientCertIfGiven
+	if required {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: clientAuth,
+		ClientCAs:  m.roots,
+	}
+}
+
+func (m *MTLSAuth) Authenticate(r *http.Request) (any, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, newTokenError(ReasonMissingToken, "client certificate is missing")
+	}
+	var leaf *x509.Certificate = r.TLS.PeerCertificates[0]
+	var intermediates *x509.CertPool = x509.NewCertPool()
+	for _, cert := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         m.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, &TokenError{Reason: ReasonInvalidSignature, Description: "client certificate is not trusted", Err: err}
+	}
+	if m.isRevoked(chains) {
+		return nil, newTokenError(ReasonRevoked, "client certificate has been revoked")
+	}
+
+	principal, err := m.principal(leaf)
+	if err != nil {
+		return nil, err
+	}
+	return principal, nil
+}
+
+// isRevoked checks every certificate of a verified chain against the CRL
+// of its issuer.
+func (m *MTLSAuth) isRevoked(chains [][]*x509.Certificate) bool {
+	for _, chain := range chains {
+		for i := 0; i < len(chain)-1; i++ {
+			if m.crls.revoked(chain[i], chain[i+1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *MTLSAuth) principal(cert *x509.Certificate) (*CertificatePrincipal, error) {
+	var principal *CertificatePrincipal = &CertificatePrincipal{
+		CommonName:  cert.Subject.CommonName,
+		DNSNames:    cert.DNSNames,
+		Certificate: cert,
+	}
+	spiffeID, err := spiffeIDOf(cert)
+	if err != nil {
+		return nil, newTokenError(ReasonInvalidToken, "%v", err)
+	}
+	if spiffeID != nil {
+		principal.SPIFFEID = spiffeID.String()
+		principal.TrustDomain = spiffeID.Host
+	}
+	if len(m.trustDomains) > 0 && !slices.Contains(m.trustDomains, principal.TrustDomain) {
+		return nil, newTokenError(ReasonInvalidToken, "client certificate is not from a trusted SPIFFE trust domain")
+	}
+
+	switch {
+	case principal.SPIFFEID != "":
+		principal.Identity = principal.SPIFFEID
+	case principal.CommonName != "":
+		principal.Identity = principal.CommonName
+	case len(principal.DNSNames) > 0:
+		principal.Identity = principal.DNSNames[0]
+	}
+	principal.Roles = m.rolesOf(principal.Identity)
+
+	if m.mapper != nil {
+		if err := m.mapper(cert, principal); err != nil {
+			return nil, newTokenError(ReasonInvalidToken, "%v", err)
+		}
+	}
+	return principal, nil
+}
+
+// spiffeIDOf returns the SPIFFE ID of a certificate. The SPIFFE X.509-SVID
+// spec allows exactly one spiffe URI SAN.
+func spiffeIDOf(cert *x509.Certificate) (*url.URL, error) {
+	var spiffeID *url.URL
+	for _, uri := range cert.URIs {
+		if uri.Scheme != "spiffe" {
+			continue
+		}
+		if spiffeID != nil {
+			return nil, fmt.Errorf("client certificate has more than one SPIFFE ID")
+		}
+		if uri.Host == "" || uri.User != nil || uri.RawQuery != "" || uri.Fragment != "" {
+			return nil, fmt.Errorf("client certificate has a malformed SPIFFE ID")
+		}
+		spiffeID = uri
+	}
+	return spiffeID, nil
+}
+
+func (m *MTLSAuth) rolesOf(identity string) []string {
+	if roles, exists := m.roles[identity]; exists {
+		return roles
+	}
+	var roles []string
+	for pattern, patternRoles := range m.roles {
+		if prefix, wildcard := strings.CutSuffix(pattern, "*"); wildcard && strings.HasPrefix(identity, prefix) {
+			roles = append(roles, patternRoles...)
+		}
+	}
+	return roles
+}
+
+// RBAC reports whether the certificate identity has one of the roles.
+func (m *MTLSAuth) RBAC(user any, allowedRoles []string) bool {
+	principal, ok := user.(*CertificatePrincipal)
+	if !ok {
+		return false
+	}
+	for _, role := range principal.Roles {
+		if slices.Contains(allowedRoles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Challenge is empty: client certificates are requested by the TLS layer,
+// not by an HTTP challenge.
+func (m *MTLSAuth) Challenge(err error) string {
+	return ""
+}
+
+func (m *MTLSAuth) GetTimeout() time.Duration {
+	return m.timeout
+}
+
+func (m *MTLSAuth) GetContextKey() any {
+	return m.contextKey
+}
+
+// Close stops reloading the CRL files.
+func (m *MTLSAuth) Close() {
+	for _, watcher := range m.crls.watchers {
+		watcher.Stop()
+	}
+}
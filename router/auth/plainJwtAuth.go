@@ -2,9 +2,7 @@ package auth
 
 import (
 	"crypto/ecdsa"
-	"fmt"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
 
@@ -12,87 +10,100 @@ import (
 )
 
 type jwtOptionsFunc func(*JwtAuth)
+
+// JwtAuth is safe for concurrent use: every request is parsed into a new
+// claims instance created by newClaims.
 type JwtAuth struct {
-	authKey     *ecdsa.PrivateKey
-	claimsType  plainClaimsInterface
-	tokenString string
-	options     *jwtAuthOptions
+	keys      KeyResolver
+	newClaims func() plainClaimsInterface
+	options   *jwtAuthOptions
 }
 type jwtAuthOptions struct {
 	Timeout    time.Duration
 	ContextKey any
+	Algorithms []string
+	Policy     ValidationPolicy
+	Revocation RevocationChecker
 }
 
 var jwtAuthInstance *JwtAuth
 var jwtOnce sync.Once
 
-func NewJwtAuth(secret *ecdsa.PrivateKey, claimsType plainClaimsInterface, options ...jwtOptionsFunc) *JwtAuth {
-	return initJwtAuth(secret, claimsType, options...)
+// NewJwtAuth creates a JWT auth verifying ES512 tokens with the public part
+// of secret. newClaims must return a new claims value on every call, e.g.
+// auth.NewPlainClaims.
+func NewJwtAuth[T plainClaimsInterface](secret *ecdsa.PrivateKey, newClaims func() T, options ...jwtOptionsFunc) *JwtAuth {
+	return initJwtAuth(verificationKeys(secret), plainFactory(newClaims), options...)
 }
 
-func NewSingletonJwtAuth(secret *ecdsa.PrivateKey, claimsType plainClaimsInterface, options ...jwtOptionsFunc) *JwtAuth {
-	jwtOnce.Do(func() { initJwtAuthInstance(secret, claimsType, options...) })
-	return jwtAuthInstance
+// NewJwtAuthWithKeys creates a JWT auth verifying tokens with the key that
+// matches their kid, e.g. a KeySet holding only public keys.
+func NewJwtAuthWithKeys[T plainClaimsInterface](keys KeyResolver, newClaims func() T, options ...jwtOptionsFunc) *JwtAuth {
+	return initJwtAuth(keys, plainFactory(newClaims), options...)
 }
 
-func (j *JwtAuth) Authorize(r *http.Request) (bool, error) {
-	var jwtTokenString string = r.Header.Get("Authorization")
-	if strings.TrimSpace(jwtTokenString) == "" {
-		return false, fmt.Errorf("authorization header is missing")
-	}
-	if !strings.HasPrefix(jwtTokenString, "Bearer ") {
-		return false, fmt.Errorf("authorization header must start with 'bearer '")
-	}
+func NewSingletonJwtAuth[T plainClaimsInterface](secret *ecdsa.PrivateKey, newClaims func() T, options ...jwtOptionsFunc) *JwtAuth {
+	jwtOnce.Do(func() { initJwtAuthInstance(verificationKeys(secret), plainFactory(newClaims), options...) })
+	return jwtAuthInstance
+}
 
-	var tokenParts []string = strings.SplitN(jwtTokenString, "Bearer ", 2)
-	if len(tokenParts) != 2 || strings.TrimSpace(tokenParts[1]) == "" {
-		return false, fmt.Errorf("bearer token is missing or malformed")
+func (j *JwtAuth) Authenticate(r *http.Request) (any, error) {
+	tokenString, err := ExtractBearerToken(r)
+	if err != nil {
+		return nil, err
 	}
+	return j.ParseToken(tokenString)
+}
 
-	var tokenString string = strings.TrimSpace(tokenParts[1])
-	j.tokenString = tokenString
-	return true, nil
+// ParseToken validates a token and returns its claims.
+func (j *JwtAuth) ParseToken(tokenString string) (jwt.Claims, error) {
+	return parseClaims(tokenString, j.newClaims(), j.keys, j.options.Algorithms, j.options.Policy)
 }
-func (j *JwtAuth) GetUser(r *http.Request) (any, error) {
-	var jwtToken *jwt.Token
-	var err error
 
-	jwtToken, err = jwt.ParseWithClaims(j.tokenString, j.claimsType, func(t *jwt.Token) (any, error) {
-		return &j.authKey.PublicKey, nil
-	})
+// IsRevoked consults the revocation checker with the claims returned by
+// Authenticate.
+func (j *JwtAuth) IsRevoked(user any) (bool, error) {
+	return isClaimsRevoked(j.options.Revocation, user)
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse token: %v", err)
+func (j *JwtAuth) GetTimeout() time.Duration {
+	return j.options.Timeout
+}
+func (j *JwtAuth) GetContextKey() any {
+	return j.options.ContextKey
+}
+func JwtAuthWithCustomTimeout(timeout time.Duration) jwtOptionsFunc {
+	return func(ja *JwtAuth) {
+		ja.options.Timeout = timeout
 	}
-
-	if !jwtToken.Valid {
-		return nil, fmt.Errorf("token is invalid")
+}
+func JwtAuthWithCustomContextKey(key any) jwtOptionsFunc {
+	return func(ja *JwtAuth) {
+		ja.options.ContextKey = key
 	}
+}
 
-	if jwtToken == nil {
-		return nil, fmt.Errorf("no token available")
+// JwtAuthWithValidationPolicy sets the registered claims checks.
+func JwtAuthWithValidationPolicy(policy ValidationPolicy) jwtOptionsFunc {
+	return func(ja *JwtAuth) {
+		ja.options.Policy = policy
 	}
-	var expirationTime *jwt.NumericDate
-	expirationTime, err = jwtToken.Claims.GetExpirationTime()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get expiration time: %v", err)
-	}
-	if expirationTime == nil {
-		return nil, fmt.Errorf("expiration time is missing")
+}
 
+// JwtAuthWithRevocationChecker makes the auth middlewares reject revoked
+// tokens.
+func JwtAuthWithRevocationChecker(checker RevocationChecker) jwtOptionsFunc {
+	return func(ja *JwtAuth) {
+		ja.options.Revocation = checker
 	}
-	if expirationTime.Before(time.Now()) {
-		return nil, fmt.Errorf("token has expired")
-	}
-
-	return jwtToken.Claims, nil
 }
 
-func (j *JwtAuth) GetTimeout() time.Duration {
-	return j.options.Timeout
-}
-func (j *JwtAuth) GetContextKey() any {
-	return j.options.ContextKey
+// JwtAuthWithAllowedAlgorithms restricts the accepted alg header values.
+// Tokens are always verified with the algorithm of the resolved key.
+func JwtAuthWithAllowedAlgorithms(algorithms ...string) jwtOptionsFunc {
+	return func(ja *JwtAuth) {
+		ja.options.Algorithms = algorithms
+	}
 }
 func WithCustomTimeout(timeout time.Duration) jwtRbacOptionsFunc {
 	return func(ja *RBACJwtAuth) {
@@ -105,16 +116,20 @@ func WithCustomContextKey(key any) jwtRbacOptionsFunc {
 	}
 }
 
-func initJwtAuthInstance(secret *ecdsa.PrivateKey, claimsType plainClaimsInterface, options ...jwtOptionsFunc) {
-	jwtAuthInstance = initJwtAuth(secret, claimsType, options...)
+func plainFactory[T plainClaimsInterface](newClaims func() T) func() plainClaimsInterface {
+	return func() plainClaimsInterface { return newClaims() }
+}
+
+func initJwtAuthInstance(keys KeyResolver, newClaims func() plainClaimsInterface, options ...jwtOptionsFunc) {
+	jwtAuthInstance = initJwtAuth(keys, newClaims, options...)
 }
-func initJwtAuth(secret *ecdsa.PrivateKey, claimsType plainClaimsInterface, options ...jwtOptionsFunc) *JwtAuth {
+func initJwtAuth(keys KeyResolver, newClaims func() plainClaimsInterface, options ...jwtOptionsFunc) *JwtAuth {
 	var jwtAuth *JwtAuth = &JwtAuth{
-		authKey:    secret,
-		claimsType: claimsType,
+		keys:      keys,
+		newClaims: newClaims,
 		options: &jwtAuthOptions{
-			Timeout:    time.Duration(DefaultContextTimeout),
-			ContextKey: DefaultContextKey,
+			Timeout: time.Duration(DefaultContextTimeout) * time.Second,
+			Policy:  DefaultValidationPolicy(),
 		},
 	}
 
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+const DefaultTenantClaim = "tenant"
+
+type userKey struct{}
+
+// userEntry builds the principal of the user on first use.
+type userEntry struct {
+	user      any
+	once      sync.Once
+	principal *Principal
+}
+
+// Principal is the typed view of the user returned by a provider. User is
+// the provider value itself, e.g. *RBACClaims, *APIKey or *ChainResult.
+type Principal struct {
+	Subject string
+	Roles   []string
+	Scopes  []string
+	Tenant  string
+	Claims  map[string]any
+	User    any
+}
+
+// ClaimsPrincipal is implemented by users whose raw claims are not their
+// JSON form, e.g. introspected tokens and OIDC identities.
+type ClaimsPrincipal interface {
+	GetClaims() map[string]any
+}
+
+// TenantPrincipal is implemented by users that know their tenant. Other
+// users are read from the "tenant" claim.
+type TenantPrincipal interface {
+	GetTenant() string
+}
+
+// NewPrincipal reads the subject, roles, scopes, tenant and claims of a
+// user returned by Authenticate.
+func NewPrincipal(user any) *Principal {
+	var principal *Principal = &Principal{User: user, Scopes: Scopes(user), Claims: rawClaims(user)}
+	if subject, ok := user.(interface{ GetSubject() (string, error) }); ok {
+		principal.Subject, _ = subject.GetSubject()
+	}
+	if roles, ok := user.(interface{ GetRoles() []string }); ok {
+		principal.Roles = roles.GetRoles()
+	}
+	if tenant, ok := user.(TenantPrincipal); ok {
+		principal.Tenant = tenant.GetTenant()
+	} else if tenant, ok := principal.Claims[DefaultTenantClaim].(string); ok {
+		principal.Tenant = tenant
+	}
+	return principal
+}
+
+func rawClaims(user any) map[string]any {
+	var claims map[string]any
+	switch u := user.(type) {
+	case nil:
+		return nil
+	case ClaimsPrincipal:
+		return u.GetClaims()
+	case map[string]any:
+		return u
+	case *ChainResult:
+		return rawClaims(u.User)
+	default:
+		if data, err := json.Marshal(user); err == nil {
+			_ = json.Unmarshal(data, &claims)
+		}
+	}
+	return claims
+}
+
+// ContextWithUser stores the user for UserFrom, PrincipalFrom and
+// ClaimsFrom. The auth middlewares call it for every provider.
+func ContextWithUser(ctx context.Context, user any) context.Context {
+	return context.WithValue(ctx, userKey{}, &userEntry{user: user})
+}
+
+// UserFrom returns the user stored by the auth middleware.
+func UserFrom(ctx context.Context) (any, bool) {
+	entry, ok := ctx.Value(userKey{}).(*userEntry)
+	if !ok {
+		return nil, false
+	}
+	return entry.user, true
+}
+
+// PrincipalFrom returns the principal of the user stored by the auth
+// middleware. It is built once per request.
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	entry, ok := ctx.Value(userKey{}).(*userEntry)
+	if !ok {
+		return nil, false
+	}
+	entry.once.Do(func() {
+		entry.principal = NewPrincipal(entry.user)
+	})
+	return entry.principal, true
+}
+
+// PrincipalFromContext returns the principal of the user stored under key,
+// or the one stored by the auth middleware when key is nil. It is the
+// PrincipalFrom counterpart of UserFromContext.
+func PrincipalFromContext(ctx context.Context, key any) (*Principal, bool) {
+	if key == nil {
+		return PrincipalFrom(ctx)
+	}
+	user := ctx.Value(key)
+	if user == nil {
+		return nil, false
+	}
+	return NewPrincipal(user), true
+}
+
+// ClaimsFrom returns the user stored by the auth middleware as T, e.g.
+// ClaimsFrom[*RBACClaims](ctx). For chains it also looks at the user of
+// every provider that authenticated the request.
+func ClaimsFrom[T any](ctx context.Context) (T, bool) {
+	var zero T
+	user, ok := UserFrom(ctx)
+	if !ok {
+		return zero, false
+	}
+	if claims, ok := user.(T); ok {
+		return claims, true
+	}
+	if result, ok := user.(*ChainResult); ok {
+		if claims, ok := result.User.(T); ok {
+			return claims, true
+		}
+		for _, name := range result.Providers {
+			if claims, ok := result.Users[name].(T); ok {
+				return claims, true
+			}
+		}
+	}
+	return zero, false
+}
+
+// UserFromContext returns the user stored under key, or the one stored by
+// the auth middleware when key is nil. Packages with a context key option
+// use it so the option keeps working for users stored by custom code.
+func UserFromContext(ctx context.Context, key any) (any, bool) {
+	if key == nil {
+		return UserFrom(ctx)
+	}
+	user := ctx.Value(key)
+	return user, user != nil
+}
@@ -4,98 +4,85 @@ import (
 	"crypto/ecdsa"
 	"fmt"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
 
 	"slices"
-
-	"github.com/golang-jwt/jwt/v5"
 )
 
 type jwtRbacOptionsFunc func(*RBACJwtAuth)
+
+// RBACJwtAuth is safe for concurrent use: every request is parsed into a new
+// claims instance created by newClaims, and roles are checked on the claims
+// returned by Authenticate.
 type RBACJwtAuth struct {
-	authKey     *ecdsa.PrivateKey
-	claimsType  rBACClaimsInterface
-	tokenString string
-	options     *jwtRbacAuthOptions
+	keys      KeyResolver
+	newClaims func() rBACClaimsInterface
+	options   *jwtRbacAuthOptions
 }
 type jwtRbacAuthOptions struct {
 	Timeout    time.Duration
 	ContextKey any
+	Algorithms []string
+	Policy     ValidationPolicy
+	Revocation RevocationChecker
+	Hierarchy  RoleHierarchy
 }
 
 var jwtRbacAuthInstance *RBACJwtAuth
 var jwtRbacOnce sync.Once
 
-func NewJwtAuthWithRbac(secret *ecdsa.PrivateKey, claimsType rBACClaimsInterface, options ...jwtRbacOptionsFunc) *RBACJwtAuth {
-	return initJwtAuthRbac(secret, claimsType, options...)
+// NewJwtAuthWithRbac creates a JWT auth with roles. newClaims must return a
+// new claims value on every call, e.g. auth.NewRBACClaims.
+func NewJwtAuthWithRbac[T rBACClaimsInterface](secret *ecdsa.PrivateKey, newClaims func() T, options ...jwtRbacOptionsFunc) *RBACJwtAuth {
+	return initJwtAuthRbac(verificationKeys(secret), rbacFactory(newClaims), options...)
 }
 
-func NewSingletonJwtAuthWithRbac(secret *ecdsa.PrivateKey, claimsType rBACClaimsInterface, options ...jwtRbacOptionsFunc) *RBACJwtAuth {
-	jwtRbacOnce.Do(func() { initJwtAuthRbacInstance(secret, claimsType, options...) })
-	return jwtRbacAuthInstance
+// NewJwtAuthWithRbacAndKeys creates a JWT auth with roles verifying tokens
+// with the key that matches their kid.
+func NewJwtAuthWithRbacAndKeys[T rBACClaimsInterface](keys KeyResolver, newClaims func() T, options ...jwtRbacOptionsFunc) *RBACJwtAuth {
+	return initJwtAuthRbac(keys, rbacFactory(newClaims), options...)
 }
 
-func (j *RBACJwtAuth) Authorize(r *http.Request) (bool, error) {
-	var jwtTokenString string = r.Header.Get("Authorization")
-	if strings.TrimSpace(jwtTokenString) == "" {
-		return false, fmt.Errorf("authorization header is missing")
-	}
-	if !strings.HasPrefix(jwtTokenString, "Bearer ") {
-		return false, fmt.Errorf("authorization header must start with 'bearer '")
-	}
-
-	var tokenParts []string = strings.SplitN(jwtTokenString, "Bearer ", 2)
-	if len(tokenParts) != 2 || strings.TrimSpace(tokenParts[1]) == "" {
-		return false, fmt.Errorf("bearer token is missing or malformed")
-	}
-
-	var tokenString string = strings.TrimSpace(tokenParts[1])
-	j.tokenString = tokenString
-	return true, nil
+func NewSingletonJwtAuthWithRbac[T rBACClaimsInterface](secret *ecdsa.PrivateKey, newClaims func() T, options ...jwtRbacOptionsFunc) *RBACJwtAuth {
+	jwtRbacOnce.Do(func() { initJwtAuthRbacInstance(verificationKeys(secret), rbacFactory(newClaims), options...) })
+	return jwtRbacAuthInstance
 }
-func (j *RBACJwtAuth) GetUser(r *http.Request) (any, error) {
-	var jwtToken *jwt.Token
-	var err error
-
-	jwtToken, err = jwt.ParseWithClaims(j.tokenString, j.claimsType, func(t *jwt.Token) (any, error) {
-		return &j.authKey.PublicKey, nil
-	})
 
+func (j *RBACJwtAuth) Authenticate(r *http.Request) (any, error) {
+	tokenString, err := ExtractBearerToken(r)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse token: %v", err)
-	}
-
-	if !jwtToken.Valid {
-		return nil, fmt.Errorf("token is invalid")
+		return nil, err
 	}
+	return j.ParseToken(tokenString)
+}
 
-	if jwtToken == nil {
-		return nil, fmt.Errorf("no token available")
-	}
-	var expirationTime *jwt.NumericDate
-	expirationTime, err = jwtToken.Claims.GetExpirationTime()
+// ParseToken validates a token and returns its claims.
+func (j *RBACJwtAuth) ParseToken(tokenString string) (rBACClaimsInterface, error) {
+	claims, err := parseClaims(tokenString, j.newClaims(), j.keys, j.options.Algorithms, j.options.Policy)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get expiration time: %v", err)
+		return nil, err
 	}
-	if expirationTime == nil {
-		return nil, fmt.Errorf("expiration time is missing")
-
+	rbacClaims, ok := claims.(rBACClaimsInterface)
+	if !ok {
+		return nil, fmt.Errorf("claims do not carry roles")
 	}
-	if expirationTime.Before(time.Now()) {
-		return nil, fmt.Errorf("token has expired")
-	}
-
-	return jwtToken.Claims, nil
+	return rbacClaims, nil
 }
-func (j *RBACJwtAuth) RBAC(allowedRoles []string) bool {
 
-	var roles []string = j.claimsType.GetRoles()
-	if len(roles) == 0 {
+// RBAC reports whether the user returned by Authenticate has one of the
+// allowed roles, directly or through the role hierarchy.
+func (j *RBACJwtAuth) RBAC(user any, allowedRoles []string) bool {
+	claims, ok := user.(rBACClaimsInterface)
+	if !ok {
 		return false
 	}
-	for _, role := range roles {
+	if j.options.Hierarchy != nil {
+		return slices.ContainsFunc(allowedRoles, func(role string) bool {
+			return j.options.Hierarchy.HasRole(claims.GetRoles(), role)
+		})
+	}
+	for _, role := range claims.GetRoles() {
 		if slices.Contains(allowedRoles, role) {
 			return true
 		}
@@ -103,6 +90,12 @@ func (j *RBACJwtAuth) RBAC(allowedRoles []string) bool {
 	return false
 }
 
+// IsRevoked consults the revocation checker with the claims returned by
+// Authenticate.
+func (j *RBACJwtAuth) IsRevoked(user any) (bool, error) {
+	return isClaimsRevoked(j.options.Revocation, user)
+}
+
 func (j *RBACJwtAuth) GetTimeout() time.Duration {
 	return j.options.Timeout
 }
@@ -120,16 +113,49 @@ func JwtAuthRbacWithCustomContextKey(key any) jwtRbacOptionsFunc {
 	}
 }
 
-func initJwtAuthRbacInstance(secret *ecdsa.PrivateKey, claimsType rBACClaimsInterface, options ...jwtRbacOptionsFunc) {
-	jwtRbacAuthInstance = initJwtAuthRbac(secret, claimsType, options...)
+// JwtAuthRbacWithValidationPolicy sets the registered claims checks.
+func JwtAuthRbacWithValidationPolicy(policy ValidationPolicy) jwtRbacOptionsFunc {
+	return func(ja *RBACJwtAuth) {
+		ja.options.Policy = policy
+	}
+}
+
+// JwtAuthRbacWithRevocationChecker makes the auth middlewares reject
+// revoked tokens.
+func JwtAuthRbacWithRevocationChecker(checker RevocationChecker) jwtRbacOptionsFunc {
+	return func(ja *RBACJwtAuth) {
+		ja.options.Revocation = checker
+	}
+}
+
+// JwtAuthRbacWithRoleHierarchy makes RBAC accept roles that inherit the
+// allowed ones, e.g. admin on a route for editors.
+func JwtAuthRbacWithRoleHierarchy(hierarchy RoleHierarchy) jwtRbacOptionsFunc {
+	return func(ja *RBACJwtAuth) {
+		ja.options.Hierarchy = hierarchy
+	}
+}
+
+func JwtAuthRbacWithAllowedAlgorithms(algorithms ...string) jwtRbacOptionsFunc {
+	return func(ja *RBACJwtAuth) {
+		ja.options.Algorithms = algorithms
+	}
+}
+
+func rbacFactory[T rBACClaimsInterface](newClaims func() T) func() rBACClaimsInterface {
+	return func() rBACClaimsInterface { return newClaims() }
+}
+
+func initJwtAuthRbacInstance(keys KeyResolver, newClaims func() rBACClaimsInterface, options ...jwtRbacOptionsFunc) {
+	jwtRbacAuthInstance = initJwtAuthRbac(keys, newClaims, options...)
 }
-func initJwtAuthRbac(secret *ecdsa.PrivateKey, claimsType rBACClaimsInterface, options ...jwtRbacOptionsFunc) *RBACJwtAuth {
+func initJwtAuthRbac(keys KeyResolver, newClaims func() rBACClaimsInterface, options ...jwtRbacOptionsFunc) *RBACJwtAuth {
 	var jwtAuth *RBACJwtAuth = &RBACJwtAuth{
-		authKey:    secret,
-		claimsType: claimsType,
+		keys:      keys,
+		newClaims: newClaims,
 		options: &jwtRbacAuthOptions{
-			Timeout:    time.Duration(DefaultContextTimeout),
-			ContextKey: DefaultContextKey,
+			Timeout: time.Duration(DefaultContextTimeout) * time.Second,
+			Policy:  DefaultValidationPolicy(),
 		},
 	}
 
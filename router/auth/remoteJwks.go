@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/logger"
+	"github.com/angelbarreiros/Penguin/scheduler"
+)
+
+const (
+	DefaultJWKSRefreshInterval    = 15 * time.Minute
+	DefaultJWKSMinRefetchInterval = time.Minute
+	maxJWKSBytes                  = 1 << 20
+)
+
+type remoteKeySetOptionsFunc func(*RemoteKeySet)
+
+// RemoteKeySet verifies tokens with the keys published by a JWKS endpoint.
+// Keys are cached and refreshed in the background by the scheduler; an
+// unknown kid triggers a refetch, at most once per minRefetchInterval, so
+// tokens signed with a freshly rotated key are accepted immediately.
+type RemoteKeySet struct {
+	mu                 sync.RWMutex
+	url                string
+	client             *http.Client
+	keys               *KeySet
+	fetchedAt          time.Time
+	refreshInterval    time.Duration
+	minRefetchInterval time.Duration
+
+	fetchMu     sync.Mutex
+	lastAttempt time.Time
+
+	jobID   uint64
+	cleaner *scheduler.Scheduler
+}
+
+// NewRemoteKeySet fetches the JWKS once and schedules its refresh.
+func NewRemoteKeySet(url string, options ...remoteKeySetOptionsFunc) (*RemoteKeySet, error) {
+	var remote *RemoteKeySet = &RemoteKeySet{
+		url:                url,
+		client:             &http.Client{Timeout: 10 * time.Second},
+		keys:               &KeySet{keys: make(map[string]Key)},
+		refreshInterval:    DefaultJWKSRefreshInterval,
+		minRefetchInterval: DefaultJWKSMinRefetchInterval,
+	}
+	for _, option := range options {
+		option(remote)
+	}
+
+	if err := remote.Refresh(); err != nil {
+		return nil, err
+	}
+
+	remote.cleaner = scheduler.StartScheduler()
+	jobID, err := remote.cleaner.ScheduleIntervalJob(remote.refreshInterval, scheduler.JobFunction(remote))
+	if err != nil {
+		return nil, err
+	}
+	remote.jobID = jobID
+	return remote, nil
+}
+
+// RemoteKeySetWithClient sets the HTTP client, e.g. one built with the
+// client package.
+func RemoteKeySetWithClient(client *http.Client) remoteKeySetOptionsFunc {
+	return func(r *RemoteKeySet) {
+		r.client = client
+	}
+}
+
+func RemoteKeySetWithRefreshInterval(interval time.Duration) remoteKeySetOptionsFunc {
+	return func(r *RemoteKeySet) {
+		if interval > 0 {
+			r.refreshInterval = interval
+		}
+	}
+}
+
+// RemoteKeySetWithMinRefetchInterval limits how often an unknown kid can
+// trigger a refetch.
+func RemoteKeySetWithMinRefetchInterval(interval time.Duration) remoteKeySetOptionsFunc {
+	return func(r *RemoteKeySet) {
+		r.minRefetchInterval = interval
+	}
+}
+
+// Execute refreshes the keys from the scheduler.
+func (r *RemoteKeySet) Execute() []any {
+	if err := r.Refresh(); err != nil {
+		logger.GetConsoleLogger().Error("Failed to refresh JWKS from %s: %v", r.url, err)
+		return []any{err}
+	}
+	return nil
+}
+
+// Refresh fetches the JWKS. On error the cached keys are kept.
+func (r *RemoteKeySet) Refresh() error {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+	return r.fetchLocked()
+}
+
+func (r *RemoteKeySet) fetchLocked() error {
+	r.lastAttempt = time.Now()
+
+	response, err := r.client.Get(r.url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch JWKS: unexpected status %d", response.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxJWKSBytes))
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS: %w", err)
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.keys = keys
+	r.fetchedAt = time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+// ResolveKey returns the cached key, refetching the JWKS once when the kid
+// is unknown and the last fetch is older than the minimum refetch interval.
+func (r *RemoteKeySet) ResolveKey(kid string, algorithm string) (Key, error) {
+	key, err := r.current().ResolveKey(kid, algorithm)
+	if err == nil || kid == "" || r.has(kid) {
+		return key, err
+	}
+
+	r.fetchMu.Lock()
+	if !r.has(kid) && time.Since(r.lastAttempt) >= r.minRefetchInterval {
+		if fetchErr := r.fetchLocked(); fetchErr != nil {
+			logger.GetConsoleLogger().Warn("Failed to refetch JWKS from %s for kid '%s': %v", r.url, kid, fetchErr)
+		}
+	}
+	r.fetchMu.Unlock()
+	return r.current().ResolveKey(kid, algorithm)
+}
+
+func (r *RemoteKeySet) current() *KeySet {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.keys
+}
+
+func (r *RemoteKeySet) has(kid string) bool {
+	var keys *KeySet = r.current()
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+	_, exists := keys.keys[kid]
+	return exists
+}
+
+// Keys returns the cached keys.
+func (r *RemoteKeySet) Keys() []Key {
+	return r.current().Keys()
+}
+
+func (r *RemoteKeySet) FetchedAt() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.fetchedAt
+}
+
+// Close stops the background refresh.
+func (r *RemoteKeySet) Close() {
+	if r == nil || r.jobID == 0 {
+		return
+	}
+	_ = r.cleaner.RemoveJob(r.jobID)
+	r.jobID = 0
+}
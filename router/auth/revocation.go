@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/logger"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	ReasonRevoked TokenErrorReason = "revoked"
+
+	// DefaultMaxTokenLifetime bounds how long a subject revocation is kept:
+	// tokens issued before it have expired once it is dropped.
+	DefaultMaxTokenLifetime = 24 * time.Hour
+)
+
+// ErrRevocationUnavailable wraps checker failures. Requests are rejected
+// when the revocation state cannot be read.
+var ErrRevocationUnavailable = errors.New("revocation state is unavailable")
+
+// RevocationChecker tells whether a token was revoked, either by its jti or
+// because its subject revoked every token issued up to a point in time.
+type RevocationChecker interface {
+	IsRevoked(jti string, subject string, issuedAt time.Time) (bool, error)
+}
+
+// Revoker records revocations. expiresAt is the expiry of the token, after
+// which the entry is no longer needed.
+type Revoker interface {
+	RevokeToken(jti string, expiresAt time.Time) error
+	RevokeSubject(subject string, before time.Time) error
+}
+
+// RevocableAuthInterface is implemented by providers that can report
+// revoked credentials. The auth middlewares call IsRevoked with the user
+// returned by Authenticate.
+type RevocableAuthInterface interface {
+	IsRevoked(user any) (bool, error)
+}
+
+// CheckRevocation returns a TokenError with ReasonRevoked when the provider
+// reports the user as revoked, and ErrRevocationUnavailable when the check
+// fails. Providers without revocation support always pass.
+func CheckRevocation(provider any, user any) error {
+	revocable, ok := provider.(RevocableAuthInterface)
+	if !ok {
+		return nil
+	}
+	revoked, err := revocable.IsRevoked(user)
+	if err != nil {
+		logger.GetConsoleLogger().Error("Failed to check token revocation: %v", err)
+		return errors.Join(ErrRevocationUnavailable, err)
+	}
+	if revoked {
+		return newTokenError(ReasonRevoked, "token has been revoked")
+	}
+	return nil
+}
+
+// isClaimsRevoked consults checker with the jti, sub and iat of claims.
+func isClaimsRevoked(checker RevocationChecker, user any) (bool, error) {
+	if checker == nil {
+		return false, nil
+	}
+	claims, ok := user.(jwt.Claims)
+	if !ok {
+		return false, nil
+	}
+	subject, _ := claims.GetSubject()
+	var issuedAt time.Time
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		issuedAt = iat.Time
+	}
+	return checker.IsRevoked(TokenID(claims), subject, issuedAt)
+}
+
+// TokenID returns the jti of claims. Claims embedding jwt.RegisteredClaims
+// expose it through their JSON form.
+func TokenID(claims jwt.Claims) string {
+	switch c := claims.(type) {
+	case interface{ GetID() string }:
+		return c.GetID()
+	case *jwt.RegisteredClaims:
+		return c.ID
+	}
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return ""
+	}
+	var registered struct {
+		ID string `json:"jti"`
+	}
+	if err := json.Unmarshal(data, &registered); err != nil {
+		return ""
+	}
+	return registered.ID
+}
+
+type denylistOptionsFunc func(*MemoryDenylist)
+
+// MemoryDenylist keeps revocations in string caches. Token entries expire
+// with the token; subject entries expire after the maximum token lifetime.
+type MemoryDenylist struct {
+	tokens           helpers.StringCache[time.Time]
+	subjects         helpers.StringCache[time.Time]
+	maxTokenLifetime time.Duration
+}
+
+func NewMemoryDenylist(options ...denylistOptionsFunc) *MemoryDenylist {
+	var denylist *MemoryDenylist = &MemoryDenylist{
+		tokens:           helpers.NewStringCache[time.Time](),
+		subjects:         helpers.NewStringCache[time.Time](),
+		maxTokenLifetime: DefaultMaxTokenLifetime,
+	}
+	for _, option := range options {
+		option(denylist)
+	}
+	return denylist
+}
+
+// DenylistWithMaxTokenLifetime sets the longest lifetime of the tokens
+// issued, used to expire subject revocations and tokens without exp.
+func DenylistWithMaxTokenLifetime(lifetime time.Duration) denylistOptionsFunc {
+	return func(d *MemoryDenylist) {
+		if lifetime > 0 {
+			d.maxTokenLifetime = lifetime
+		}
+	}
+}
+
+func (d *MemoryDenylist) RevokeToken(jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return errors.New("jti is required")
+	}
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().Add(d.maxTokenLifetime)
+	}
+	if ttl := time.Until(expiresAt); ttl > 0 {
+		d.tokens.Store(jti, expiresAt, ttl)
+	}
+	return nil
+}
+
+// RevokeSubject revokes every token of subject issued before before. iat
+// has second precision, so before is truncated to the second: tokens issued
+// in the same second, such as the one issued right after a password change,
+// stay valid. A later revocation replaces an earlier one.
+func (d *MemoryDenylist) RevokeSubject(subject string, before time.Time) error {
+	if subject == "" {
+		return errors.New("subject is required")
+	}
+	if before.IsZero() {
+		before = time.Now()
+	}
+	before = before.Truncate(time.Second)
+	if current, exists := d.subjects.Load(subject); exists && current.After(before) {
+		return nil
+	}
+	if ttl := time.Until(before.Add(d.maxTokenLifetime)); ttl > 0 {
+		d.subjects.Store(subject, before, ttl)
+	}
+	return nil
+}
+
+// RevokeClaims revokes the token the claims were parsed from.
+func (d *MemoryDenylist) RevokeClaims(claims jwt.Claims) error {
+	var expiresAt time.Time
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		expiresAt = exp.Time
+	}
+	return d.RevokeToken(TokenID(claims), expiresAt)
+}
+
+// IsRevoked reports tokens denied by jti and tokens of a revoked subject
+// issued before the second of the revocation. Tokens without iat are considered
+// issued before it.
+func (d *MemoryDenylist) IsRevoked(jti string, subject string, issuedAt time.Time) (bool, error) {
+	if jti != "" && d.tokens.Has(jti) {
+		return true, nil
+	}
+	if subject == "" {
+		return false, nil
+	}
+	before, exists := d.subjects.Load(subject)
+	if !exists {
+		return false, nil
+	}
+	return issuedAt.IsZero() || issuedAt.Before(before), nil
+}
+
+// Revocations is the content of a denylist: the expiry of each revoked jti
+// and the revoked-before time of each subject.
+type Revocations struct {
+	Tokens   map[string]time.Time `json:"tokens"`
+	Subjects map[string]time.Time `json:"subjects"`
+}
+
+func (d *MemoryDenylist) Revocations() Revocations {
+	return Revocations{Tokens: d.tokens.GetAll(), Subjects: d.subjects.GetAll()}
+}
+
+type revocationRequest struct {
+	JTI       string    `json:"jti"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Subject   string    `json:"subject"`
+	Before    time.Time `json:"before"`
+}
+
+// RevocationAdminHandler is the admin API of a revoker. POST revokes a
+// token with {"jti": "...", "expiresAt": "..."} or a subject with
+// {"subject": "...", "before": "..."}, before defaulting to now. GET lists
+// the revocations when the revoker is a MemoryDenylist. Protect it with an
+// auth middleware.
+func RevocationAdminHandler(revoker Revoker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			lister, ok := revoker.(interface{ Revocations() Revocations })
+			if !ok {
+				helpers.SendErrorResponse(w, http.StatusMethodNotAllowed, "Listing revocations is not supported")
+				return
+			}
+			helpers.SendSuccessResponse(w, lister.Revocations())
+		case http.MethodPost:
+			var request revocationRequest
+			if err := helpers.DeserializeBodyWithLimit(r, &request, 1<<16); err != nil {
+				helpers.SendErrorResponse(w, http.StatusBadRequest, "Invalid revocation request")
+				return
+			}
+			var err error
+			switch {
+			case request.JTI != "":
+				err = revoker.RevokeToken(request.JTI, request.ExpiresAt)
+			case request.Subject != "":
+				err = revoker.RevokeSubject(request.Subject, request.Before)
+			default:
+				helpers.SendErrorResponse(w, http.StatusBadRequest, "jti or subject is required")
+				return
+			}
+			if err != nil {
+				helpers.SendErrorResponse(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			logger.GetConsoleLogger().Info("Revoked jti '%s' subject '%s'", request.JTI, request.Subject)
+			helpers.SendNoContentResponse(w)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			helpers.SendErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
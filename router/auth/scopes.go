@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+)
+
+// ScopedPrincipal is implemented by users that carry OAuth2 scopes, e.g.
+// introspected tokens and API keys.
+type ScopedPrincipal interface {
+	GetScopes() []string
+}
+
+// Scopes returns the scopes of a user returned by Authenticate. Users that
+// are not a ScopedPrincipal are read as JWT claims: the space separated
+// "scope" claim of RFC 8693, or the "scp" claim as a string or a list.
+func Scopes(user any) []string {
+	switch u := user.(type) {
+	case nil:
+		return nil
+	case ScopedPrincipal:
+		return u.GetScopes()
+	}
+
+	var claims map[string]any = rawClaims(user)
+	var scopes []string
+	for _, name := range []string{"scope", "scp"} {
+		switch value := claims[name].(type) {
+		case string:
+			scopes = append(scopes, strings.Fields(value)...)
+		case []any:
+			for _, item := range value {
+				if scope, ok := item.(string); ok {
+					scopes = append(scopes, scope)
+				}
+			}
+		}
+	}
+	return scopes
+}
+
+// HasScopes reports whether the user was granted every required scope.
+func HasScopes(user any, required ...string) bool {
+	var granted []string = Scopes(user)
+	for _, scope := range required {
+		if !slices.Contains(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// InsufficientScopeChallenge builds the RFC 6750 WWW-Authenticate value of
+// a 403 for a token that lacks the scopes.
+func InsufficientScopeChallenge(realm string, scopes []string) string {
+	var params []string
+	if realm != "" {
+		params = append(params, `realm="`+quoteChallenge(realm)+`"`)
+	}
+	params = append(params,
+		`error="`+BearerErrorInsufficientScope+`"`,
+		`error_description="the request requires higher privileges than provided by the access token"`,
+		`scope="`+quoteChallenge(strings.Join(scopes, " "))+`"`)
+	return "Bearer " + strings.Join(params, ", ")
+}
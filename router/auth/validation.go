@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ValidationPolicy describes which registered claims a token must satisfy.
+// Zero values disable a check, except RequireExpiration which the default
+// policy enables.
+type ValidationPolicy struct {
+	Issuer            string
+	Audiences         []string
+	Leeway            time.Duration
+	MaxAge            time.Duration
+	RequiredClaims    []string
+	RequireExpiration bool
+}
+
+func DefaultValidationPolicy() ValidationPolicy {
+	return ValidationPolicy{RequireExpiration: true}
+}
+
+type TokenErrorReason string
+
+const (
+	ReasonMissingToken     TokenErrorReason = "missing_token"
+	ReasonMalformedRequest TokenErrorReason = "malformed_request"
+	ReasonMalformedToken   TokenErrorReason = "malformed_token"
+	ReasonInvalidSignature TokenErrorReason = "invalid_signature"
+	ReasonUnknownKey       TokenErrorReason = "unknown_key"
+	ReasonExpired          TokenErrorReason = "expired"
+	ReasonNotYetValid      TokenErrorReason = "not_yet_valid"
+	ReasonIssuedInFuture   TokenErrorReason = "issued_in_future"
+	ReasonTooOld           TokenErrorReason = "too_old"
+	ReasonWrongIssuer      TokenErrorReason = "wrong_issuer"
+	ReasonWrongAudience    TokenErrorReason = "wrong_audience"
+	ReasonMissingClaim     TokenErrorReason = "missing_claim"
+	ReasonInvalidToken     TokenErrorReason = "invalid_token"
+)
+
+// TokenError is returned by Authenticate and ParseToken. Reason tells why the
+// token was rejected; BearerChallenge maps it to RFC 6750.
+type TokenError struct {
+	Reason      TokenErrorReason
+	Description string
+	Err         error
+}
+
+func (e *TokenError) Error() string {
+	return e.Description
+}
+
+func (e *TokenError) Unwrap() error {
+	return e.Err
+}
+
+func newTokenError(reason TokenErrorReason, format string, args ...any) *TokenError {
+	return &TokenError{Reason: reason, Description: fmt.Sprintf(format, args...)}
+}
+
+// TokenErrorReasonOf returns the reason of a *TokenError in the chain.
+func TokenErrorReasonOf(err error) (TokenErrorReason, bool) {
+	var tokenError *TokenError
+	if errors.As(err, &tokenError) {
+		return tokenError.Reason, true
+	}
+	return "", false
+}
+
+// RFC 6750 section 3.1 error codes.
+const (
+	BearerErrorInvalidRequest    = "invalid_request"
+	BearerErrorInvalidToken      = "invalid_token"
+	BearerErrorInsufficientScope = "insufficient_scope"
+)
+
+// BearerError returns the RFC 6750 error code of an authentication error.
+// Requests without credentials get no error code.
+func BearerError(err error) string {
+	reason, ok := TokenErrorReasonOf(err)
+	if !ok {
+		return BearerErrorInvalidToken
+	}
+	switch reason {
+	case ReasonMissingToken:
+		return ""
+	case ReasonMalformedRequest:
+		return BearerErrorInvalidRequest
+	default:
+		return BearerErrorInvalidToken
+	}
+}
+
+// BearerChallenge builds the WWW-Authenticate header value for an
+// authentication error, e.g. `Bearer error="invalid_token",
+// error_description="token has expired"`.
+func BearerChallenge(realm string, err error) string {
+	var params []string
+	if realm != "" {
+		params = append(params, `realm="`+quoteChallenge(realm)+`"`)
+	}
+	if code := BearerError(err); code != "" {
+		params = append(params, `error="`+code+`"`)
+		params = append(params, `error_description="`+quoteChallenge(err.Error())+`"`)
+	}
+	if len(params) == 0 {
+		return "Bearer"
+	}
+	return "Bearer " + strings.Join(params, ", ")
+}
+
+func quoteChallenge(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+}
+
+func (p ValidationPolicy) parserOptions(algorithms []string) []jwt.ParserOption {
+	var options []jwt.ParserOption = []jwt.ParserOption{jwt.WithIssuedAt()}
+	if len(algorithms) > 0 {
+		options = append(options, jwt.WithValidMethods(algorithms))
+	}
+	if p.Issuer != "" {
+		options = append(options, jwt.WithIssuer(p.Issuer))
+	}
+	if len(p.Audiences) > 0 {
+		options = append(options, jwt.WithAudience(p.Audiences...))
+	}
+	if p.Leeway > 0 {
+		options = append(options, jwt.WithLeeway(p.Leeway))
+	}
+	if p.RequireExpiration {
+		options = append(options, jwt.WithExpirationRequired())
+	}
+	return options
+}
+
+// validateExtra enforces the checks the jwt parser does not cover.
+func (p ValidationPolicy) validateExtra(token *jwt.Token) error {
+	if p.MaxAge > 0 {
+		issuedAt, err := token.Claims.GetIssuedAt()
+		if err != nil || issuedAt == nil {
+			return newTokenError(ReasonMissingClaim, "token is missing the iat claim")
+		}
+		if time.Since(issuedAt.Time) > p.MaxAge+p.Leeway {
+			return newTokenError(ReasonTooOld, "token is older than %s", p.MaxAge)
+		}
+	}
+	if len(p.RequiredClaims) > 0 {
+		var claims map[string]any
+		if err := decodePayload(token.Raw, &claims); err != nil {
+			return newTokenError(ReasonMalformedToken, "failed to decode token claims: %v", err)
+		}
+		for _, name := range p.RequiredClaims {
+			if value, exists := claims[name]; !exists || value == nil || value == "" {
+				return newTokenError(ReasonMissingClaim, "token is missing the %s claim", name)
+			}
+		}
+	}
+	return nil
+}
+
+func decodePayload(raw string, claims *map[string]any) error {
+	var parts []string = strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("token must have three segments")
+	}
+	data, err := jwt.NewParser().DecodeSegment(parts[1])
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, claims)
+}
+
+// classifyParseError maps jwt parser errors to token error reasons.
+func classifyParseError(err error) *TokenError {
+	var reasons = []struct {
+		target error
+		reason TokenErrorReason
+		text   string
+	}{
+		{jwt.ErrTokenMalformed, ReasonMalformedToken, "token is malformed"},
+		{jwt.ErrTokenUnverifiable, ReasonUnknownKey, "token cannot be verified"},
+		{jwt.ErrTokenSignatureInvalid, ReasonInvalidSignature, "token signature is invalid"},
+		{jwt.ErrTokenExpired, ReasonExpired, "token has expired"},
+		{jwt.ErrTokenNotValidYet, ReasonNotYetValid, "token is not valid yet"},
+		{jwt.ErrTokenUsedBeforeIssued, ReasonIssuedInFuture, "token was issued in the future"},
+		{jwt.ErrTokenInvalidIssuer, ReasonWrongIssuer, "token has a wrong issuer"},
+		{jwt.ErrTokenInvalidAudience, ReasonWrongAudience, "token has a wrong audience"},
+		{jwt.ErrTokenRequiredClaimMissing, ReasonMissingClaim, "token is missing a required claim"},
+	}
+	for _, candidate := range reasons {
+		if errors.Is(err, candidate.target) {
+			return &TokenError{Reason: candidate.reason, Description: candidate.text, Err: err}
+		}
+	}
+	return &TokenError{Reason: ReasonInvalidToken, Description: "failed to parse token: " + err.Error(), Err: err}
+}
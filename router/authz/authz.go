@@ -0,0 +1,440 @@
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/logger"
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+	"gopkg.in/yaml.v3"
+)
+
+type Effect string
+
+const (
+	EffectAllow Effect = "allow"
+	EffectDeny  Effect = "deny"
+)
+
+type Reason string
+
+const (
+	// ReasonRule: the rule named in the decision decided it.
+	ReasonRule Reason = "rule"
+	// ReasonNoMatch: no rule applies, which denies.
+	ReasonNoMatch Reason = "no_match"
+	// ReasonError: a deny rule could not be evaluated, which denies.
+	ReasonError Reason = "error"
+)
+
+var (
+	ErrDenied = errors.New("access denied")
+	// ErrResourceNotFound is returned by resolvers for missing resources;
+	// the middleware answers 404.
+	ErrResourceNotFound = errors.New("resource not found")
+)
+
+// ResourceResolver loads the resource a request targets, e.g. the order of
+// /orders/{id}, for the conditions of the rules.
+type ResourceResolver func(r *http.Request) (any, error)
+
+// DecisionLogger receives every decision of the engine.
+type DecisionLogger func(ctx context.Context, decision Decision)
+
+// Rule applies to the actions it lists when its condition holds. Actions
+// ending with "*" match by prefix, and no actions match every action.
+type Rule struct {
+	Name    string   `json:"name" yaml:"name"`
+	Effect  Effect   `json:"effect" yaml:"effect"`
+	Actions []string `json:"actions,omitempty" yaml:"actions,omitempty"`
+	When    string   `json:"when,omitempty" yaml:"when,omitempty"`
+
+	condition node
+}
+
+// Decision is the outcome of a check. With deny-overrides, any applicable
+// deny rule wins over allow rules, and nothing applicable denies.
+type Decision struct {
+	Action        string
+	Subject       string
+	Authenticated bool
+	Allowed       bool
+	Effect        Effect
+	Rule          string
+	Reason        Reason
+	Err           error
+}
+
+type policyFile struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+type engineOptionsFunc func(*Engine)
+
+type Engine struct {
+	mu             sync.RWMutex
+	rules          []Rule
+	file           string
+	reloadInterval time.Duration
+	watcher        *helpers.FileWatcher
+	userContextKey any
+	logDecision    DecisionLogger
+	err            error
+}
+
+var defaultEngine atomic.Pointer[Engine]
+
+func NewEngine(options ...engineOptionsFunc) (*Engine, error) {
+	var engine *Engine = &Engine{
+		reloadInterval: helpers.DefaultFileWatchInterval,
+		logDecision:    logDecision,
+	}
+
+	for _, option := range options {
+		option(engine)
+	}
+	if engine.err != nil {
+		return nil, engine.err
+	}
+
+	if engine.file != "" {
+		if err := engine.Reload(); err != nil {
+			return nil, err
+		}
+		watcher, err := helpers.WatchFile(engine.file, engine.reloadInterval, engine.Reload)
+		if err != nil {
+			return nil, err
+		}
+		engine.watcher = watcher
+	}
+	return engine, nil
+}
+
+// SetDefault makes the engine the one used by Check when the context was
+// not prepared by the authorization middleware.
+func SetDefault(engine *Engine) {
+	defaultEngine.Store(engine)
+}
+
+func Default() *Engine {
+	return defaultEngine.Load()
+}
+
+// EngineWithPolicyFile loads the rules from a JSON or YAML file (by
+// extension) and reloads it when it changes.
+func EngineWithPolicyFile(path string, reloadInterval time.Duration) engineOptionsFunc {
+	return func(e *Engine) {
+		e.file = path
+		if reloadInterval > 0 {
+			e.reloadInterval = reloadInterval
+		}
+	}
+}
+
+// EngineWithRules defines the rules in code. Rules loaded from a file
+// replace them.
+func EngineWithRules(rules []Rule) engineOptionsFunc {
+	return func(e *Engine) {
+		prepared, err := prepareRules(rules)
+		if err != nil {
+			e.err = err
+			return
+		}
+		e.rules = prepared
+	}
+}
+
+// EngineWithUserContextKey reads the user from a context key set by custom
+// code instead of the user stored by the auth middleware.
+func EngineWithUserContextKey(key any) engineOptionsFunc {
+	return func(e *Engine) {
+		e.userContextKey = key
+	}
+}
+
+// EngineWithDecisionLogger replaces the console logging of decisions, e.g.
+// to write an audit trail. nil disables decision logging.
+func EngineWithDecisionLogger(decisionLogger DecisionLogger) engineOptionsFunc {
+	return func(e *Engine) {
+		e.logDecision = decisionLogger
+	}
+}
+
+// Reload parses the policy file again. On error the previous rules are kept.
+func (e *Engine) Reload() error {
+	if e.file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(e.file)
+	if err != nil {
+		return fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	var definitions policyFile
+	switch strings.ToLower(filepath.Ext(e.file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &definitions)
+	default:
+		err = json.Unmarshal(data, &definitions)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse policy file: %w", err)
+	}
+
+	rules, err := prepareRules(definitions.Rules)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.rules = rules
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *Engine) Close() {
+	e.watcher.Stop()
+}
+
+func prepareRules(rules []Rule) ([]Rule, error) {
+	var prepared []Rule = make([]Rule, len(rules))
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i)
+		}
+		if rule.Effect != EffectAllow && rule.Effect != EffectDeny {
+			return nil, fmt.Errorf("rule '%s': effect must be '%s' or '%s'", rule.Name, EffectAllow, EffectDeny)
+		}
+		condition, err := compile(rule.When)
+		if err != nil {
+			return nil, fmt.Errorf("rule '%s': %w", rule.Name, err)
+		}
+		rule.Actions = append([]string(nil), rule.Actions...)
+		rule.condition = condition
+		prepared[i] = rule
+	}
+	return prepared, nil
+}
+
+func (r *Rule) appliesTo(action string) bool {
+	if len(r.Actions) == 0 {
+		return true
+	}
+	for _, pattern := range r.Actions {
+		if pattern == action || strings.HasSuffix(pattern, "*") && strings.HasPrefix(action, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Decide evaluates the rules for the user of the context. A deny rule that
+// fails to evaluate denies, an allow rule that fails does not apply.
+func (e *Engine) Decide(ctx context.Context, action string, resource any) Decision {
+	e.mu.RLock()
+	var rules []Rule = e.rules
+	e.mu.RUnlock()
+
+	var env map[string]any = e.environment(ctx, action, resource)
+	var decision Decision = Decision{Action: action, Reason: ReasonNoMatch}
+	if principal, ok := auth.PrincipalFromContext(ctx, e.userContextKey); ok {
+		decision.Authenticated = true
+		decision.Subject = principal.Subject
+	}
+
+	for i := range rules {
+		var rule *Rule = &rules[i]
+		if !rule.appliesTo(action) {
+			continue
+		}
+		matched, err := evalBool(rule.condition, env)
+		if err != nil {
+			if rule.Effect == EffectDeny {
+				decision.Allowed, decision.Effect, decision.Rule, decision.Reason, decision.Err = false, EffectDeny, rule.Name, ReasonError, err
+				break
+			}
+			continue
+		}
+		if !matched {
+			continue
+		}
+		if rule.Effect == EffectDeny {
+			decision.Allowed, decision.Effect, decision.Rule, decision.Reason, decision.Err = false, EffectDeny, rule.Name, ReasonRule, nil
+			break
+		}
+		if !decision.Allowed {
+			decision.Allowed, decision.Effect, decision.Rule, decision.Reason = true, EffectAllow, rule.Name, ReasonRule
+		}
+	}
+
+	if e.logDecision != nil {
+		e.logDecision(ctx, decision)
+	}
+	return decision
+}
+
+// Authenticated reports whether the context holds a user, i.e. whether a
+// denied action is a 403 rather than a 401.
+func (e *Engine) Authenticated(ctx context.Context) bool {
+	_, ok := auth.PrincipalFromContext(ctx, e.userContextKey)
+	return ok
+}
+
+// Check returns nil when the action is allowed and an error wrapping
+// ErrDenied otherwise.
+func (e *Engine) Check(ctx context.Context, action string, resource any) error {
+	return decisionError(e.Decide(ctx, action, resource))
+}
+
+func decisionError(decision Decision) error {
+	if decision.Allowed {
+		return nil
+	}
+	if decision.Rule != "" {
+		return fmt.Errorf("%w: %s by rule '%s'", ErrDenied, decision.Action, decision.Rule)
+	}
+	return fmt.Errorf("%w: %s", ErrDenied, decision.Action)
+}
+
+func logDecision(ctx context.Context, decision Decision) {
+	if decision.Allowed {
+		logger.GetConsoleLogger().Debug("Authorization allowed %s for '%s' by rule '%s'", decision.Action, decision.Subject, decision.Rule)
+		return
+	}
+	if decision.Err != nil {
+		logger.GetConsoleLogger().Warn("Authorization denied %s for '%s': rule '%s' failed: %v", decision.Action, decision.Subject, decision.Rule, decision.Err)
+		return
+	}
+	logger.GetConsoleLogger().Info("Authorization denied %s for '%s' (%s %s)", decision.Action, decision.Subject, decision.Reason, decision.Rule)
+}
+
+type requestKey struct{}
+type resourceKey struct{}
+
+type requestAttributes struct {
+	engine     *Engine
+	attributes map[string]any
+}
+
+var pathWildcard = regexp.MustCompile(`\{([^}.]+)(\.\.\.)?\}`)
+
+// ContextFromRequest returns the request context carrying the request
+// attributes of the conditions: method, path, params (the path values of
+// the matched pattern) and query (the first value of every parameter).
+func (e *Engine) ContextFromRequest(r *http.Request) context.Context {
+	var params map[string]any = make(map[string]any)
+	for _, match := range pathWildcard.FindAllStringSubmatch(r.Pattern, -1) {
+		params[match[1]] = r.PathValue(match[1])
+	}
+	var query map[string]any = make(map[string]any)
+	for name, values := range r.URL.Query() {
+		query[name] = values[0]
+	}
+	return context.WithValue(r.Context(), requestKey{}, requestAttributes{
+		engine: e,
+		attributes: map[string]any{
+			"method": r.Method,
+			"path":   r.URL.Path,
+			"params": params,
+			"query":  query,
+		},
+	})
+}
+
+// WithResource stores the resource loaded by the middleware so the handler
+// does not load it again.
+func WithResource(ctx context.Context, resource any) context.Context {
+	return context.WithValue(ctx, resourceKey{}, resource)
+}
+
+func Resource(ctx context.Context) any {
+	return ctx.Value(resourceKey{})
+}
+
+func (e *Engine) environment(ctx context.Context, action string, resource any) map[string]any {
+	var env map[string]any = map[string]any{
+		"action":   action,
+		"resource": toAttributes(resource),
+		"request":  map[string]any{},
+		"subject":  map[string]any{},
+	}
+	if attributes, ok := ctx.Value(requestKey{}).(requestAttributes); ok {
+		env["request"] = attributes.attributes
+	}
+
+	principal, ok := auth.PrincipalFromContext(ctx, e.userContextKey)
+	if !ok {
+		return env
+	}
+	// The claims map is shared by every reader of the principal.
+	var subject map[string]any = maps.Clone(principal.Claims)
+	if subject == nil {
+		subject = map[string]any{}
+	}
+	if principal.Subject != "" {
+		subject["id"] = principal.Subject
+	}
+	var roles []any = make([]any, 0, len(principal.Roles))
+	for _, role := range principal.Roles {
+		roles = append(roles, role)
+	}
+	subject["roles"] = roles
+	if principal.Tenant != "" {
+		subject["tenant"] = principal.Tenant
+	}
+	env["subject"] = subject
+	return env
+}
+
+// toAttributes converts structs to their JSON form, so conditions use the
+// JSON field names, e.g. subject.sub on auth.RBACClaims.
+func toAttributes(value any) any {
+	switch v := value.(type) {
+	case nil, string, float64, bool, map[string]any, []any:
+		return v
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var attributes any
+	if err := json.Unmarshal(data, &attributes); err != nil {
+		return nil
+	}
+	return attributes
+}
+
+func engineFrom(ctx context.Context) *Engine {
+	if attributes, ok := ctx.Value(requestKey{}).(requestAttributes); ok && attributes.engine != nil {
+		return attributes.engine
+	}
+	return Default()
+}
+
+// Decide uses the engine that prepared the request context, or the default
+// engine. Without an engine every action is denied.
+func Decide(ctx context.Context, action string, resource any) Decision {
+	var engine *Engine = engineFrom(ctx)
+	if engine == nil {
+		return Decision{Action: action, Reason: ReasonNoMatch}
+	}
+	return engine.Decide(ctx, action, resource)
+}
+
+// Check is the in-handler API, e.g. after loading the resources of a list:
+//
+//	if err := authz.Check(r.Context(), "orders:edit", order); err != nil {
+func Check(ctx context.Context, action string, resource any) error {
+	return decisionError(Decide(ctx, action, resource))
+}
@@ -0,0 +1,431 @@
+package authz
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The condition language is a small boolean expression language:
+//
+//	resource.owner == subject.sub && request.method != "DELETE"
+//	"admin" in subject.roles || (subject.tenant == resource.tenant && resource.amount < 1000)
+//
+// Attributes are read with dotted paths from subject, resource, request and
+// action. Missing attributes are null. Literals are strings in single or
+// double quotes, numbers, true, false, null and lists such as ["a", "b"].
+// Operators are ==, !=, <, <=, >, >=, in, !, && and ||.
+
+var roots []string = []string{"subject", "resource", "request", "action"}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value any
+	pos   int
+}
+
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(input); {
+		var c byte = input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			var end int = i + 1
+			var text strings.Builder
+			for end < len(input) && input[end] != c {
+				if input[end] == '\\' && end+1 < len(input) {
+					end++
+				}
+				text.WriteByte(input[end])
+				end++
+			}
+			if end >= len(input) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenString, value: text.String(), pos: i})
+			i = end + 1
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(input) && input[i+1] >= '0' && input[i+1] <= '9':
+			var end int = i + 1
+			for end < len(input) && (input[end] >= '0' && input[end] <= '9' || input[end] == '.') {
+				end++
+			}
+			number, err := strconv.ParseFloat(input[i:end], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number '%s' at %d", input[i:end], i)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, value: number, pos: i})
+			i = end
+		case c == '_' || unicode.IsLetter(rune(c)):
+			var end int = i + 1
+			for end < len(input) && (input[end] == '_' || input[end] == '-' || unicode.IsLetter(rune(input[end])) || unicode.IsDigit(rune(input[end]))) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: input[i:end], pos: i})
+			i = end
+		default:
+			var operator string
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ",", "."} {
+				if strings.HasPrefix(input[i:], candidate) {
+					operator = candidate
+					break
+				}
+			}
+			if operator == "" {
+				return nil, fmt.Errorf("unexpected character '%c' at %d", c, i)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: operator, pos: i})
+			i += len(operator)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(input)}), nil
+}
+
+type node interface {
+	eval(env map[string]any) (any, error)
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// compile parses a condition. An empty condition always matches.
+func compile(expression string) (node, error) {
+	if strings.TrimSpace(expression) == "" {
+		return literal{value: true}, nil
+	}
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	var p *parser = &parser{tokens: tokens}
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected '%s' at %d", next.text, next.pos)
+	}
+	return expr, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) accept(operator string) bool {
+	if next := p.peek(); (next.kind == tokenOperator || next.kind == tokenIdent) && next.text == operator {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	for err == nil && p.accept("||") {
+		var right node
+		right, err = p.and()
+		left = logical{operator: "||", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.not()
+	for err == nil && p.accept("&&") {
+		var right node
+		right, err = p.not()
+		left = logical{operator: "&&", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *parser) not() (node, error) {
+	if p.accept("!") {
+		operand, err := p.not()
+		return negation{operand: operand}, err
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (node, error) {
+	left, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	for _, operator := range []string{"==", "!=", "<=", ">=", "<", ">", "in"} {
+		if p.accept(operator) {
+			right, err := p.value()
+			return comparison{operator: operator, left: left, right: right}, err
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) value() (node, error) {
+	var next token = p.next()
+	switch next.kind {
+	case tokenString, tokenNumber:
+		return literal{value: next.value}, nil
+	case tokenIdent:
+		switch next.text {
+		case "true":
+			return literal{value: true}, nil
+		case "false":
+			return literal{value: false}, nil
+		case "null":
+			return literal{value: nil}, nil
+		}
+		if !slices.Contains(roots, next.text) {
+			return nil, fmt.Errorf("unknown attribute '%s' at %d, expected one of %s", next.text, next.pos, strings.Join(roots, ", "))
+		}
+		var path attribute = attribute{next.text}
+		for p.accept(".") {
+			var segment token = p.next()
+			if segment.kind != tokenIdent {
+				return nil, fmt.Errorf("expected an attribute name at %d", segment.pos)
+			}
+			path = append(path, segment.text)
+		}
+		return path, nil
+	case tokenOperator:
+		switch next.text {
+		case "(":
+			expr, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(")") {
+				return nil, fmt.Errorf("expected ')' at %d", p.peek().pos)
+			}
+			return expr, nil
+		case "[":
+			var items list
+			for !p.accept("]") {
+				if len(items) > 0 && !p.accept(",") {
+					return nil, fmt.Errorf("expected ',' or ']' at %d", p.peek().pos)
+				}
+				item, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+			return items, nil
+		}
+	}
+	if next.kind == tokenEOF {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected '%s' at %d", next.text, next.pos)
+}
+
+func (p *parser) next() token {
+	var next token = p.tokens[p.pos]
+	if next.kind != tokenEOF {
+		p.pos++
+	}
+	return next
+}
+
+type literal struct {
+	value any
+}
+
+func (l literal) eval(map[string]any) (any, error) {
+	return l.value, nil
+}
+
+type attribute []string
+
+func (a attribute) eval(env map[string]any) (any, error) {
+	var value any = env[a[0]]
+	for _, segment := range a[1:] {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, nil
+		}
+		value = object[segment]
+	}
+	return value, nil
+}
+
+type list []node
+
+func (l list) eval(env map[string]any) (any, error) {
+	var values []any = make([]any, 0, len(l))
+	for _, item := range l {
+		value, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+type negation struct {
+	operand node
+}
+
+func (n negation) eval(env map[string]any) (any, error) {
+	value, err := evalBool(n.operand, env)
+	return !value, err
+}
+
+type logical struct {
+	operator    string
+	left, right node
+}
+
+func (l logical) eval(env map[string]any) (any, error) {
+	left, err := evalBool(l.left, env)
+	if err != nil {
+		return nil, err
+	}
+	if l.operator == "&&" && !left || l.operator == "||" && left {
+		return left, nil
+	}
+	return evalBool(l.right, env)
+}
+
+// evalBool treats null as false so conditions on missing attributes do not
+// fail.
+func evalBool(n node, env map[string]any) (bool, error) {
+	value, err := n.eval(env)
+	if err != nil {
+		return false, err
+	}
+	switch v := value.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	default:
+		return false, fmt.Errorf("expected a boolean, got %T", value)
+	}
+}
+
+type comparison struct {
+	operator    string
+	left, right node
+}
+
+func (c comparison) eval(env map[string]any) (any, error) {
+	left, err := c.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch c.operator {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		switch container := right.(type) {
+		case nil:
+			return false, nil
+		case []any:
+			return slices.ContainsFunc(container, func(item any) bool { return equal(left, item) }), nil
+		case map[string]any:
+			key, ok := left.(string)
+			_, exists := container[key]
+			return ok && exists, nil
+		case string:
+			item, ok := left.(string)
+			return ok && strings.Contains(container, item), nil
+		default:
+			return nil, fmt.Errorf("'in' expects a list, an object or a string, got %T", right)
+		}
+	}
+
+	var order int
+	if l, r, ok := numbers(left, right); ok {
+		order = compareOrdered(l, r)
+	} else if l, ok := left.(string); ok {
+		r, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare %T with %T", left, right)
+		}
+		order = strings.Compare(l, r)
+	} else {
+		return nil, fmt.Errorf("cannot compare %T with %T", left, right)
+	}
+	switch c.operator {
+	case "<":
+		return order < 0, nil
+	case "<=":
+		return order <= 0, nil
+	case ">":
+		return order > 0, nil
+	default:
+		return order >= 0, nil
+	}
+}
+
+func compareOrdered(l, r float64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+// equal compares numbers numerically, also against numeric strings such as
+// path values, so resource.id == request.params.id holds for 42 and "42".
+func equal(left, right any) bool {
+	if l, r, ok := numbers(left, right); ok {
+		return l == r
+	}
+	switch l := left.(type) {
+	case nil:
+		return right == nil
+	case string, bool:
+		return left == right
+	case []any:
+		r, ok := right.([]any)
+		return ok && slices.EqualFunc(l, r, equal)
+	}
+	return false
+}
+
+func numbers(left, right any) (float64, float64, bool) {
+	l, lok := number(left)
+	r, rok := number(right)
+	_, lnum := left.(float64)
+	_, rnum := right.(float64)
+	return l, r, lok && rok && (lnum || rnum)
+}
+
+func number(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		number, err := strconv.ParseFloat(v, 64)
+		return number, err == nil
+	}
+	return 0, false
+}
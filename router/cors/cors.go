@@ -1,28 +1,65 @@
 package cors
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
 	"slices"
-
-	"github.com/angelbarreiros/Penguin/logger"
+	"strings"
 )
 
 const (
 	AllowAllOrigin = "*"
+	// AllowAllHeaders in the allowed headers accepts any requested header.
+	AllowAllHeaders = "*"
+)
+
+var (
+	ErrOriginNotAllowed         = errors.New("origin not allowed")
+	ErrMethodNotAllowed         = errors.New("method not allowed")
+	ErrHeadersNotAllowed        = errors.New("headers not allowed")
+	ErrPrivateNetworkNotAllowed = errors.New("private network access not allowed")
 )
 
+// OriginValidator decides at request time whether an origin is allowed,
+// e.g. by looking up the origins of a tenant.
+type OriginValidator func(origin string, r *http.Request) bool
+
 type CORSConfig struct {
-	allowedOrigins     []string
-	allowedHeaders     []string
-	exposedHeaders     []string
-	allowCredentials   bool
-	maxAge             int
-	optionsPassthrough bool
+	allowedOrigins      []string
+	originPatterns      []string
+	exactOrigins        []string
+	wildcardOrigins     []wildcardOrigin
+	originRegexps       []*regexp.Regexp
+	originValidator     OriginValidator
+	allowAllOrigins     bool
+	allowedMethods      []string
+	allowedHeaders      []string
+	exposedHeaders      []string
+	allowCredentials    bool
+	maxAge              int
+	optionsPassthrough  bool
+	allowPrivateNetwork bool
+}
+
+// wildcardOrigin matches "scheme://*.domain[:port]": any subdomain of
+// domain, but not domain itself.
+type wildcardOrigin struct {
+	scheme string
+	domain string
+	port   string
 }
 
 func (c *CORSConfig) AllowedOrigins() []string {
 	return c.allowedOrigins
 }
 
+func (c *CORSConfig) AllowedMethods() []string {
+	return c.allowedMethods
+}
+
 func (c *CORSConfig) AllowedHeaders() []string {
 	return c.allowedHeaders
 }
@@ -43,10 +80,28 @@ func (c *CORSConfig) OptionsPassthrough() bool {
 	return c.optionsPassthrough
 }
 
-func NewCORSConfig(options ...func(*CORSConfig)) *CORSConfig {
+func (c *CORSConfig) AllowPrivateNetwork() bool {
+	return c.allowPrivateNetwork
+}
+
+// AllowsAllOrigins reports whether "*" is an allowed origin.
+func (c *CORSConfig) AllowsAllOrigins() bool {
+	return c.allowAllOrigins
+}
+
+// NewCORSConfig rejects invalid configurations: "*" with credentials, which
+// browsers refuse, and malformed origin patterns.
+func NewCORSConfig(options ...func(*CORSConfig)) (*CORSConfig, error) {
 	var config *CORSConfig = &CORSConfig{
 		allowedOrigins: []string{},
-
+		allowedMethods: []string{
+			http.MethodGet,
+			http.MethodHead,
+			http.MethodPost,
+			http.MethodPut,
+			http.MethodPatch,
+			http.MethodDelete,
+		},
 		allowedHeaders: []string{
 			"Origin",
 			"Accept",
@@ -62,22 +117,113 @@ func NewCORSConfig(options ...func(*CORSConfig)) *CORSConfig {
 		option(config)
 	}
 
-	// Validate: AllowAllOrigin (*) + AllowCredentials = true is invalid per CORS spec
-	if config.allowCredentials && len(config.allowedOrigins) > 0 {
-		if slices.Contains(config.allowedOrigins, AllowAllOrigin) {
-			logger.GetConsoleLogger().Warn("CORS Configuration Warning: AllowAllOrigin (*) with AllowCredentials=true is invalid per CORS spec. Browsers will ignore credentials. Consider using a specific list of allowed origins with credentials.")
+	for _, origin := range config.allowedOrigins {
+		switch {
+		case origin == AllowAllOrigin:
+			config.allowAllOrigins = true
+		case strings.Contains(origin, "*"):
+			wildcard, err := parseWildcardOrigin(origin)
+			if err != nil {
+				return nil, err
+			}
+			config.wildcardOrigins = append(config.wildcardOrigins, wildcard)
+		default:
+			config.exactOrigins = append(config.exactOrigins, strings.ToLower(origin))
+		}
+	}
+	for _, pattern := range config.originPatterns {
+		expression, err := regexp.Compile(`^(?:` + pattern + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("invalid origin pattern '%s': %w", pattern, err)
 		}
+		config.originRegexps = append(config.originRegexps, expression)
+	}
+	if config.allowAllOrigins && config.allowCredentials {
+		return nil, fmt.Errorf("allowed origin '*' cannot be combined with credentials: list the origins, use patterns or an origin validator")
+	}
+
+	return config, nil
+}
+
+func parseWildcardOrigin(origin string) (wildcardOrigin, error) {
+	scheme, host, ok := strings.Cut(origin, "://")
+	domain, found := strings.CutPrefix(host, "*.")
+	if !ok || scheme == "" || !found || domain == "" || strings.Contains(domain, "*") || strings.Contains(domain, "/") {
+		return wildcardOrigin{}, fmt.Errorf("invalid origin pattern '%s': expected scheme://*.domain", origin)
 	}
+	var wildcard wildcardOrigin = wildcardOrigin{scheme: strings.ToLower(scheme), domain: strings.ToLower(domain)}
+	if name, port, hasPort := strings.Cut(domain, ":"); hasPort {
+		wildcard.domain, wildcard.port = name, port
+	}
+	return wildcard, nil
+}
 
-	return config
+func (w wildcardOrigin) matches(origin *url.URL) bool {
+	var host string = strings.ToLower(origin.Hostname())
+	return strings.EqualFold(origin.Scheme, w.scheme) && origin.Port() == w.port &&
+		strings.HasSuffix(host, "."+w.domain) && len(host) > len(w.domain)+1
 }
 
+// IsOriginAllowed reports whether the origin matches "*", an exact origin,
+// a wildcard subdomain, a pattern or the origin validator.
+func (c *CORSConfig) IsOriginAllowed(origin string, r *http.Request) bool {
+	if origin == "" {
+		return false
+	}
+	if c.allowAllOrigins || slices.Contains(c.exactOrigins, strings.ToLower(origin)) {
+		return true
+	}
+	if len(c.wildcardOrigins) > 0 {
+		if parsed, err := url.Parse(origin); err == nil && parsed.Path == "" {
+			for _, wildcard := range c.wildcardOrigins {
+				if wildcard.matches(parsed) {
+					return true
+				}
+			}
+		}
+	}
+	for _, expression := range c.originRegexps {
+		if expression.MatchString(origin) {
+			return true
+		}
+	}
+	return c.originValidator != nil && c.originValidator(origin, r)
+}
+
+// WithAllowedOrigins sets the allowed origins: exact origins, "*", or
+// wildcard subdomains such as "https://*.example.com".
 func WithAllowedOrigins(origins []string) func(*CORSConfig) {
 	return func(c *CORSConfig) {
 		c.allowedOrigins = origins
 	}
 }
 
+// WithAllowedOriginPatterns allows the origins matching the regular
+// expressions. Patterns must match the whole origin.
+func WithAllowedOriginPatterns(patterns []string) func(*CORSConfig) {
+	return func(c *CORSConfig) {
+		c.originPatterns = patterns
+	}
+}
+
+// WithOriginValidator allows the origins accepted by the validator, in
+// addition to the configured ones.
+func WithOriginValidator(validator OriginValidator) func(*CORSConfig) {
+	return func(c *CORSConfig) {
+		c.originValidator = validator
+	}
+}
+
+// WithAllowedMethods sets the methods allowed by preflight requests. The
+// router further restricts them to the methods of the route.
+func WithAllowedMethods(methods []string) func(*CORSConfig) {
+	return func(c *CORSConfig) {
+		c.allowedMethods = methods
+	}
+}
+
+// WithAllowedHeaders sets the request headers allowed by preflight
+// requests; AllowAllHeaders accepts any.
 func WithAllowedHeaders(headers []string) func(*CORSConfig) {
 	return func(c *CORSConfig) {
 		c.allowedHeaders = headers
@@ -107,3 +253,11 @@ func WithOptionsPassthrough(passthrough bool) func(*CORSConfig) {
 		c.optionsPassthrough = passthrough
 	}
 }
+
+// WithAllowPrivateNetwork accepts Private Network Access preflights, sent
+// by browsers when a public site calls a server on a private network.
+func WithAllowPrivateNetwork(allow bool) func(*CORSConfig) {
+	return func(c *CORSConfig) {
+		c.allowPrivateNetwork = allow
+	}
+}
@@ -0,0 +1,145 @@
+package cors
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// IsPreflight reports whether r is a CORS preflight request rather than a
+// plain OPTIONS request.
+func IsPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// CheckPreflight validates the origin, Access-Control-Request-Method,
+// Access-Control-Request-Headers and Access-Control-Request-Private-Network
+// of a preflight request, and sets the CORS response headers when they are
+// allowed. routeMethods, when not nil, restricts the allowed methods to
+// those of the route. The caller writes the status.
+func (c *CORSConfig) CheckPreflight(w http.ResponseWriter, r *http.Request, routeMethods []string) error {
+	var header http.Header = w.Header()
+	addVary(header, "Origin")
+	addVary(header, "Access-Control-Request-Method")
+	addVary(header, "Access-Control-Request-Headers")
+
+	var origin string = r.Header.Get("Origin")
+	if !c.IsOriginAllowed(origin, r) {
+		return ErrOriginNotAllowed
+	}
+	var methods []string = c.methodsFor(routeMethods)
+	if !slices.Contains(methods, r.Header.Get("Access-Control-Request-Method")) {
+		return ErrMethodNotAllowed
+	}
+	var requestedHeaders []string = parseHeaderList(r.Header.Values("Access-Control-Request-Headers"))
+	for _, name := range requestedHeaders {
+		if !c.isHeaderAllowed(name) {
+			return ErrHeadersNotAllowed
+		}
+	}
+	var privateNetwork bool = r.Header.Get("Access-Control-Request-Private-Network") == "true"
+	if privateNetwork && !c.allowPrivateNetwork {
+		return ErrPrivateNetworkNotAllowed
+	}
+
+	c.setOrigin(header, origin)
+	header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(requestedHeaders) > 0 {
+		if slices.Contains(c.allowedHeaders, AllowAllHeaders) {
+			// "*" is literal for requests with credentials, so echo the headers.
+			header.Set("Access-Control-Allow-Headers", strings.Join(requestedHeaders, ", "))
+		} else {
+			header.Set("Access-Control-Allow-Headers", strings.Join(c.allowedHeaders, ", "))
+		}
+	}
+	if c.maxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(c.maxAge))
+	}
+	if privateNetwork {
+		header.Set("Access-Control-Allow-Private-Network", "true")
+	}
+	return nil
+}
+
+// CheckRequest validates the origin of an actual request and sets the CORS
+// response headers. Requests without an origin (same-origin or non-browser
+// clients) are allowed without CORS headers.
+func (c *CORSConfig) CheckRequest(w http.ResponseWriter, r *http.Request) error {
+	var header http.Header = w.Header()
+	if !c.allowAllOrigins {
+		// The response depends on the origin, so caches must not share it.
+		addVary(header, "Origin")
+	}
+	var origin string = r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	if !c.IsOriginAllowed(origin, r) {
+		return ErrOriginNotAllowed
+	}
+	c.setOrigin(header, origin)
+	if len(c.exposedHeaders) > 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(c.exposedHeaders, ", "))
+	}
+	return nil
+}
+
+func (c *CORSConfig) setOrigin(header http.Header, origin string) {
+	if c.allowAllOrigins {
+		header.Set("Access-Control-Allow-Origin", AllowAllOrigin)
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.allowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// methodsFor returns the allowed methods that the route also handles.
+func (c *CORSConfig) methodsFor(routeMethods []string) []string {
+	if routeMethods == nil {
+		return c.allowedMethods
+	}
+	var methods []string
+	for _, method := range c.allowedMethods {
+		if slices.Contains(routeMethods, method) {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+func (c *CORSConfig) isHeaderAllowed(name string) bool {
+	for _, allowed := range c.allowedHeaders {
+		if allowed == AllowAllHeaders || strings.EqualFold(allowed, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHeaderList splits comma separated header names and lowercases them.
+func parseHeaderList(values []string) []string {
+	var names []string
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// addVary adds a value to the Vary header unless it is already listed.
+func addVary(header http.Header, value string) {
+	for _, existing := range header.Values("Vary") {
+		for _, name := range strings.Split(existing, ",") {
+			if strings.EqualFold(strings.TrimSpace(name), value) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", value)
+}
@@ -0,0 +1,146 @@
+package credentials
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// RangePrefixLength is the number of hex characters of the SHA-1 hash
+	// used to select a range, as in the Pwned Passwords range API.
+	RangePrefixLength = 5
+	rangeCount        = 1 << (4 * RangePrefixLength)
+)
+
+// RangeSource returns the breached password hashes starting with a prefix,
+// one "HASH:COUNT" line each. HASH is the uppercase SHA-1 hex suffix, or
+// the full hash. Only the prefix leaves the application, so a remote source
+// never learns the password.
+type RangeSource interface {
+	Range(prefix string) (io.ReadCloser, error)
+}
+
+// RangeDirectory reads one file per prefix, e.g. "21BD1.txt", the layout
+// written by the Pwned Passwords downloader. Missing files are empty ranges.
+type RangeDirectory string
+
+func (d RangeDirectory) Range(prefix string) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(string(d), prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	return file, err
+}
+
+// RangeFile reads a single file of "HASH:COUNT" lines sorted by hash. The
+// offset of every prefix is indexed when the file is opened.
+type RangeFile struct {
+	file    *os.File
+	offsets []int64
+}
+
+func OpenRangeFile(path string) (*RangeFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	var rangeFile *RangeFile = &RangeFile{file: file, offsets: make([]int64, rangeCount+1)}
+	if err := rangeFile.index(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return rangeFile, nil
+}
+
+func (f *RangeFile) index() error {
+	var reader *bufio.Reader = bufio.NewReader(f.file)
+	var offset int64
+	var next int
+	for number := 1; ; number++ {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			if len(line) < RangePrefixLength {
+				return fmt.Errorf("malformed breach file line %d", number)
+			}
+			prefix, parseErr := strconv.ParseUint(line[:RangePrefixLength], 16, 32)
+			if parseErr != nil {
+				return fmt.Errorf("malformed breach file line %d", number)
+			}
+			if int(prefix) < next-1 {
+				return fmt.Errorf("breach file is not sorted at line %d", number)
+			}
+			for ; next <= int(prefix); next++ {
+				f.offsets[next] = offset
+			}
+			offset += int64(len(line))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for ; next <= rangeCount; next++ {
+		f.offsets[next] = offset
+	}
+	return nil
+}
+
+func (f *RangeFile) Range(prefix string) (io.ReadCloser, error) {
+	value, err := strconv.ParseUint(prefix, 16, 32)
+	if err != nil || len(prefix) != RangePrefixLength {
+		return nil, fmt.Errorf("invalid range prefix '%s'", prefix)
+	}
+	var start, end int64 = f.offsets[value], f.offsets[value+1]
+	return io.NopCloser(io.NewSectionReader(f.file, start, end-start)), nil
+}
+
+func (f *RangeFile) Close() error {
+	return f.file.Close()
+}
+
+// BreachChecker looks passwords up in a k-anonymity range source.
+type BreachChecker struct {
+	source RangeSource
+}
+
+func NewBreachChecker(source RangeSource) *BreachChecker {
+	return &BreachChecker{source: source}
+}
+
+// Count returns how many times the password appears in breaches.
+func (b *BreachChecker) Count(password string) (int, error) {
+	var sum [sha1.Size]byte = sha1.Sum([]byte(password))
+	var hash string = strings.ToUpper(hex.EncodeToString(sum[:]))
+	var prefix, suffix string = hash[:RangePrefixLength], hash[RangePrefixLength:]
+
+	reader, err := b.source.Range(prefix)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read breached passwords: %w", err)
+	}
+	defer reader.Close()
+	var scanner *bufio.Scanner = bufio.NewScanner(reader)
+	for scanner.Scan() {
+		candidate, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok {
+			continue
+		}
+		candidate = strings.ToUpper(candidate)
+		if candidate == suffix || candidate == hash {
+			return strconv.Atoi(count)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read breached passwords: %w", err)
+	}
+	return 0, nil
+}
@@ -0,0 +1,168 @@
+package credentials
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Algorithm string
+
+const (
+	Argon2id Algorithm = "argon2id"
+	Bcrypt   Algorithm = "bcrypt"
+)
+
+const DefaultBcryptCost = 12
+
+// Argon2Params are the argon2id parameters. Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the second recommendation of RFC 9106.
+var DefaultArgon2Params Argon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 4,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+type hasherOptionsFunc func(*Hasher)
+
+// Hasher hashes passwords in PHC string format and tells when a stored
+// hash was made with other settings, so it can be upgraded on login.
+type Hasher struct {
+	algorithm  Algorithm
+	argon2     Argon2Params
+	bcryptCost int
+	err        error
+}
+
+func NewHasher(options ...hasherOptionsFunc) (*Hasher, error) {
+	var h *Hasher = &Hasher{
+		algorithm:  Argon2id,
+		argon2:     DefaultArgon2Params,
+		bcryptCost: DefaultBcryptCost,
+	}
+	for _, option := range options {
+		option(h)
+	}
+	if h.err != nil {
+		return nil, h.err
+	}
+	return h, nil
+}
+
+func HasherWithAlgorithm(algorithm Algorithm) hasherOptionsFunc {
+	return func(h *Hasher) {
+		if algorithm != Argon2id && algorithm != Bcrypt {
+			h.err = fmt.Errorf("unsupported password hash algorithm '%s'", algorithm)
+			return
+		}
+		h.algorithm = algorithm
+	}
+}
+
+func HasherWithArgon2Params(params Argon2Params) hasherOptionsFunc {
+	return func(h *Hasher) {
+		if params.Memory < 8*uint32(params.Parallelism) || params.Iterations < 1 || params.Parallelism < 1 ||
+			params.SaltLength < 16 || params.KeyLength < 16 {
+			h.err = fmt.Errorf("invalid argon2 parameters")
+			return
+		}
+		h.argon2 = params
+	}
+}
+
+func HasherWithBcryptCost(cost int) hasherOptionsFunc {
+	return func(h *Hasher) {
+		if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			h.err = fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+			return
+		}
+		h.bcryptCost = cost
+	}
+}
+
+// Hash returns the PHC string of the password. bcrypt rejects passwords
+// longer than 72 bytes.
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.algorithm == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+	var salt []byte = make([]byte, h.argon2.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	var key []byte = argon2.IDKey([]byte(password), salt, h.argon2.Iterations, h.argon2.Memory, h.argon2.Parallelism, h.argon2.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.argon2.Memory, h.argon2.Iterations, h.argon2.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks the password against a bcrypt or argon2 hash, whatever the
+// configured algorithm is.
+func (h *Hasher) Verify(hash string, password string) (bool, error) {
+	return auth.VerifyPasswordHash(hash, password)
+}
+
+// NeedsRehash reports whether the hash uses another algorithm or other
+// parameters than the hasher.
+func (h *Hasher) NeedsRehash(hash string) bool {
+	if h.algorithm == Bcrypt {
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != h.bcryptCost
+	}
+	params, err := parseArgon2id(hash)
+	return err != nil || params != h.argon2
+}
+
+// VerifyAndRehash verifies the password and, when the hash is outdated,
+// returns a new hash to store in place of the old one. newHash is empty
+// when the hash is current or the password is wrong.
+func (h *Hasher) VerifyAndRehash(hash string, password string) (ok bool, newHash string, err error) {
+	if ok, err = h.Verify(hash, password); !ok || err != nil {
+		return false, "", err
+	}
+	if !h.NeedsRehash(hash) {
+		return true, "", nil
+	}
+	newHash, err = h.Hash(password)
+	return true, newHash, err
+}
+
+func parseArgon2id(hash string) (Argon2Params, error) {
+	var parts []string = strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != string(Argon2id) || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return Argon2Params{}, fmt.Errorf("malformed argon2id hash")
+	}
+	var params Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return Argon2Params{}, fmt.Errorf("malformed argon2 parameters: %w", err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, fmt.Errorf("malformed argon2 salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Argon2Params{}, fmt.Errorf("malformed argon2 hash: %w", err)
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, nil
+}
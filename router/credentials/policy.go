@@ -0,0 +1,147 @@
+package credentials
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Violation keys are i18n keys; helpers.SendI18NValidationErrorResponse
+// translates PolicyError.Violations with their arguments.
+const (
+	KeyTooShort          = "password.too_short"
+	KeyTooLong           = "password.too_long"
+	KeyMissingUppercase  = "password.missing_uppercase"
+	KeyMissingLowercase  = "password.missing_lowercase"
+	KeyMissingDigit      = "password.missing_digit"
+	KeyMissingSymbol     = "password.missing_symbol"
+	KeyContainsUserInput = "password.contains_user_input"
+	KeyBreached          = "password.breached"
+)
+
+const (
+	DefaultMinLength = 12
+	DefaultMaxLength = 128
+	// minUserInputLength ignores short inputs such as initials.
+	minUserInputLength = 4
+)
+
+// PolicyError lists the violated rules, mapped to the arguments of their
+// message, e.g. {"password.too_short": [12]}.
+type PolicyError struct {
+	Violations map[string][]any
+}
+
+func (e *PolicyError) Error() string {
+	var keys []string = make([]string, 0, len(e.Violations))
+	for key := range e.Violations {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return "password policy violated: " + strings.Join(keys, ", ")
+}
+
+type policyOptionsFunc func(*Policy)
+
+// Policy validates new passwords. Lengths are counted in characters.
+type Policy struct {
+	minLength        int
+	maxLength        int
+	requireUppercase bool
+	requireLowercase bool
+	requireDigit     bool
+	requireSymbol    bool
+	breaches         *BreachChecker
+	maxBreachCount   int
+}
+
+func NewPolicy(options ...policyOptionsFunc) *Policy {
+	var p *Policy = &Policy{
+		minLength: DefaultMinLength,
+		maxLength: DefaultMaxLength,
+	}
+	for _, option := range options {
+		option(p)
+	}
+	return p
+}
+
+func PolicyWithLength(min int, max int) policyOptionsFunc {
+	return func(p *Policy) {
+		p.minLength = min
+		p.maxLength = max
+	}
+}
+
+// PolicyWithCharacterClasses requires at least one character of each
+// enabled class.
+func PolicyWithCharacterClasses(uppercase bool, lowercase bool, digit bool, symbol bool) policyOptionsFunc {
+	return func(p *Policy) {
+		p.requireUppercase = uppercase
+		p.requireLowercase = lowercase
+		p.requireDigit = digit
+		p.requireSymbol = symbol
+	}
+}
+
+// PolicyWithBreachChecker rejects passwords found in breaches more than
+// maxCount times.
+func PolicyWithBreachChecker(checker *BreachChecker, maxCount int) policyOptionsFunc {
+	return func(p *Policy) {
+		p.breaches = checker
+		p.maxBreachCount = maxCount
+	}
+}
+
+// Validate returns a *PolicyError listing every violated rule. userInputs
+// such as the username or email must not be part of the password. Errors of
+// the breach checker are returned as they are.
+func (p *Policy) Validate(password string, userInputs ...string) error {
+	var violations map[string][]any = make(map[string][]any)
+	var length int = utf8.RuneCountInString(password)
+	if length < p.minLength {
+		violations[KeyTooShort] = []any{p.minLength}
+	}
+	if p.maxLength > 0 && length > p.maxLength {
+		violations[KeyTooLong] = []any{p.maxLength}
+	}
+	for _, class := range []struct {
+		required bool
+		key      string
+		matches  func(rune) bool
+	}{
+		{p.requireUppercase, KeyMissingUppercase, unicode.IsUpper},
+		{p.requireLowercase, KeyMissingLowercase, unicode.IsLower},
+		{p.requireDigit, KeyMissingDigit, unicode.IsDigit},
+		{p.requireSymbol, KeyMissingSymbol, isSymbol},
+	} {
+		if class.required && !strings.ContainsFunc(password, class.matches) {
+			violations[class.key] = []any{}
+		}
+	}
+	var lowered string = strings.ToLower(password)
+	for _, input := range userInputs {
+		if utf8.RuneCountInString(input) >= minUserInputLength && strings.Contains(lowered, strings.ToLower(input)) {
+			violations[KeyContainsUserInput] = []any{}
+			break
+		}
+	}
+	if p.breaches != nil && password != "" {
+		count, err := p.breaches.Count(password)
+		if err != nil {
+			return err
+		}
+		if count > p.maxBreachCount {
+			violations[KeyBreached] = []any{count}
+		}
+	}
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func isSymbol(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+}
@@ -0,0 +1,247 @@
+package credentials
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultTOTPPeriod  = 30 * time.Second
+	DefaultTOTPDigits  = 6
+	DefaultTOTPSkew    = 1
+	totpSecretBytes    = 20
+	recoveryCodeLength = 10
+)
+
+var (
+	ErrInvalidCode        = errors.New("invalid one-time code")
+	ErrCodeAlreadyUsed    = errors.New("one-time code was already used")
+	ErrInvalidTOTPSecret  = errors.New("invalid totp secret")
+	totpSecretEncoding    = base32.StdEncoding.WithPadding(base32.NoPadding)
+	recoveryCodeAlphabet  = "0123456789abcdefghjkmnpqrstvwxyz"
+	totpAlgorithmHashFunc = map[string]func() hash.Hash{"SHA1": sha1.New, "SHA256": sha256.New, "SHA512": sha512.New}
+)
+
+type totpOptionsFunc func(*TOTP)
+
+// TOTP generates and verifies time-based one-time passwords (RFC 6238)
+// compatible with authenticator apps.
+type TOTP struct {
+	issuer    string
+	period    time.Duration
+	digits    int
+	skew      int
+	algorithm string
+	err       error
+}
+
+func NewTOTP(issuer string, options ...totpOptionsFunc) (*TOTP, error) {
+	var t *TOTP = &TOTP{
+		issuer:    issuer,
+		period:    DefaultTOTPPeriod,
+		digits:    DefaultTOTPDigits,
+		skew:      DefaultTOTPSkew,
+		algorithm: "SHA1",
+	}
+	for _, option := range options {
+		option(t)
+	}
+	if t.err != nil {
+		return nil, t.err
+	}
+	return t, nil
+}
+
+func TOTPWithPeriod(period time.Duration) totpOptionsFunc {
+	return func(t *TOTP) {
+		if period < time.Second {
+			t.err = fmt.Errorf("totp period must be at least one second")
+			return
+		}
+		t.period = period
+	}
+}
+
+func TOTPWithDigits(digits int) totpOptionsFunc {
+	return func(t *TOTP) {
+		if digits < 6 || digits > 8 {
+			t.err = fmt.Errorf("totp digits must be between 6 and 8")
+			return
+		}
+		t.digits = digits
+	}
+}
+
+// TOTPWithSkew accepts codes of this many periods before and after the
+// current one, for clock drift.
+func TOTPWithSkew(skew int) totpOptionsFunc {
+	return func(t *TOTP) {
+		t.skew = max(skew, 0)
+	}
+}
+
+// TOTPWithAlgorithm sets the HMAC hash: SHA1 (default, the only one every
+// app supports), SHA256 or SHA512.
+func TOTPWithAlgorithm(algorithm string) totpOptionsFunc {
+	return func(t *TOTP) {
+		if _, ok := totpAlgorithmHashFunc[algorithm]; !ok {
+			t.err = fmt.Errorf("unsupported totp algorithm '%s'", algorithm)
+			return
+		}
+		t.algorithm = algorithm
+	}
+}
+
+// Enrollment is a new second factor. Show URL as a QR code and the
+// recovery codes once; store the secret and RecoveryHashes. Confirm the
+// enrollment with a first Verify before enabling it.
+type Enrollment struct {
+	Secret         string
+	URL            string
+	RecoveryCodes  []string
+	RecoveryHashes []string
+}
+
+func (t *TOTP) Enroll(account string, recoveryCodes int) (*Enrollment, error) {
+	var secret []byte = make([]byte, totpSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := GenerateRecoveryCodes(recoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+	var enrollment *Enrollment = &Enrollment{
+		Secret:         totpSecretEncoding.EncodeToString(secret),
+		RecoveryCodes:  codes,
+		RecoveryHashes: hashes,
+	}
+	enrollment.URL = t.URL(account, enrollment.Secret)
+	return enrollment, nil
+}
+
+// URL returns the otpauth:// key URI of the secret.
+func (t *TOTP) URL(account string, secret string) string {
+	var query url.Values = url.Values{
+		"secret":    {secret},
+		"issuer":    {t.issuer},
+		"algorithm": {t.algorithm},
+		"digits":    {fmt.Sprint(t.digits)},
+		"period":    {fmt.Sprint(int(t.period / time.Second))},
+	}
+	var label string = url.PathEscape(t.issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Code returns the code of the secret at the given time.
+func (t *TOTP) Code(secret string, at time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return t.code(key, t.step(at)), nil
+}
+
+// Verify checks a code at the given time and returns its time step. Store
+// the step and pass it as lastStep next time, so a code cannot be replayed;
+// use 0 before the first verification.
+func (t *TOTP) Verify(secret string, code string, at time.Time, lastStep int64) (int64, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return 0, err
+	}
+	code = strings.ReplaceAll(code, " ", "")
+	var current int64 = t.step(at)
+	for offset := -t.skew; offset <= t.skew; offset++ {
+		var step int64 = current + int64(offset)
+		if subtle.ConstantTimeCompare([]byte(t.code(key, step)), []byte(code)) == 1 {
+			if step <= lastStep {
+				return 0, ErrCodeAlreadyUsed
+			}
+			return step, nil
+		}
+	}
+	return 0, ErrInvalidCode
+}
+
+func (t *TOTP) step(at time.Time) int64 {
+	return at.Unix() / int64(t.period/time.Second)
+}
+
+// code is the HOTP value (RFC 4226) of the time step.
+func (t *TOTP) code(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	var mac hash.Hash = hmac.New(totpAlgorithmHashFunc[t.algorithm], key)
+	mac.Write(counter[:])
+	var sum []byte = mac.Sum(nil)
+	var offset byte = sum[len(sum)-1] & 0x0f
+	var value uint32 = binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	var modulo uint32 = 1
+	for range t.digits {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", t.digits, value%modulo)
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	key, err := totpSecretEncoding.DecodeString(strings.ToUpper(strings.TrimRight(strings.ReplaceAll(secret, " ", ""), "=")))
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidTOTPSecret
+	}
+	return key, nil
+}
+
+// GenerateRecoveryCodes returns single use codes such as "k7pm2-xq4fz" and
+// the SHA-256 hashes to store. Each code has 50 random bits, so a fast hash
+// is sufficient.
+func GenerateRecoveryCodes(count int) (codes []string, hashes []string, err error) {
+	var random []byte = make([]byte, recoveryCodeLength)
+	for range count {
+		if _, err := rand.Read(random); err != nil {
+			return nil, nil, err
+		}
+		var code strings.Builder
+		for i, value := range random {
+			if i == recoveryCodeLength/2 {
+				code.WriteByte('-')
+			}
+			code.WriteByte(recoveryCodeAlphabet[value&31])
+		}
+		codes = append(codes, code.String())
+		hashes = append(hashes, hashRecoveryCode(code.String()))
+	}
+	return codes, hashes, nil
+}
+
+// UseRecoveryCode checks a recovery code against the stored hashes and
+// returns the hashes left once it is consumed.
+func UseRecoveryCode(hashes []string, code string) (remaining []string, ok bool) {
+	var candidate string = hashRecoveryCode(code)
+	for i, stored := range hashes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(candidate)) == 1 {
+			remaining = append(append(remaining, hashes[:i]...), hashes[i+1:]...)
+			return remaining, true
+		}
+	}
+	return hashes, false
+}
+
+func hashRecoveryCode(code string) string {
+	var normalized string = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	var sum [sha256.Size]byte = sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
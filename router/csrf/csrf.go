@@ -0,0 +1,488 @@
+package csrf
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/router"
+	"github.com/angelbarreiros/Penguin/router/cors"
+	routerErrors "github.com/angelbarreiros/Penguin/router/errors"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+)
+
+type Mode uint8
+
+const (
+	// DoubleSubmitCookie stores the token in a readable cookie and expects the
+	// client to echo it back in a header or form field. The token is signed
+	// and bound to the csrf_session cookie, which a sibling subdomain can
+	// plant as well; bind it to the authenticated session with
+	// WithSessionIDFunc to reject tokens planted that way.
+	DoubleSubmitCookie Mode = iota
+	// SynchronizerToken keeps the token server side, bound to an HttpOnly
+	// session cookie.
+	SynchronizerToken
+)
+
+const (
+	DefaultCookieName        = "csrf_token"
+	DefaultSessionCookieName = "csrf_session"
+	DefaultHeaderName        = "X-CSRF-Token"
+	DefaultFormField         = "csrf_token"
+	tokenBytes               = 32
+	MinSigningKeyLength      = 32
+)
+
+// ErrNoSession is returned when a token is issued for a request for which
+// the WithSessionIDFunc function returns no session.
+var ErrNoSession = errors.New("csrf: the request has no session to bind the token to")
+
+type contextKey struct{}
+
+type CSRFConfig struct {
+	mode              Mode
+	cookieName        string
+	sessionCookieName string
+	headerName        string
+	formField         string
+	cookiePath        string
+	cookieDomain      string
+	cookieSecure      bool
+	sameSite          http.SameSite
+	maxAge            time.Duration
+	exemptPaths       []string
+	trustedOrigins    []string
+	corsConfig        *cors.CORSConfig
+	rotateTokens      bool
+	store             helpers.StringCache[string]
+	signingKey        []byte
+	sessionIDFunc     func(r *http.Request) string
+	trustedProxies    []netip.Prefix
+}
+
+func (c *CSRFConfig) Mode() Mode {
+	return c.mode
+}
+
+func (c *CSRFConfig) CookieName() string {
+	return c.cookieName
+}
+
+func (c *CSRFConfig) HeaderName() string {
+	return c.headerName
+}
+
+func (c *CSRFConfig) FormField() string {
+	return c.formField
+}
+
+func (c *CSRFConfig) MaxAge() time.Duration {
+	return c.maxAge
+}
+
+func (c *CSRFConfig) ExemptPaths() []string {
+	return c.exemptPaths
+}
+
+func (c *CSRFConfig) TrustedOrigins() []string {
+	return c.trustedOrigins
+}
+
+func (c *CSRFConfig) RotateTokens() bool {
+	return c.rotateTokens
+}
+
+func NewCSRFConfig(options ...func(*CSRFConfig)) (*CSRFConfig, error) {
+	var config *CSRFConfig = &CSRFConfig{
+		mode:              DoubleSubmitCookie,
+		cookieName:        DefaultCookieName,
+		sessionCookieName: DefaultSessionCookieName,
+		headerName:        DefaultHeaderName,
+		formField:         DefaultFormField,
+		cookiePath:        "/",
+		cookieSecure:      true,
+		sameSite:          http.SameSiteLaxMode,
+		maxAge:            12 * time.Hour,
+		exemptPaths:       []string{},
+		trustedOrigins:    []string{},
+		rotateTokens:      false,
+	}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	if config.signingKey == nil {
+		// Tokens signed with a random key are invalidated by a restart.
+		config.signingKey = make([]byte, MinSigningKeyLength)
+		if _, err := rand.Read(config.signingKey); err != nil {
+			return nil, fmt.Errorf("failed to generate a CSRF signing key: %w", err)
+		}
+	} else if len(config.signingKey) < MinSigningKeyLength {
+		return nil, fmt.Errorf("the CSRF signing key must be at least %d bytes", MinSigningKeyLength)
+	}
+
+	if config.mode == SynchronizerToken && config.store == nil {
+		config.store = helpers.NewStringCache[string]()
+	}
+
+	return config, nil
+}
+
+func WithMode(mode Mode) func(*CSRFConfig) {
+	return func(c *CSRFConfig) {
+		c.mode = mode
+	}
+}
+
+func WithCookieName(name string) func(*CSRFConfig) {
+	return func(c *CSRFConfig) {
+		c.cookieName = name
+	}
+}
+
+func WithSessionCookieName(name string) func(*CSRFConfig) {
+	return func(c *CSRFConfig) {
+		c.sessionCookieName = name
+	}
+}
+
+func WithHeaderName(name string) func(*CSRFConfig) {
+	return func(c *CSRFConfig) {
+		c.headerName = name
+	}
+}
+
+func WithFormField(field string) func(*CSRFConfig) {
+	return func(c *CSRFConfig) {
+		c.formField = field
+	}
+}
+
+func WithCookiePath(path string) func(*CSRFConfig) {
+	return func(c *CSRFConfig) {
+		c.cookiePath = path
+	}
+}
+
+func WithCookieDomain(domain string) func(*CSRFConfig) {
+	return func(c *CSRFConfig) {
+		c.cookieDomain = domain
+	}
+}
+
+func WithCookieSecure(secure bool) func(*CSRFConfig) {
+	return func(c *CSRFConfig) {
+		c.cookieSecure = secure
+	}
+}
+
+func WithSameSite(sameSite http.SameSite) func(*CSRFConfig) {
+	return func(c *CSRFConfig) {
+		c.sameSite = sameSite
+	}
+}
+
+func WithMaxAge(maxAge time.Duration) func(*CSRFConfig) {
+	return func(c *CSRFConfig) {
+		c.maxAge = maxAge
+	}
+}
+
+// WithExemptPaths skips CSRF checks for the given paths. A trailing "*"
+// matches any path with that prefix; route patterns such as "/webhooks/{id}"
+// or "POST /webhooks/{id}" are matched against the Penguin route of the
+// request.
+func WithExemptPaths(paths []string) func(*CSRFConfig) {
+	return func(c *CSRFConfig) {
+		c.exemptPaths = paths
+	}
+}
+
+func WithTrustedOrigins(origins []string) func(*CSRFConfig) {
+	return func(c *CSRFConfig) {
+		c.trustedOrigins = origins
+	}
+}
+
+// WithCORSConfig trusts the allowed origins of a CORS configuration, so a
+// cross-origin frontend allowed by CORS can also perform unsafe requests.
+func WithCORSConfig(config *cors.CORSConfig) func(*CSRFConfig) {
+	return func(c *CSRFConfig) {
+		c.corsConfig = config
+	}
+}
+
+func WithTokenRotation(rotate bool) func(*CSRFConfig) {
+	return func(c *CSRFConfig) {
+		c.rotateTokens = rotate
+	}
+}
+
+func WithTokenStore(store helpers.StringCache[string]) func(*CSRFConfig) {
+	return func(c *CSRFConfig) {
+		c.store = store
+	}
+}
+
+// WithSigningKey sets the HMAC key of double-submit tokens, at least 32
+// bytes. Share it between instances behind a load balancer.
+func WithSigningKey(key []byte) func(*CSRFConfig) {
+	return func(c *CSRFConfig) {
+		c.signingKey = key
+	}
+}
+
+// WithSessionIDFunc binds tokens to the session returned by fn, e.g. the ID
+// of a sessions.Session, instead of the session cookie of the config. When
+// fn returns "" no token is issued and unsafe requests are rejected.
+func WithSessionIDFunc(fn func(r *http.Request) string) func(*CSRFConfig) {
+	return func(c *CSRFConfig) {
+		c.sessionIDFunc = fn
+	}
+}
+
+// WithTrustedProxies sets the proxies whose X-Forwarded-Proto header is
+// trusted when comparing the request scheme with the origin.
+func WithTrustedProxies(proxies []netip.Prefix) func(*CSRFConfig) {
+	return func(c *CSRFConfig) {
+		c.trustedProxies = proxies
+	}
+}
+
+func (c *CSRFConfig) IsExempt(r *http.Request) bool {
+	for _, path := range c.exemptPaths {
+		if prefix, ok := strings.CutSuffix(path, "*"); ok {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				return true
+			}
+			continue
+		}
+		if path == r.URL.Path {
+			return true
+		}
+		if info, ok := router.RouteInfoFromRequest(r); ok && (path == info.Path || path == string(info.Method)+" "+info.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateOrigin checks Sec-Fetch-Site and Origin (falling back to Referer)
+// against the request host, the trusted origins and the CORS allowed origins.
+func (c *CSRFConfig) ValidateOrigin(r *http.Request) error {
+	var fetchSite string = r.Header.Get("Sec-Fetch-Site")
+	if fetchSite == "same-origin" || fetchSite == "none" {
+		return nil
+	}
+
+	var origin string = r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		if referer := r.Header.Get("Referer"); referer != "" {
+			if u, err := url.Parse(referer); err == nil && u.Host != "" {
+				origin = u.Scheme + "://" + u.Host
+			}
+		}
+	}
+
+	if origin == "" {
+		if fetchSite == "cross-site" || fetchSite == "same-site" {
+			return routerErrors.ErrCSRFOriginNotAllowed(fetchSite)
+		}
+		return nil
+	}
+
+	if c.isTrustedOrigin(origin, r) {
+		return nil
+	}
+	return routerErrors.ErrCSRFOriginNotAllowed(origin)
+}
+
+func (c *CSRFConfig) isTrustedOrigin(origin string, r *http.Request) bool {
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) && strings.EqualFold(u.Scheme, helpers.RequestScheme(r, c.trustedProxies)) {
+		return true
+	}
+	if slices.Contains(c.trustedOrigins, origin) {
+		return true
+	}
+	// A CORS configuration allowing every origin is not a list of trusted
+	// origins.
+	return c.corsConfig != nil && !c.corsConfig.AllowsAllOrigins() && c.corsConfig.IsOriginAllowed(origin, r)
+}
+
+// ValidateToken compares the token sent in the header or form field with the
+// token expected for the request.
+func (c *CSRFConfig) ValidateToken(r *http.Request) error {
+	var expected string = c.expectedToken(r)
+	if expected == "" {
+		return routerErrors.ErrCSRFTokenMissing()
+	}
+
+	var sent string = r.Header.Get(c.headerName)
+	if sent == "" && c.formField != "" {
+		var contentType string = r.Header.Get("Content-Type")
+		if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") || strings.HasPrefix(contentType, "multipart/form-data") {
+			sent = r.PostFormValue(c.formField)
+		}
+	}
+	if sent == "" {
+		return routerErrors.ErrCSRFTokenMissing()
+	}
+
+	if subtle.ConstantTimeCompare([]byte(sent), []byte(expected)) != 1 {
+		return routerErrors.ErrCSRFTokenInvalid()
+	}
+	return nil
+}
+
+// IssueToken returns the current token for the request, generating and
+// persisting a new one when none exists yet.
+func (c *CSRFConfig) IssueToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if token := c.expectedToken(r); token != "" {
+		return token, nil
+	}
+	return c.RotateToken(w, r)
+}
+
+// RotateToken always generates a new token, invalidating the previous one.
+// Call it after login or any privilege change.
+func (c *CSRFConfig) RotateToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	var sessionID string = c.sessionID(r)
+	if sessionID == "" && c.sessionIDFunc != nil {
+		return "", ErrNoSession
+	}
+	if sessionID == "" {
+		sessionID, err = generateToken()
+		if err != nil {
+			return "", err
+		}
+		http.SetCookie(w, c.newCookie(c.sessionCookieName, sessionID, true))
+		r.AddCookie(&http.Cookie{Name: c.sessionCookieName, Value: sessionID})
+	}
+
+	switch c.mode {
+	case SynchronizerToken:
+		c.store.Store(sessionID, token, c.maxAge)
+	default:
+		token = token + "." + c.sign(sessionID, token)
+		http.SetCookie(w, c.newCookie(c.cookieName, token, false))
+	}
+
+	return token, nil
+}
+
+func (c *CSRFConfig) expectedToken(r *http.Request) string {
+	switch c.mode {
+	case SynchronizerToken:
+		var sessionID string = c.sessionID(r)
+		if sessionID == "" {
+			return ""
+		}
+		token, _ := c.store.Load(sessionID)
+		return token
+	default:
+		cookie, err := r.Cookie(c.cookieName)
+		if err != nil || !c.verify(c.sessionID(r), cookie.Value) {
+			return ""
+		}
+		return cookie.Value
+	}
+}
+
+// sign returns the HMAC of the random part of a token bound to the session.
+func (c *CSRFConfig) sign(sessionID string, random string) string {
+	var mac = hmac.New(sha256.New, c.signingKey)
+	mac.Write([]byte(sessionID))
+	mac.Write([]byte{0})
+	mac.Write([]byte(random))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (c *CSRFConfig) verify(sessionID string, token string) bool {
+	random, signature, ok := strings.Cut(token, ".")
+	if !ok || sessionID == "" {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(c.sign(sessionID, random)))
+}
+
+func (c *CSRFConfig) sessionID(r *http.Request) string {
+	if c.sessionIDFunc != nil {
+		return c.sessionIDFunc(r)
+	}
+	cookies := r.CookiesNamed(c.sessionCookieName)
+	if len(cookies) == 0 {
+		return ""
+	}
+	return cookies[len(cookies)-1].Value
+}
+
+func (c *CSRFConfig) newCookie(name string, value string, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     c.cookiePath,
+		Domain:   c.cookieDomain,
+		MaxAge:   int(c.maxAge.Seconds()),
+		Secure:   c.cookieSecure,
+		HttpOnly: httpOnly,
+		SameSite: c.sameSite,
+	}
+}
+
+func generateToken() (string, error) {
+	var b []byte = make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func ContextWithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, contextKey{}, token)
+}
+
+// Token returns the CSRF token issued for the request by the middleware.
+func Token(r *http.Request) string {
+	token, _ := r.Context().Value(contextKey{}).(string)
+	return token
+}
+
+// SetTokenHeader exposes the request token in the configured response header
+// so single page applications can read it.
+func SetTokenHeader(w http.ResponseWriter, r *http.Request, config *CSRFConfig) {
+	var headerName string = DefaultHeaderName
+	if config != nil {
+		headerName = config.headerName
+	}
+	if token := Token(r); token != "" {
+		w.Header().Set(headerName, token)
+	}
+}
+
+// TemplateField renders a hidden input with the request token for HTML forms.
+func TemplateField(r *http.Request, config *CSRFConfig) template.HTML {
+	var field string = DefaultFormField
+	if config != nil {
+		field = config.formField
+	}
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(field) + `" value="` + template.HTMLEscapeString(Token(r)) + `">`)
+}
@@ -43,3 +43,24 @@ var (
 		return fmt.Errorf("request body exceeds maximum size of %d bytes", maxSize)
 	}
 )
+
+var (
+	ErrCSRFTokenMissing = func() error {
+		return errors.New("CSRF token is missing")
+	}
+	ErrCSRFTokenInvalid = func() error {
+		return errors.New("CSRF token is invalid")
+	}
+	ErrCSRFOriginNotAllowed = func(origin string) error {
+		return fmt.Errorf("CSRF origin '%s' is not allowed", origin)
+	}
+)
+
+var (
+	ErrUnsupportedMediaType = func(mediaType string) error {
+		return fmt.Errorf("media type '%s' is not supported", mediaType)
+	}
+	ErrUnsupportedContentEncoding = func(encoding string) error {
+		return fmt.Errorf("content encoding '%s' is not supported", encoding)
+	}
+)
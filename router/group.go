@@ -0,0 +1,60 @@
+package router
+
+import "strings"
+
+// Group registers routes under a common path prefix. Group middlewares run
+// after the router-level middlewares and before the route handler.
+type Group struct {
+	router      *Router
+	prefix      string
+	middlewares []Middleware
+	cors        CORSPolicy
+}
+
+func (r *Router) Group(prefix string, middlewares ...Middleware) *Group {
+	return &Group{
+		router:      r,
+		prefix:      "/" + strings.Trim(prefix, "/"),
+		middlewares: middlewares,
+	}
+}
+
+func (g *Group) Prefix() string {
+	return g.prefix
+}
+
+func (g *Group) Use(middlewares ...Middleware) {
+	g.middlewares = append(g.middlewares, middlewares...)
+	g.router.chains.Clear()
+}
+
+// UseCORS sets the CORS policy of the group routes without a route policy.
+func (g *Group) UseCORS(policy CORSPolicy) {
+	g.cors = policy
+	g.router.chains.Clear()
+}
+
+// Group creates a nested group; it inherits the middlewares and the CORS
+// policy of its parent.
+func (g *Group) Group(prefix string, middlewares ...Middleware) *Group {
+	var inherited []Middleware = make([]Middleware, 0, len(g.middlewares)+len(middlewares))
+	inherited = append(inherited, g.middlewares...)
+	inherited = append(inherited, middlewares...)
+	return &Group{
+		router:      g.router,
+		prefix:      strings.TrimSuffix(g.prefix, "/") + "/" + strings.Trim(prefix, "/"),
+		middlewares: inherited,
+		cors:        g.cors,
+	}
+}
+
+func (g *Group) NewRoute(route Route) {
+	route.Path = strings.TrimSuffix(g.prefix, "/") + "/" + strings.TrimPrefix(route.Path, "/")
+	g.router.addRoute(route, g)
+}
+
+// RouteRegistrar is implemented by Router and Group, so packages that ship
+// ready-made routes can register them on either.
+type RouteRegistrar interface {
+	NewRoute(route Route)
+}
@@ -372,7 +372,14 @@ type stringCleaner struct {
 	cache *sync.Map
 }
 
+// Execute only deletes the entry when it has expired, so the job of a
+// replaced entry does not remove the new one early.
 func (c *stringCleaner) Execute() []any {
+	if item, ok := c.cache.Load(c.key); ok {
+		if entry, ok := item.(interface{ expiresAt() time.Time }); ok && time.Now().Before(entry.expiresAt()) {
+			return nil
+		}
+	}
 	c.cache.Delete(c.key)
 	return nil
 }
@@ -388,6 +395,10 @@ func (c stringCacheItem[T]) getValue() T {
 	return c.value
 }
 
+func (c stringCacheItem[T]) expiresAt() time.Time {
+	return c.generated.Add(c.expiration)
+}
+
 func newStringCacheItem[T any](value T, expiration time.Duration) stringCacheItem[T] {
 	return stringCacheItem[T]{
 		value:      value,
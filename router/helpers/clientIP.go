@@ -0,0 +1,111 @@
+package helpers
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ClientIP returns the address of the client that sent the request. The
+// X-Forwarded-For and X-Real-IP headers are only honoured when the direct
+// peer is one of the trusted proxies; X-Forwarded-For is walked from right to
+// left skipping trusted proxies so clients cannot spoof their address.
+func ClientIP(r *http.Request, trustedProxies []netip.Prefix) netip.Addr {
+	var remote netip.Addr = parseAddr(r.RemoteAddr)
+	if !remote.IsValid() || !containsAddr(trustedProxies, remote) {
+		return remote
+	}
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	var client netip.Addr = remote
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		var addr netip.Addr = parseAddr(forwarded[i])
+		if !addr.IsValid() {
+			break
+		}
+		client = addr
+		if !containsAddr(trustedProxies, addr) {
+			return addr
+		}
+	}
+	if client != remote {
+		return client
+	}
+
+	if realIP := parseAddr(r.Header.Get("X-Real-IP")); realIP.IsValid() {
+		return realIP
+	}
+	return remote
+}
+
+// RequestScheme returns "https" or "http" for the request. X-Forwarded-Proto
+// is only honoured when the direct peer is one of the trusted proxies.
+func RequestScheme(r *http.Request, trustedProxies []netip.Prefix) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if remote := parseAddr(r.RemoteAddr); remote.IsValid() && containsAddr(trustedProxies, remote) {
+		proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+		if proto = strings.ToLower(strings.TrimSpace(proto)); proto == "https" || proto == "http" {
+			return proto
+		}
+	}
+	return "http"
+}
+
+// ParsePrefixes parses CIDR ranges, accepting bare addresses as single-host
+// ranges.
+func ParsePrefixes(values []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix = make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		prefix, err := ParsePrefix(value)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+func ParsePrefix(value string) (netip.Prefix, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseAddr(value string) netip.Addr {
+	value = strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
@@ -5,15 +5,18 @@ import (
 	"net/http"
 )
 
-func GetContextValue[T any](r *http.Request, key string) (T, error) {
+// GetContextValue returns the value of a context key as T. The key is
+// compared like any context key, so pass the value GetContextKey returns,
+// not its string form. Use auth.ClaimsFrom for the authenticated user.
+func GetContextValue[T any](r *http.Request, key any) (T, error) {
 	var value T
 	v := r.Context().Value(key)
 	if v == nil {
-		return value, fmt.Errorf("key %q not found in context", key)
+		return value, fmt.Errorf("key %v not found in context", key)
 	}
 	val, ok := v.(T)
 	if !ok {
-		return value, fmt.Errorf("value for key %q is not of expected type %T", key, value)
+		return value, fmt.Errorf("value for key %v is not of expected type %T", key, value)
 	}
 	return val, nil
 }
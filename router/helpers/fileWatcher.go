@@ -0,0 +1,79 @@
+package helpers
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/logger"
+	"github.com/angelbarreiros/Penguin/scheduler"
+)
+
+const DefaultFileWatchInterval = 5 * time.Second
+
+// FileWatcher polls a file through the scheduler and calls onChange whenever
+// its modification time or size changes.
+type FileWatcher struct {
+	mu       sync.Mutex
+	path     string
+	modTime  time.Time
+	size     int64
+	onChange func() error
+	jobID    uint64
+	cleaner  *scheduler.Scheduler
+}
+
+func (f *FileWatcher) Execute() []any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		logger.GetConsoleLogger().Warn("File watcher could not stat %s: %v", f.path, err)
+		return nil
+	}
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return nil
+	}
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+
+	if err := f.onChange(); err != nil {
+		logger.GetConsoleLogger().Error("File watcher failed to reload %s: %v", f.path, err)
+		return []any{err}
+	}
+	logger.GetConsoleLogger().Info("File watcher reloaded %s", f.path)
+	return nil
+}
+
+func WatchFile(path string, interval time.Duration, onChange func() error) (*FileWatcher, error) {
+	if interval <= 0 {
+		interval = DefaultFileWatchInterval
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch file: %w", err)
+	}
+
+	var watcher *FileWatcher = &FileWatcher{
+		path:     path,
+		modTime:  info.ModTime(),
+		size:     info.Size(),
+		onChange: onChange,
+		cleaner:  scheduler.StartScheduler(),
+	}
+	watcher.jobID, err = watcher.cleaner.ScheduleIntervalJob(interval, scheduler.JobFunction(watcher))
+	if err != nil {
+		return nil, err
+	}
+	return watcher, nil
+}
+
+func (f *FileWatcher) Stop() {
+	if f == nil || f.jobID == 0 {
+		return
+	}
+	_ = f.cleaner.RemoveJob(f.jobID)
+	f.jobID = 0
+}
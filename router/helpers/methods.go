@@ -0,0 +1,13 @@
+package helpers
+
+import "net/http"
+
+// IsSafeMethod reports whether the method is safe as defined by RFC 9110,
+// i.e. it is not expected to change server state.
+func IsSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
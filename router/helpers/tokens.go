@@ -8,9 +8,23 @@ import (
 )
 
 func GenerateJwtToken(claims jwt.Claims, secret *ecdsa.PrivateKey) (string, error) {
+	return GenerateJwtTokenWithAlgorithm(claims, "ES512", secret, "")
+}
+
+// GenerateJwtTokenWithAlgorithm signs the claims with any supported JWS
+// algorithm. key is a []byte secret for HS*, or the private key otherwise.
+// kid is set in the header when not empty.
+func GenerateJwtTokenWithAlgorithm(claims jwt.Claims, algorithm string, key any, kid string) (string, error) {
+	var method jwt.SigningMethod = jwt.GetSigningMethod(algorithm)
+	if method == nil || method == jwt.SigningMethodNone {
+		return "", fmt.Errorf("unsupported signing algorithm '%s'", algorithm)
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodES512, claims)
-	tokenString, err := token.SignedString(secret)
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	tokenString, err := token.SignedString(key)
 	if err != nil {
 		return "", fmt.Errorf("failed to sign token: %w", err)
 	}
@@ -0,0 +1,243 @@
+package ipfilter
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/logger"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+)
+
+// GeoReader resolves an address to an ISO 3166-1 alpha-2 country code.
+// Implementations must work offline, e.g. MMDBReader.
+type GeoReader interface {
+	Country(addr netip.Addr) (string, error)
+}
+
+type IPFilterConfig struct {
+	mu               sync.RWMutex
+	allowList        []netip.Prefix
+	denyList         []netip.Prefix
+	trustedProxies   []netip.Prefix
+	geoReader        GeoReader
+	allowedCountries []string
+	deniedCountries  []string
+	listFile         string
+	reloadInterval   time.Duration
+	watcher          *helpers.FileWatcher
+	err              error
+}
+
+func NewIPFilterConfig(options ...func(*IPFilterConfig)) (*IPFilterConfig, error) {
+	var config *IPFilterConfig = &IPFilterConfig{
+		allowList:        []netip.Prefix{},
+		denyList:         []netip.Prefix{},
+		trustedProxies:   []netip.Prefix{},
+		allowedCountries: []string{},
+		deniedCountries:  []string{},
+		reloadInterval:   helpers.DefaultFileWatchInterval,
+	}
+
+	for _, option := range options {
+		option(config)
+	}
+	if config.err != nil {
+		return nil, config.err
+	}
+
+	if config.listFile != "" {
+		if err := config.Reload(); err != nil {
+			return nil, err
+		}
+		watcher, err := helpers.WatchFile(config.listFile, config.reloadInterval, config.Reload)
+		if err != nil {
+			return nil, err
+		}
+		config.watcher = watcher
+	}
+
+	return config, nil
+}
+
+// WithAllowList restricts access to the given CIDR ranges (IPv4 or IPv6).
+func WithAllowList(cidrs []string) func(*IPFilterConfig) {
+	return func(c *IPFilterConfig) {
+		c.allowList = c.parse(cidrs)
+	}
+}
+
+func WithDenyList(cidrs []string) func(*IPFilterConfig) {
+	return func(c *IPFilterConfig) {
+		c.denyList = c.parse(cidrs)
+	}
+}
+
+// WithTrustedProxies sets the proxies whose X-Forwarded-For header is trusted
+// when resolving the client address.
+func WithTrustedProxies(cidrs []string) func(*IPFilterConfig) {
+	return func(c *IPFilterConfig) {
+		c.trustedProxies = c.parse(cidrs)
+	}
+}
+
+func WithGeoReader(reader GeoReader) func(*IPFilterConfig) {
+	return func(c *IPFilterConfig) {
+		c.geoReader = reader
+	}
+}
+
+func WithAllowedCountries(countries []string) func(*IPFilterConfig) {
+	return func(c *IPFilterConfig) {
+		c.allowedCountries = normalizeCountries(countries)
+	}
+}
+
+func WithDeniedCountries(countries []string) func(*IPFilterConfig) {
+	return func(c *IPFilterConfig) {
+		c.deniedCountries = normalizeCountries(countries)
+	}
+}
+
+// WithListFile loads the allow and deny lists from a file and reloads it when
+// it changes. Each line is "allow <cidr>" or "deny <cidr>"; "#" starts a
+// comment. Lists loaded from the file replace the ones given as options.
+func WithListFile(path string, reloadInterval time.Duration) func(*IPFilterConfig) {
+	return func(c *IPFilterConfig) {
+		c.listFile = path
+		if reloadInterval > 0 {
+			c.reloadInterval = reloadInterval
+		}
+	}
+}
+
+func (c *IPFilterConfig) parse(cidrs []string) []netip.Prefix {
+	prefixes, err := helpers.ParsePrefixes(cidrs)
+	if err != nil && c.err == nil {
+		c.err = fmt.Errorf("invalid CIDR: %w", err)
+	}
+	return prefixes
+}
+
+func normalizeCountries(countries []string) []string {
+	var normalized []string = make([]string, 0, len(countries))
+	for _, country := range countries {
+		normalized = append(normalized, strings.ToUpper(strings.TrimSpace(country)))
+	}
+	return normalized
+}
+
+// Reload reads the list file again. It is called automatically by the file
+// watcher and can also be called manually, e.g. from a signal handler.
+func (c *IPFilterConfig) Reload() error {
+	if c.listFile == "" {
+		return nil
+	}
+	file, err := os.Open(c.listFile)
+	if err != nil {
+		return fmt.Errorf("failed to open IP list file: %w", err)
+	}
+	defer file.Close()
+
+	var allowList, denyList []netip.Prefix
+	var scanner *bufio.Scanner = bufio.NewScanner(file)
+	var lineNumber int
+	for scanner.Scan() {
+		lineNumber++
+		var line string = scanner.Text()
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		var fields []string = strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid IP list line %d: expected '<allow|deny> <cidr>'", lineNumber)
+		}
+		prefix, err := helpers.ParsePrefix(fields[1])
+		if err != nil {
+			return fmt.Errorf("invalid IP list line %d: %w", lineNumber, err)
+		}
+		switch strings.ToLower(fields[0]) {
+		case "allow":
+			allowList = append(allowList, prefix)
+		case "deny":
+			denyList = append(denyList, prefix)
+		default:
+			return fmt.Errorf("invalid IP list line %d: unknown action '%s'", lineNumber, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read IP list file: %w", err)
+	}
+
+	c.mu.Lock()
+	c.allowList = allowList
+	c.denyList = denyList
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *IPFilterConfig) Close() {
+	c.watcher.Stop()
+}
+
+func (c *IPFilterConfig) ClientIP(r *http.Request) netip.Addr {
+	return helpers.ClientIP(r, c.trustedProxies)
+}
+
+// Allowed applies, in order: deny list, denied countries, then the allow list
+// and allowed countries. When neither allow rule is configured every address
+// not denied is allowed.
+func (c *IPFilterConfig) Allowed(addr netip.Addr) (bool, error) {
+	if !addr.IsValid() {
+		return false, fmt.Errorf("client address is invalid")
+	}
+	addr = addr.Unmap()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if containsAddr(c.denyList, addr) {
+		return false, fmt.Errorf("address %s is denied", addr)
+	}
+
+	var country string
+	if c.geoReader != nil && (len(c.allowedCountries) > 0 || len(c.deniedCountries) > 0) {
+		var err error
+		country, err = c.geoReader.Country(addr)
+		if err != nil {
+			logger.GetConsoleLogger().Warn("IP filter geo lookup failed for %s: %v", addr, err)
+		}
+		if country != "" && slices.Contains(c.deniedCountries, country) {
+			return false, fmt.Errorf("country %s is denied", country)
+		}
+	}
+
+	if len(c.allowList) == 0 && len(c.allowedCountries) == 0 {
+		return true, nil
+	}
+	if containsAddr(c.allowList, addr) {
+		return true, nil
+	}
+	if country != "" && slices.Contains(c.allowedCountries, country) {
+		return true, nil
+	}
+	return false, fmt.Errorf("address %s is not allowed", addr)
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,363 @@
+package ipfilter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+const (
+	mmdbDataSectionSeparator = 16
+	// mmdbMaxDepth and mmdbMaxValues bound the decoding of one record, so a
+	// corrupt or malicious file cannot recurse or fan out without limit.
+	mmdbMaxDepth  = 64
+	mmdbMaxValues = 1 << 16
+)
+
+// MMDBReader is a minimal reader for MaxMind DB files (GeoLite2/GeoIP2
+// Country and City) parsed locally, without network access.
+type MMDBReader struct {
+	buffer      []byte
+	nodeCount   uint
+	recordSize  uint
+	ipVersion   uint
+	treeSize    uint
+	dataSection []byte
+	ipv4Start   uint
+}
+
+func OpenMMDB(path string) (*MMDBReader, error) {
+	buffer, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MMDB file: %w", err)
+	}
+	return NewMMDBReader(buffer)
+}
+
+func NewMMDBReader(buffer []byte) (*MMDBReader, error) {
+	var markerIndex int = bytes.LastIndex(buffer, mmdbMetadataMarker)
+	if markerIndex < 0 {
+		return nil, fmt.Errorf("invalid MMDB file: metadata marker not found")
+	}
+	var metadataStart int = markerIndex + len(mmdbMetadataMarker)
+
+	var decoder mmdbDecoder = mmdbDecoder{buffer: buffer[metadataStart:]}
+	value, _, err := decoder.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MMDB metadata: %w", err)
+	}
+	metadata, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid MMDB metadata: expected map")
+	}
+
+	var reader *MMDBReader = &MMDBReader{
+		buffer:     buffer,
+		nodeCount:  uint(toUint64(metadata["node_count"])),
+		recordSize: uint(toUint64(metadata["record_size"])),
+		ipVersion:  uint(toUint64(metadata["ip_version"])),
+	}
+	switch reader.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported MMDB record size %d", reader.recordSize)
+	}
+
+	if reader.nodeCount > uint(markerIndex) {
+		return nil, fmt.Errorf("invalid MMDB file: search tree exceeds file size")
+	}
+	reader.treeSize = reader.nodeCount * reader.recordSize / 4
+	var dataStart uint = reader.treeSize + mmdbDataSectionSeparator
+	if dataStart > uint(markerIndex) {
+		return nil, fmt.Errorf("invalid MMDB file: search tree exceeds file size")
+	}
+	reader.dataSection = buffer[dataStart:markerIndex]
+
+	if reader.ipVersion == 6 {
+		var node uint
+		for i := 0; i < 96 && node < reader.nodeCount; i++ {
+			node = reader.readRecord(node, 0)
+		}
+		reader.ipv4Start = node
+	}
+
+	return reader, nil
+}
+
+// Lookup returns the decoded record for the address, or nil when the
+// address is not in the database.
+func (m *MMDBReader) Lookup(addr netip.Addr) (any, error) {
+	addr = addr.Unmap()
+	var node uint
+	var bitCount int = addr.BitLen()
+	if addr.Is4() {
+		node = m.ipv4Start
+	} else if m.ipVersion == 4 {
+		return nil, fmt.Errorf("IPv6 address %s in an IPv4 only database", addr)
+	}
+
+	var ip []byte = addr.AsSlice()
+	for i := 0; i < bitCount && node < m.nodeCount; i++ {
+		var bit uint = uint(ip[i>>3]>>(7-uint(i%8))) & 1
+		node = m.readRecord(node, bit)
+	}
+
+	if node == m.nodeCount {
+		return nil, nil
+	}
+	if node < m.nodeCount {
+		return nil, fmt.Errorf("invalid MMDB search tree")
+	}
+
+	var offset uint = node - m.nodeCount - mmdbDataSectionSeparator
+	if offset >= uint(len(m.dataSection)) {
+		return nil, fmt.Errorf("invalid MMDB data pointer")
+	}
+	var decoder mmdbDecoder = mmdbDecoder{buffer: m.dataSection}
+	value, _, err := decoder.decode(offset, 0)
+	return value, err
+}
+
+// Country implements GeoReader using the country (or registered country)
+// ISO code of the record.
+func (m *MMDBReader) Country(addr netip.Addr) (string, error) {
+	record, err := m.Lookup(addr)
+	if err != nil || record == nil {
+		return "", err
+	}
+	fields, ok := record.(map[string]any)
+	if !ok {
+		return "", nil
+	}
+	for _, key := range []string{"country", "registered_country"} {
+		if country, ok := fields[key].(map[string]any); ok {
+			if isoCode, ok := country["iso_code"].(string); ok && isoCode != "" {
+				return strings.ToUpper(isoCode), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func (m *MMDBReader) readRecord(node uint, bit uint) uint {
+	var base uint = node * m.recordSize / 4
+	var b []byte = m.buffer[base : base+m.recordSize/4]
+	switch m.recordSize {
+	case 24:
+		var offset uint = bit * 3
+		return uint(b[offset])<<16 | uint(b[offset+1])<<8 | uint(b[offset+2])
+	case 28:
+		if bit == 0 {
+			return (uint(b[3])&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return (uint(b[3])&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		var offset uint = bit * 4
+		return uint(binary.BigEndian.Uint32(b[offset : offset+4]))
+	}
+}
+
+type mmdbDecoder struct {
+	buffer []byte
+	values int
+}
+
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbSlice
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBool
+	mmdbFloat32
+)
+
+func (d *mmdbDecoder) decode(offset uint, depth int) (any, uint, error) {
+	if offset >= uint(len(d.buffer)) {
+		return nil, 0, fmt.Errorf("unexpected end of MMDB data")
+	}
+	if depth > mmdbMaxDepth {
+		return nil, 0, fmt.Errorf("MMDB data nested too deeply")
+	}
+	if d.values++; d.values > mmdbMaxValues {
+		return nil, 0, fmt.Errorf("MMDB record has too many values")
+	}
+	var control byte = d.buffer[offset]
+	offset++
+	var dataType int = int(control >> 5)
+
+	if dataType == mmdbPointer {
+		pointer, next, err := d.decodePointer(control, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		// The spec forbids pointers to pointers, which could form loops.
+		if pointer >= uint(len(d.buffer)) || d.buffer[pointer]>>5 == mmdbPointer {
+			return nil, 0, fmt.Errorf("invalid MMDB pointer")
+		}
+		value, _, err := d.decode(pointer, depth+1)
+		return value, next, err
+	}
+
+	if dataType == mmdbExtended {
+		if offset >= uint(len(d.buffer)) {
+			return nil, 0, fmt.Errorf("unexpected end of MMDB data")
+		}
+		dataType = 7 + int(d.buffer[offset])
+		offset++
+	}
+
+	size, offset, err := d.decodeSize(control, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Every map entry takes at least two bytes and every element one, so
+	// larger sizes cannot be valid.
+	var remaining uint = uint(len(d.buffer)) - offset
+	if (dataType == mmdbMap && size > remaining/2) || (dataType == mmdbSlice && size > remaining) {
+		return nil, 0, fmt.Errorf("invalid MMDB container size %d", size)
+	}
+
+	if dataType == mmdbMap {
+		var result map[string]any = make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			keyString, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("invalid MMDB map key")
+			}
+			value, next, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			result[keyString] = value
+			offset = next
+		}
+		return result, offset, nil
+	}
+	if dataType == mmdbSlice {
+		var result []any = make([]any, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			result = append(result, value)
+			offset = next
+		}
+		return result, offset, nil
+	}
+	if dataType == mmdbBool {
+		return size != 0, offset, nil
+	}
+
+	var end uint = offset + size
+	if end > uint(len(d.buffer)) {
+		return nil, 0, fmt.Errorf("unexpected end of MMDB data")
+	}
+	var raw []byte = d.buffer[offset:end]
+
+	switch dataType {
+	case mmdbString:
+		return string(raw), end, nil
+	case mmdbBytes:
+		return append([]byte(nil), raw...), end, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid MMDB double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), end, nil
+	case mmdbFloat32:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid MMDB float size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), end, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64, mmdbInt32:
+		var value uint64
+		for _, b := range raw {
+			value = value<<8 | uint64(b)
+		}
+		if dataType == mmdbInt32 {
+			return int64(int32(uint32(value))), end, nil
+		}
+		return value, end, nil
+	case mmdbUint128:
+		return append([]byte(nil), raw...), end, nil
+	case mmdbContainer, mmdbEndMarker:
+		return nil, end, nil
+	}
+	return nil, 0, fmt.Errorf("unknown MMDB data type %d", dataType)
+}
+
+func (d *mmdbDecoder) decodeSize(control byte, offset uint) (uint, uint, error) {
+	var size uint = uint(control & 0x1f)
+	if size < 29 {
+		return size, offset, nil
+	}
+	var extra uint = size - 28
+	if offset+extra > uint(len(d.buffer)) {
+		return 0, 0, fmt.Errorf("unexpected end of MMDB data")
+	}
+	var value uint
+	for _, b := range d.buffer[offset : offset+extra] {
+		value = value<<8 | uint(b)
+	}
+	switch size {
+	case 29:
+		size = 29 + value
+	case 30:
+		size = 285 + value
+	default:
+		size = 65821 + value
+	}
+	return size, offset + extra, nil
+}
+
+func (d *mmdbDecoder) decodePointer(control byte, offset uint) (uint, uint, error) {
+	var pointerSize uint = uint((control>>3)&0x3) + 1
+	if offset+pointerSize > uint(len(d.buffer)) {
+		return 0, 0, fmt.Errorf("unexpected end of MMDB data")
+	}
+	var value uint
+	for _, b := range d.buffer[offset : offset+pointerSize] {
+		value = value<<8 | uint(b)
+	}
+	switch pointerSize {
+	case 1:
+		value = uint(control&0x7)<<8 | value
+	case 2:
+		value = (uint(control&0x7)<<16 | value) + 2048
+	case 3:
+		value = (uint(control&0x7)<<24 | value) + 526336
+	}
+	return value, offset + pointerSize, nil
+}
+
+func toUint64(value any) uint64 {
+	switch v := value.(type) {
+	case uint64:
+		return v
+	case int64:
+		return uint64(v)
+	}
+	return 0
+}
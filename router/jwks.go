@@ -0,0 +1,9 @@
+package router
+
+import "github.com/angelbarreiros/Penguin/router/auth"
+
+// RegisterJWKSRoute serves the public keys of a key set at
+// /.well-known/jwks.json on a router or group.
+func RegisterJWKSRoute(r RouteRegistrar, keys *auth.KeySet) {
+	r.NewRoute(Route{Path: auth.JWKSPath, Method: GET, Handler: auth.JWKSHandler(keys)})
+}
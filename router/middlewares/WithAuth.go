@@ -2,9 +2,11 @@ package middlewares
 
 import (
 	"context"
+	"errors"
 	"net/http"
 
 	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/helpers"
 )
 
 func WithAuthMiddleWare(auth auth.PlainAuthInterface, hf http.HandlerFunc) http.HandlerFunc {
@@ -18,25 +20,14 @@ func authMiddleWareFunc(auth auth.PlainAuthInterface) middlewareFunc {
 				hf(w, r)
 				return
 			}
-			if authorize, err := auth.Authorize(r); !authorize || err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusUnauthorized)
-				w.Write([]byte(`{"error": "Unauthorized: ` + err.Error() + `"}`))
-				return
-
-			}
-			user, err := auth.GetUser(r)
-
+			user, err := authenticate(auth, r)
 			if err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusUnauthorized)
-				w.Write([]byte(`{"error": "Unauthorized: ` + err.Error() + `"}`))
+				writeAuthError(w, auth, err)
 				return
 			}
 			var ctx, cancel = context.WithTimeout(r.Context(), auth.GetTimeout())
 			defer cancel()
-			ctx = context.WithValue(ctx, auth.GetContextKey(), user)
-			r = r.WithContext(ctx)
+			r = r.WithContext(withUser(ctx, auth, user))
 			hf(w, r)
 		}
 	}
@@ -44,27 +35,16 @@ func authMiddleWareFunc(auth auth.PlainAuthInterface) middlewareFunc {
 
 func WithAuthAndRBAC(authType auth.RBACAuthInterface, roles []string, hf http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-
-		if authorize, err := authType.Authorize(r); !authorize || err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(`{"error": "Unauthorized: ` + err.Error() + `"}`))
-			return
-		}
-
-		user, err := authType.GetUser(r)
+		user, err := authenticate(authType, r)
 		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(`{"error": "Unauthorized: ` + err.Error() + `"}`))
+			writeAuthError(w, authType, err)
 			return
 		}
 
 		ctx, cancel := context.WithTimeout(r.Context(), authType.GetTimeout())
 		defer cancel()
-		ctx = context.WithValue(ctx, authType.GetContextKey(), user)
-		r = r.WithContext(ctx)
-		if !authType.RBAC(roles) {
+		r = r.WithContext(withUser(ctx, authType, user))
+		if !authType.RBAC(user, roles) {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusForbidden)
 			w.Write([]byte(`{"error": "Forbidden: You don't have the required role"}`))
@@ -74,3 +54,48 @@ func WithAuthAndRBAC(authType auth.RBACAuthInterface, roles []string, hf http.Ha
 		hf(w, r)
 	}
 }
+
+// withUser stores the user for auth.UserFrom, auth.PrincipalFrom and
+// auth.ClaimsFrom, and under the context key of the provider for handlers
+// that read it directly.
+func withUser(ctx context.Context, provider auth.PlainAuthInterface, user any) context.Context {
+	if key := provider.GetContextKey(); key != nil {
+		ctx = context.WithValue(ctx, key, user)
+	}
+	return auth.ContextWithUser(ctx, user)
+}
+
+// authenticate runs the provider and rejects users it reports as revoked.
+func authenticate(provider auth.PlainAuthInterface, r *http.Request) (any, error) {
+	user, err := provider.Authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := auth.CheckRevocation(provider, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// writeAuthError answers with the RFC 6750 challenge of the error: 400 for
+// malformed requests, 401 otherwise. A failing revocation check or
+// introspection endpoint is a 503.
+// Providers with their own scheme set the challenge.
+func writeAuthError(w http.ResponseWriter, provider auth.PlainAuthInterface, err error) {
+	if errors.Is(err, auth.ErrRevocationUnavailable) || errors.Is(err, auth.ErrIntrospectionUnavailable) {
+		helpers.SendErrorResponse(w, http.StatusServiceUnavailable, "Service Unavailable")
+		return
+	}
+	var status int = http.StatusUnauthorized
+	if auth.BearerError(err) == auth.BearerErrorInvalidRequest {
+		status = http.StatusBadRequest
+	}
+	if challenger, ok := provider.(auth.ChallengeAuthInterface); ok {
+		if challenge := challenger.Challenge(err); challenge != "" {
+			w.Header().Set("WWW-Authenticate", challenge)
+		}
+	} else {
+		w.Header().Set("WWW-Authenticate", auth.BearerChallenge("", err))
+	}
+	helpers.SendErrorResponse(w, status, "Unauthorized: "+err.Error())
+}
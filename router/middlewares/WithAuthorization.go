@@ -0,0 +1,74 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/angelbarreiros/Penguin/logger"
+	"github.com/angelbarreiros/Penguin/router/authz"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+)
+
+// WithAuthorization loads the resource with the resolver and only lets the
+// request through when the engine allows the action on it. It must run
+// after the auth middleware: requests without a user get 401 before the
+// resource is loaded. A nil resolver checks the action without a resource.
+// The handler reads the resource with authz.Resource and can run further
+// checks with authz.Check. A nil engine denies every request with 500.
+func WithAuthorization(engine *authz.Engine, action string, resolver authz.ResourceResolver, hf http.HandlerFunc) http.HandlerFunc {
+	return authorization(engine, action, resolver)(hf)
+}
+
+// AuthorizationMiddleware prepares the request context for authz.Check
+// without gating the route, e.g. with Router.Use.
+func AuthorizationMiddleware(engine *authz.Engine) middlewareFunc {
+	return authorization(engine, "", nil)
+}
+
+func authorization(engine *authz.Engine, action string, resolver authz.ResourceResolver) middlewareFunc {
+	return func(hf http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if engine == nil {
+				logger.GetConsoleLogger().Error("No authorization engine configured for %s", action)
+				helpers.SendErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+			r = r.WithContext(engine.ContextFromRequest(r))
+			if action == "" {
+				hf(w, r)
+				return
+			}
+			if !engine.Authenticated(r.Context()) {
+				helpers.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+
+			var resource any
+			if resolver != nil {
+				var err error
+				resource, err = resolver(r)
+				if errors.Is(err, authz.ErrResourceNotFound) {
+					helpers.SendErrorResponse(w, http.StatusNotFound, "Not Found")
+					return
+				}
+				if err != nil {
+					logger.GetConsoleLogger().Error("Failed to resolve the resource of %s: %v", action, err)
+					helpers.SendErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+					return
+				}
+				r = r.WithContext(authz.WithResource(r.Context(), resource))
+			}
+
+			var decision authz.Decision = engine.Decide(r.Context(), action, resource)
+			if !decision.Allowed {
+				if !decision.Authenticated {
+					helpers.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+					return
+				}
+				helpers.SendErrorResponse(w, http.StatusForbidden, "Forbidden")
+				return
+			}
+			hf(w, r)
+		}
+	}
+}
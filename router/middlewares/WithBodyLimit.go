@@ -0,0 +1,249 @@
+package middlewares
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/angelbarreiros/Penguin/router"
+	routerErrors "github.com/angelbarreiros/Penguin/router/errors"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+)
+
+const (
+	DefaultMaxBodyBytes          int64 = 1 << 20 // 1 MiB
+	DefaultMaxDecompressionRatio int64 = 100
+)
+
+type bodyLimitOption func(*bodyLimitConfig)
+type bodyLimitConfig struct {
+	maxBytes              int64
+	maxDecompressedBytes  int64
+	maxDecompressionRatio int64
+	consumes              []string
+	allowGzip             bool
+}
+
+func BodyLimitOptMaxBytes(maxBytes int64) bodyLimitOption {
+	return func(c *bodyLimitConfig) {
+		c.maxBytes = maxBytes
+	}
+}
+
+// BodyLimitOptConsumes overrides the media types declared on the route.
+func BodyLimitOptConsumes(mediaTypes ...string) bodyLimitOption {
+	return func(c *bodyLimitConfig) {
+		c.consumes = mediaTypes
+	}
+}
+
+func BodyLimitOptGzip(allow bool) bodyLimitOption {
+	return func(c *bodyLimitConfig) {
+		c.allowGzip = allow
+	}
+}
+
+// BodyLimitOptMaxDecompressedBytes bounds the size of a gzip body once
+// decompressed. It defaults to maxBytes * maxDecompressionRatio.
+func BodyLimitOptMaxDecompressedBytes(maxBytes int64) bodyLimitOption {
+	return func(c *bodyLimitConfig) {
+		c.maxDecompressedBytes = maxBytes
+	}
+}
+
+func BodyLimitOptMaxDecompressionRatio(ratio int64) bodyLimitOption {
+	return func(c *bodyLimitConfig) {
+		c.maxDecompressionRatio = ratio
+	}
+}
+
+func WithBodyLimit(hf http.HandlerFunc, opts ...bodyLimitOption) http.HandlerFunc {
+	return bodyLimit(opts...)(hf)
+}
+
+// BodyLimitMiddleware returns the body limit middleware so it can be applied
+// to every route with Router.Use.
+func BodyLimitMiddleware(opts ...bodyLimitOption) middlewareFunc {
+	return bodyLimit(opts...)
+}
+
+func bodyLimit(opts ...bodyLimitOption) middlewareFunc {
+	var config *bodyLimitConfig = &bodyLimitConfig{
+		maxBytes:              DefaultMaxBodyBytes,
+		maxDecompressionRatio: DefaultMaxDecompressionRatio,
+		allowGzip:             true,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if config.maxDecompressedBytes <= 0 {
+		config.maxDecompressedBytes = config.maxBytes * config.maxDecompressionRatio
+	}
+
+	return func(hf http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+				hf(w, r)
+				return
+			}
+
+			var consumes []string = config.consumes
+			if len(consumes) == 0 {
+				if info, ok := router.RouteInfoFromRequest(r); ok {
+					consumes = info.Consumes
+				}
+			}
+			if len(consumes) > 0 {
+				mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+				if err != nil || !mediaTypeAllowed(mediaType, consumes) {
+					helpers.SendErrorResponse(w, http.StatusUnsupportedMediaType, routerErrors.ErrUnsupportedMediaType(r.Header.Get("Content-Type")).Error())
+					return
+				}
+			}
+
+			if r.ContentLength > config.maxBytes {
+				helpers.SendErrorResponse(w, http.StatusRequestEntityTooLarge, routerErrors.ErrRequestBodyTooLarge(int(config.maxBytes)).Error())
+				return
+			}
+
+			var limited *limitedBody = &limitedBody{
+				compressed: &countingReader{reader: http.MaxBytesReader(w, r.Body, config.maxBytes)},
+				closer:     r.Body,
+			}
+			limited.reader = limited.compressed
+
+			var encoding string = strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+			switch encoding {
+			case "", "identity":
+			case "gzip", "x-gzip":
+				if !config.allowGzip {
+					helpers.SendErrorResponse(w, http.StatusUnsupportedMediaType, routerErrors.ErrUnsupportedContentEncoding(encoding).Error())
+					return
+				}
+				gz, err := gzip.NewReader(limited.compressed)
+				if err != nil {
+					if limited.exceeded(err) {
+						helpers.SendErrorResponse(w, http.StatusRequestEntityTooLarge, routerErrors.ErrRequestBodyTooLarge(int(config.maxBytes)).Error())
+						return
+					}
+					helpers.SendErrorResponse(w, http.StatusBadRequest, routerErrors.ErrRequestBodyInvalid(err.Error()).Error())
+					return
+				}
+				limited.reader = &ratioReader{
+					reader:     gz,
+					compressed: limited.compressed,
+					maxBytes:   config.maxDecompressedBytes,
+					maxRatio:   config.maxDecompressionRatio,
+				}
+				r.Header.Del("Content-Encoding")
+				r.Header.Del("Content-Length")
+				r.ContentLength = -1
+			default:
+				helpers.SendErrorResponse(w, http.StatusUnsupportedMediaType, routerErrors.ErrUnsupportedContentEncoding(encoding).Error())
+				return
+			}
+
+			r.Body = limited
+			var tracker *writeTracker = &writeTracker{ResponseWriter: w}
+			hf(tracker, r)
+
+			if limited.tooLarge && !tracker.written {
+				helpers.SendErrorResponse(w, http.StatusRequestEntityTooLarge, routerErrors.ErrRequestBodyTooLarge(int(config.maxBytes)).Error())
+			}
+		}
+	}
+}
+
+func mediaTypeAllowed(mediaType string, consumes []string) bool {
+	for _, allowed := range consumes {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == "*/*" || allowed == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+type countingReader struct {
+	reader io.Reader
+	read   int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.read += int64(n)
+	return n, err
+}
+
+type ratioReader struct {
+	reader     io.Reader
+	compressed *countingReader
+	read       int64
+	maxBytes   int64
+	maxRatio   int64
+}
+
+func (rr *ratioReader) Read(p []byte) (int, error) {
+	n, err := rr.reader.Read(p)
+	rr.read += int64(n)
+	// Reported as *http.MaxBytesError so helpers.DeserializeBodyWithLimit and
+	// handlers treat a decompression bomb like any other oversized body.
+	if rr.read > rr.maxBytes {
+		return n, &http.MaxBytesError{Limit: rr.maxBytes}
+	}
+	if rr.maxRatio > 0 && rr.compressed.read > 0 && rr.read > rr.compressed.read*rr.maxRatio {
+		return n, &http.MaxBytesError{Limit: rr.compressed.read * rr.maxRatio}
+	}
+	return n, err
+}
+
+// limitedBody records whether the handler hit one of the limits so the
+// middleware can answer with 413 when the handler did not write a response.
+type limitedBody struct {
+	reader     io.Reader
+	compressed *countingReader
+	closer     io.Closer
+	tooLarge   bool
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	n, err := l.reader.Read(p)
+	if err != nil && l.exceeded(err) {
+		l.tooLarge = true
+	}
+	return n, err
+}
+
+func (l *limitedBody) Close() error {
+	return l.closer.Close()
+}
+
+func (l *limitedBody) exceeded(err error) bool {
+	var maxBytesError *http.MaxBytesError
+	return errors.As(err, &maxBytesError)
+}
+
+type writeTracker struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (t *writeTracker) WriteHeader(statusCode int) {
+	t.written = true
+	t.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (t *writeTracker) Write(b []byte) (int, error) {
+	t.written = true
+	return t.ResponseWriter.Write(b)
+}
+
+func (t *writeTracker) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
@@ -2,11 +2,9 @@ package middlewares
 
 import (
 	"net/http"
-	"slices"
-	"strconv"
-	"strings"
 
 	"github.com/angelbarreiros/Penguin/router/cors"
+	"github.com/angelbarreiros/Penguin/router/helpers"
 )
 
 func WithCors(corrsConfig *cors.CORSConfig, hf http.HandlerFunc) http.HandlerFunc {
@@ -21,24 +19,11 @@ func corsMiddleware(corrsConfig *cors.CORSConfig) middlewareFunc {
 				return
 			}
 
-			origin := r.Header.Get("Origin")
-			allowAllOrigins := slices.Contains(corrsConfig.AllowedOrigins(), cors.AllowAllOrigin)
-
-			if r.Method == http.MethodOptions {
-				// For OPTIONS: echo back the actual origin if allowed, or "*" if all origins allowed
-				if allowAllOrigins {
-					w.Header().Set("Access-Control-Allow-Origin", cors.AllowAllOrigin)
-				} else if origin != "" && slices.Contains(corrsConfig.AllowedOrigins(), origin) {
-					w.Header().Set("Access-Control-Allow-Origin", origin)
-				}
-
-				w.Header().Set("Access-Control-Allow-Headers", strings.Join(corrsConfig.AllowedHeaders(), ","))
-				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corrsConfig.MaxAge()))
-				if corrsConfig.AllowCredentials() {
-					w.Header().Set("Access-Control-Allow-Credentials", "true")
-				}
-				if corrsConfig.ExposedHeaders() != nil {
-					w.Header().Set("Access-Control-Expose-Headers", strings.Join(corrsConfig.ExposedHeaders(), ","))
+			if cors.IsPreflight(r) {
+				// Rejected preflights get no CORS headers, so the browser blocks the request.
+				if err := corrsConfig.CheckPreflight(w, r, nil); err != nil {
+					helpers.SendErrorResponse(w, http.StatusForbidden, "CORS preflight rejected: "+err.Error())
+					return
 				}
 				if corrsConfig.OptionsPassthrough() {
 					hf(w, r)
@@ -48,33 +33,11 @@ func corsMiddleware(corrsConfig *cors.CORSConfig) middlewareFunc {
 				return
 			}
 
-			// For regular requests: empty origin is allowed (same-origin or non-browser tools)
-			// Only reject if origin is non-empty AND not in allowed list
-			if !allowAllOrigins && origin != "" && !slices.Contains(corrsConfig.AllowedOrigins(), origin) {
-				// Add CORS headers to error response so browser can read it
-				w.Header().Set("Access-Control-Allow-Origin", origin)
-				w.Header().Set("Access-Control-Allow-Headers", strings.Join(corrsConfig.AllowedHeaders(), ","))
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusForbidden)
-				w.Write([]byte(`{"error": "Origin not allowed"}`))
+			// Empty origin is allowed (same-origin or non-browser tools)
+			if err := corrsConfig.CheckRequest(w, r); err != nil {
+				helpers.SendErrorResponse(w, http.StatusForbidden, "Origin not allowed")
 				return
 			}
-
-			// Set CORS headers for allowed requests
-			if allowAllOrigins {
-				w.Header().Set("Access-Control-Allow-Origin", cors.AllowAllOrigin)
-			} else if origin != "" {
-				w.Header().Set("Access-Control-Allow-Origin", origin)
-			}
-
-			w.Header().Set("Access-Control-Allow-Headers", strings.Join(corrsConfig.AllowedHeaders(), ","))
-			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corrsConfig.MaxAge()))
-			if corrsConfig.AllowCredentials() {
-				w.Header().Set("Access-Control-Allow-Credentials", "true")
-			}
-			if corrsConfig.ExposedHeaders() != nil {
-				w.Header().Set("Access-Control-Expose-Headers", strings.Join(corrsConfig.ExposedHeaders(), ","))
-			}
 			hf(w, r)
 		}
 	}
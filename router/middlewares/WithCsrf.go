@@ -0,0 +1,50 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/angelbarreiros/Penguin/router/csrf"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+)
+
+func WithCsrf(csrfConfig *csrf.CSRFConfig, hf http.HandlerFunc) http.HandlerFunc {
+	return csrfMiddleware(csrfConfig)(hf)
+}
+
+func csrfMiddleware(csrfConfig *csrf.CSRFConfig) middlewareFunc {
+	return func(hf http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if csrfConfig == nil || csrfConfig.IsExempt(r) {
+				hf(w, r)
+				return
+			}
+
+			token, err := csrfConfig.IssueToken(w, r)
+			if err != nil && !errors.Is(err, csrf.ErrNoSession) {
+				helpers.SendErrorResponse(w, http.StatusInternalServerError, "Failed to issue CSRF token")
+				return
+			}
+
+			if !helpers.IsSafeMethod(r.Method) {
+				if err := csrfConfig.ValidateOrigin(r); err != nil {
+					helpers.SendErrorResponse(w, http.StatusForbidden, "Forbidden: "+err.Error())
+					return
+				}
+				if err := csrfConfig.ValidateToken(r); err != nil {
+					helpers.SendErrorResponse(w, http.StatusForbidden, "Forbidden: "+err.Error())
+					return
+				}
+				if csrfConfig.RotateTokens() {
+					if token, err = csrfConfig.RotateToken(w, r); err != nil {
+						helpers.SendErrorResponse(w, http.StatusInternalServerError, "Failed to issue CSRF token")
+						return
+					}
+				}
+			}
+
+			r = r.WithContext(csrf.ContextWithToken(r.Context(), token))
+			hf(w, r)
+		}
+	}
+}
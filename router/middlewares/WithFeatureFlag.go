@@ -0,0 +1,37 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/angelbarreiros/Penguin/flags"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+)
+
+// WithFeatureFlag only lets the request through when the flag is enabled for
+// it, and prepares the context so the handler can evaluate other flags.
+func WithFeatureFlag(engine *flags.Engine, flag string, hf http.HandlerFunc) http.HandlerFunc {
+	return featureFlag(engine, flag)(hf)
+}
+
+// FeatureFlagsMiddleware prepares the request context for flags.IsEnabled
+// without gating the route, e.g. with Router.Use.
+func FeatureFlagsMiddleware(engine *flags.Engine) middlewareFunc {
+	return featureFlag(engine, "")
+}
+
+func featureFlag(engine *flags.Engine, flag string) middlewareFunc {
+	return func(hf http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if engine == nil {
+				hf(w, r)
+				return
+			}
+			r = r.WithContext(engine.ContextFromRequest(r))
+			if flag != "" && !engine.IsEnabled(r.Context(), flag) {
+				helpers.SendErrorResponse(w, http.StatusForbidden, "Feature not enabled")
+				return
+			}
+			hf(w, r)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/angelbarreiros/Penguin/logger"
+	"github.com/angelbarreiros/Penguin/router/ipfilter"
+)
+
+func WithIPFilter(ipFilterConfig *ipfilter.IPFilterConfig, hf http.HandlerFunc) http.HandlerFunc {
+	return ipFilter(ipFilterConfig)(hf)
+}
+
+func ipFilter(ipFilterConfig *ipfilter.IPFilterConfig) middlewareFunc {
+	return func(hf http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if ipFilterConfig == nil {
+				hf(w, r)
+				return
+			}
+			if allowed, err := ipFilterConfig.Allowed(ipFilterConfig.ClientIP(r)); !allowed {
+				logger.GetConsoleLogger().Warn("IP filter rejected %s %s: %v", r.Method, r.URL.Path, err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"error": "Forbidden: IP address not allowed"}`))
+				return
+			}
+			hf(w, r)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/angelbarreiros/Penguin/logger"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+	"github.com/angelbarreiros/Penguin/router/rbac"
+)
+
+// WithPermission only lets the request through when the roles of the
+// authenticated user grant the permission. It reads the user stored by the
+// auth middleware, so it must run after it: 401 without a user, 403 when
+// the permission is missing. A nil authorizer denies every request with 500.
+func WithPermission(authorizer *rbac.Authorizer, permission string, hf http.HandlerFunc) http.HandlerFunc {
+	return PermissionMiddleware(authorizer, permission)(hf)
+}
+
+// PermissionMiddleware is WithPermission for Router.Use and groups.
+func PermissionMiddleware(authorizer *rbac.Authorizer, permission string) middlewareFunc {
+	return func(hf http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if authorizer == nil {
+				logger.GetConsoleLogger().Error("No authorizer configured for permission %s", permission)
+				helpers.SendErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+			if !authorizer.Authenticated(r.Context()) {
+				helpers.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+			if !authorizer.Allowed(r.Context(), permission) {
+				helpers.SendErrorResponse(w, http.StatusForbidden, "Forbidden: missing permission "+permission)
+				return
+			}
+			hf(w, r)
+		}
+	}
+}
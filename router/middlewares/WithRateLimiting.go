@@ -6,6 +6,8 @@ import (
 	"strconv"
 	"sync"
 	"time"
+
+	"github.com/angelbarreiros/Penguin/router/auth"
 )
 
 type bucketOption func(*tokenBucket)
@@ -15,6 +17,8 @@ type tokenBucket struct {
 	mu            sync.Mutex
 	startingLimit int32
 	limitPerSec   float64
+	perPrincipal  bool
+	principalKey  any
 }
 
 func RateLimitOptStartingLimit(limit int32) bucketOption {
@@ -30,6 +34,18 @@ func RateLimitOptLimitPerSecond(limit float64) bucketOption {
 	}
 }
 
+// RateLimitOptPerPrincipal keys the buckets by the authenticated user when
+// it implements auth.RateLimitedPrincipal, and applies its rate limit
+// override, e.g. the one of an API key. A nil contextKey reads the user
+// stored by the auth middleware. Requests without such a user keep the
+// per-IP buckets. The auth middleware must run before the rate limiter.
+func RateLimitOptPerPrincipal(contextKey any) bucketOption {
+	return func(tb *tokenBucket) {
+		tb.perPrincipal = true
+		tb.principalKey = contextKey
+	}
+}
+
 func WithRateLimiting(hf http.HandlerFunc, opts ...bucketOption) http.HandlerFunc {
 	return rateLimiting(opts...)(hf)
 }
@@ -62,6 +78,17 @@ func rateLimiting(opts ...bucketOption) middlewareFunc {
 			for _, opt := range opts {
 				opt(bucket)
 			}
+			if bucket.perPrincipal {
+				user, _ := auth.UserFromContext(r.Context(), bucket.principalKey)
+				if principal, ok := user.(auth.RateLimitedPrincipal); ok {
+					bucketKey = principal.RateLimitKey() + ":" + r.URL.Path
+					if limit, ok := principal.RateLimitOverride(); ok {
+						bucket.startingLimit = limit.Burst
+						bucket.tokens = limit.Burst
+						bucket.limitPerSec = limit.PerSecond
+					}
+				}
+			}
 
 			b, _ := tokenBuckets.LoadOrStore(bucketKey, bucket)
 			currentBucket := b.(*tokenBucket)
@@ -0,0 +1,66 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/angelbarreiros/Penguin/router"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+	"github.com/angelbarreiros/Penguin/router/toggles"
+)
+
+func WithRouteToggle(registry *toggles.Registry, hf http.HandlerFunc) http.HandlerFunc {
+	return routeToggle(registry)(hf)
+}
+
+// RouteToggleMiddleware returns the toggle middleware so it can be applied to
+// every route with Router.Use or to a group.
+func RouteToggleMiddleware(registry *toggles.Registry) middlewareFunc {
+	return routeToggle(registry)
+}
+
+func routeToggle(registry *toggles.Registry) middlewareFunc {
+	return func(hf http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if registry == nil {
+				hf(w, r)
+				return
+			}
+			info, ok := router.RouteInfoFromRequest(r)
+			if !ok {
+				info = router.RouteInfo{Path: r.URL.Path, Method: router.HTTPMethod(r.Method)}
+			}
+
+			var state toggles.State = registry.Resolve(info)
+			switch state.Mode {
+			case toggles.ModeDisabled:
+				helpers.SendErrorResponse(w, http.StatusNotFound, messageOr(state.Message, "Route disabled"))
+				return
+			case toggles.ModeMaintenance:
+				setRetryAfter(w, state)
+				helpers.SendErrorResponse(w, http.StatusServiceUnavailable, messageOr(state.Message, "Service under maintenance"))
+				return
+			case toggles.ModeReadOnly:
+				if !helpers.IsSafeMethod(r.Method) {
+					setRetryAfter(w, state)
+					helpers.SendErrorResponse(w, http.StatusServiceUnavailable, messageOr(state.Message, "Service is in read-only mode"))
+					return
+				}
+			}
+			hf(w, r)
+		}
+	}
+}
+
+func setRetryAfter(w http.ResponseWriter, state toggles.State) {
+	if state.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(state.RetryAfter.Seconds())))
+	}
+}
+
+func messageOr(message string, fallback string) string {
+	if message == "" {
+		return fallback
+	}
+	return message
+}
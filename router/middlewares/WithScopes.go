@@ -0,0 +1,43 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+)
+
+// RequireScopes only lets the request through when the user stored by the
+// auth middleware was granted every scope. It reads the JWT scope/scp claims
+// and the scopes of introspected tokens and API keys. Without a user it
+// answers 401, without the scopes 403 with an insufficient_scope challenge.
+func RequireScopes(scopes ...string) middlewareFunc {
+	return RequireScopesWithContextKey(nil, scopes...)
+}
+
+// RequireScopesWithContextKey is RequireScopes for users stored under a
+// context key by custom code.
+func RequireScopesWithContextKey(key any, scopes ...string) middlewareFunc {
+	return func(hf http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			user, ok := auth.UserFromContext(r.Context(), key)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				helpers.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+			if !auth.HasScopes(user, scopes...) {
+				w.Header().Set("WWW-Authenticate", auth.InsufficientScopeChallenge("", scopes))
+				helpers.SendErrorResponse(w, http.StatusForbidden, "Forbidden: "+auth.BearerErrorInsufficientScope)
+				return
+			}
+			hf(w, r)
+		}
+	}
+}
+
+// WithAuthAndScopes authenticates the request with the provider and
+// requires every scope.
+func WithAuthAndScopes(provider auth.PlainAuthInterface, scopes []string, hf http.HandlerFunc) http.HandlerFunc {
+	return authMiddleWareFunc(provider)(RequireScopes(scopes...)(hf))
+}
@@ -0,0 +1,24 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/angelbarreiros/Penguin/router/sessions"
+)
+
+// WithSessions loads the session into the context; handlers read it with
+// sessions.FromContext. Changes are saved before the response is written.
+func WithSessions(manager *sessions.Manager, hf http.HandlerFunc) http.HandlerFunc {
+	return SessionsMiddleware(manager)(hf)
+}
+
+// SessionsMiddleware is WithSessions for Router.Use.
+func SessionsMiddleware(manager *sessions.Manager) middlewareFunc {
+	return func(hf http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w, r = manager.Start(w, r)
+			hf(w, r)
+			manager.Finish(w)
+		}
+	}
+}
@@ -0,0 +1,543 @@
+package oidc
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/logger"
+	"github.com/angelbarreiros/Penguin/router"
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+	"github.com/angelbarreiros/Penguin/router/sessions"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	DefaultLoginPath      = "/auth/login"
+	DefaultLogoutPath     = "/auth/logout"
+	DefaultSessionTTL     = 8 * time.Hour
+	DefaultLoginFlowTTL   = 10 * time.Minute
+	flowCookieName        = "penguin_oidc_flow"
+	returnToParameter     = "return_to"
+	maxTokenResponseBytes = 1 << 20
+)
+
+var defaultScopes []string = []string{"openid", "profile", "email"}
+
+// loginFlow is kept in a cookie session scoped to the callback path between
+// the redirect to the provider and the callback.
+type loginFlow struct {
+	State     string    `json:"state"`
+	Nonce     string    `json:"nonce"`
+	Verifier  string    `json:"verifier"`
+	ReturnTo  string    `json:"returnTo"`
+	ExpiresAt time.Time `json:"exp"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+type relyingPartyOptionsFunc func(*RelyingParty)
+
+// RelyingParty logs browser users in with the authorization code flow and
+// PKCE. It implements auth.PlainAuthInterface over the session it creates.
+type RelyingParty struct {
+	issuer                string
+	clientID              string
+	clientSecret          string
+	redirectURL           *url.URL
+	postLogoutRedirectURL string
+	scopes                []string
+	client                *http.Client
+	metadata              *ProviderMetadata
+	keys                  *auth.RemoteKeySet
+	idTokens              *auth.JwtAuth
+	cookieKey             []byte
+	flows                 *sessions.Manager
+	sessions              SessionStore
+	sessionTTL            time.Duration
+	loginPath             string
+	logoutPath            string
+	timeout               time.Duration
+	contextKey            any
+	err                   error
+}
+
+// NewRelyingParty loads the discovery document and the keys of the issuer.
+// redirectURL must be registered at the provider; its path is served by
+// the callback route.
+func NewRelyingParty(issuer string, clientID string, redirectURL string, options ...relyingPartyOptionsFunc) (*RelyingParty, error) {
+	callback, err := url.Parse(redirectURL)
+	if err != nil || !callback.IsAbs() {
+		return nil, fmt.Errorf("invalid redirect url '%s'", redirectURL)
+	}
+	var rp *RelyingParty = &RelyingParty{
+		issuer:      issuer,
+		clientID:    clientID,
+		redirectURL: callback,
+		scopes:      defaultScopes,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		sessionTTL:  DefaultSessionTTL,
+		loginPath:   DefaultLoginPath,
+		logoutPath:  DefaultLogoutPath,
+		timeout:     time.Duration(auth.DefaultContextTimeout) * time.Second,
+	}
+	for _, option := range options {
+		option(rp)
+	}
+	if rp.err != nil {
+		return nil, rp.err
+	}
+	if rp.cookieKey == nil {
+		rp.cookieKey = make([]byte, 32)
+		rand.Read(rp.cookieKey)
+	}
+	if rp.flows, err = rp.cookieManager(flowCookieName, rp.redirectURL.Path, DefaultLoginFlowTTL); err != nil {
+		return nil, err
+	}
+	if rp.sessions == nil {
+		manager, err := rp.cookieManager(DefaultSessionCookieName, "/", rp.sessionTTL)
+		if err != nil {
+			return nil, err
+		}
+		rp.sessions = &managerSessionStore{manager: manager}
+	}
+
+	if rp.metadata, err = Discover(rp.client, issuer); err != nil {
+		return nil, err
+	}
+	if rp.keys, err = auth.NewRemoteKeySet(rp.metadata.JWKSURI, auth.RemoteKeySetWithClient(rp.client)); err != nil {
+		return nil, err
+	}
+	rp.idTokens = auth.NewJwtAuthWithKeys(rp.keys, func() jwt.MapClaims { return jwt.MapClaims{} },
+		auth.JwtAuthWithAllowedAlgorithms(rp.algorithms()...),
+		auth.JwtAuthWithValidationPolicy(auth.ValidationPolicy{
+			Issuer:            rp.metadata.Issuer,
+			Audiences:         []string{clientID},
+			Leeway:            time.Minute,
+			RequiredClaims:    []string{"sub", "iat"},
+			RequireExpiration: true,
+		}))
+	return rp, nil
+}
+
+// RelyingPartyWithClientSecret authenticates the client at the token
+// endpoint. Public clients rely on PKCE alone.
+func RelyingPartyWithClientSecret(secret string) relyingPartyOptionsFunc {
+	return func(rp *RelyingParty) {
+		rp.clientSecret = secret
+	}
+}
+
+// RelyingPartyWithScopes sets the requested scopes. "openid" is always
+// requested.
+func RelyingPartyWithScopes(scopes ...string) relyingPartyOptionsFunc {
+	return func(rp *RelyingParty) {
+		rp.scopes = scopes
+		if !slices.Contains(scopes, "openid") {
+			rp.scopes = append([]string{"openid"}, scopes...)
+		}
+	}
+}
+
+// RelyingPartyWithClient sets the HTTP client, e.g. one built with the
+// client package.
+func RelyingPartyWithClient(client *http.Client) relyingPartyOptionsFunc {
+	return func(rp *RelyingParty) {
+		rp.client = client
+	}
+}
+
+// RelyingPartyWithCookieKey sets the key signing the login flow and session
+// cookies. It must hold at least 32 bytes and be shared by every instance.
+func RelyingPartyWithCookieKey(key []byte) relyingPartyOptionsFunc {
+	return func(rp *RelyingParty) {
+		if len(key) < 32 {
+			rp.err = fmt.Errorf("cookie key must hold at least 32 bytes")
+			return
+		}
+		rp.cookieKey = key
+	}
+}
+
+// RelyingPartyWithSessionStore replaces the signed cookie session.
+func RelyingPartyWithSessionStore(store SessionStore) relyingPartyOptionsFunc {
+	return func(rp *RelyingParty) {
+		rp.sessions = store
+	}
+}
+
+// RelyingPartyWithSessionManager keeps the identity in the sessions of
+// manager, e.g. one with a server side sessions.Store.
+func RelyingPartyWithSessionManager(manager *sessions.Manager) relyingPartyOptionsFunc {
+	return func(rp *RelyingParty) {
+		rp.sessions = &managerSessionStore{manager: manager}
+	}
+}
+
+func RelyingPartyWithSessionTTL(ttl time.Duration) relyingPartyOptionsFunc {
+	return func(rp *RelyingParty) {
+		if ttl > 0 {
+			rp.sessionTTL = ttl
+		}
+	}
+}
+
+// RelyingPartyWithPostLogoutRedirectURL is where the provider sends the
+// browser after logout. It must be registered at the provider.
+func RelyingPartyWithPostLogoutRedirectURL(redirectURL string) relyingPartyOptionsFunc {
+	return func(rp *RelyingParty) {
+		rp.postLogoutRedirectURL = redirectURL
+	}
+}
+
+func RelyingPartyWithPaths(loginPath string, logoutPath string) relyingPartyOptionsFunc {
+	return func(rp *RelyingParty) {
+		rp.loginPath = loginPath
+		rp.logoutPath = logoutPath
+	}
+}
+
+func RelyingPartyWithCustomTimeout(timeout time.Duration) relyingPartyOptionsFunc {
+	return func(rp *RelyingParty) {
+		rp.timeout = timeout
+	}
+}
+
+func RelyingPartyWithCustomContextKey(key any) relyingPartyOptionsFunc {
+	return func(rp *RelyingParty) {
+		rp.contextKey = key
+	}
+}
+
+func (rp *RelyingParty) Metadata() *ProviderMetadata {
+	return rp.metadata
+}
+
+func (rp *RelyingParty) Close() {
+	rp.keys.Close()
+}
+
+func (rp *RelyingParty) secure() bool {
+	return rp.redirectURL.Scheme == "https"
+}
+
+// cookieManager returns the cookie-only session manager of the login flow
+// or of the default session store. Both share the cookie key; the cookie
+// name is signed too, so values cannot be moved between them.
+func (rp *RelyingParty) cookieManager(name string, path string, ttl time.Duration) (*sessions.Manager, error) {
+	return sessions.NewManager(nil,
+		sessions.ManagerWithSigningKey(rp.cookieKey),
+		sessions.ManagerWithCookieName(name),
+		sessions.ManagerWithCookiePath(path),
+		sessions.ManagerWithCookieSecure(rp.secure()),
+		sessions.ManagerWithIdleTimeout(0),
+		sessions.ManagerWithAbsoluteTimeout(ttl))
+}
+
+// algorithms accepts the asymmetric algorithms of the provider, RS256 when
+// it does not list any.
+func (rp *RelyingParty) algorithms() []string {
+	var algorithms []string
+	for _, algorithm := range rp.metadata.IDTokenSigningAlgValuesSupported {
+		if algorithm != "none" && !strings.HasPrefix(algorithm, "HS") {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+	if len(algorithms) == 0 {
+		return []string{"RS256"}
+	}
+	return algorithms
+}
+
+// RegisterRoutes serves the login, callback and logout endpoints. Logout
+// only accepts POST: the SameSite=Lax session cookie is sent on cross-site
+// GET navigations, so any site could log users out with a link.
+func (rp *RelyingParty) RegisterRoutes(r router.RouteRegistrar) {
+	r.NewRoute(router.Route{Path: rp.loginPath, Method: router.GET, Handler: rp.LoginHandler()})
+	r.NewRoute(router.Route{Path: rp.redirectURL.Path, Method: router.GET, Handler: rp.CallbackHandler()})
+	r.NewRoute(router.Route{Path: rp.logoutPath, Method: router.POST, Handler: rp.LogoutHandler()})
+}
+
+// LoginHandler redirects the browser to the provider. The return_to
+// parameter is where the browser lands after the login; only local paths
+// are accepted.
+func (rp *RelyingParty) LoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var flow loginFlow = loginFlow{
+			State:     randomToken(),
+			Nonce:     randomToken(),
+			Verifier:  randomToken() + randomToken(),
+			ReturnTo:  localPath(r.URL.Query().Get(returnToParameter)),
+			ExpiresAt: time.Now().Add(DefaultLoginFlowTTL),
+		}
+		session, err := rp.flows.Load(r)
+		if err == nil {
+			session.Set(flowSessionKey, flow)
+			err = rp.flows.Save(w, session)
+		}
+		if err != nil {
+			logger.GetConsoleLogger().Error("Failed to start the login flow: %v", err)
+			helpers.SendErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		var challenge [32]byte = sha256.Sum256([]byte(flow.Verifier))
+		var query url.Values = url.Values{
+			"response_type":         {"code"},
+			"client_id":             {rp.clientID},
+			"redirect_uri":          {rp.redirectURL.String()},
+			"scope":                 {strings.Join(rp.scopes, " ")},
+			"state":                 {flow.State},
+			"nonce":                 {flow.Nonce},
+			"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+			"code_challenge_method": {"S256"},
+		}
+		http.Redirect(w, r, appendQuery(rp.metadata.AuthorizationEndpoint, query), http.StatusFound)
+	}
+}
+
+// CallbackHandler exchanges the code, verifies the ID token and creates
+// the session.
+func (rp *RelyingParty) CallbackHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var flow loginFlow
+		session, err := rp.flows.Load(r)
+		if err != nil || !sessionValue(session, flowSessionKey, &flow) || !time.Now().Before(flow.ExpiresAt) {
+			helpers.SendErrorResponse(w, http.StatusBadRequest, "Login flow expired, please try again")
+			return
+		}
+		rp.flows.Destroy(w, session)
+
+		var query url.Values = r.URL.Query()
+		if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(flow.State)) != 1 {
+			helpers.SendErrorResponse(w, http.StatusBadRequest, "Invalid state")
+			return
+		}
+		if providerError := query.Get("error"); providerError != "" {
+			logger.GetConsoleLogger().Warn("OIDC login failed at the provider: %s %s", providerError, query.Get("error_description"))
+			helpers.SendErrorResponse(w, http.StatusUnauthorized, "Login failed: "+providerError)
+			return
+		}
+
+		tokens, err := rp.exchange(r.Context(), query.Get("code"), flow.Verifier)
+		if err != nil {
+			logger.GetConsoleLogger().Error("OIDC code exchange failed: %v", err)
+			helpers.SendErrorResponse(w, http.StatusBadGateway, "Login failed")
+			return
+		}
+		identity, err := rp.verifyIDToken(tokens.IDToken, flow.Nonce)
+		if err != nil {
+			logger.GetConsoleLogger().Warn("OIDC ID token rejected: %v", err)
+			helpers.SendErrorResponse(w, http.StatusUnauthorized, "Login failed")
+			return
+		}
+		if err := rp.sessions.Save(w, r, identity); err != nil {
+			logger.GetConsoleLogger().Error("Failed to create the session: %v", err)
+			helpers.SendErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		http.Redirect(w, r, flow.ReturnTo, http.StatusFound)
+	}
+}
+
+func (rp *RelyingParty) exchange(ctx context.Context, code string, verifier string) (*tokenResponse, error) {
+	if code == "" {
+		return nil, fmt.Errorf("missing authorization code")
+	}
+	var form url.Values = url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {rp.redirectURL.String()},
+		"code_verifier": {verifier},
+		"client_id":     {rp.clientID},
+	}
+	var basic bool = rp.clientSecret != "" && (len(rp.metadata.TokenEndpointAuthMethodsSupported) == 0 ||
+		slices.Contains(rp.metadata.TokenEndpointAuthMethodsSupported, "client_secret_basic"))
+	if rp.clientSecret != "" && !basic {
+		form.Set("client_secret", rp.clientSecret)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, rp.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if basic {
+		request.SetBasicAuth(url.QueryEscape(rp.clientID), url.QueryEscape(rp.clientSecret))
+	}
+
+	response, err := rp.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxTokenResponseBytes))
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint answered %d: %s", response.StatusCode, data)
+	}
+	var tokens *tokenResponse = &tokenResponse{}
+	if err := json.Unmarshal(data, tokens); err != nil {
+		return nil, fmt.Errorf("invalid token response: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+	return tokens, nil
+}
+
+// verifyIDToken checks the signature, issuer, audience, expiry and nonce of
+// the ID token, and the authorized party when there are several audiences.
+func (rp *RelyingParty) verifyIDToken(idToken string, nonce string) (*Identity, error) {
+	parsed, err := rp.idTokens.ParseToken(idToken)
+	if err != nil {
+		return nil, err
+	}
+	var claims jwt.MapClaims = parsed.(jwt.MapClaims)
+	if value, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(value), []byte(nonce)) != 1 {
+		return nil, errors.New("id token nonce does not match")
+	}
+	audiences, _ := claims.GetAudience()
+	azp, _ := claims["azp"].(string)
+	if (len(audiences) > 1 || azp != "") && azp != rp.clientID {
+		return nil, errors.New("id token authorized party does not match")
+	}
+	subject, _ := claims.GetSubject()
+	return &Identity{
+		Subject:   subject,
+		Claims:    claims,
+		IDToken:   idToken,
+		ExpiresAt: time.Now().Add(rp.sessionTTL),
+	}, nil
+}
+
+// LogoutHandler ends the session of a POST request and, when the provider
+// supports it, redirects to its end session endpoint.
+func (rp *RelyingParty) LogoutHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			helpers.SendErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		identity, _ := rp.sessions.Load(r)
+		if err := rp.sessions.Clear(w, r); err != nil {
+			logger.GetConsoleLogger().Error("Failed to clear the session: %v", err)
+		}
+
+		if rp.metadata.EndSessionEndpoint == "" {
+			http.Redirect(w, r, cmp.Or(rp.postLogoutRedirectURL, "/"), http.StatusSeeOther)
+			return
+		}
+		var query url.Values = url.Values{"client_id": {rp.clientID}}
+		if identity != nil && identity.IDToken != "" {
+			query.Set("id_token_hint", identity.IDToken)
+		}
+		if rp.postLogoutRedirectURL != "" {
+			query.Set("post_logout_redirect_uri", rp.postLogoutRedirectURL)
+		}
+		http.Redirect(w, r, appendQuery(rp.metadata.EndSessionEndpoint, query), http.StatusSeeOther)
+	}
+}
+
+// Authenticate returns the *Identity of the session.
+func (rp *RelyingParty) Authenticate(r *http.Request) (any, error) {
+	identity, err := rp.sessions.Load(r)
+	if err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// RBAC checks the roles and groups claims of the ID token.
+func (rp *RelyingParty) RBAC(user any, allowedRoles []string) bool {
+	identity, ok := user.(*Identity)
+	if !ok {
+		return false
+	}
+	return slices.ContainsFunc(identity.GetRoles(), func(role string) bool { return slices.Contains(allowedRoles, role) })
+}
+
+// Challenge sets no WWW-Authenticate header: browsers are sent to the login
+// by RequireLogin instead.
+func (rp *RelyingParty) Challenge(err error) string {
+	return ""
+}
+
+func (rp *RelyingParty) GetTimeout() time.Duration {
+	return rp.timeout
+}
+
+func (rp *RelyingParty) GetContextKey() any {
+	return rp.contextKey
+}
+
+// RequireLogin stores the identity in the context like the auth middleware,
+// and redirects GET requests without a session to the login.
+func (rp *RelyingParty) RequireLogin(hf http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity, err := rp.sessions.Load(r)
+		if err != nil {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				http.Redirect(w, r, rp.loginPath+"?"+url.Values{returnToParameter: {r.URL.RequestURI()}}.Encode(), http.StatusFound)
+				return
+			}
+			helpers.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), rp.timeout)
+		defer cancel()
+		if rp.contextKey != nil {
+			ctx = context.WithValue(ctx, rp.contextKey, identity)
+		}
+		hf(w, r.WithContext(auth.ContextWithUser(ctx, identity)))
+	}
+}
+
+func randomToken() string {
+	var data []byte = make([]byte, 32)
+	rand.Read(data)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// localPath only accepts paths of this site, so the login cannot be used as
+// an open redirect. Browsers drop tabs and newlines from URLs and read
+// backslashes as slashes, so "/\t/evil.com" would land on //evil.com:
+// control characters and backslashes are rejected before parsing.
+func localPath(path string) string {
+	if strings.ContainsFunc(path, func(c rune) bool { return c < 0x20 || c == 0x7f || c == '\\' }) {
+		return "/"
+	}
+	u, err := url.Parse(path)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Opaque != "" || !strings.HasPrefix(u.Path, "/") || strings.HasPrefix(path, "//") {
+		return "/"
+	}
+	return path
+}
+
+func appendQuery(endpoint string, query url.Values) string {
+	if strings.Contains(endpoint, "?") {
+		return endpoint + "&" + query.Encode()
+	}
+	return endpoint + "?" + query.Encode()
+}
@@ -0,0 +1,124 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/router/sessions"
+)
+
+const (
+	DefaultSessionCookieName = "penguin_session"
+	identitySessionKey       = "identity"
+	flowSessionKey           = "flow"
+)
+
+var (
+	ErrNoSession      = errors.New("no session")
+	ErrSessionExpired = errors.New("session has expired")
+	// ErrSessionTooLarge is returned when the claims alone do not fit in the
+	// session cookie; keep fewer claims or use a server side sessions.Store.
+	ErrSessionTooLarge = sessions.ErrCookieTooLarge
+)
+
+// Identity is the logged in user. Claims are the claims of the ID token.
+type Identity struct {
+	Subject   string         `json:"sub"`
+	Claims    map[string]any `json:"claims,omitempty"`
+	IDToken   string         `json:"idToken,omitempty"`
+	ExpiresAt time.Time      `json:"exp"`
+}
+
+func (i *Identity) GetSubject() (string, error) {
+	return i.Subject, nil
+}
+
+func (i *Identity) GetClaims() map[string]any {
+	return i.Claims
+}
+
+// GetRoles returns the "roles" and "groups" claims.
+func (i *Identity) GetRoles() []string {
+	var roles []string
+	for _, name := range []string{"roles", "groups"} {
+		if values, ok := i.Claims[name].([]any); ok {
+			for _, value := range values {
+				if role, ok := value.(string); ok && !slices.Contains(roles, role) {
+					roles = append(roles, role)
+				}
+			}
+		}
+	}
+	return roles
+}
+
+// SessionStore keeps the identity between requests. The default store
+// keeps it in a signed cookie; implement it to keep sessions server side.
+type SessionStore interface {
+	Save(w http.ResponseWriter, r *http.Request, identity *Identity) error
+	Load(r *http.Request) (*Identity, error)
+	Clear(w http.ResponseWriter, r *http.Request) error
+}
+
+// managerSessionStore keeps the identity in a session of a
+// sessions.Manager. The ID token is dropped when a cookie session would not
+// fit, which only loses the logout hint.
+type managerSessionStore struct {
+	manager *sessions.Manager
+}
+
+func (m *managerSessionStore) Save(w http.ResponseWriter, r *http.Request, identity *Identity) error {
+	session, err := m.manager.Load(r)
+	if err != nil {
+		return err
+	}
+	if err := m.manager.RenewID(session); err != nil {
+		return err
+	}
+	session.Set(identitySessionKey, identity)
+	err = m.manager.Save(w, session)
+	if errors.Is(err, sessions.ErrCookieTooLarge) && identity.IDToken != "" {
+		var withoutToken Identity = *identity
+		withoutToken.IDToken = ""
+		session.Set(identitySessionKey, &withoutToken)
+		err = m.manager.Save(w, session)
+	}
+	return err
+}
+
+func (m *managerSessionStore) Load(r *http.Request) (*Identity, error) {
+	session, err := m.manager.Load(r)
+	if err != nil {
+		return nil, err
+	}
+	var identity *Identity = &Identity{}
+	if !sessionValue(session, identitySessionKey, identity) {
+		return nil, ErrNoSession
+	}
+	if !time.Now().Before(identity.ExpiresAt) {
+		return nil, ErrSessionExpired
+	}
+	return identity, nil
+}
+
+func (m *managerSessionStore) Clear(w http.ResponseWriter, r *http.Request) error {
+	session, err := m.manager.Load(r)
+	if err != nil {
+		return err
+	}
+	return m.manager.Destroy(w, session)
+}
+
+// sessionValue decodes a session value into target. Values read back from
+// a cookie or a store are generic JSON.
+func sessionValue(session *sessions.Session, key string, target any) bool {
+	value, ok := session.Get(key)
+	if !ok {
+		return false
+	}
+	data, err := json.Marshal(value)
+	return err == nil && json.Unmarshal(data, target) == nil
+}
@@ -0,0 +1,200 @@
+package rbac
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	// PermissionSeparator splits a permission into segments, e.g.
+	// "orders:items:write".
+	PermissionSeparator = ":"
+	// Wildcard matches one segment, or every remaining segment when it is
+	// the last one: "orders:*" grants "orders:write" and "orders:items:write".
+	Wildcard = "*"
+)
+
+// Role is the definition of a role in the policy file. It grants its own
+// permissions and the permissions of the roles it inherits.
+type Role struct {
+	Inherits    []string `json:"inherits" yaml:"inherits"`
+	Permissions []string `json:"permissions" yaml:"permissions"`
+}
+
+// Policy is a compiled set of roles. It is immutable and safe for
+// concurrent use; Authorizer swaps it when the policy file changes.
+type Policy struct {
+	roles map[string]*compiledRole
+}
+
+type compiledRole struct {
+	// roles holds the role and every role it inherits, directly or not.
+	roles       []string
+	permissions [][]string
+}
+
+// NewPolicy resolves the inheritance of the roles. It fails on unknown
+// parents, inheritance cycles and malformed permissions.
+func NewPolicy(roles map[string]Role) (*Policy, error) {
+	var policy *Policy = &Policy{roles: make(map[string]*compiledRole, len(roles))}
+	for name, role := range roles {
+		if name == "" {
+			return nil, fmt.Errorf("role without name")
+		}
+		for _, permission := range role.Permissions {
+			if err := validatePermission(permission); err != nil {
+				return nil, fmt.Errorf("role '%s': %w", name, err)
+			}
+		}
+	}
+	for name := range roles {
+		if _, err := policy.compile(roles, name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+func (p *Policy) compile(roles map[string]Role, name string, path []string) (*compiledRole, error) {
+	if compiled, ok := p.roles[name]; ok {
+		return compiled, nil
+	}
+	if slices.Contains(path, name) {
+		return nil, fmt.Errorf("role inheritance cycle: %s -> %s", strings.Join(path, " -> "), name)
+	}
+	role, ok := roles[name]
+	if !ok {
+		return nil, fmt.Errorf("role '%s' inherits unknown role '%s'", path[len(path)-1], name)
+	}
+
+	var compiled *compiledRole = &compiledRole{roles: []string{name}}
+	for _, permission := range role.Permissions {
+		compiled.addPermission(strings.Split(permission, PermissionSeparator))
+	}
+	for _, parent := range role.Inherits {
+		inherited, err := p.compile(roles, parent, append(path, name))
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range inherited.roles {
+			if !slices.Contains(compiled.roles, role) {
+				compiled.roles = append(compiled.roles, role)
+			}
+		}
+		for _, permission := range inherited.permissions {
+			compiled.addPermission(permission)
+		}
+	}
+	p.roles[name] = compiled
+	return compiled, nil
+}
+
+func (c *compiledRole) addPermission(permission []string) {
+	for _, existing := range c.permissions {
+		if slices.Equal(existing, permission) {
+			return
+		}
+	}
+	c.permissions = append(c.permissions, permission)
+}
+
+func validatePermission(permission string) error {
+	if permission == "" {
+		return fmt.Errorf("empty permission")
+	}
+	for _, segment := range strings.Split(permission, PermissionSeparator) {
+		if segment == "" {
+			return fmt.Errorf("permission '%s' has an empty segment", permission)
+		}
+		if segment != Wildcard && strings.Contains(segment, Wildcard) {
+			return fmt.Errorf("permission '%s': the wildcard must be a whole segment", permission)
+		}
+	}
+	return nil
+}
+
+// Can reports whether any of the roles grants the permission. Unknown roles
+// grant nothing.
+func (p *Policy) Can(roles []string, permission string) bool {
+	if p == nil || permission == "" {
+		return false
+	}
+	var required []string = strings.Split(permission, PermissionSeparator)
+	for _, name := range roles {
+		role, ok := p.roles[name]
+		if !ok {
+			continue
+		}
+		for _, granted := range role.permissions {
+			if matchPermission(granted, required) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasRole reports whether any of the roles is the role or inherits it, so a
+// user with "admin" has "editor" when admin inherits editor.
+func (p *Policy) HasRole(roles []string, role string) bool {
+	if slices.Contains(roles, role) {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	for _, name := range roles {
+		if compiled, ok := p.roles[name]; ok && slices.Contains(compiled.roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions returns the effective permissions of a role, inherited ones
+// included.
+func (p *Policy) Permissions(role string) []string {
+	if p == nil {
+		return nil
+	}
+	compiled, ok := p.roles[role]
+	if !ok {
+		return nil
+	}
+	var permissions []string = make([]string, 0, len(compiled.permissions))
+	for _, permission := range compiled.permissions {
+		permissions = append(permissions, strings.Join(permission, PermissionSeparator))
+	}
+	sort.Strings(permissions)
+	return permissions
+}
+
+// Roles returns the names of the roles of the policy.
+func (p *Policy) Roles() []string {
+	if p == nil {
+		return nil
+	}
+	var names []string = make([]string, 0, len(p.roles))
+	for name := range p.roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func matchPermission(granted []string, required []string) bool {
+	for i, segment := range granted {
+		if segment == Wildcard && i == len(granted)-1 {
+			return len(required) > i
+		}
+		if i >= len(required) {
+			return false
+		}
+		if segment != Wildcard && segment != required[i] {
+			return false
+		}
+	}
+	return len(granted) == len(required)
+}
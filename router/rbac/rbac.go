@@ -0,0 +1,160 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+	"gopkg.in/yaml.v3"
+)
+
+type policyFile struct {
+	Roles map[string]Role `json:"roles" yaml:"roles"`
+}
+
+type authorizerOptionsFunc func(*Authorizer)
+
+// Authorizer checks permissions against a policy that can be reloaded from
+// a file while the server runs. It implements auth.RoleHierarchy.
+type Authorizer struct {
+	policy         atomic.Pointer[Policy]
+	file           string
+	reloadInterval time.Duration
+	watcher        *helpers.FileWatcher
+	userContextKey any
+	err            error
+}
+
+func NewAuthorizer(options ...authorizerOptionsFunc) (*Authorizer, error) {
+	var authorizer *Authorizer = &Authorizer{
+		reloadInterval: helpers.DefaultFileWatchInterval,
+	}
+	authorizer.policy.Store(&Policy{roles: map[string]*compiledRole{}})
+
+	for _, option := range options {
+		option(authorizer)
+	}
+	if authorizer.err != nil {
+		return nil, authorizer.err
+	}
+
+	if authorizer.file != "" {
+		if err := authorizer.Reload(); err != nil {
+			return nil, err
+		}
+		watcher, err := helpers.WatchFile(authorizer.file, authorizer.reloadInterval, authorizer.Reload)
+		if err != nil {
+			return nil, err
+		}
+		authorizer.watcher = watcher
+	}
+	return authorizer, nil
+}
+
+// AuthorizerWithPolicyFile loads the roles from a JSON or YAML file (by
+// extension) and reloads them when it changes.
+func AuthorizerWithPolicyFile(path string, reloadInterval time.Duration) authorizerOptionsFunc {
+	return func(a *Authorizer) {
+		a.file = path
+		if reloadInterval > 0 {
+			a.reloadInterval = reloadInterval
+		}
+	}
+}
+
+// AuthorizerWithRoles defines the roles in code, e.g. for tests. Roles
+// loaded from a file replace them.
+func AuthorizerWithRoles(roles map[string]Role) authorizerOptionsFunc {
+	return func(a *Authorizer) {
+		policy, err := NewPolicy(roles)
+		if err != nil {
+			a.err = err
+			return
+		}
+		a.policy.Store(policy)
+	}
+}
+
+// AuthorizerWithUserContextKey reads the user from a context key set by
+// custom code instead of the user stored by the auth middleware.
+func AuthorizerWithUserContextKey(key any) authorizerOptionsFunc {
+	return func(a *Authorizer) {
+		a.userContextKey = key
+	}
+}
+
+// Reload parses the policy file again. On error the previous policy is kept.
+func (a *Authorizer) Reload() error {
+	if a.file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(a.file)
+	if err != nil {
+		return fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	var definitions policyFile
+	switch strings.ToLower(filepath.Ext(a.file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &definitions)
+	default:
+		err = json.Unmarshal(data, &definitions)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse policy file: %w", err)
+	}
+
+	policy, err := NewPolicy(definitions.Roles)
+	if err != nil {
+		return err
+	}
+	a.policy.Store(policy)
+	return nil
+}
+
+func (a *Authorizer) Close() {
+	a.watcher.Stop()
+}
+
+// Policy returns the current policy.
+func (a *Authorizer) Policy() *Policy {
+	return a.policy.Load()
+}
+
+func (a *Authorizer) Can(roles []string, permission string) bool {
+	return a.Policy().Can(roles, permission)
+}
+
+func (a *Authorizer) HasRole(roles []string, role string) bool {
+	return a.Policy().HasRole(roles, role)
+}
+
+// Allowed checks the permission for the user the auth middleware stored in
+// the context. Users expose their roles with GetRoles, like auth.RBACClaims,
+// auth.APIKey or auth.ChainResult.
+func (a *Authorizer) Allowed(ctx context.Context, permission string) bool {
+	roles, ok := a.userRoles(ctx)
+	return ok && a.Can(roles, permission)
+}
+
+// Authenticated reports whether the context holds a user, i.e. whether a
+// denied permission is a 403 rather than a 401.
+func (a *Authorizer) Authenticated(ctx context.Context) bool {
+	_, ok := auth.PrincipalFromContext(ctx, a.userContextKey)
+	return ok
+}
+
+func (a *Authorizer) userRoles(ctx context.Context) ([]string, bool) {
+	principal, ok := auth.PrincipalFromContext(ctx, a.userContextKey)
+	if !ok {
+		return nil, false
+	}
+	return principal.Roles, true
+}
@@ -1,31 +1,105 @@
 package router
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/router/helpers"
 )
 
+// Middleware wraps a handler. Router-level middlewares registered with Use
+// run for every route, outermost first.
+type Middleware = func(http.HandlerFunc) http.HandlerFunc
+
+// CORSPolicy validates cross-origin requests of a route, e.g.
+// *cors.CORSConfig. The router answers the preflight requests of routes
+// with a policy and checks their other requests before any middleware.
+type CORSPolicy interface {
+	CheckPreflight(w http.ResponseWriter, r *http.Request, routeMethods []string) error
+	CheckRequest(w http.ResponseWriter, r *http.Request) error
+}
+
 type Router struct {
-	mux    *http.ServeMux
-	routes map[string]routeEntry
+	mux         *http.ServeMux
+	routes      map[string]routeEntry
+	middlewares []Middleware
+	cors        CORSPolicy
+	// chains caches the handlers wrapped with their middlewares, per
+	// routeKey. It is cleared when middlewares or policies change.
+	chains sync.Map
+}
+
+type routeKey struct {
+	path   string
+	method HTTPMethod
 }
 
 type routeEntry struct {
 	handlers       map[HTTPMethod]http.HandlerFunc
+	infos          map[HTTPMethod]RouteInfo
+	groups         map[HTTPMethod]*Group
+	cors           map[HTTPMethod]CORSPolicy
+	methods        []string
 	allowedMethods string
 }
 
+// RouteInfo describes the route that matched a request. It is stored in the
+// request context before the handler and the router-level middlewares run.
+type RouteInfo struct {
+	Path     string
+	Method   HTTPMethod
+	Group    string
+	Consumes []string
+}
+
+type routeInfoKey struct{}
+
+func RouteInfoFromRequest(r *http.Request) (RouteInfo, bool) {
+	info, ok := r.Context().Value(routeInfoKey{}).(RouteInfo)
+	return info, ok
+}
+
 func (r *Router) StartServer(port string) error {
 	return http.ListenAndServe(port, r.mux)
 }
 
+// StartTLSServer serves HTTPS with the certificate and key files. config
+// may require client certificates, e.g. the one of auth.MTLSAuth; certFile
+// and keyFile may be empty when config already holds the certificates.
+func (r *Router) StartTLSServer(port string, certFile string, keyFile string, config *tls.Config) error {
+	var server *http.Server = &http.Server{
+		Addr:              port,
+		Handler:           r.mux,
+		TLSConfig:         config,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServeTLS(certFile, keyFile)
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mux.ServeHTTP(w, req)
+}
+
 func InitRouter() *Router {
 	routerOnce.Do(initRouter)
 	return routerInstance
 }
 
+// NewRouter returns a router independent from the InitRouter singleton.
+func NewRouter() *Router {
+	return &Router{
+		mux:    http.NewServeMux(),
+		routes: make(map[string]routeEntry),
+	}
+}
+
 type Route struct {
 	Path              string
 	Method            HTTPMethod
@@ -33,12 +107,43 @@ type Route struct {
 	AdditionalMethods []HTTPMethod
 	// Deprecated: use AdditionalMethods.
 	AditionalMethods []HTTPMethod
+	// Consumes lists the media types accepted in the request body, e.g.
+	// "application/json". Middlewares read it through RouteInfoFromRequest.
+	Consumes []string
+	// CORS overrides the CORS policy of the group and the router.
+	CORS CORSPolicy
+}
+
+func (r *Router) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+	r.chains.Clear()
+}
+
+// UseCORS sets the CORS policy of the routes without a route or group
+// policy.
+func (r *Router) UseCORS(policy CORSPolicy) {
+	r.cors = policy
+	r.chains.Clear()
 }
 
 func (r *Router) NewRoute(route Route) {
+	r.addRoute(route, nil)
+}
+
+func (r *Router) addRoute(route Route, group *Group) {
+	var groupPrefix string
+	if group != nil {
+		groupPrefix = group.prefix
+	}
+
 	entry, exists := r.routes[route.Path]
 	if !exists {
-		entry = routeEntry{handlers: make(map[HTTPMethod]http.HandlerFunc)}
+		entry = routeEntry{
+			handlers: make(map[HTTPMethod]http.HandlerFunc),
+			infos:    make(map[HTTPMethod]RouteInfo),
+			groups:   make(map[HTTPMethod]*Group),
+			cors:     make(map[HTTPMethod]CORSPolicy),
+		}
 		r.mux.HandleFunc(route.Path, r.methodHandler(route.Path))
 	}
 
@@ -47,6 +152,9 @@ func (r *Router) NewRoute(route Route) {
 	}
 
 	entry.handlers[route.Method] = route.Handler
+	entry.infos[route.Method] = RouteInfo{Path: route.Path, Method: route.Method, Group: groupPrefix, Consumes: route.Consumes}
+	entry.groups[route.Method] = group
+	entry.cors[route.Method] = route.CORS
 
 	additionalMethods := route.AdditionalMethods
 	if len(additionalMethods) == 0 {
@@ -58,19 +166,61 @@ func (r *Router) NewRoute(route Route) {
 			continue
 		}
 		entry.handlers[method] = route.Handler
+		entry.infos[method] = RouteInfo{Path: route.Path, Method: method, Group: groupPrefix, Consumes: route.Consumes}
+		entry.groups[method] = group
+		entry.cors[method] = route.CORS
 	}
 
-	entry.allowedMethods = buildAllowedMethodsHeader(entry.handlers)
+	entry.methods = make([]string, 0, len(entry.handlers))
+	for m := range entry.handlers {
+		entry.methods = append(entry.methods, string(m))
+	}
+	sort.Strings(entry.methods)
+	entry.allowedMethods = buildAllowedMethodsHeader(entry.methods)
 	r.routes[route.Path] = entry
 }
 
-func buildAllowedMethodsHeader(handlers map[HTTPMethod]http.HandlerFunc) string {
-	allowedMethods := make([]string, 0, len(handlers))
-	for m := range handlers {
-		allowedMethods = append(allowedMethods, string(m))
+// buildAllowedMethodsHeader lists the route methods and OPTIONS, which the
+// router answers for every route.
+func buildAllowedMethodsHeader(methods []string) string {
+	if !slices.Contains(methods, http.MethodOptions) {
+		methods = append(slices.Clone(methods), http.MethodOptions)
+		sort.Strings(methods)
+	}
+	return strings.Join(methods, ", ")
+}
+
+// corsPolicy returns the policy of the route, its group or the router.
+func (r *Router) corsPolicy(route routeEntry, method HTTPMethod) CORSPolicy {
+	if _, exists := route.handlers[method]; !exists {
+		return nil
+	}
+	if policy := route.cors[method]; policy != nil {
+		return policy
+	}
+	if group := route.groups[method]; group != nil && group.cors != nil {
+		return group.cors
+	}
+	return r.cors
+}
+
+// handleOptions answers OPTIONS requests of routes without an OPTIONS
+// handler: preflights with the CORS policy of the requested method, other
+// requests with the allowed methods.
+func (r *Router) handleOptions(w http.ResponseWriter, req *http.Request, route routeEntry) {
+	var requestedMethod string = req.Header.Get("Access-Control-Request-Method")
+	if req.Header.Get("Origin") != "" && requestedMethod != "" {
+		if policy := r.corsPolicy(route, HTTPMethod(requestedMethod)); policy != nil {
+			if err := policy.CheckPreflight(w, req, route.methods); err != nil {
+				helpers.SendErrorResponse(w, http.StatusForbidden, "CORS preflight rejected: "+err.Error())
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
 	}
-	sort.Strings(allowedMethods)
-	return strings.Join(allowedMethods, ", ")
+	w.Header().Set("Allow", route.allowedMethods)
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (r *Router) methodHandler(path string) http.HandlerFunc {
@@ -79,16 +229,20 @@ func (r *Router) methodHandler(path string) http.HandlerFunc {
 		var method HTTPMethod = HTTPMethod(req.Method)
 		route := r.routes[path]
 		handlers := route.handlers
-		w.Header().Set("Access-Control-Allow-Methods", route.allowedMethods)
 
 		handler, exists := handlers[method]
+		if !exists && req.Method == http.MethodOptions {
+			r.handleOptions(w, req, route)
+			return
+		}
 		if !exists {
 			w.Header().Set("Allow", route.allowedMethods)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			w.Write([]byte(`{"error": "Method not allowed"}`))
+			helpers.SendErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
 			return
 		}
+		req = req.WithContext(context.WithValue(req.Context(), routeInfoKey{}, route.infos[method]))
+		handler = r.chain(path, route, method, handler)
+
 		if req.Method == http.MethodOptions {
 			handler(w, req)
 			return
@@ -104,6 +258,35 @@ func (r *Router) methodHandler(path string) http.HandlerFunc {
 	}
 }
 
+// chain returns the handler wrapped with the group middlewares, the router
+// middlewares and the CORS check, composed on first use.
+func (r *Router) chain(path string, route routeEntry, method HTTPMethod, handler http.HandlerFunc) http.HandlerFunc {
+	var key routeKey = routeKey{path: path, method: method}
+	if cached, ok := r.chains.Load(key); ok {
+		return cached.(http.HandlerFunc)
+	}
+	if group := route.groups[method]; group != nil {
+		for i := len(group.middlewares) - 1; i >= 0; i-- {
+			handler = group.middlewares[i](handler)
+		}
+	}
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		handler = r.middlewares[i](handler)
+	}
+	if policy := r.corsPolicy(route, method); policy != nil {
+		next := handler
+		handler = func(w http.ResponseWriter, req *http.Request) {
+			if err := policy.CheckRequest(w, req); err != nil {
+				helpers.SendErrorResponse(w, http.StatusForbidden, "Origin not allowed")
+				return
+			}
+			next(w, req)
+		}
+	}
+	r.chains.Store(key, handler)
+	return handler
+}
+
 type responseRecorder struct {
 	http.ResponseWriter
 }
@@ -114,10 +297,7 @@ func (rec *responseRecorder) Write(b []byte) (int, error) {
 
 func initRouter() {
 	if nil == routerInstance {
-		routerInstance = &Router{
-			mux:    http.NewServeMux(),
-			routes: make(map[string]routeEntry),
-		}
+		routerInstance = NewRouter()
 	}
 
 }
@@ -0,0 +1,80 @@
+package sessions
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var errInvalidCookie = errors.New("invalid session cookie")
+
+// codec protects cookie values. With an encryption key values are sealed
+// with AES-GCM, otherwise they are readable but signed with HMAC-SHA256.
+// The cookie name is authenticated too, so values cannot be moved between
+// cookies.
+type codec struct {
+	signingKey []byte
+	aead       cipher.AEAD
+}
+
+func newCodec(signingKey []byte, encryptionKey []byte) (*codec, error) {
+	var c *codec = &codec{signingKey: signingKey}
+	if encryptionKey != nil {
+		block, err := aes.NewCipher(encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		if c.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *codec) encode(name string, data []byte) string {
+	if c.aead != nil {
+		var nonce []byte = make([]byte, c.aead.NonceSize())
+		rand.Read(nonce)
+		return base64.RawURLEncoding.EncodeToString(c.aead.Seal(nonce, nonce, data, []byte(name)))
+	}
+	var payload string = base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(c.mac(name, payload))
+}
+
+func (c *codec) decode(name string, value string) ([]byte, error) {
+	if c.aead != nil {
+		sealed, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || len(sealed) < c.aead.NonceSize() {
+			return nil, errInvalidCookie
+		}
+		data, err := c.aead.Open(nil, sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():], []byte(name))
+		if err != nil {
+			return nil, errInvalidCookie
+		}
+		return data, nil
+	}
+	payload, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, errInvalidCookie
+	}
+	expected, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, c.mac(name, payload)) {
+		return nil, errInvalidCookie
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errInvalidCookie
+	}
+	return data, nil
+}
+
+func (c *codec) mac(name string, payload string) []byte {
+	var mac = hmac.New(sha256.New, c.signingKey)
+	mac.Write([]byte(name + "|" + payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,147 @@
+package sessions
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+type contextKey struct{}
+
+// Session holds the values of one browser session. Values are stored as
+// JSON, so after a round trip numbers are float64 and structs are maps.
+type Session struct {
+	mu           sync.Mutex
+	id           string
+	createdAt    time.Time
+	lastActivity time.Time
+	subject      string
+	roles        []string
+	values       map[string]any
+	flashes      []string
+	isNew        bool
+	modified     bool
+	destroyed    bool
+}
+
+// sessionData is the stored form of a session.
+type sessionData struct {
+	ID           string         `json:"id,omitempty"`
+	CreatedAt    time.Time      `json:"created"`
+	LastActivity time.Time      `json:"active"`
+	Subject      string         `json:"sub,omitempty"`
+	Roles        []string       `json:"roles,omitempty"`
+	Values       map[string]any `json:"values,omitempty"`
+	Flashes      []string       `json:"flashes,omitempty"`
+}
+
+func newSession(id string, now time.Time) *Session {
+	return &Session{id: id, createdAt: now, lastActivity: now, values: make(map[string]any), isNew: true}
+}
+
+func sessionFromData(data sessionData) *Session {
+	if data.Values == nil {
+		data.Values = make(map[string]any)
+	}
+	return &Session{
+		id:           data.ID,
+		createdAt:    data.CreatedAt,
+		lastActivity: data.LastActivity,
+		subject:      data.Subject,
+		roles:        data.Roles,
+		values:       data.Values,
+		flashes:      data.Flashes,
+	}
+}
+
+func (s *Session) data() sessionData {
+	return sessionData{
+		ID:           s.id,
+		CreatedAt:    s.createdAt,
+		LastActivity: s.lastActivity,
+		Subject:      s.subject,
+		Roles:        s.roles,
+		Values:       s.values,
+		Flashes:      s.flashes,
+	}
+}
+
+// FromContext returns the session prepared by the sessions middleware.
+func FromContext(ctx context.Context) (*Session, bool) {
+	session, ok := ctx.Value(contextKey{}).(*Session)
+	return session, ok
+}
+
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+func (s *Session) CreatedAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createdAt
+}
+
+func (s *Session) Get(key string) (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	return value, ok
+}
+
+func (s *Session) Set(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.modified = true
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	s.modified = true
+}
+
+// AddFlash stores a message for the next request that reads the flashes,
+// typically after a redirect.
+func (s *Session) AddFlash(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flashes = append(s.flashes, message)
+	s.modified = true
+}
+
+// Flashes returns the pending messages and removes them from the session.
+func (s *Session) Flashes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var flashes []string = s.flashes
+	if len(flashes) > 0 {
+		s.flashes = nil
+		s.modified = true
+	}
+	return flashes
+}
+
+// Authenticated reports whether a user logged in with Manager.Login.
+func (s *Session) Authenticated() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.subject != ""
+}
+
+func (s *Session) GetSubject() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.subject, nil
+}
+
+func (s *Session) GetRoles() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.roles)
+}
@@ -0,0 +1,458 @@
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/logger"
+	"github.com/angelbarreiros/Penguin/router/auth"
+)
+
+const (
+	DefaultCookieName      = "penguin_sid"
+	DefaultIdleTimeout     = 30 * time.Minute
+	DefaultAbsoluteTimeout = 12 * time.Hour
+	// touchInterval limits how often an unchanged session is written back
+	// to extend its idle timeout.
+	touchInterval = time.Minute
+	// maxCookieBytes keeps cookies below the 4096 bytes browsers accept.
+	maxCookieBytes = 4000
+	idBytes        = 32
+)
+
+var (
+	ErrNoSession      = errors.New("no session in the request context")
+	ErrCookieTooLarge = errors.New("session does not fit in a cookie")
+)
+
+type managerOptionsFunc func(*Manager)
+
+// Manager loads and saves sessions. With a Store the cookie carries the
+// signed session ID; without one the whole session is kept in the cookie,
+// which cannot be revoked server side before it expires.
+//
+// Manager implements auth.PlainAuthInterface for users logged in with
+// Login.
+type Manager struct {
+	store           Store
+	codec           *codec
+	signingKey      []byte
+	encryptionKey   []byte
+	cookieName      string
+	cookiePath      string
+	cookieDomain    string
+	cookieSecure    bool
+	sameSite        http.SameSite
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
+	timeout         time.Duration
+	contextKey      any
+	err             error
+}
+
+func NewManager(store Store, options ...managerOptionsFunc) (*Manager, error) {
+	var m *Manager = &Manager{
+		store:           store,
+		cookieName:      DefaultCookieName,
+		cookiePath:      "/",
+		cookieSecure:    true,
+		sameSite:        http.SameSiteLaxMode,
+		idleTimeout:     DefaultIdleTimeout,
+		absoluteTimeout: DefaultAbsoluteTimeout,
+		timeout:         time.Duration(auth.DefaultContextTimeout) * time.Second,
+	}
+	for _, option := range options {
+		option(m)
+	}
+	if m.err != nil {
+		return nil, m.err
+	}
+	if m.absoluteTimeout <= 0 {
+		return nil, fmt.Errorf("absolute timeout must be greater than zero")
+	}
+	if m.signingKey == nil {
+		// Without a configured key sessions do not survive a restart.
+		m.signingKey = make([]byte, 32)
+		rand.Read(m.signingKey)
+	}
+	codec, err := newCodec(m.signingKey, m.encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	m.codec = codec
+	return m, nil
+}
+
+// ManagerWithSigningKey sets the HMAC key of the cookie. It must be at
+// least 32 bytes long.
+func ManagerWithSigningKey(key []byte) managerOptionsFunc {
+	return func(m *Manager) {
+		if len(key) < 32 {
+			m.err = fmt.Errorf("signing key must be at least 32 bytes long")
+			return
+		}
+		m.signingKey = key
+	}
+}
+
+// ManagerWithEncryptionKey encrypts the cookie with AES-GCM, so the values
+// of cookie sessions cannot be read by the client. The key must be 16, 24
+// or 32 bytes long.
+func ManagerWithEncryptionKey(key []byte) managerOptionsFunc {
+	return func(m *Manager) {
+		if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+			m.err = fmt.Errorf("encryption key must be 16, 24 or 32 bytes long")
+			return
+		}
+		m.encryptionKey = key
+	}
+}
+
+func ManagerWithCookieName(name string) managerOptionsFunc {
+	return func(m *Manager) {
+		m.cookieName = name
+	}
+}
+
+func ManagerWithCookiePath(path string) managerOptionsFunc {
+	return func(m *Manager) {
+		m.cookiePath = path
+	}
+}
+
+func ManagerWithCookieDomain(domain string) managerOptionsFunc {
+	return func(m *Manager) {
+		m.cookieDomain = domain
+	}
+}
+
+func ManagerWithCookieSecure(secure bool) managerOptionsFunc {
+	return func(m *Manager) {
+		m.cookieSecure = secure
+	}
+}
+
+func ManagerWithSameSite(sameSite http.SameSite) managerOptionsFunc {
+	return func(m *Manager) {
+		m.sameSite = sameSite
+	}
+}
+
+// ManagerWithIdleTimeout ends sessions without requests for the timeout.
+// Every request extends it; zero disables it.
+func ManagerWithIdleTimeout(timeout time.Duration) managerOptionsFunc {
+	return func(m *Manager) {
+		m.idleTimeout = timeout
+	}
+}
+
+// ManagerWithAbsoluteTimeout ends sessions the timeout after they were
+// created or the user logged in, however active they are.
+func ManagerWithAbsoluteTimeout(timeout time.Duration) managerOptionsFunc {
+	return func(m *Manager) {
+		m.absoluteTimeout = timeout
+	}
+}
+
+func ManagerWithCustomTimeout(timeout time.Duration) managerOptionsFunc {
+	return func(m *Manager) {
+		m.timeout = timeout
+	}
+}
+
+func ManagerWithCustomContextKey(key any) managerOptionsFunc {
+	return func(m *Manager) {
+		m.contextKey = key
+	}
+}
+
+// Start loads the session of the request into the context. The returned
+// writer saves the session before the response headers are written; call
+// Finish after the handler for responses without a body.
+func (m *Manager) Start(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request) {
+	if _, ok := FromContext(r.Context()); ok {
+		return w, r
+	}
+	session, err := m.load(r)
+	if err != nil {
+		logger.GetConsoleLogger().Error("Failed to load session: %v", err)
+		session = newSession(newID(), time.Now())
+	}
+	return &sessionWriter{ResponseWriter: w, manager: m, session: session},
+		r.WithContext(context.WithValue(r.Context(), contextKey{}, session))
+}
+
+// Finish saves the session when the handler did not write a response.
+func (m *Manager) Finish(w http.ResponseWriter) {
+	if writer, ok := w.(*sessionWriter); ok {
+		writer.commit()
+	}
+}
+
+// Load returns the session of the context, or loads it from the cookie.
+// A new empty session is returned when the request has none or it expired.
+// Sessions loaded without Start must be saved with Save.
+func (m *Manager) Load(r *http.Request) (*Session, error) {
+	if session, ok := FromContext(r.Context()); ok {
+		return session, nil
+	}
+	return m.load(r)
+}
+
+func (m *Manager) load(r *http.Request) (*Session, error) {
+	var now time.Time = time.Now()
+	cookie, err := r.Cookie(m.cookieName)
+	if err != nil {
+		return newSession(newID(), now), nil
+	}
+	value, err := m.codec.decode(m.cookieName, cookie.Value)
+	if err != nil {
+		return newSession(newID(), now), nil
+	}
+
+	var data []byte = value
+	if m.store != nil {
+		if data, err = m.store.Load(string(value)); errors.Is(err, ErrSessionNotFound) {
+			return newSession(newID(), now), nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	var stored sessionData
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return newSession(newID(), now), nil
+	}
+	if m.store != nil && stored.ID != string(value) {
+		return newSession(newID(), now), nil
+	}
+	var session *Session = sessionFromData(stored)
+	if m.expired(session, now) {
+		if m.store != nil {
+			m.store.Delete(session.id)
+		}
+		return newSession(newID(), now), nil
+	}
+	return session, nil
+}
+
+// Save stores the session and sets the cookie. It must be called before
+// the response is written.
+func (m *Manager) Save(w http.ResponseWriter, session *Session) error {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return m.save(w, session)
+}
+
+func (m *Manager) save(w http.ResponseWriter, session *Session) error {
+	if session.destroyed {
+		m.setCookie(w, "", time.Time{}, -1)
+		return nil
+	}
+	session.lastActivity = time.Now()
+	var expiresAt time.Time = m.expiresAt(session)
+	data, err := json.Marshal(session.data())
+	if err != nil {
+		return err
+	}
+	var value []byte = data
+	if m.store != nil {
+		if err := m.store.Save(session.id, data, expiresAt); err != nil {
+			return err
+		}
+		value = []byte(session.id)
+	}
+	var encoded string = m.codec.encode(m.cookieName, value)
+	if len(encoded) > maxCookieBytes {
+		return ErrCookieTooLarge
+	}
+	m.setCookie(w, encoded, expiresAt, 0)
+	session.isNew = false
+	session.modified = false
+	return nil
+}
+
+// commit saves the session when it changed, or when its idle timeout
+// should be extended. New sessions are only saved once they hold data.
+func (m *Manager) commit(w http.ResponseWriter, session *Session) error {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if !session.destroyed && !session.modified &&
+		(session.isNew || m.idleTimeout <= 0 || time.Since(session.lastActivity) < touchInterval) {
+		return nil
+	}
+	return m.save(w, session)
+}
+
+// RenewID gives the session a new ID and removes the old one from the
+// store, so an ID known before a privilege change becomes useless.
+func (m *Manager) RenewID(session *Session) error {
+	session.mu.Lock()
+	var previousID string = session.id
+	var stored bool = !session.isNew
+	session.id = newID()
+	session.modified = true
+	session.mu.Unlock()
+	if m.store != nil && stored {
+		return m.store.Delete(previousID)
+	}
+	return nil
+}
+
+// Login renews the session ID against session fixation and stores the
+// user. The absolute timeout starts again.
+func (m *Manager) Login(r *http.Request, subject string, roles ...string) error {
+	session, ok := FromContext(r.Context())
+	if !ok {
+		return ErrNoSession
+	}
+	if err := m.RenewID(session); err != nil {
+		return err
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.subject = subject
+	session.roles = roles
+	session.createdAt = time.Now()
+	return nil
+}
+
+// Logout destroys the session; the cookie is cleared with the response.
+func (m *Manager) Logout(r *http.Request) error {
+	session, ok := FromContext(r.Context())
+	if !ok {
+		return ErrNoSession
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return m.destroy(session)
+}
+
+// Destroy ends a session loaded without Start and clears its cookie. It
+// must be called before the response is written.
+func (m *Manager) Destroy(w http.ResponseWriter, session *Session) error {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	var err error = m.destroy(session)
+	m.setCookie(w, "", time.Time{}, -1)
+	return err
+}
+
+func (m *Manager) destroy(session *Session) error {
+	session.destroyed = true
+	session.values = make(map[string]any)
+	session.subject = ""
+	session.roles = nil
+	if m.store != nil && !session.isNew {
+		return m.store.Delete(session.id)
+	}
+	return nil
+}
+
+// Authenticate returns the *Session when a user is logged in.
+func (m *Manager) Authenticate(r *http.Request) (any, error) {
+	session, err := m.Load(r)
+	if err != nil {
+		return nil, err
+	}
+	if !session.Authenticated() {
+		return nil, &auth.TokenError{Reason: auth.ReasonMissingToken, Description: "no authenticated session"}
+	}
+	return session, nil
+}
+
+// RBAC checks the roles given to Login.
+func (m *Manager) RBAC(user any, allowedRoles []string) bool {
+	session, ok := user.(*Session)
+	if !ok {
+		return false
+	}
+	return slices.ContainsFunc(session.GetRoles(), func(role string) bool { return slices.Contains(allowedRoles, role) })
+}
+
+// Challenge sets no WWW-Authenticate header: sessions are not an HTTP
+// authentication scheme.
+func (m *Manager) Challenge(err error) string {
+	return ""
+}
+
+func (m *Manager) GetTimeout() time.Duration {
+	return m.timeout
+}
+
+func (m *Manager) GetContextKey() any {
+	return m.contextKey
+}
+
+func (m *Manager) expired(session *Session, now time.Time) bool {
+	if m.idleTimeout > 0 && !now.Before(session.lastActivity.Add(m.idleTimeout)) {
+		return true
+	}
+	return !now.Before(session.createdAt.Add(m.absoluteTimeout))
+}
+
+func (m *Manager) expiresAt(session *Session) time.Time {
+	var expiresAt time.Time = session.createdAt.Add(m.absoluteTimeout)
+	if m.idleTimeout > 0 && session.lastActivity.Add(m.idleTimeout).Before(expiresAt) {
+		return session.lastActivity.Add(m.idleTimeout)
+	}
+	return expiresAt
+}
+
+func (m *Manager) setCookie(w http.ResponseWriter, value string, expiresAt time.Time, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.cookieName,
+		Value:    value,
+		Path:     m.cookiePath,
+		Domain:   m.cookieDomain,
+		Expires:  expiresAt,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   m.cookieSecure,
+		SameSite: m.sameSite,
+	})
+}
+
+func newID() string {
+	var data []byte = make([]byte, idBytes)
+	rand.Read(data)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// sessionWriter saves the session right before the headers are written.
+type sessionWriter struct {
+	http.ResponseWriter
+	manager   *Manager
+	session   *Session
+	committed bool
+}
+
+func (w *sessionWriter) commit() {
+	if w.committed {
+		return
+	}
+	w.committed = true
+	if err := w.manager.commit(w.ResponseWriter, w.session); err != nil {
+		logger.GetConsoleLogger().Error("Failed to save session: %v", err)
+	}
+}
+
+func (w *sessionWriter) WriteHeader(statusCode int) {
+	w.commit()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	w.commit()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
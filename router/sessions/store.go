@@ -0,0 +1,243 @@
+package sessions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/scheduler"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Store keeps sessions server side; the cookie only carries the signed
+// session ID. Implement it to keep sessions in a database: data is opaque
+// and can be stored as a blob next to the ID and the expiry. Load must
+// return ErrSessionNotFound for unknown or expired sessions.
+type Store interface {
+	Load(id string) ([]byte, error)
+	Save(id string, data []byte, expiresAt time.Time) error
+	Delete(id string) error
+}
+
+type storedSession struct {
+	data      []byte
+	expiresAt time.Time
+	job       uint64
+}
+
+type expiredSession struct {
+	id    string
+	store *MemoryStore
+}
+
+func (e *expiredSession) Execute() []any {
+	e.store.expire(e.id)
+	return nil
+}
+
+// MemoryStore keeps sessions in memory and removes them through the
+// scheduler when they expire.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]storedSession
+	cleaner  *scheduler.Scheduler
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]storedSession),
+		cleaner:  scheduler.StartScheduler(),
+	}
+}
+
+func (m *MemoryStore) Load(id string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, exists := m.sessions[id]
+	if !exists || !time.Now().Before(stored.expiresAt) {
+		return nil, ErrSessionNotFound
+	}
+	return stored.data, nil
+}
+
+func (m *MemoryStore) Save(id string, data []byte, expiresAt time.Time) error {
+	job, err := m.cleaner.ScheduleProgrammedOneTimeJob(expiresAt, scheduler.JobFunction(&expiredSession{id: id, store: m}))
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	previous, exists := m.sessions[id]
+	m.sessions[id] = storedSession{data: data, expiresAt: expiresAt, job: job}
+	m.mu.Unlock()
+	if exists {
+		m.cleaner.RemoveJob(previous.job)
+	}
+	return nil
+}
+
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	stored, exists := m.sessions[id]
+	delete(m.sessions, id)
+	m.mu.Unlock()
+	if exists {
+		m.cleaner.RemoveJob(stored.job)
+	}
+	return nil
+}
+
+// Close removes the sessions and their expiry jobs. The shared scheduler
+// keeps running for the other jobs.
+func (m *MemoryStore) Close() {
+	m.mu.Lock()
+	var jobs []uint64 = make([]uint64, 0, len(m.sessions))
+	for _, stored := range m.sessions {
+		jobs = append(jobs, stored.job)
+	}
+	m.sessions = make(map[string]storedSession)
+	m.mu.Unlock()
+	for _, job := range jobs {
+		m.cleaner.RemoveJob(job)
+	}
+}
+
+func (m *MemoryStore) expire(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stored, exists := m.sessions[id]; exists && !time.Now().Before(stored.expiresAt) {
+		delete(m.sessions, id)
+	}
+}
+
+type fileSession struct {
+	Data      []byte    `json:"data"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type expiredFiles struct {
+	store *FileStore
+}
+
+func (e *expiredFiles) Execute() []any {
+	e.store.removeExpired()
+	return nil
+}
+
+// FileStore keeps one file per session in a directory. Expired files are
+// removed every cleanupInterval.
+type FileStore struct {
+	dir     string
+	cleaner *scheduler.Scheduler
+	jobID   uint64
+}
+
+func NewFileStore(dir string, cleanupInterval time.Duration) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+	var store *FileStore = &FileStore{dir: dir, cleaner: scheduler.StartScheduler()}
+	if cleanupInterval > 0 {
+		jobID, err := store.cleaner.ScheduleIntervalJob(cleanupInterval, scheduler.JobFunction(&expiredFiles{store: store}))
+		if err != nil {
+			return nil, err
+		}
+		store.jobID = jobID
+	}
+	return store, nil
+}
+
+func (f *FileStore) Load(id string) ([]byte, error) {
+	path, err := f.path(id)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored fileSession
+	if err := json.Unmarshal(content, &stored); err != nil || !time.Now().Before(stored.ExpiresAt) {
+		return nil, ErrSessionNotFound
+	}
+	return stored.Data, nil
+}
+
+// Save writes to a temporary file first, so concurrent loads never see a
+// partial session.
+func (f *FileStore) Save(id string, data []byte, expiresAt time.Time) error {
+	path, err := f.path(id)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(fileSession{Data: data, ExpiresAt: expiresAt})
+	if err != nil {
+		return err
+	}
+	temporary, err := os.CreateTemp(f.dir, ".session-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporary.Name())
+	if _, err := temporary.Write(content); err != nil {
+		temporary.Close()
+		return err
+	}
+	if err := temporary.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temporary.Name(), path)
+}
+
+func (f *FileStore) Delete(id string) error {
+	path, err := f.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Close stops the cleanup of expired files.
+func (f *FileStore) Close() {
+	if f.jobID == 0 {
+		return
+	}
+	_ = f.cleaner.RemoveJob(f.jobID)
+	f.jobID = 0
+}
+
+// path rejects IDs that are not plain file names; the manager only issues
+// base64url IDs.
+func (f *FileStore) path(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", ErrSessionNotFound
+	}
+	return filepath.Join(f.dir, id+".json"), nil
+}
+
+func (f *FileStore) removeExpired() {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if _, err := f.Load(id); errors.Is(err, ErrSessionNotFound) {
+			os.Remove(filepath.Join(f.dir, entry.Name()))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package toggles
+
+import (
+	"sync"
+	"time"
+)
+
+const DefaultAuditCapacity = 1000
+
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	Scope    Scope     `json:"scope"`
+	Target   string    `json:"target,omitempty"`
+	Previous Mode      `json:"previous"`
+	Mode     Mode      `json:"mode"`
+	Message  string    `json:"message,omitempty"`
+}
+
+// AuditRecorder stores every state change. Implement it to persist the audit
+// trail in a database or an external log.
+type AuditRecorder interface {
+	Record(entry AuditEntry)
+	Entries() []AuditEntry
+}
+
+// MemoryAuditRecorder keeps the last entries in a ring buffer.
+type MemoryAuditRecorder struct {
+	mu       sync.Mutex
+	entries  []AuditEntry
+	capacity int
+}
+
+func NewMemoryAuditRecorder(capacity int) *MemoryAuditRecorder {
+	if capacity <= 0 {
+		capacity = DefaultAuditCapacity
+	}
+	return &MemoryAuditRecorder{capacity: capacity}
+}
+
+func (m *MemoryAuditRecorder) Record(entry AuditEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, entry)
+	if len(m.entries) > m.capacity {
+		m.entries = m.entries[len(m.entries)-m.capacity:]
+	}
+}
+
+func (m *MemoryAuditRecorder) Entries() []AuditEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var entries []AuditEntry = make([]AuditEntry, len(m.entries))
+	copy(entries, m.entries)
+	return entries
+}
@@ -0,0 +1,370 @@
+package toggles
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/logger"
+	"github.com/angelbarreiros/Penguin/router"
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+)
+
+type Mode string
+
+const (
+	ModeEnabled     Mode = "enabled"
+	ModeDisabled    Mode = "disabled"
+	ModeMaintenance Mode = "maintenance"
+	ModeReadOnly    Mode = "read-only"
+)
+
+type Scope string
+
+const (
+	ScopeGlobal Scope = "global"
+	ScopeGroup  Scope = "group"
+	ScopeRoute  Scope = "route"
+)
+
+// State is the runtime state of a route, a group or the whole router.
+// RetryAfter is sent as the Retry-After header in maintenance and read-only
+// modes.
+type State struct {
+	Mode       Mode
+	Message    string
+	RetryAfter time.Duration
+	UpdatedAt  time.Time
+	UpdatedBy  string
+}
+
+type stateJSON struct {
+	Mode              Mode      `json:"mode"`
+	Message           string    `json:"message,omitempty"`
+	RetryAfterSeconds int       `json:"retryAfterSeconds,omitempty"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+	UpdatedBy         string    `json:"updatedBy,omitempty"`
+}
+
+func (s State) MarshalJSON() ([]byte, error) {
+	return json.Marshal(stateJSON{
+		Mode:              s.Mode,
+		Message:           s.Message,
+		RetryAfterSeconds: int(s.RetryAfter.Seconds()),
+		UpdatedAt:         s.UpdatedAt,
+		UpdatedBy:         s.UpdatedBy,
+	})
+}
+
+func (s *State) UnmarshalJSON(data []byte) error {
+	var decoded stateJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*s = State{
+		Mode:       decoded.Mode,
+		Message:    decoded.Message,
+		RetryAfter: time.Duration(decoded.RetryAfterSeconds) * time.Second,
+		UpdatedAt:  decoded.UpdatedAt,
+		UpdatedBy:  decoded.UpdatedBy,
+	}
+	return nil
+}
+
+type Registry struct {
+	mu     sync.RWMutex
+	global State
+	groups map[string]State
+	routes map[string]State
+	audit  AuditRecorder
+}
+
+var registryInstance *Registry
+var registryOnce sync.Once
+
+func NewRegistry(options ...func(*Registry)) *Registry {
+	var registry *Registry = &Registry{
+		global: State{Mode: ModeEnabled},
+		groups: make(map[string]State),
+		routes: make(map[string]State),
+		audit:  NewMemoryAuditRecorder(DefaultAuditCapacity),
+	}
+	for _, option := range options {
+		option(registry)
+	}
+	return registry
+}
+
+func GetRegistry() *Registry {
+	registryOnce.Do(func() { registryInstance = NewRegistry() })
+	return registryInstance
+}
+
+func WithAuditRecorder(recorder AuditRecorder) func(*Registry) {
+	return func(r *Registry) {
+		r.audit = recorder
+	}
+}
+
+func (r *Registry) AuditRecorder() AuditRecorder {
+	return r.audit
+}
+
+// Set changes the state of a scope. target is the route path for ScopeRoute,
+// the group prefix for ScopeGroup and is ignored for ScopeGlobal.
+func (r *Registry) Set(scope Scope, target string, state State, actor string) error {
+	switch state.Mode {
+	case ModeEnabled, ModeDisabled, ModeMaintenance, ModeReadOnly:
+	default:
+		return fmt.Errorf("unknown mode '%s'", state.Mode)
+	}
+	state.UpdatedAt = time.Now()
+	state.UpdatedBy = actor
+
+	r.mu.Lock()
+	var previous State
+	switch scope {
+	case ScopeGlobal:
+		previous = r.global
+		r.global = state
+	case ScopeGroup:
+		previous = r.groups[target]
+		r.groups[target] = state
+	case ScopeRoute:
+		previous = r.routes[target]
+		r.routes[target] = state
+	default:
+		r.mu.Unlock()
+		return fmt.Errorf("unknown scope '%s'", scope)
+	}
+	r.mu.Unlock()
+
+	if previous.Mode == "" {
+		previous.Mode = ModeEnabled
+	}
+	logger.GetConsoleLogger().Warn("Toggle %s %s changed from %s to %s by %s", scope, target, previous.Mode, state.Mode, actor)
+	if r.audit != nil {
+		r.audit.Record(AuditEntry{
+			Time:     state.UpdatedAt,
+			Actor:    actor,
+			Scope:    scope,
+			Target:   target,
+			Previous: previous.Mode,
+			Mode:     state.Mode,
+			Message:  state.Message,
+		})
+	}
+	return nil
+}
+
+// Clear removes the state of a route or group, which then inherits the
+// state of its group or of the router again.
+func (r *Registry) Clear(scope Scope, target string, actor string) error {
+	r.mu.Lock()
+	var previous State
+	switch scope {
+	case ScopeGroup:
+		previous = r.groups[target]
+		delete(r.groups, target)
+	case ScopeRoute:
+		previous = r.routes[target]
+		delete(r.routes, target)
+	default:
+		r.mu.Unlock()
+		return fmt.Errorf("scope '%s' cannot be cleared", scope)
+	}
+	r.mu.Unlock()
+
+	logger.GetConsoleLogger().Warn("Toggle %s %s cleared by %s", scope, target, actor)
+	if r.audit != nil && previous.Mode != "" {
+		r.audit.Record(AuditEntry{
+			Time:     time.Now(),
+			Actor:    actor,
+			Scope:    scope,
+			Target:   target,
+			Previous: previous.Mode,
+		})
+	}
+	return nil
+}
+
+func (r *Registry) SetRoute(path string, state State, actor string) error {
+	return r.Set(ScopeRoute, path, state, actor)
+}
+
+func (r *Registry) SetGroup(prefix string, state State, actor string) error {
+	return r.Set(ScopeGroup, prefix, state, actor)
+}
+
+func (r *Registry) SetGlobal(state State, actor string) error {
+	return r.Set(ScopeGlobal, "", state, actor)
+}
+
+// Resolve returns the effective state of a route: the route state wins over
+// its group state, which wins over the global state, whatever their modes,
+// so an enabled route stays available in a group under maintenance. Nested
+// groups are resolved from the longest prefix.
+func (r *Registry) Resolve(info router.RouteInfo) State {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if state, exists := r.routes[info.Path]; exists {
+		return state
+	}
+	var bestPrefix string
+	var best State
+	for prefix, state := range r.groups {
+		if len(prefix) <= len(bestPrefix) {
+			continue
+		}
+		if info.Group == prefix || strings.HasPrefix(info.Path, strings.TrimSuffix(prefix, "/")+"/") {
+			bestPrefix = prefix
+			best = state
+		}
+	}
+	if bestPrefix != "" {
+		return best
+	}
+	if r.global.Mode == "" {
+		return State{Mode: ModeEnabled}
+	}
+	return r.global
+}
+
+type snapshot struct {
+	Global State            `json:"global"`
+	Groups map[string]State `json:"groups"`
+	Routes map[string]State `json:"routes"`
+}
+
+func (r *Registry) snapshot() snapshot {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var s snapshot = snapshot{Global: r.global, Groups: make(map[string]State), Routes: make(map[string]State)}
+	for k, v := range r.groups {
+		s.Groups[k] = v
+	}
+	for k, v := range r.routes {
+		s.Routes[k] = v
+	}
+	return s
+}
+
+// LoadFile applies the states described in a JSON file with the same shape
+// as the admin API GET response: {"global": {...}, "groups": {...}, "routes": {...}}.
+func (r *Registry) LoadFile(path string, actor string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read toggle file: %w", err)
+	}
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("failed to parse toggle file: %w", err)
+	}
+	if s.Global.Mode != "" {
+		if err := r.SetGlobal(s.Global, actor); err != nil {
+			return err
+		}
+	}
+	for prefix, state := range s.Groups {
+		if err := r.SetGroup(prefix, state, actor); err != nil {
+			return err
+		}
+	}
+	for path, state := range s.Routes {
+		if err := r.SetRoute(path, state, actor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReloadOnSignal reloads the toggle file every time one of the signals is
+// received, e.g. syscall.SIGHUP. The returned function stops listening.
+func (r *Registry) ReloadOnSignal(path string, signals ...os.Signal) func() {
+	var ch chan os.Signal = make(chan os.Signal, 1)
+	var done chan struct{} = make(chan struct{})
+	signal.Notify(ch, signals...)
+	go func() {
+		for {
+			select {
+			case sig := <-ch:
+				if err := r.LoadFile(path, "signal:"+sig.String()); err != nil {
+					logger.GetConsoleLogger().Error("Failed to reload toggles on %s: %v", sig, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
+
+type adminRequest struct {
+	Scope             Scope  `json:"scope"`
+	Target            string `json:"target"`
+	Mode              Mode   `json:"mode"`
+	Message           string `json:"message"`
+	RetryAfterSeconds int    `json:"retryAfterSeconds"`
+}
+
+// AdminHandler exposes the registry: GET returns every state and the audit
+// log, PUT/POST changes one scope and DELETE clears one. It must run behind
+// an auth middleware: the audit actor is the subject of the authenticated
+// principal, and requests without one are rejected.
+func (r *Registry) AdminHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			var entries []AuditEntry
+			if r.audit != nil {
+				entries = r.audit.Entries()
+			}
+			helpers.SendSuccessResponse(w, map[string]any{"states": r.snapshot(), "audit": entries})
+			return
+		}
+		if req.Method != http.MethodPut && req.Method != http.MethodPost && req.Method != http.MethodDelete {
+			w.Header().Set("Allow", "GET, POST, PUT, DELETE")
+			helpers.SendErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		principal, ok := auth.PrincipalFrom(req.Context())
+		if !ok || principal.Subject == "" {
+			helpers.SendErrorResponse(w, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+		var body adminRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1<<16)).Decode(&body); err != nil {
+			helpers.SendErrorResponse(w, http.StatusBadRequest, "Invalid toggle request: "+err.Error())
+			return
+		}
+
+		var err error
+		if req.Method == http.MethodDelete {
+			err = r.Clear(body.Scope, body.Target, principal.Subject)
+		} else {
+			err = r.Set(body.Scope, body.Target, State{
+				Mode:       body.Mode,
+				Message:    body.Message,
+				RetryAfter: time.Duration(body.RetryAfterSeconds) * time.Second,
+			}, principal.Subject)
+		}
+		if err != nil {
+			helpers.SendErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		helpers.SendSuccessResponse(w, r.snapshot())
+	}
+}
@@ -0,0 +1,133 @@
+package tokens
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/angelbarreiros/Penguin/logger"
+	"github.com/angelbarreiros/Penguin/router"
+)
+
+const (
+	RefreshPath      = "/token/refresh"
+	RevokePath       = "/token/revoke"
+	maxTokenBodySize = 16 << 10
+)
+
+type tokenRequest struct {
+	GrantType    string `json:"grant_type"`
+	RefreshToken string `json:"refresh_token"`
+	Token        string `json:"token"`
+}
+
+// readTokenRequest accepts the form encoding of RFC 6749 and a JSON body
+// with the same field names.
+func readTokenRequest(w http.ResponseWriter, r *http.Request) (tokenRequest, error) {
+	var request tokenRequest
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	r.Body = http.MaxBytesReader(w, r.Body, maxTokenBodySize)
+	if mediaType == "application/json" {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return request, err
+		}
+		err = json.Unmarshal(data, &request)
+		return request, err
+	}
+	if err := r.ParseForm(); err != nil {
+		return request, err
+	}
+	request.GrantType = r.PostForm.Get("grant_type")
+	request.RefreshToken = r.PostForm.Get("refresh_token")
+	request.Token = r.PostForm.Get("token")
+	return request, nil
+}
+
+// writeOAuthError writes an RFC 6749 section 5.2 error response.
+func writeOAuthError(w http.ResponseWriter, status int, code string, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": description})
+}
+
+// RefreshHandler exchanges a refresh token for a new token pair. The
+// grant_type, when present, must be "refresh_token".
+func (s *Service) RefreshHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request, err := readTokenRequest(w, r)
+		if err != nil {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_request", "malformed token request")
+			return
+		}
+		if request.GrantType != "" && request.GrantType != "refresh_token" {
+			writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be refresh_token")
+			return
+		}
+		if request.RefreshToken == "" {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_request", "refresh_token is required")
+			return
+		}
+
+		pair, err := s.Refresh(request.RefreshToken)
+		switch {
+		case errors.Is(err, ErrInvalidRefreshToken), errors.Is(err, ErrRefreshTokenExpired),
+			errors.Is(err, ErrRefreshTokenRevoked), errors.Is(err, ErrRefreshTokenReused):
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+			return
+		case err != nil:
+			logger.GetConsoleLogger().Error("Failed to refresh token: %v", err)
+			writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to refresh token")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Pragma", "no-cache")
+		json.NewEncoder(w).Encode(pair)
+	}
+}
+
+// RevokeHandler revokes the family of a refresh token following RFC 7009:
+// unknown tokens are not an error, so the response is always 200.
+func (s *Service) RevokeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request, err := readTokenRequest(w, r)
+		if err != nil {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_request", "malformed revocation request")
+			return
+		}
+		var token string = request.Token
+		if token == "" {
+			token = request.RefreshToken
+		}
+		if token == "" {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_request", "token is required")
+			return
+		}
+		if err := s.Revoke(token); err != nil && !errors.Is(err, ErrInvalidRefreshToken) {
+			logger.GetConsoleLogger().Error("Failed to revoke token: %v", err)
+			writeOAuthError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "failed to revoke token")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// RegisterRoutes serves POST /token/refresh and POST /token/revoke on a
+// router or group.
+func (s *Service) RegisterRoutes(r router.RouteRegistrar) {
+	r.NewRoute(router.Route{Path: RefreshPath, Method: router.POST, Handler: s.RefreshHandler()})
+	r.NewRoute(router.Route{Path: RevokePath, Method: router.POST, Handler: s.RevokeHandler()})
+}
+
+// RegisterJWKSRoute serves the public keys of the service key set at
+// /.well-known/jwks.json on a router or group, so resource servers can
+// verify the access tokens with auth.NewRemoteKeySet.
+func (s *Service) RegisterJWKSRoute(r router.RouteRegistrar) {
+	router.RegisterJWKSRoute(r, s.keys)
+}
@@ -0,0 +1,283 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/logger"
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultAccessTokenTTL  = 15 * time.Minute
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+	refreshSecretBytes     = 32
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid")
+	ErrRefreshTokenExpired = errors.New("refresh token has expired")
+	ErrRefreshTokenRevoked = errors.New("refresh token was revoked")
+	// ErrRefreshTokenReused is returned when a rotated refresh token is
+	// presented again. The whole family is revoked, since either the client
+	// or an attacker holds a stolen token.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+// Grant is what a token pair is issued for. It is stored with the refresh
+// token so access tokens can be reissued without the login data.
+type Grant struct {
+	Subject string
+	Roles   []string
+	Data    map[string]string
+}
+
+// ClaimsBuilder builds the access token claims of a grant. registered holds
+// the issuer, subject, audience, times and a unique jti.
+type ClaimsBuilder func(grant Grant, registered jwt.RegisteredClaims) jwt.Claims
+
+// TokenPair is the token response of RFC 6749 section 5.1.
+type TokenPair struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int    `json:"refresh_expires_in"`
+}
+
+type serviceOptionsFunc func(*Service)
+
+// Service issues short-lived access tokens signed with a key set and
+// opaque rotating refresh tokens. Every refresh consumes the presented
+// refresh token and returns a new one of the same family.
+type Service struct {
+	keys            *auth.KeySet
+	store           Store
+	issuer          string
+	audience        []string
+	accessTTL       time.Duration
+	refreshTTL      time.Duration
+	claimsBuilder   ClaimsBuilder
+	now             func() time.Time
+	revokeListeners []func(RefreshToken)
+}
+
+func NewService(keys *auth.KeySet, store Store, options ...serviceOptionsFunc) *Service {
+	var service *Service = &Service{
+		keys:          keys,
+		store:         store,
+		accessTTL:     DefaultAccessTokenTTL,
+		refreshTTL:    DefaultRefreshTokenTTL,
+		claimsBuilder: rbacClaims,
+		now:           time.Now,
+	}
+	if service.store == nil {
+		service.store = NewMemoryStore()
+	}
+	for _, option := range options {
+		option(service)
+	}
+	return service
+}
+
+func ServiceWithIssuer(issuer string) serviceOptionsFunc {
+	return func(s *Service) {
+		s.issuer = issuer
+	}
+}
+
+func ServiceWithAudience(audience ...string) serviceOptionsFunc {
+	return func(s *Service) {
+		s.audience = audience
+	}
+}
+
+func ServiceWithAccessTokenTTL(ttl time.Duration) serviceOptionsFunc {
+	return func(s *Service) {
+		s.accessTTL = ttl
+	}
+}
+
+func ServiceWithRefreshTokenTTL(ttl time.Duration) serviceOptionsFunc {
+	return func(s *Service) {
+		s.refreshTTL = ttl
+	}
+}
+
+// ServiceWithClaimsBuilder replaces the default auth.RBACClaims access
+// token claims.
+func ServiceWithClaimsBuilder(builder ClaimsBuilder) serviceOptionsFunc {
+	return func(s *Service) {
+		s.claimsBuilder = builder
+	}
+}
+
+func rbacClaims(grant Grant, registered jwt.RegisteredClaims) jwt.Claims {
+	return &auth.RBACClaims{Roles: grant.Roles, RegisteredClaims: registered}
+}
+
+func (s *Service) AccessTokenTTL() time.Duration {
+	return s.accessTTL
+}
+
+// Issue starts a new refresh token family, e.g. after a login.
+func (s *Service) Issue(grant Grant) (TokenPair, error) {
+	return s.issueWithID(grant, uuid.NewString(), uuid.NewString())
+}
+
+// Refresh exchanges a refresh token for a new pair. Presenting a token that
+// was already rotated revokes its whole family.
+func (s *Service) Refresh(refreshToken string) (TokenPair, error) {
+	stored, err := s.lookup(refreshToken)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if stored.Revoked {
+		return TokenPair{}, ErrRefreshTokenRevoked
+	}
+	if !stored.UsedAt.IsZero() {
+		return TokenPair{}, s.reuseDetected(stored)
+	}
+	if !s.now().Before(stored.ExpiresAt) {
+		return TokenPair{}, ErrRefreshTokenExpired
+	}
+
+	var nextID string = uuid.NewString()
+	if err := s.store.MarkUsed(stored.ID, nextID, s.now()); err != nil {
+		if errors.Is(err, ErrRefreshTokenUsed) {
+			return TokenPair{}, s.reuseDetected(stored)
+		}
+		return TokenPair{}, err
+	}
+	return s.issueWithID(stored.Grant, stored.FamilyID, nextID)
+}
+
+// Revoke revokes the family of a refresh token, e.g. on logout.
+func (s *Service) Revoke(refreshToken string) error {
+	stored, err := s.lookup(refreshToken)
+	if err != nil {
+		return err
+	}
+	return s.revokeFamily(stored)
+}
+
+// RevokeSubject revokes every refresh token of a subject. The revoke
+// listeners are notified once per revoked family.
+func (s *Service) RevokeSubject(subject string) error {
+	revoked, err := s.store.RevokeSubject(subject)
+	if err != nil {
+		return err
+	}
+	for _, token := range revoked {
+		for _, listener := range s.revokeListeners {
+			listener(token)
+		}
+	}
+	return nil
+}
+
+// OnRevoke registers a function called with the token whose family was
+// revoked, e.g. to deny the access tokens already issued.
+func (s *Service) OnRevoke(listener func(RefreshToken)) {
+	s.revokeListeners = append(s.revokeListeners, listener)
+}
+
+func (s *Service) reuseDetected(stored RefreshToken) error {
+	logger.GetConsoleLogger().Warn("Refresh token reuse detected for subject %s, revoking family %s", stored.Grant.Subject, stored.FamilyID)
+	if err := s.revokeFamily(stored); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+func (s *Service) revokeFamily(stored RefreshToken) error {
+	if err := s.store.RevokeFamily(stored.FamilyID); err != nil {
+		return err
+	}
+	for _, listener := range s.revokeListeners {
+		listener(stored)
+	}
+	return nil
+}
+
+func (s *Service) issueWithID(grant Grant, familyID string, refreshID string) (TokenPair, error) {
+	var now time.Time = s.now()
+	var registered jwt.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    s.issuer,
+		Subject:   grant.Subject,
+		Audience:  s.audience,
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(s.accessTTL)),
+		ID:        uuid.NewString(),
+	}
+	accessToken, err := s.keys.Sign(s.claimsBuilder(grant, registered))
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	var stored RefreshToken = RefreshToken{
+		ID:        refreshID,
+		Hash:      hashSecret(secret),
+		FamilyID:  familyID,
+		Grant:     grant,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(s.refreshTTL),
+	}
+	if err := s.store.Save(stored); err != nil {
+		return TokenPair{}, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	return TokenPair{
+		AccessToken:      accessToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        int(s.accessTTL.Seconds()),
+		RefreshToken:     refreshID + "." + secret,
+		RefreshExpiresIn: int(s.refreshTTL.Seconds()),
+	}, nil
+}
+
+// lookup finds the stored token by its id part and checks the secret part
+// against the stored hash in constant time.
+func (s *Service) lookup(refreshToken string) (RefreshToken, error) {
+	id, secret, found := strings.Cut(refreshToken, ".")
+	if !found || id == "" || secret == "" {
+		return RefreshToken{}, ErrInvalidRefreshToken
+	}
+	stored, err := s.store.Get(id)
+	if errors.Is(err, ErrRefreshTokenNotFound) {
+		return RefreshToken{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if subtle.ConstantTimeCompare(stored.Hash, hashSecret(secret)) != 1 {
+		return RefreshToken{}, ErrInvalidRefreshToken
+	}
+	return stored, nil
+}
+
+func generateSecret() (string, error) {
+	var b []byte = make([]byte, refreshSecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashSecret(secret string) []byte {
+	var sum [32]byte = sha256.Sum256([]byte(secret))
+	return sum[:]
+}
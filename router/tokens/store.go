@@ -0,0 +1,151 @@
+package tokens
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/scheduler"
+)
+
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenUsed     = errors.New("refresh token was already used")
+)
+
+// RefreshToken is the stored form of a refresh token. Only the SHA-256 hash
+// of the secret part is kept, so a leaked store cannot be replayed. Tokens
+// issued from the same login share a FamilyID.
+type RefreshToken struct {
+	ID         string
+	Hash       []byte
+	FamilyID   string
+	Grant      Grant
+	IssuedAt   time.Time
+	ExpiresAt  time.Time
+	UsedAt     time.Time
+	ReplacedBy string
+	Revoked    bool
+}
+
+// Store persists refresh tokens. MarkUsed must be atomic: when two requests
+// present the same token only one of them may succeed, the other gets
+// ErrRefreshTokenUsed. RevokeSubject returns one token of every family it
+// revoked, so the service can notify its revoke listeners.
+type Store interface {
+	Save(token RefreshToken) error
+	Get(id string) (RefreshToken, error)
+	MarkUsed(id string, replacedBy string, at time.Time) error
+	RevokeFamily(familyID string) error
+	RevokeSubject(subject string) ([]RefreshToken, error)
+}
+
+type expiredToken struct {
+	id    string
+	store *MemoryStore
+}
+
+func (e *expiredToken) Execute() []any {
+	e.store.delete(e.id)
+	return nil
+}
+
+// MemoryStore keeps refresh tokens in memory and removes them through the
+// scheduler when they expire.
+type MemoryStore struct {
+	mu       sync.Mutex
+	tokens   map[string]RefreshToken
+	families map[string]map[string]struct{}
+	cleaner  *scheduler.Scheduler
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tokens:   make(map[string]RefreshToken),
+		families: make(map[string]map[string]struct{}),
+		cleaner:  scheduler.StartScheduler(),
+	}
+}
+
+func (m *MemoryStore) Save(token RefreshToken) error {
+	m.mu.Lock()
+	m.tokens[token.ID] = token
+	if m.families[token.FamilyID] == nil {
+		m.families[token.FamilyID] = make(map[string]struct{})
+	}
+	m.families[token.FamilyID][token.ID] = struct{}{}
+	m.mu.Unlock()
+
+	_, err := m.cleaner.ScheduleProgrammedOneTimeJob(token.ExpiresAt, scheduler.JobFunction(&expiredToken{id: token.ID, store: m}))
+	return err
+}
+
+func (m *MemoryStore) Get(id string) (RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, exists := m.tokens[id]
+	if !exists {
+		return RefreshToken{}, ErrRefreshTokenNotFound
+	}
+	return token, nil
+}
+
+func (m *MemoryStore) MarkUsed(id string, replacedBy string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, exists := m.tokens[id]
+	if !exists {
+		return ErrRefreshTokenNotFound
+	}
+	if !token.UsedAt.IsZero() {
+		return ErrRefreshTokenUsed
+	}
+	token.UsedAt = at
+	token.ReplacedBy = replacedBy
+	m.tokens[id] = token
+	return nil
+}
+
+func (m *MemoryStore) RevokeFamily(familyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id := range m.families[familyID] {
+		token := m.tokens[id]
+		token.Revoked = true
+		m.tokens[id] = token
+	}
+	return nil
+}
+
+func (m *MemoryStore) RevokeSubject(subject string) ([]RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var revoked map[string]RefreshToken = make(map[string]RefreshToken)
+	for id, token := range m.tokens {
+		if token.Grant.Subject != subject || token.Revoked {
+			continue
+		}
+		token.Revoked = true
+		m.tokens[id] = token
+		revoked[token.FamilyID] = token
+	}
+	var families []RefreshToken = make([]RefreshToken, 0, len(revoked))
+	for _, token := range revoked {
+		families = append(families, token)
+	}
+	return families, nil
+}
+
+func (m *MemoryStore) delete(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, exists := m.tokens[id]
+	if !exists {
+		return
+	}
+	delete(m.tokens, id)
+	delete(m.families[token.FamilyID], id)
+	if len(m.families[token.FamilyID]) == 0 {
+		delete(m.families, token.FamilyID)
+	}
+}
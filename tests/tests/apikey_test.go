@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/middlewares"
+)
+
+func TestAPIKeyAuthCarriers(t *testing.T) {
+	plain, key, err := auth.GenerateAPIKey("pk", auth.APIKeyWithSubject("billing-service"), auth.APIKeyWithRoles("billing"), auth.APIKeyWithScopes("invoices:read"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(plain, key.Prefix+"_") || len(key.Hash) == 0 {
+		t.Fatalf("unexpected key %q for prefix %q", plain, key.Prefix)
+	}
+	var store *auth.MemoryAPIKeyStore = auth.NewMemoryAPIKeyStore(key)
+	var apiKeyAuth *auth.APIKeyAuth = auth.NewAPIKeyAuth(store, auth.APIKeyAuthWithCarriers(
+		auth.APIKeyFromHeader(auth.DefaultAPIKeyHeader),
+		auth.APIKeyFromQuery("api_key"),
+		auth.APIKeyFromBasicAuth(),
+	))
+
+	fromHeader := httptest.NewRequest(http.MethodGet, "/", nil)
+	fromHeader.Header.Set(auth.DefaultAPIKeyHeader, plain)
+	fromQuery := httptest.NewRequest(http.MethodGet, "/?api_key="+plain, nil)
+	fromBasic := httptest.NewRequest(http.MethodGet, "/", nil)
+	fromBasic.SetBasicAuth("ignored", plain)
+
+	for name, request := range map[string]*http.Request{"header": fromHeader, "query": fromQuery, "basic": fromBasic} {
+		user, err := apiKeyAuth.Authenticate(request)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		apiKey := user.(*auth.APIKey)
+		if apiKey.Subject != "billing-service" || apiKey.Hash != nil {
+			t.Fatalf("%s: unexpected key %+v", name, apiKey)
+		}
+		if !apiKeyAuth.RBAC(user, []string{"billing"}) || apiKeyAuth.RBAC(user, []string{"admin"}) {
+			t.Fatalf("%s: roles must be checked by RBAC", name)
+		}
+		if apiKeyAuth.RBAC(user, []string{"invoices:read"}) || !apiKey.HasScope("invoices:read") {
+			t.Fatalf("%s: scopes must not satisfy RBAC", name)
+		}
+	}
+
+	stored, _ := store.Find(key.Prefix)
+	if stored.LastUsedAt.IsZero() {
+		t.Fatal("expected the last use to be recorded")
+	}
+
+	wrongSecret := httptest.NewRequest(http.MethodGet, "/", nil)
+	wrongSecret.Header.Set(auth.DefaultAPIKeyHeader, key.Prefix+"_wrongsecret")
+	if _, err := apiKeyAuth.Authenticate(wrongSecret); err == nil {
+		t.Fatal("expected a wrong secret to be rejected")
+	}
+}
+
+func TestAPIKeyAuthExpiry(t *testing.T) {
+	plain, key, err := auth.GenerateAPIKey("pk", auth.APIKeyWithExpiry(time.Now().Add(-time.Minute)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var apiKeyAuth *auth.APIKeyAuth = auth.NewAPIKeyAuth(auth.NewMemoryAPIKeyStore(key), auth.APIKeyAuthWithRealm("api"))
+	var handler http.HandlerFunc = middlewares.WithAuthMiddleWare(apiKeyAuth, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set(auth.DefaultAPIKeyHeader, plain)
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	if recorder.Code != http.StatusUnauthorized || recorder.Header().Get("WWW-Authenticate") != `ApiKey realm="api"` {
+		t.Fatalf("expected 401 with an ApiKey challenge, got %d %q", recorder.Code, recorder.Header().Get("WWW-Authenticate"))
+	}
+	if reason, _ := auth.TokenErrorReasonOf(mustAuthenticateError(t, apiKeyAuth, request)); reason != auth.ReasonExpired {
+		t.Fatalf("expected ReasonExpired, got %s", reason)
+	}
+}
+
+func mustAuthenticateError(t *testing.T, provider auth.PlainAuthInterface, r *http.Request) error {
+	t.Helper()
+	_, err := provider.Authenticate(r)
+	if err == nil {
+		t.Fatal("expected authentication to fail")
+	}
+	return err
+}
+
+func TestAPIKeyRateLimitOverride(t *testing.T) {
+	limitedPlain, limited, err := auth.GenerateAPIKey("pk", auth.APIKeyWithRateLimit(2, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultPlain, unlimited, err := auth.GenerateAPIKey("pk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var apiKeyAuth *auth.APIKeyAuth = auth.NewAPIKeyAuth(auth.NewMemoryAPIKeyStore(limited, unlimited))
+	var handler http.HandlerFunc = middlewares.WithAuthMiddleWare(apiKeyAuth, middlewares.WithRateLimiting(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, middlewares.RateLimitOptPerPrincipal(nil)))
+
+	serve := func(plain string) int {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set(auth.DefaultAPIKeyHeader, plain)
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		return recorder.Code
+	}
+	for i := range 2 {
+		if code := serve(limitedPlain); code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, code)
+		}
+	}
+	if code := serve(limitedPlain); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the key override to apply, got %d", code)
+	}
+	if code := serve(defaultPlain); code != http.StatusOK {
+		t.Fatalf("other keys must have their own bucket, got %d", code)
+	}
+}
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/middlewares"
+)
+
+type chainFixture struct {
+	jwtToken  string
+	apiKey    string
+	providers []auth.ChainProvider
+}
+
+func newChainFixture(t *testing.T) chainFixture {
+	t.Helper()
+	var key = newTestKey(t)
+	var claims *auth.RBACClaims = &auth.RBACClaims{Roles: []string{"user"}, RegisteredClaims: registeredClaims("user-1")}
+	plain, apiKey, err := auth.GenerateAPIKey("pk", auth.APIKeyWithSubject("reporting"), auth.APIKeyWithRoles("reporter"), auth.APIKeyWithScopes("reports:read"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chainFixture{
+		jwtToken: signTestToken(t, key, claims),
+		apiKey:   plain,
+		providers: []auth.ChainProvider{
+			auth.ChainWith("jwt", auth.NewJwtAuthWithRbac(key, auth.NewRBACClaims)),
+			auth.ChainWith("apikey", auth.NewAPIKeyAuth(auth.NewMemoryAPIKeyStore(apiKey))),
+		},
+	}
+}
+
+func (f chainFixture) request(withJWT bool, withAPIKey bool) *http.Request {
+	var request *http.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	if withJWT {
+		request.Header.Set("Authorization", "Bearer "+f.jwtToken)
+	}
+	if withAPIKey {
+		request.Header.Set(auth.DefaultAPIKeyHeader, f.apiKey)
+	}
+	return request
+}
+
+func TestChainAuthFirstSuccess(t *testing.T) {
+	var fixture chainFixture = newChainFixture(t)
+	var chain *auth.ChainAuth = auth.NewChainAuth(auth.ChainFirstSuccess, fixture.providers)
+	var handler http.HandlerFunc = middlewares.WithAuthMiddleWare(chain, func(w http.ResponseWriter, r *http.Request) {
+		result, _ := chain.Result(r.Context())
+		w.Write([]byte(result.Provider))
+	})
+
+	for name, request := range map[string]*http.Request{
+		"jwt":    fixture.request(true, false),
+		"apikey": fixture.request(false, true),
+	} {
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		if recorder.Code != http.StatusOK || recorder.Body.String() != name {
+			t.Fatalf("expected %s to authenticate, got %d %q", name, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	var invalidJWT *http.Request = fixture.request(false, true)
+	invalidJWT.Header.Set("Authorization", "Bearer invalid")
+	recorder := httptest.NewRecorder()
+	handler(recorder, invalidJWT)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "apikey" {
+		t.Fatalf("expected the api key to authenticate after an invalid jwt, got %d %q", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	handler(recorder, fixture.request(false, false))
+	if recorder.Code != http.StatusUnauthorized || recorder.Header().Get("WWW-Authenticate") != "Bearer, ApiKey" {
+		t.Fatalf("expected 401 with every challenge, got %d %q", recorder.Code, recorder.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestChainAuthAllMustPassUnionOfRoles(t *testing.T) {
+	var fixture chainFixture = newChainFixture(t)
+	var chain *auth.ChainAuth = auth.NewChainAuth(auth.ChainAllMustPass, fixture.providers)
+	var handler http.HandlerFunc = middlewares.WithAuthAndRBAC(chain, []string{"reporter"}, func(w http.ResponseWriter, r *http.Request) {
+		result, _ := chain.Result(r.Context())
+		if len(result.Providers) != 2 {
+			t.Errorf("expected both providers recorded, got %v", result.Providers)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, fixture.request(true, false))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 when a provider fails, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler(recorder, fixture.request(true, true))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected the api key role to satisfy RBAC, got %d", recorder.Code)
+	}
+
+	user, err := chain.Authenticate(fixture.request(true, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !chain.RBAC(user, []string{"user"}) || !chain.RBAC(user, []string{"reporter"}) || chain.RBAC(user, []string{"admin"}) {
+		t.Fatalf("expected the union of roles, got %v", user.(*auth.ChainResult).Roles)
+	}
+	if chain.RBAC(user, []string{"reports:read"}) {
+		t.Fatal("expected the api key scope not to satisfy RBAC")
+	}
+
+	var scoped http.HandlerFunc = middlewares.WithAuthAndRBAC(chain, []string{"reports:read"}, func(w http.ResponseWriter, r *http.Request) {})
+	recorder = httptest.NewRecorder()
+	scoped(recorder, fixture.request(true, true))
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected a route requiring the scope as a role to be forbidden, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,180 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/authz"
+	"github.com/angelbarreiros/Penguin/router/middlewares"
+)
+
+type orderClaims struct {
+	auth.RBACClaims
+	Tenant string `json:"tenant"`
+}
+
+type order struct {
+	ID     int    `json:"id"`
+	Owner  string `json:"owner"`
+	Tenant string `json:"tenant"`
+	Locked bool   `json:"locked"`
+}
+
+var orderRules []authz.Rule = []authz.Rule{
+	{Name: "owner", Effect: authz.EffectAllow, Actions: []string{"orders:read", "orders:edit"}, When: "resource.owner == subject.sub"},
+	{Name: "manager", Effect: authz.EffectAllow, Actions: []string{"orders:*"}, When: `"manager" in subject.roles && subject.tenant == resource.tenant`},
+	{Name: "locked", Effect: authz.EffectDeny, Actions: []string{"orders:edit"}, When: "resource.locked && !(subject.sub in ['root'])"},
+	{Name: "route", Effect: authz.EffectDeny, When: "request.method == 'DELETE' && resource.id != request.params.id"},
+}
+
+func userContext(subject string, tenant string, roles ...string) context.Context {
+	var claims *orderClaims = &orderClaims{RBACClaims: auth.RBACClaims{Roles: roles, RegisteredClaims: registeredClaims(subject)}, Tenant: tenant}
+	return auth.ContextWithUser(context.Background(), claims)
+}
+
+func TestAuthzDenyOverrides(t *testing.T) {
+	var decisions []authz.Decision
+	engine, err := authz.NewEngine(authz.EngineWithRules(orderRules), authz.EngineWithDecisionLogger(func(_ context.Context, decision authz.Decision) {
+		decisions = append(decisions, decision)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var own order = order{ID: 1, Owner: "alice", Tenant: "acme"}
+	var locked order = order{ID: 2, Owner: "alice", Tenant: "acme", Locked: true}
+
+	for _, check := range []struct {
+		ctx      context.Context
+		action   string
+		resource order
+		allowed  bool
+		rule     string
+	}{
+		{userContext("alice", "acme"), "orders:edit", own, true, "owner"},
+		{userContext("bob", "acme"), "orders:edit", own, false, ""},
+		{userContext("bob", "acme", "manager"), "orders:refund", own, true, "manager"},
+		{userContext("bob", "globex", "manager"), "orders:refund", own, false, ""},
+		{userContext("alice", "acme"), "orders:edit", locked, false, "locked"},
+		{userContext("alice", "acme"), "orders:read", locked, true, "owner"},
+		{userContext("root", "acme", "manager"), "orders:edit", locked, true, "manager"},
+	} {
+		var decision authz.Decision = engine.Decide(check.ctx, check.action, check.resource)
+		if decision.Allowed != check.allowed || decision.Rule != check.rule {
+			t.Errorf("%s on %+v: expected %v by %q, got %+v", check.action, check.resource, check.allowed, check.rule, decision)
+		}
+	}
+	if len(decisions) != 7 || decisions[4].Subject != "alice" || decisions[4].Effect != authz.EffectDeny {
+		t.Fatalf("expected every decision logged, got %+v", decisions)
+	}
+
+	if err := engine.Check(userContext("bob", "acme"), "orders:edit", own); !errors.Is(err, authz.ErrDenied) {
+		t.Fatalf("expected ErrDenied, got %v", err)
+	}
+
+	for _, when := range []string{"resource.owner ==", "owner == 'x'", "resource.id == (1", "'unterminated"} {
+		if _, err := authz.NewEngine(authz.EngineWithRules([]authz.Rule{{Effect: authz.EffectAllow, When: when}})); err == nil {
+			t.Errorf("expected %q to be rejected", when)
+		}
+	}
+}
+
+func TestAuthzChainUserSubject(t *testing.T) {
+	engine, err := authz.NewEngine(authz.EngineWithRules(orderRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims *orderClaims = &orderClaims{RBACClaims: auth.RBACClaims{RegisteredClaims: registeredClaims("bob")}, Tenant: "acme"}
+	var ctx context.Context = auth.ContextWithUser(context.Background(), &auth.ChainResult{
+		Provider: "jwt", User: claims, Providers: []string{"jwt"}, Users: map[string]any{"jwt": claims}, Roles: []string{"manager"},
+	})
+	if decision := engine.Decide(ctx, "orders:refund", order{ID: 1, Owner: "alice", Tenant: "acme"}); !decision.Allowed || decision.Rule != "manager" || decision.Subject != "bob" {
+		t.Errorf("expected the tenant and roles of the chain user, got %+v", decision)
+	}
+	if decision := engine.Decide(ctx, "orders:edit", order{ID: 2, Owner: "bob", Tenant: "globex"}); !decision.Allowed || decision.Rule != "owner" {
+		t.Errorf("expected subject.sub of the chain user, got %+v", decision)
+	}
+}
+
+func TestWithAuthorization(t *testing.T) {
+	engine, err := authz.NewEngine(authz.EngineWithRules(orderRules), authz.EngineWithDecisionLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var orders map[string]order = map[string]order{"1": {ID: 1, Owner: "alice", Tenant: "acme"}}
+	var resolver authz.ResourceResolver = func(r *http.Request) (any, error) {
+		found, ok := orders[r.PathValue("id")]
+		if !ok {
+			return nil, authz.ErrResourceNotFound
+		}
+		return found, nil
+	}
+	var key = newTestKey(t)
+	var jwtAuth *auth.JwtAuth = auth.NewJwtAuth(key, func() *orderClaims { return &orderClaims{} })
+
+	var mux *http.ServeMux = http.NewServeMux()
+	mux.HandleFunc("/orders/{id}", middlewares.WithAuthMiddleWare(jwtAuth,
+		middlewares.WithAuthorization(engine, "orders:edit", resolver, func(w http.ResponseWriter, r *http.Request) {
+			if err := authz.Check(r.Context(), "orders:refund", authz.Resource(r.Context())); err == nil {
+				w.Write([]byte("refundable"))
+			}
+		})))
+
+	serve := func(method string, path string, subject string, roles ...string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, nil)
+		if subject != "" {
+			var claims *orderClaims = &orderClaims{RBACClaims: auth.RBACClaims{Roles: roles, RegisteredClaims: registeredClaims(subject)}, Tenant: "acme"}
+			request.Header.Set("Authorization", "Bearer "+signTestToken(t, key, claims))
+		}
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	if recorder := serve(http.MethodPut, "/orders/1", "alice"); recorder.Code != http.StatusOK || recorder.Body.String() != "" {
+		t.Fatalf("expected the owner to edit without refund, got %d %q", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serve(http.MethodPut, "/orders/1", "bob", "manager"); recorder.Body.String() != "refundable" {
+		t.Fatalf("expected the manager to refund, got %d %q", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serve(http.MethodPut, "/orders/1", "bob"); recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", recorder.Code)
+	}
+	if recorder := serve(http.MethodPut, "/orders/2", "alice"); recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", recorder.Code)
+	}
+
+	orders["01"] = orders["1"]
+	if recorder := serve(http.MethodDelete, "/orders/01", "alice"); recorder.Code != http.StatusOK {
+		t.Fatalf("expected numeric path values to match, got %d", recorder.Code)
+	}
+	orders["3"] = orders["1"]
+	if recorder := serve(http.MethodDelete, "/orders/3", "alice"); recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected the path value rule to deny, got %d", recorder.Code)
+	}
+
+	var resolved int
+	var counting authz.ResourceResolver = func(r *http.Request) (any, error) {
+		resolved++
+		return resolver(r)
+	}
+	for _, path := range []string{"/orders/1", "/orders/404"} {
+		recorder := httptest.NewRecorder()
+		middlewares.WithAuthorization(engine, "orders:edit", counting, func(w http.ResponseWriter, r *http.Request) {})(recorder, httptest.NewRequest(http.MethodPut, path, nil))
+		if recorder.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401 without a user, got %d", path, recorder.Code)
+		}
+	}
+	if resolved != 0 {
+		t.Fatalf("expected no resource to be loaded without a user, got %d", resolved)
+	}
+
+	recorder := httptest.NewRecorder()
+	middlewares.WithAuthorization(nil, "orders:edit", nil, func(w http.ResponseWriter, r *http.Request) {})(recorder, httptest.NewRequest(http.MethodPut, "/orders/1", nil))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("expected a nil engine to deny the request, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/middlewares"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func protectedHandler(provider auth.PlainAuthInterface) http.HandlerFunc {
+	return middlewares.WithAuthMiddleWare(provider, func(w http.ResponseWriter, r *http.Request) {
+		user, _ := auth.ClaimsFrom[*auth.PasswordUser](r.Context())
+		w.Write([]byte(user.Username))
+	})
+}
+
+func TestBasicAuthHtpasswd(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("bcrypt-secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var salt []byte = []byte("0123456789abcdef")
+	var argonHash string = fmt.Sprintf("$argon2id$v=19$m=%d,t=%d,p=%d$%s$%s", 8*1024, 1, 1,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte("argon-secret"), salt, 1, 8*1024, 1, 32)))
+
+	var path string = filepath.Join(t.TempDir(), ".htpasswd")
+	var content string = "# users\nalice:" + string(bcryptHash) + "\nbob:" + argonHash + "\nlegacy:$apr1$abc$def\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := auth.LoadHtpasswdFile(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var handler http.HandlerFunc = protectedHandler(auth.NewBasicAuth(store, auth.BasicAuthWithRealm("tools")))
+
+	serve := func(username, password string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		if username != "" {
+			request.SetBasicAuth(username, password)
+		}
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		return recorder
+	}
+
+	for username, password := range map[string]string{"alice": "bcrypt-secret", "bob": "argon-secret"} {
+		recorder := serve(username, password)
+		if recorder.Code != http.StatusOK || recorder.Body.String() != username {
+			t.Fatalf("%s: expected 200, got %d %s", username, recorder.Code, recorder.Body.String())
+		}
+	}
+	for _, credentials := range [][2]string{{"alice", "wrong"}, {"legacy", "anything"}, {"nobody", "secret"}, {"", ""}} {
+		recorder := serve(credentials[0], credentials[1])
+		if recorder.Code != http.StatusUnauthorized {
+			t.Fatalf("%v: expected 401, got %d", credentials, recorder.Code)
+		}
+		if recorder.Header().Get("WWW-Authenticate") != `Basic realm="tools", charset="UTF-8"` {
+			t.Fatalf("unexpected challenge %q", recorder.Header().Get("WWW-Authenticate"))
+		}
+	}
+
+	type customKey struct{}
+	handler = middlewares.WithAuthMiddleWare(auth.NewBasicAuth(store, auth.BasicAuthWithCustomContextKey(customKey{})), func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Value("user") != nil {
+			t.Error("expected no user under the \"user\" string key")
+		}
+		user, _ := r.Context().Value(customKey{}).(*auth.PasswordUser)
+		w.Write([]byte(user.Username))
+	})
+	if recorder := serve("alice", "bcrypt-secret"); recorder.Code != http.StatusOK || recorder.Body.String() != "alice" {
+		t.Fatalf("expected the user under the custom key, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+var digestParam = regexp.MustCompile(`(\w+)="?([^",]+)"?`)
+
+func digestResponse(challenge string, username, password, method, uri, nc string) string {
+	var params map[string]string = make(map[string]string)
+	for _, match := range digestParam.FindAllStringSubmatch(strings.TrimPrefix(challenge, "Digest "), -1) {
+		params[match[1]] = match[2]
+	}
+	h := func(value string) string {
+		sum := sha256.Sum256([]byte(value))
+		return hex.EncodeToString(sum[:])
+	}
+	var ha1 string = h(username + ":" + params["realm"] + ":" + password)
+	var ha2 string = h(method + ":" + uri)
+	var cnonce string = "0a4f113b"
+	var response string = h(strings.Join([]string{ha1, params["nonce"], nc, cnonce, "auth", ha2}, ":"))
+	return fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=SHA-256, qop=auth, nc=%s, cnonce="%s", response="%s", opaque="%s"`,
+		username, params["realm"], params["nonce"], uri, nc, cnonce, response, params["opaque"])
+}
+
+func TestDigestAuth(t *testing.T) {
+	var digestAuth *auth.DigestAuth = auth.NewDigestAuth(auth.NewDigestPasswordStore(map[string]string{"Mufasa": "Circle of Life"}),
+		auth.DigestAuthWithRealm("http-auth@example.org"))
+	var handler http.HandlerFunc = protectedHandler(digestAuth)
+
+	serve := func(authorization string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/dir/index.html?x=1", nil)
+		if authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		return recorder
+	}
+
+	var challenge string = serve("").Header().Get("WWW-Authenticate")
+	if !strings.HasPrefix(challenge, `Digest realm="http-auth@example.org", qop="auth", algorithm=SHA-256, nonce="`) {
+		t.Fatalf("unexpected challenge %q", challenge)
+	}
+
+	var authorization string = digestResponse(challenge, "Mufasa", "Circle of Life", http.MethodGet, "/dir/index.html?x=1", "00000001")
+	if recorder := serve(authorization); recorder.Code != http.StatusOK || recorder.Body.String() != "Mufasa" {
+		t.Fatalf("expected 200, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serve(authorization); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a replayed nonce count to be rejected, got %d", recorder.Code)
+	}
+	if recorder := serve(digestResponse(challenge, "Mufasa", "Circle of Life", http.MethodGet, "/dir/index.html?x=1", "00000002")); recorder.Code != http.StatusOK {
+		t.Fatalf("expected the next nonce count to be accepted, got %d", recorder.Code)
+	}
+	if recorder := serve(digestResponse(challenge, "Mufasa", "wrong", http.MethodGet, "/dir/index.html?x=1", "00000003")); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a wrong password to be rejected, got %d", recorder.Code)
+	}
+
+	var forgedNonce string = strings.Replace(challenge, `nonce="`, `nonce="forged`, 1)
+	recorder := serve(digestResponse(forgedNonce, "Mufasa", "Circle of Life", http.MethodGet, "/dir/index.html?x=1", "00000001"))
+	if recorder.Code != http.StatusUnauthorized || strings.HasSuffix(recorder.Header().Get("WWW-Authenticate"), "stale=true") {
+		t.Fatalf("expected a forged nonce to be rejected, got %d %q", recorder.Code, recorder.Header().Get("WWW-Authenticate"))
+	}
+
+	var expiring *auth.DigestAuth = auth.NewDigestAuth(auth.NewDigestPasswordStore(map[string]string{"Mufasa": "Circle of Life"}),
+		auth.DigestAuthWithRealm("http-auth@example.org"), auth.DigestAuthWithNonceTTL(time.Nanosecond))
+	handler = protectedHandler(expiring)
+	recorder = serve(digestResponse(serve("").Header().Get("WWW-Authenticate"), "Mufasa", "Circle of Life", http.MethodGet, "/dir/index.html?x=1", "00000001"))
+	if recorder.Code != http.StatusUnauthorized || !strings.HasSuffix(recorder.Header().Get("WWW-Authenticate"), "stale=true") {
+		t.Fatalf("expected a stale challenge, got %d %q", recorder.Code, recorder.Header().Get("WWW-Authenticate"))
+	}
+}
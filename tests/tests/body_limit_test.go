@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/angelbarreiros/Penguin/router"
+	"github.com/angelbarreiros/Penguin/router/middlewares"
+)
+
+func TestBodyLimitWithRouteConsumes(t *testing.T) {
+	var compositions int
+	var app *router.Router = router.NewRouter()
+	app.Use(middlewares.BodyLimitMiddleware(middlewares.BodyLimitOptMaxBytes(64)))
+	app.Use(func(hf http.HandlerFunc) http.HandlerFunc {
+		compositions++
+		return hf
+	})
+	app.NewRoute(router.Route{Path: "/orders", Method: router.POST, Consumes: []string{"application/json"}, Handler: func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}})
+
+	for name, test := range map[string]struct {
+		contentType string
+		body        io.Reader
+		status      int
+	}{
+		"accepted":           {"application/json; charset=utf-8", strings.NewReader(`{"id":1}`), http.StatusCreated},
+		"unsupported type":   {"text/plain", strings.NewReader("id=1"), http.StatusUnsupportedMediaType},
+		"declared too large": {"application/json", strings.NewReader(strings.Repeat("a", 65)), http.StatusRequestEntityTooLarge},
+		"streamed too large": {"application/json", io.MultiReader(strings.NewReader(strings.Repeat("a", 65))), http.StatusRequestEntityTooLarge},
+		"missing media type": {"", strings.NewReader(`{}`), http.StatusUnsupportedMediaType},
+	} {
+		request := httptest.NewRequest(http.MethodPost, "/orders", test.body)
+		request.Header.Set("Content-Type", test.contentType)
+		recorder := httptest.NewRecorder()
+		app.ServeHTTP(recorder, request)
+		if recorder.Code != test.status {
+			t.Errorf("%s: expected %d, got %d", name, test.status, recorder.Code)
+		}
+	}
+	if compositions != 1 {
+		t.Fatalf("expected the middleware chain to be composed once, got %d", compositions)
+	}
+}
+
+func TestBodyLimitRejectsGzipBombs(t *testing.T) {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(make([]byte, 1<<20))
+	writer.Close()
+
+	var handler http.HandlerFunc = middlewares.WithBodyLimit(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+	}, middlewares.BodyLimitOptMaxBytes(16<<10), middlewares.BodyLimitOptMaxDecompressionRatio(10))
+	request := httptest.NewRequest(http.MethodPost, "/upload", bytes.NewReader(compressed.Bytes()))
+	request.Header.Set("Content-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected a decompression bomb to be rejected, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/client"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func statusResponse(status int, header http.Header) *http.Response {
+	var recorder *httptest.ResponseRecorder = httptest.NewRecorder()
+	for name, values := range header {
+		recorder.Header()[name] = values
+	}
+	recorder.WriteHeader(status)
+	return recorder.Result()
+}
+
+func TestCircuitBreakerIgnoresResultsAdmittedBeforeATransition(t *testing.T) {
+	var admitted, slow chan struct{} = make(chan struct{}), make(chan struct{})
+	var base roundTripperFunc = func(r *http.Request) (*http.Response, error) {
+		switch r.URL.Path {
+		case "/slow":
+			close(admitted)
+			<-slow
+			return statusResponse(http.StatusOK, nil), nil
+		case "/fail":
+			return statusResponse(http.StatusInternalServerError, nil), nil
+		}
+		return statusResponse(http.StatusOK, nil), nil
+	}
+	var transport *client.Transport = client.NewTransport(
+		client.TransportWithBase(base),
+		client.TransportWithRetry(client.RetryConfig{}),
+		client.TransportWithCircuitBreaker(client.BreakerConfig{Window: time.Minute, Buckets: 1, MinRequests: 2, ErrorRateThreshold: 0.5, OpenTimeout: 20 * time.Millisecond}))
+	var httpClient *http.Client = &http.Client{Transport: transport}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if resp, err := httpClient.Get("http://orders/slow"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-admitted
+	for range 2 {
+		resp, err := httpClient.Get("http://orders/fail")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if state := transport.BreakerState("orders"); state != client.StateOpen {
+		t.Fatalf("expected the breaker to open, got %s", state)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if state := transport.BreakerState("orders"); state != client.StateHalfOpen {
+		t.Fatalf("expected the breaker to be half-open, got %s", state)
+	}
+
+	close(slow)
+	wg.Wait()
+	if state := transport.BreakerState("orders"); state != client.StateHalfOpen {
+		t.Fatalf("expected a request admitted while closed not to close the breaker, got %s", state)
+	}
+	resp, err := httpClient.Get("http://orders/probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if state := transport.BreakerState("orders"); state != client.StateClosed {
+		t.Fatalf("expected a successful probe to close the breaker, got %s", state)
+	}
+}
+
+func TestBulkheadIsReleasedBetweenRetries(t *testing.T) {
+	var retrying chan struct{} = make(chan struct{})
+	var once sync.Once
+	var base roundTripperFunc = func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path == "/flaky" {
+			once.Do(func() { close(retrying) })
+			return statusResponse(http.StatusServiceUnavailable, http.Header{"Retry-After": {"1"}}), nil
+		}
+		return statusResponse(http.StatusOK, nil), nil
+	}
+	var httpClient *http.Client = &http.Client{Transport: client.NewTransport(
+		client.TransportWithBase(base),
+		client.TransportWithRetry(client.RetryConfig{MaxRetries: 1, MaxDelay: 300 * time.Millisecond, RetryableStatus: []int{http.StatusServiceUnavailable}}),
+		client.TransportWithBulkhead(1, 100*time.Millisecond))}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if resp, err := httpClient.Get("http://orders/flaky"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-retrying
+	resp, err := httpClient.Get("http://orders/healthy")
+	if err != nil {
+		t.Fatalf("expected the slot to be free during the backoff, got %v", err)
+	}
+	resp.Body.Close()
+	wg.Wait()
+}
@@ -0,0 +1,172 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/angelbarreiros/Penguin/router"
+	"github.com/angelbarreiros/Penguin/router/cors"
+	"github.com/angelbarreiros/Penguin/router/middlewares"
+)
+
+func TestCORSConfigRejectsInvalidOrigins(t *testing.T) {
+	for name, options := range map[string][]func(*cors.CORSConfig){
+		"wildcard with credentials": {cors.WithAllowedOrigins([]string{"*"}), cors.WithAllowCredentials(true)},
+		"inner wildcard":            {cors.WithAllowedOrigins([]string{"https://api.*.example.com"})},
+		"wildcard without scheme":   {cors.WithAllowedOrigins([]string{"*.example.com"})},
+		"invalid pattern":           {cors.WithAllowedOriginPatterns([]string{"https://(foo"})},
+	} {
+		if _, err := cors.NewCORSConfig(options...); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestCORSOriginMatching(t *testing.T) {
+	config, err := cors.NewCORSConfig(
+		cors.WithAllowedOrigins([]string{"https://app.example.org", "https://*.example.com", "http://*.local.test:8080"}),
+		cors.WithAllowedOriginPatterns([]string{`https://pr-\d+\.preview\.example\.net`}),
+		cors.WithOriginValidator(func(origin string, r *http.Request) bool {
+			return origin == "https://"+r.Header.Get("X-Tenant")+".customer.io"
+		}),
+		cors.WithAllowCredentials(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var handler http.HandlerFunc = middlewares.WithCors(config, func(w http.ResponseWriter, r *http.Request) {})
+
+	for origin, allowed := range map[string]bool{
+		"https://app.example.org":            true,
+		"https://a.b.example.com":            true,
+		"https://example.com":                false,
+		"http://api.example.com":             false,
+		"https://evilexample.com":            false,
+		"http://dev.local.test:8080":         true,
+		"http://dev.local.test":              false,
+		"https://pr-42.preview.example.net":  true,
+		"https://pr-42.preview.example.net.": false,
+		"https://acme.customer.io":           true,
+		"https://other.customer.io":          false,
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Origin", origin)
+		request.Header.Set("X-Tenant", "acme")
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		if (recorder.Code == http.StatusOK) != allowed {
+			t.Errorf("%s: expected allowed=%v, got %d", origin, allowed, recorder.Code)
+		}
+		if recorder.Header().Get("Vary") != "Origin" {
+			t.Errorf("%s: expected Vary: Origin, got %q", origin, recorder.Header().Get("Vary"))
+		}
+	}
+}
+
+func preflightRequest(path string, origin string, method string, headers string) *http.Request {
+	request := httptest.NewRequest(http.MethodOptions, path, nil)
+	request.Header.Set("Origin", origin)
+	request.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		request.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	return request
+}
+
+func TestCORSPreflightValidation(t *testing.T) {
+	config, err := cors.NewCORSConfig(
+		cors.WithAllowedOrigins([]string{"https://app.example.com"}),
+		cors.WithAllowedMethods([]string{http.MethodGet, http.MethodPut}),
+		cors.WithAllowedHeaders([]string{"Content-Type", "X-Request-ID"}),
+		cors.WithAllowPrivateNetwork(true),
+		cors.WithMaxAge(600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var handler http.HandlerFunc = middlewares.WithCors(config, func(w http.ResponseWriter, r *http.Request) {})
+
+	for name, test := range map[string]struct {
+		request *http.Request
+		status  int
+	}{
+		"allowed":            {preflightRequest("/", "https://app.example.com", http.MethodPut, "content-type, x-request-id"), http.StatusNoContent},
+		"origin not allowed": {preflightRequest("/", "https://evil.example.com", http.MethodPut, ""), http.StatusForbidden},
+		"method not allowed": {preflightRequest("/", "https://app.example.com", http.MethodDelete, ""), http.StatusForbidden},
+		"header not allowed": {preflightRequest("/", "https://app.example.com", http.MethodGet, "Authorization"), http.StatusForbidden},
+	} {
+		recorder := httptest.NewRecorder()
+		handler(recorder, test.request)
+		if recorder.Code != test.status {
+			t.Errorf("%s: expected %d, got %d", name, test.status, recorder.Code)
+		}
+		if test.status == http.StatusForbidden && recorder.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("%s: expected no CORS headers on a rejected preflight", name)
+		}
+	}
+
+	request := preflightRequest("/", "https://app.example.com", http.MethodGet, "")
+	request.Header.Set("Access-Control-Request-Private-Network", "true")
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	var header http.Header = recorder.Header()
+	if recorder.Code != http.StatusNoContent || header.Get("Access-Control-Allow-Private-Network") != "true" ||
+		header.Get("Access-Control-Allow-Methods") != "GET, PUT" || header.Get("Access-Control-Max-Age") != "600" {
+		t.Fatalf("unexpected private network preflight %d %v", recorder.Code, header)
+	}
+}
+
+func TestRouterCORSPolicies(t *testing.T) {
+	groupPolicy, _ := cors.NewCORSConfig(cors.WithAllowedOrigins([]string{"https://app.example.com"}), cors.WithAllowedHeaders([]string{"Content-Type"}))
+	routePolicy, _ := cors.NewCORSConfig(cors.WithAllowedOrigins([]string{"*"}))
+	var ok http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {}
+
+	var r *router.Router = router.NewRouter()
+	var api *router.Group = r.Group("/api")
+	api.UseCORS(groupPolicy)
+	api.Group("/v1").NewRoute(router.Route{Path: "/orders", Method: router.GET, Handler: ok, AdditionalMethods: []router.HTTPMethod{router.POST}})
+	api.NewRoute(router.Route{Path: "/status", Method: router.GET, Handler: ok, CORS: routePolicy})
+	r.NewRoute(router.Route{Path: "/health", Method: router.GET, Handler: ok})
+
+	for name, test := range map[string]struct {
+		request *http.Request
+		status  int
+		methods string
+	}{
+		"inherited group policy": {preflightRequest("/api/v1/orders", "https://app.example.com", http.MethodPost, "Content-Type"), http.StatusNoContent, "GET, POST"},
+		"method of no route":     {preflightRequest("/api/v1/orders", "https://app.example.com", http.MethodPut, ""), http.StatusNoContent, ""},
+		"group origin rejected":  {preflightRequest("/api/v1/orders", "https://other.example.com", http.MethodGet, ""), http.StatusForbidden, ""},
+		"route policy":           {preflightRequest("/api/status", "https://other.example.com", http.MethodGet, ""), http.StatusNoContent, "GET"},
+		"no policy":              {preflightRequest("/health", "https://app.example.com", http.MethodGet, ""), http.StatusNoContent, ""},
+	} {
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, test.request)
+		if recorder.Code != test.status || recorder.Header().Get("Access-Control-Allow-Methods") != test.methods {
+			t.Errorf("%s: expected %d %q, got %d %q", name, test.status, test.methods, recorder.Code, recorder.Header().Get("Access-Control-Allow-Methods"))
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodOptions, "/health", nil))
+	if recorder.Code != http.StatusNoContent || recorder.Header().Get("Allow") != "GET, OPTIONS" {
+		t.Fatalf("expected an automatic OPTIONS response, got %d %q", recorder.Code, recorder.Header().Get("Allow"))
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/api/v1/orders", nil)
+	request.Header.Set("Origin", "https://other.example.com")
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected a disallowed origin to be rejected, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, preflightRequest("/api/v1/orders", "https://other.example.com", http.MethodGet, ""))
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil || !strings.HasPrefix(body.Error, "CORS preflight rejected") {
+		t.Fatalf("expected a JSON error for a rejected preflight, got %q (%v)", body.Error, err)
+	}
+}
@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/router/credentials"
+)
+
+var testArgon2Params credentials.Argon2Params = credentials.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHasherRehashesOutdatedHashes(t *testing.T) {
+	bcryptHasher, err := credentials.NewHasher(credentials.HasherWithAlgorithm(credentials.Bcrypt), credentials.HasherWithBcryptCost(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := bcryptHasher.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hasher, err := credentials.NewHasher(credentials.HasherWithArgon2Params(testArgon2Params))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, newHash, err := hasher.VerifyAndRehash(legacy, "wrong password"); ok || newHash != "" || err != nil {
+		t.Fatalf("expected a wrong password to be rejected without a rehash, got %v %q %v", ok, newHash, err)
+	}
+	ok, upgraded, err := hasher.VerifyAndRehash(legacy, "correct horse battery staple")
+	if !ok || err != nil || !strings.HasPrefix(upgraded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("expected the bcrypt hash to be upgraded, got %v %q %v", ok, upgraded, err)
+	}
+	if ok, newHash, err := hasher.VerifyAndRehash(upgraded, "correct horse battery staple"); !ok || newHash != "" || err != nil {
+		t.Fatalf("expected a current hash to be kept, got %v %q %v", ok, newHash, err)
+	}
+
+	stronger, _ := credentials.NewHasher(credentials.HasherWithArgon2Params(credentials.Argon2Params{Memory: 2048, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}))
+	if !stronger.NeedsRehash(upgraded) {
+		t.Fatal("expected new argon2 parameters to require a rehash")
+	}
+}
+
+func TestPasswordPolicyWithBreachedPasswords(t *testing.T) {
+	var directory string = t.TempDir()
+	var sum [sha1.Size]byte = sha1.Sum([]byte("Password123!"))
+	var hash string = strings.ToUpper(hex.EncodeToString(sum[:]))
+	if err := os.WriteFile(filepath.Join(directory, hash[:5]+".txt"), []byte("0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n"+hash[5:]+":1200\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var directoryChecker *credentials.BreachChecker = credentials.NewBreachChecker(credentials.RangeDirectory(directory))
+	if count, err := directoryChecker.Count("Password123!"); err != nil || count != 1200 {
+		t.Fatalf("expected 1200 breaches, got %d %v", count, err)
+	}
+	if count, err := directoryChecker.Count("Correct-Horse-42-Battery"); err != nil || count != 0 {
+		t.Fatalf("expected no breaches, got %d %v", count, err)
+	}
+
+	var full []string
+	for _, password := range []string{"Password123!", "Tr0ub4dor&3xyz"} {
+		var sum [sha1.Size]byte = sha1.Sum([]byte(password))
+		full = append(full, strings.ToUpper(hex.EncodeToString(sum[:])))
+	}
+	if full[0] > full[1] {
+		full[0], full[1] = full[1], full[0]
+	}
+	var path string = filepath.Join(t.TempDir(), "pwned.txt")
+	if err := os.WriteFile(path, []byte(full[0]+":1200\n"+full[1]+":1200\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rangeFile, err := credentials.OpenRangeFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rangeFile.Close()
+
+	var policy *credentials.Policy = credentials.NewPolicy(
+		credentials.PolicyWithCharacterClasses(true, true, true, false),
+		credentials.PolicyWithBreachChecker(credentials.NewBreachChecker(rangeFile), 0))
+
+	var policyErr *credentials.PolicyError
+	if err := policy.Validate("Password123!"); !errors.As(err, &policyErr) || policyErr.Violations[credentials.KeyBreached][0] != 1200 {
+		t.Fatalf("expected a breached password to be rejected, got %v", err)
+	}
+	err = policy.Validate("alicepassword", "alice")
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("expected violations, got %v", err)
+	}
+	for _, key := range []string{credentials.KeyMissingUppercase, credentials.KeyMissingDigit, credentials.KeyContainsUserInput} {
+		if _, found := policyErr.Violations[key]; !found {
+			t.Fatalf("expected %s in %v", key, policyErr.Violations)
+		}
+	}
+	if policyErr.Violations[credentials.KeyTooShort] != nil {
+		t.Fatalf("unexpected length violation %v", policyErr.Violations)
+	}
+	if err := policy.Validate("Correct-Horse-42-Battery"); err != nil {
+		t.Fatalf("expected a strong password to pass, got %v", err)
+	}
+}
+
+func TestTOTPAndRecoveryCodes(t *testing.T) {
+	totp, err := credentials.NewTOTP("Penguin", credentials.TOTPWithDigits(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RFC 6238 test vectors for SHA1.
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	for unix, expected := range map[int64]string{59: "94287082", 1111111109: "07081804", 1234567890: "89005924", 2000000000: "69279037"} {
+		if code, err := totp.Code(secret, time.Unix(unix, 0)); err != nil || code != expected {
+			t.Fatalf("at %d expected %s, got %s %v", unix, expected, code, err)
+		}
+	}
+
+	totp, _ = credentials.NewTOTP("Penguin")
+	enrollment, err := totp.Enroll("alice@example.com", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enrollment.URL, "otpauth://totp/Penguin:alice@example.com?") || !strings.Contains(enrollment.URL, "secret="+enrollment.Secret) {
+		t.Fatalf("unexpected key uri %q", enrollment.URL)
+	}
+	var now time.Time = time.Now()
+	code, _ := totp.Code(enrollment.Secret, now.Add(-30*time.Second))
+	step, err := totp.Verify(enrollment.Secret, code, now, 0)
+	if err != nil {
+		t.Fatalf("expected a code of the previous period to be accepted, got %v", err)
+	}
+	if _, err := totp.Verify(enrollment.Secret, code, now, step); !errors.Is(err, credentials.ErrCodeAlreadyUsed) {
+		t.Fatalf("expected a replayed code to be rejected, got %v", err)
+	}
+	code, _ = totp.Code(enrollment.Secret, now.Add(-2*time.Minute))
+	if _, err := totp.Verify(enrollment.Secret, code, now, 0); !errors.Is(err, credentials.ErrInvalidCode) {
+		t.Fatalf("expected an old code to be rejected, got %v", err)
+	}
+
+	remaining, ok := credentials.UseRecoveryCode(enrollment.RecoveryHashes, strings.ToUpper(enrollment.RecoveryCodes[3]))
+	if !ok || len(remaining) != 9 {
+		t.Fatalf("expected the recovery code to be consumed, got %v %d", ok, len(remaining))
+	}
+	if _, ok := credentials.UseRecoveryCode(remaining, enrollment.RecoveryCodes[3]); ok {
+		t.Fatal("expected a recovery code to be single use")
+	}
+}
@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/angelbarreiros/Penguin/router"
+	"github.com/angelbarreiros/Penguin/router/csrf"
+	"github.com/angelbarreiros/Penguin/router/middlewares"
+)
+
+// issueCSRFToken runs a safe request through the handler and returns the
+// cookies it set.
+func issueCSRFToken(t *testing.T, handler http.HandlerFunc, cookies ...*http.Cookie) map[string]*http.Cookie {
+	t.Helper()
+	request := httptest.NewRequest(http.MethodGet, "https://app.example.com/", nil)
+	for _, cookie := range cookies {
+		request.AddCookie(cookie)
+	}
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	var set map[string]*http.Cookie = make(map[string]*http.Cookie)
+	for _, cookie := range recorder.Result().Cookies() {
+		set[cookie.Name] = cookie
+	}
+	return set
+}
+
+func TestCSRFDoubleSubmitTokenIsBoundToTheSession(t *testing.T) {
+	config, err := csrf.NewCSRFConfig(csrf.WithSigningKey([]byte("0123456789abcdef0123456789abcdef")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var handler http.HandlerFunc = middlewares.WithCsrf(config, func(w http.ResponseWriter, r *http.Request) {})
+	var victim map[string]*http.Cookie = issueCSRFToken(t, handler)
+	var attacker map[string]*http.Cookie = issueCSRFToken(t, handler)
+
+	post := func(session *http.Cookie, token *http.Cookie, header string) int {
+		request := httptest.NewRequest(http.MethodPost, "https://app.example.com/transfer", nil)
+		request.Header.Set("Origin", "https://app.example.com")
+		request.Header.Set(csrf.DefaultHeaderName, header)
+		request.AddCookie(session)
+		request.AddCookie(token)
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		return recorder.Code
+	}
+	var session, token *http.Cookie = victim[csrf.DefaultSessionCookieName], victim[csrf.DefaultCookieName]
+	if status := post(session, token, token.Value); status != http.StatusOK {
+		t.Fatalf("expected the session token to be accepted, got %d", status)
+	}
+	var planted *http.Cookie = attacker[csrf.DefaultCookieName]
+	if status := post(session, planted, planted.Value); status != http.StatusForbidden {
+		t.Fatalf("expected a token of another session to be rejected, got %d", status)
+	}
+	var unsigned *http.Cookie = &http.Cookie{Name: csrf.DefaultCookieName, Value: "forged"}
+	if status := post(session, unsigned, unsigned.Value); status != http.StatusForbidden {
+		t.Fatalf("expected an unsigned token to be rejected, got %d", status)
+	}
+}
+
+func TestCSRFOriginMustMatchTheRequestScheme(t *testing.T) {
+	config, err := csrf.NewCSRFConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for origin, allowed := range map[string]bool{
+		"https://app.example.com": true,
+		"http://app.example.com":  false,
+		"https://evil.example":    false,
+	} {
+		request := httptest.NewRequest(http.MethodPost, "https://app.example.com/", nil)
+		request.Header.Set("Origin", origin)
+		if err := config.ValidateOrigin(request); (err == nil) != allowed {
+			t.Errorf("%s: expected allowed=%v, got %v", origin, allowed, err)
+		}
+	}
+}
+
+func TestCSRFRejectsShortSigningKeys(t *testing.T) {
+	if _, err := csrf.NewCSRFConfig(csrf.WithSigningKey([]byte("short"))); err == nil {
+		t.Fatal("expected a short signing key to be rejected")
+	}
+}
+
+func TestCSRFSessionIDFuncDoesNotFallBackToTheCookie(t *testing.T) {
+	var authenticated string
+	config, err := csrf.NewCSRFConfig(csrf.WithSessionIDFunc(func(r *http.Request) string { return authenticated }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var handler http.HandlerFunc = middlewares.WithCsrf(config, func(w http.ResponseWriter, r *http.Request) {})
+
+	if cookies := issueCSRFToken(t, handler); len(cookies) != 0 {
+		t.Fatalf("expected no token without a session, got %v", cookies)
+	}
+
+	// A sibling subdomain plants a csrf_session cookie and a token signed for it.
+	other, err := csrf.NewCSRFConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var planted map[string]*http.Cookie = issueCSRFToken(t, middlewares.WithCsrf(other, func(w http.ResponseWriter, r *http.Request) {}))
+	for _, session := range []string{"", "session-1"} {
+		authenticated = session
+		request := httptest.NewRequest(http.MethodPost, "https://app.example.com/transfer", nil)
+		request.Header.Set("Origin", "https://app.example.com")
+		request.Header.Set(csrf.DefaultHeaderName, planted[csrf.DefaultCookieName].Value)
+		request.AddCookie(planted[csrf.DefaultSessionCookieName])
+		request.AddCookie(planted[csrf.DefaultCookieName])
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		if recorder.Code != http.StatusForbidden {
+			t.Fatalf("session %q: expected a planted token to be rejected, got %d", session, recorder.Code)
+		}
+	}
+}
+
+func TestCSRFExemptRoutePatterns(t *testing.T) {
+	config, err := csrf.NewCSRFConfig(csrf.WithExemptPaths([]string{"POST /webhooks/{id}", "/hooks/{id}"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r *router.Router = router.NewRouter()
+	r.Use(func(hf http.HandlerFunc) http.HandlerFunc { return middlewares.WithCsrf(config, hf) })
+	var ok http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {}
+	r.NewRoute(router.Route{Path: "/webhooks/{id}", Method: router.POST, Handler: ok, AdditionalMethods: []router.HTTPMethod{router.PUT}})
+	r.NewRoute(router.Route{Path: "/hooks/{id}", Method: router.POST, Handler: ok})
+
+	for _, target := range []string{"/webhooks/42", "/hooks/42"} {
+		request := httptest.NewRequest(http.MethodPost, "https://app.example.com"+target, nil)
+		request.Header.Set("Origin", "https://evil.example")
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("POST %s: expected the exempt route to pass, got %d", target, recorder.Code)
+		}
+	}
+
+	request := httptest.NewRequest(http.MethodPut, "https://app.example.com/webhooks/42", nil)
+	request.Header.Set("Origin", "https://evil.example")
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected PUT to stay protected, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/flags"
+	"github.com/angelbarreiros/Penguin/router/auth"
+)
+
+func percentage(value float64) *float64 {
+	return &value
+}
+
+func TestFlagRolloutIsDeterministic(t *testing.T) {
+	var definitions []flags.Flag = []flags.Flag{
+		{Name: "new-checkout", Enabled: true, Rules: []flags.Rule{{Percentage: percentage(25)}}},
+		{Name: "pricing", Enabled: true, Variants: []string{"a", "b", "off"}, Rules: []flags.Rule{{Split: []flags.WeightedVariant{{Variant: "a", Weight: 1}, {Variant: "b", Weight: 3}}}}},
+	}
+	first, err := flags.NewEngine(flags.WithFlags(definitions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := flags.NewEngine(flags.WithFlags(definitions))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var enabled int
+	var variants map[string]int = make(map[string]int)
+	for i := range 4000 {
+		var ctx context.Context = flags.WithTarget(context.Background(), flags.Target{Subject: fmt.Sprintf("user-%d", i)})
+		var evaluation flags.Evaluation = first.Evaluate(ctx, "new-checkout")
+		if evaluation != second.Evaluate(ctx, "new-checkout") || first.Evaluate(ctx, "new-checkout") != evaluation {
+			t.Fatalf("user-%d: expected the same bucket on every evaluation", i)
+		}
+		if evaluation.Enabled() {
+			enabled++
+		}
+		variants[first.Variant(ctx, "pricing")]++
+	}
+	if enabled < 900 || enabled > 1100 {
+		t.Errorf("expected about 25%% of 4000 subjects, got %d", enabled)
+	}
+	if variants["a"] < 900 || variants["a"] > 1100 || variants["b"] < 2900 || variants["b"] > 3100 {
+		t.Errorf("expected a 1:3 split, got %v", variants)
+	}
+
+	if evaluation := first.Evaluate(context.Background(), "new-checkout"); evaluation.Reason != flags.ReasonDefault {
+		t.Errorf("expected anonymous requests to fall outside the rollout, got %+v", evaluation)
+	}
+}
+
+func TestFlagTargetingRules(t *testing.T) {
+	engine, err := flags.NewEngine(flags.WithFlags([]flags.Flag{
+		{Name: "beta", Enabled: true, Variants: []string{"on", "off", "internal"}, Rules: []flags.Rule{
+			{Subjects: []string{"alice"}, Variant: "internal"},
+			{Tenants: []string{"acme"}, Roles: []string{"admin"}},
+			{IPRanges: []string{"10.0.0.0/8"}, Headers: map[string][]string{"x-beta": {"1"}}},
+			{Claims: map[string][]string{"plan": {"enterprise"}}},
+		}},
+		{Name: "killed", Enabled: false, Rules: []flags.Rule{{}}},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, test := range map[string]struct {
+		target  flags.Target
+		variant string
+		rule    int
+	}{
+		"subject":             {flags.Target{Subject: "alice", Tenant: "acme", Roles: []string{"admin"}}, "internal", 0},
+		"tenant and role":     {flags.Target{Subject: "bob", Tenant: "acme", Roles: []string{"admin"}}, "on", 1},
+		"tenant without role": {flags.Target{Subject: "bob", Tenant: "acme", Roles: []string{"viewer"}}, "off", -1},
+		"ip and header":       {flags.Target{IP: netip.MustParseAddr("10.1.2.3"), Headers: http.Header{"X-Beta": {"1"}}}, "on", 2},
+		"ip without header":   {flags.Target{IP: netip.MustParseAddr("10.1.2.3")}, "off", -1},
+		"claim":               {flags.Target{Claims: map[string]any{"plan": "enterprise"}}, "on", 3},
+	} {
+		var evaluation flags.Evaluation = engine.Evaluate(flags.WithTarget(context.Background(), test.target), "beta")
+		if evaluation.Variant != test.variant || evaluation.Rule != test.rule {
+			t.Errorf("%s: expected %s from rule %d, got %+v", name, test.variant, test.rule, evaluation)
+		}
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.RemoteAddr = "10.9.9.9:4711"
+	request.Header.Set("X-Beta", "1")
+	if !engine.IsEnabled(engine.ContextFromRequest(request), "beta") {
+		t.Error("expected the request IP and header to match")
+	}
+	if evaluation := engine.Evaluate(context.Background(), "killed"); evaluation.Reason != flags.ReasonDisabled || evaluation.Enabled() {
+		t.Errorf("expected a disabled flag to be off, got %+v", evaluation)
+	}
+	if evaluation := engine.Evaluate(context.Background(), "missing"); evaluation.Reason != flags.ReasonNotFound {
+		t.Errorf("expected an unknown flag to be not found, got %+v", evaluation)
+	}
+}
+
+func TestFlagTargetsChainUsers(t *testing.T) {
+	engine, err := flags.NewEngine(flags.WithFlags([]flags.Flag{
+		{Name: "tenant", Enabled: true, Rules: []flags.Rule{{Tenants: []string{"acme"}, Roles: []string{"admin"}}}},
+		{Name: "plan", Enabled: true, Rules: []flags.Rule{{Claims: map[string][]string{"plan": {"enterprise"}}}}},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user map[string]any = map[string]any{"sub": "bob", "tenant": "acme", "plan": "enterprise"}
+	var ctx context.Context = auth.ContextWithUser(context.Background(), &auth.ChainResult{
+		Provider: "jwt", User: user, Providers: []string{"jwt"}, Users: map[string]any{"jwt": user}, Roles: []string{"admin"},
+	})
+	for _, name := range []string{"tenant", "plan"} {
+		if evaluation := engine.Evaluate(ctx, name); !evaluation.Enabled() || evaluation.Rule != 0 {
+			t.Errorf("%s: expected the claims of the chain user to match, got %+v", name, evaluation)
+		}
+	}
+}
+
+func TestFlagFileHotReload(t *testing.T) {
+	var path string = filepath.Join(t.TempDir(), "flags.yaml")
+	if err := os.WriteFile(path, []byte("flags:\n  - name: search\n    enabled: false\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := flags.NewEngine(flags.WithFile(path, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	if engine.IsEnabled(context.Background(), "search") {
+		t.Fatal("expected search to be off")
+	}
+
+	if err := os.WriteFile(path, []byte("flags:\n  - name: search\n    enabled: true\n    rules:\n      - {}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var deadline time.Time = time.Now().Add(2 * time.Second)
+	for !engine.IsEnabled(context.Background(), "search") {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the changed file to be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := os.WriteFile(path, []byte("flags: [ {name: search, defaultVariant: unknown} ]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Reload(); err == nil {
+		t.Fatal("expected an invalid flag file to be rejected")
+	}
+	if !engine.IsEnabled(context.Background(), "search") {
+		t.Fatal("expected an invalid flag file to keep the previous flags")
+	}
+}
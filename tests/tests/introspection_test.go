@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/middlewares"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newIntrospectionServer(t *testing.T, calls *atomic.Int32) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if clientID, secret, ok := r.BasicAuth(); !ok || clientID != "api" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.PostFormValue("token") {
+		case "opaque-reader":
+			json.NewEncoder(w).Encode(map[string]any{
+				"active": true, "scope": "read:orders profile", "sub": "client-7", "aud": "orders",
+				"exp": time.Now().Add(time.Hour).Unix(), "tenant": "acme", "roles": []string{"auditor"},
+			})
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			json.NewEncoder(w).Encode(map[string]any{"active": false})
+		}
+	}))
+}
+
+func TestIntrospectionAuthCachesResponses(t *testing.T) {
+	var calls atomic.Int32
+	var server *httptest.Server = newIntrospectionServer(t, &calls)
+	defer server.Close()
+	var introspection *auth.IntrospectionAuth = auth.NewIntrospectionAuth(server.URL,
+		auth.IntrospectionAuthWithClientCredentials("api", "secret"),
+		auth.IntrospectionAuthWithAudience("orders"))
+
+	serve := func(handler http.HandlerFunc, token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		return recorder
+	}
+	var reader http.HandlerFunc = middlewares.WithAuthAndScopes(introspection, []string{"read:orders"}, func(w http.ResponseWriter, r *http.Request) {
+		result, _ := auth.ClaimsFrom[*auth.IntrospectionResult](r.Context())
+		w.Write([]byte(result.Subject + "@" + result.Extra["tenant"].(string)))
+	})
+
+	for range 3 {
+		if recorder := serve(reader, "opaque-reader"); recorder.Code != http.StatusOK || recorder.Body.String() != "client-7@acme" {
+			t.Fatalf("expected 200, got %d %s", recorder.Code, recorder.Body.String())
+		}
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected the response to be cached, got %d calls", calls.Load())
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	request.Header.Set("Authorization", "Bearer opaque-reader")
+	user, err := introspection.Authenticate(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !introspection.RBAC(user, []string{"auditor"}) || introspection.RBAC(user, []string{"read:orders"}) {
+		t.Fatalf("expected RBAC to check the roles and not the scopes, got %v", user.(*auth.IntrospectionResult).GetRoles())
+	}
+
+	var writer http.HandlerFunc = middlewares.WithAuthAndScopes(introspection, []string{"read:orders", "write:orders"}, func(w http.ResponseWriter, r *http.Request) {})
+	recorder := serve(writer, "opaque-reader")
+	if recorder.Code != http.StatusForbidden || !strings.Contains(recorder.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`) ||
+		!strings.Contains(recorder.Header().Get("WWW-Authenticate"), `scope="read:orders write:orders"`) {
+		t.Fatalf("expected an insufficient_scope 403, got %d %q", recorder.Code, recorder.Header().Get("WWW-Authenticate"))
+	}
+
+	if recorder := serve(reader, "revoked"); recorder.Code != http.StatusUnauthorized || !strings.Contains(recorder.Header().Get("WWW-Authenticate"), `error="invalid_token"`) {
+		t.Fatalf("expected an inactive token to be rejected, got %d %q", recorder.Code, recorder.Header().Get("WWW-Authenticate"))
+	}
+	if recorder := serve(reader, "broken"); recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 when the endpoint fails, got %d", recorder.Code)
+	}
+}
+
+func TestIntrospectionAuthBoundsTheCache(t *testing.T) {
+	var calls atomic.Int32
+	var release chan struct{} = make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.PostFormValue("token") == "slow" {
+			<-release
+		}
+		json.NewEncoder(w).Encode(map[string]any{"active": strings.HasPrefix(r.PostFormValue("token"), "active"), "exp": time.Now().Add(time.Hour).Unix()})
+	}))
+	defer server.Close()
+	var introspection *auth.IntrospectionAuth = auth.NewIntrospectionAuth(server.URL, auth.IntrospectionAuthWithCacheSize(1))
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			introspection.Introspect("slow")
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Fatalf("expected concurrent requests to share one call, got %d", calls.Load())
+	}
+
+	calls.Store(0)
+	for _, token := range []string{"unknown", "unknown", "active-1", "active-1", "active-2", "active-2"} {
+		if _, err := introspection.Introspect(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls.Load() != 5 {
+		t.Fatalf("expected only the first active token to be cached, got %d calls", calls.Load())
+	}
+}
+
+type scopedClaims struct {
+	Scope string   `json:"scope,omitempty"`
+	Scp   []string `json:"scp,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func TestRequireScopesWithJWTClaims(t *testing.T) {
+	var key = newTestKey(t)
+	var jwtAuth *auth.JwtAuth = auth.NewJwtAuth(key, func() *scopedClaims { return &scopedClaims{} })
+	var handler http.HandlerFunc = middlewares.WithAuthMiddleWare(jwtAuth, middlewares.RequireScopes("read:orders")(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for claims, expected := range map[*scopedClaims]int{
+		{Scope: "profile read:orders", RegisteredClaims: registeredClaims("a")}: http.StatusOK,
+		{Scp: []string{"read:orders"}, RegisteredClaims: registeredClaims("b")}: http.StatusOK,
+		{Scope: "profile", RegisteredClaims: registeredClaims("c")}:             http.StatusForbidden,
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		request.Header.Set("Authorization", "Bearer "+signTestToken(t, key, claims))
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		if recorder.Code != expected {
+			t.Fatalf("%+v: expected %d, got %d", claims, expected, recorder.Code)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	middlewares.RequireScopes("read:orders")(func(w http.ResponseWriter, r *http.Request) {})(recorder, httptest.NewRequest(http.MethodGet, "/orders", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a user, got %d", recorder.Code)
+	}
+}
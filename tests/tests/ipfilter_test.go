@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/angelbarreiros/Penguin/router/ipfilter"
+	"github.com/angelbarreiros/Penguin/router/middlewares"
+)
+
+// MMDB encoding of the few types the tests need.
+func mmdbString(value string) []byte {
+	return append([]byte{2<<5 | byte(len(value))}, value...)
+}
+
+func mmdbMap(size int) []byte {
+	return []byte{7<<5 | byte(size)}
+}
+
+func mmdbUint16(value uint16) []byte {
+	return []byte{5<<5 | 2, byte(value >> 8), byte(value)}
+}
+
+func mmdbPointer(offset int) []byte {
+	return []byte{1<<5 | byte(offset>>8&0x7), byte(offset)}
+}
+
+// buildMMDB returns an IPv4 database with a single node: addresses below
+// 128.0.0.0 resolve to the record at offset 0 of data, the others to nothing.
+func buildMMDB(data []byte, nodeCount uint16) []byte {
+	var file bytes.Buffer
+	var record int = 1 + 16 // node count + data section separator + offset 0
+	file.Write([]byte{0, 0, byte(record), 0, 0, 1})
+	file.Write(make([]byte, 16))
+	file.Write(data)
+	file.WriteString("\xab\xcd\xefMaxMind.com")
+	file.Write(mmdbMap(3))
+	file.Write(mmdbString("node_count"))
+	file.Write(mmdbUint16(nodeCount))
+	file.Write(mmdbString("record_size"))
+	file.Write(mmdbUint16(24))
+	file.Write(mmdbString("ip_version"))
+	file.Write(mmdbUint16(4))
+	return file.Bytes()
+}
+
+func TestMMDBCountryLookup(t *testing.T) {
+	// {"country": <pointer to {"iso_code": "es"}>} followed by the pointed map.
+	var data []byte = mmdbMap(1)
+	data = append(data, mmdbString("country")...)
+	data = append(data, mmdbPointer(len(data)+2)...)
+	data = append(data, mmdbMap(1)...)
+	data = append(data, mmdbString("iso_code")...)
+	data = append(data, mmdbString("es")...)
+
+	reader, err := ipfilter.NewMMDBReader(buildMMDB(data, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if country, err := reader.Country(netip.MustParseAddr("10.1.2.3")); err != nil || country != "ES" {
+		t.Fatalf("expected ES, got %q %v", country, err)
+	}
+	if country, err := reader.Country(netip.MustParseAddr("200.1.2.3")); err != nil || country != "" {
+		t.Fatalf("expected no country, got %q %v", country, err)
+	}
+
+	config, err := ipfilter.NewIPFilterConfig(
+		ipfilter.WithGeoReader(reader),
+		ipfilter.WithAllowedCountries([]string{"es"}),
+		ipfilter.WithDenyList([]string{"10.9.0.0/16"}),
+		ipfilter.WithTrustedProxies([]string{"192.0.2.1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var handler http.HandlerFunc = middlewares.WithIPFilter(config, func(w http.ResponseWriter, r *http.Request) {})
+	for client, status := range map[string]int{"10.1.2.3": http.StatusOK, "10.9.0.1": http.StatusForbidden, "200.1.2.3": http.StatusForbidden} {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.RemoteAddr = "192.0.2.1:4711"
+		request.Header.Set("X-Forwarded-For", client)
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		if recorder.Code != status {
+			t.Errorf("%s: expected %d, got %d", client, status, recorder.Code)
+		}
+	}
+}
+
+func TestMMDBRejectsCorruptData(t *testing.T) {
+	var selfReference []byte = append(mmdbMap(1), mmdbString("a")...)
+	selfReference = append(selfReference, mmdbPointer(0)...)
+
+	for name, data := range map[string][]byte{
+		"pointer to pointer":  append(mmdbPointer(2), mmdbPointer(0)...),
+		"pointer out of data": mmdbPointer(2000),
+		"self reference":      selfReference,
+		"oversized map":       {7<<5 | 31, 0xff, 0xff, 0xff},
+		"oversized array":     {0<<5 | 31, 4, 0xff, 0xff, 0xff},
+		"truncated string":    {2<<5 | 20, 'e', 's'},
+	} {
+		reader, err := ipfilter.NewMMDBReader(buildMMDB(data, 1))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := reader.Lookup(netip.MustParseAddr("10.1.2.3")); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := ipfilter.NewMMDBReader(buildMMDB(nil, 0xffff)); err == nil {
+		t.Error("expected a search tree larger than the file to be rejected")
+	}
+}
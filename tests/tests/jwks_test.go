@@ -0,0 +1,201 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/router"
+	"github.com/angelbarreiros/Penguin/router/auth"
+)
+
+// jwksServer publishes the public keys of keySet and counts the fetches.
+func jwksServer(t *testing.T, keySet *auth.KeySet) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var fetches *atomic.Int64 = &atomic.Int64{}
+	var r *router.Router = router.NewRouter()
+	router.RegisterJWKSRoute(r, keySet)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fetches.Add(1)
+		r.ServeHTTP(w, req)
+	}))
+	t.Cleanup(server.Close)
+	return server, fetches
+}
+
+func newSigningKey(t *testing.T, kid string) auth.Key {
+	t.Helper()
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mustKey(t)(auth.NewSigningKey(kid, "ES256", signer))
+}
+
+func TestJWKSHandlerPublishesPublicKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	keySet, err := auth.NewKeySet(
+		newSigningKey(t, "ec"),
+		mustKey(t)(auth.NewSigningKey("rsa", "PS256", rsaKey)),
+		mustKey(t)(auth.NewSigningKey("ed", "EdDSA", edKey)),
+		mustKey(t)(auth.NewHMACKey("secret", "HS256", make([]byte, 32))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, _ := jwksServer(t, keySet)
+
+	response, err := http.Get(server.URL + auth.JWKSPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var jwks auth.JWKS
+	if err := json.NewDecoder(response.Body).Decode(&jwks); err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) != 3 {
+		t.Fatalf("got %d keys, want 3 without the HMAC secret", len(jwks.Keys))
+	}
+	for _, jwk := range jwks.Keys {
+		key, err := auth.ParseJWK(jwk)
+		if err != nil {
+			t.Fatalf("published key %q cannot be parsed: %v", jwk.KeyID, err)
+		}
+		if key.CanSign() {
+			t.Fatalf("published key %q holds private material", jwk.KeyID)
+		}
+	}
+}
+
+func TestRemoteKeySetVerifiesTokens(t *testing.T) {
+	var current auth.Key = newSigningKey(t, "key-1")
+	keySet, err := auth.NewKeySet(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, fetches := jwksServer(t, keySet)
+
+	remote, err := auth.NewRemoteKeySet(server.URL+auth.JWKSPath, auth.RemoteKeySetWithMinRefetchInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	var verifier *auth.JwtAuth = auth.NewJwtAuthWithKeys(remote, auth.NewPlainClaims)
+
+	token, err := keySet.Sign(&auth.PlainClaims{RegisteredClaims: registeredClaims("user")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := verifier.ParseToken(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := fetches.Load(); got != 1 {
+		t.Fatalf("got %d fetches, want the keys to be cached", got)
+	}
+}
+
+func TestRemoteKeySetRefetchesOnUnknownKid(t *testing.T) {
+	keySet, err := auth.NewKeySet(newSigningKey(t, "key-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, fetches := jwksServer(t, keySet)
+
+	remote, err := auth.NewRemoteKeySet(server.URL+auth.JWKSPath, auth.RemoteKeySetWithMinRefetchInterval(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	var verifier *auth.JwtAuth = auth.NewJwtAuthWithKeys(remote, auth.NewPlainClaims)
+
+	// Unknown kids are rate limited: a burst of forged tokens causes at most
+	// one refetch per interval.
+	var unknown auth.Key = newSigningKey(t, "unknown")
+	forged, err := auth.SignWithKey(&auth.PlainClaims{RegisteredClaims: registeredClaims("attacker")}, unknown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 20; i++ {
+		if _, err := verifier.ParseToken(forged); err == nil {
+			t.Fatal("token with an unknown kid was accepted")
+		}
+	}
+	if got := fetches.Load(); got != 2 {
+		t.Fatalf("got %d fetches, want 2", got)
+	}
+
+	// A rotated key is picked up on its first token once the interval passed.
+	var rotated auth.Key = newSigningKey(t, "key-2")
+	if err := keySet.Add(rotated); err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.SignWithKey(&auth.PlainClaims{RegisteredClaims: registeredClaims("user")}, rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := verifier.ParseToken(token); err != nil {
+		t.Fatalf("token signed with the rotated key was rejected: %v", err)
+	}
+}
+
+func TestRemoteKeySetBackgroundRefresh(t *testing.T) {
+	keySet, err := auth.NewKeySet(newSigningKey(t, "key-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, _ := jwksServer(t, keySet)
+
+	remote, err := auth.NewRemoteKeySet(server.URL+auth.JWKSPath, auth.RemoteKeySetWithRefreshInterval(30*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+
+	keySet.Remove("key-1")
+	if err := keySet.Add(newSigningKey(t, "key-2")); err != nil {
+		t.Fatal(err)
+	}
+	var deadline time.Time = time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if keys := remote.Keys(); len(keys) == 1 && keys[0].ID == "key-2" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("keys were not refreshed: %+v", remote.Keys())
+}
+
+func TestRemoteKeySetKeepsKeysWhenServerFails(t *testing.T) {
+	keySet, err := auth.NewKeySet(newSigningKey(t, "key-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, _ := jwksServer(t, keySet)
+	remote, err := auth.NewRemoteKeySet(server.URL + auth.JWKSPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+
+	server.Close()
+	if err := remote.Refresh(); err == nil {
+		t.Fatal("refresh from a closed server succeeded")
+	}
+	if len(remote.Keys()) != 1 {
+		t.Fatal("cached keys were dropped after a failed refresh")
+	}
+}
@@ -0,0 +1,196 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+	"github.com/angelbarreiros/Penguin/router/middlewares"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const concurrentRequests = 64
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return key
+}
+
+func signTestToken(t *testing.T, key *ecdsa.PrivateKey, claims jwt.Claims) string {
+	t.Helper()
+	token, err := helpers.GenerateJwtToken(claims, key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return token
+}
+
+func registeredClaims(subject string) jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Subject:   subject,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+	}
+}
+
+func bearerRequest(token string) *http.Request {
+	var r *http.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+// runConcurrently runs fn for every index at the same time and reports the
+// first error.
+func runConcurrently(t *testing.T, n int, fn func(i int) error) {
+	t.Helper()
+	var wg sync.WaitGroup
+	var start chan struct{} = make(chan struct{})
+	var errs chan error = make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			if err := fn(i); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestJwtAuthConcurrentAuthenticate(t *testing.T) {
+	var key *ecdsa.PrivateKey = newTestKey(t)
+	var jwtAuth *auth.JwtAuth = auth.NewSingletonJwtAuth(key, auth.NewPlainClaims)
+
+	var tokens []string = make([]string, concurrentRequests)
+	for i := range tokens {
+		tokens[i] = signTestToken(t, key, &auth.PlainClaims{RegisteredClaims: registeredClaims(fmt.Sprintf("user-%d", i))})
+	}
+
+	runConcurrently(t, concurrentRequests, func(i int) error {
+		var token string = tokens[i]
+		if i%10 == 0 {
+			token = "invalid." + token
+		}
+		user, err := jwtAuth.Authenticate(bearerRequest(token))
+		if i%10 == 0 {
+			if err == nil {
+				return fmt.Errorf("request %d: invalid token was accepted", i)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("request %d: %v", i, err)
+		}
+		subject, _ := user.(*auth.PlainClaims).GetSubject()
+		if want := fmt.Sprintf("user-%d", i); subject != want {
+			return fmt.Errorf("request %d: got subject %q, want %q", i, subject, want)
+		}
+		return nil
+	})
+}
+
+func TestJwtAuthMiddlewareConcurrentRequests(t *testing.T) {
+	var key *ecdsa.PrivateKey = newTestKey(t)
+	var jwtAuth *auth.JwtAuth = auth.NewJwtAuth(key, auth.NewPlainClaims)
+	var handler http.HandlerFunc = middlewares.WithAuthMiddleWare(jwtAuth, func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := auth.ClaimsFrom[*auth.PlainClaims](r.Context())
+		w.Write([]byte(claims.Subject))
+	})
+
+	var tokens []string = make([]string, concurrentRequests)
+	for i := range tokens {
+		tokens[i] = signTestToken(t, key, &auth.PlainClaims{RegisteredClaims: registeredClaims(fmt.Sprintf("user-%d", i))})
+	}
+
+	runConcurrently(t, concurrentRequests, func(i int) error {
+		var rec *httptest.ResponseRecorder = httptest.NewRecorder()
+		handler(rec, bearerRequest(tokens[i]))
+		if rec.Code != http.StatusOK {
+			return fmt.Errorf("request %d: got status %d", i, rec.Code)
+		}
+		if want := fmt.Sprintf("user-%d", i); rec.Body.String() != want {
+			return fmt.Errorf("request %d: got subject %q, want %q", i, rec.Body.String(), want)
+		}
+		return nil
+	})
+}
+
+func TestRBACJwtAuthConcurrentRoles(t *testing.T) {
+	var key *ecdsa.PrivateKey = newTestKey(t)
+	var rbacAuth *auth.RBACJwtAuth = auth.NewSingletonJwtAuthWithRbac(key, auth.NewRBACClaims)
+	var handler http.HandlerFunc = middlewares.WithAuthAndRBAC(rbacAuth, []string{"admin"}, func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := auth.ClaimsFrom[*auth.RBACClaims](r.Context())
+		w.Write([]byte(claims.Subject))
+	})
+
+	var tokens []string = make([]string, concurrentRequests)
+	for i := range tokens {
+		var role string = "user"
+		if i%2 == 0 {
+			role = "admin"
+		}
+		tokens[i] = signTestToken(t, key, &auth.RBACClaims{Roles: []string{role}, RegisteredClaims: registeredClaims(fmt.Sprintf("user-%d", i))})
+	}
+
+	runConcurrently(t, concurrentRequests, func(i int) error {
+		var rec *httptest.ResponseRecorder = httptest.NewRecorder()
+		handler(rec, bearerRequest(tokens[i]))
+		if i%2 != 0 {
+			if rec.Code != http.StatusForbidden {
+				return fmt.Errorf("request %d: non admin got status %d", i, rec.Code)
+			}
+			return nil
+		}
+		if rec.Code != http.StatusOK {
+			return fmt.Errorf("request %d: admin got status %d", i, rec.Code)
+		}
+		if want := fmt.Sprintf("user-%d", i); rec.Body.String() != want {
+			return fmt.Errorf("request %d: got subject %q, want %q", i, rec.Body.String(), want)
+		}
+		return nil
+	})
+}
+
+func TestJwtAuthReturnsFreshClaims(t *testing.T) {
+	var key *ecdsa.PrivateKey = newTestKey(t)
+	var rbacAuth *auth.RBACJwtAuth = auth.NewJwtAuthWithRbac(key, auth.NewRBACClaims)
+
+	first, err := rbacAuth.Authenticate(bearerRequest(signTestToken(t, key, &auth.RBACClaims{Roles: []string{"admin"}, RegisteredClaims: registeredClaims("first")})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := rbacAuth.Authenticate(bearerRequest(signTestToken(t, key, &auth.RBACClaims{Roles: []string{"user"}, RegisteredClaims: registeredClaims("second")})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.(*auth.RBACClaims).Subject != "first" || !rbacAuth.RBAC(first, []string{"admin"}) {
+		t.Fatalf("first claims were overwritten: %+v", first)
+	}
+	if rbacAuth.RBAC(second, []string{"admin"}) {
+		t.Fatalf("second user must not be admin")
+	}
+	if _, err := rbacAuth.Authenticate(bearerRequest("")); err == nil {
+		t.Fatalf("missing token was accepted")
+	}
+}
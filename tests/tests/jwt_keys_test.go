@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/helpers"
+)
+
+func mustKey(t *testing.T) func(auth.Key, error) auth.Key {
+	return func(key auth.Key, err error) auth.Key {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("failed to create key: %v", err)
+		}
+		return key
+	}
+}
+
+func TestJwtAuthAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKeys := map[string]elliptic.Curve{"ES256": elliptic.P256(), "ES384": elliptic.P384(), "ES512": elliptic.P521()}
+
+	var keys []auth.Key
+	for _, alg := range []string{"HS256", "HS384", "HS512"} {
+		keys = append(keys, mustKey(t)(auth.NewHMACKey("hmac-"+alg, alg, make([]byte, 64))))
+	}
+	for _, alg := range []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"} {
+		keys = append(keys, mustKey(t)(auth.NewSigningKey("rsa-"+alg, alg, rsaKey)))
+	}
+	for alg, curve := range ecKeys {
+		ecKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, mustKey(t)(auth.NewSigningKey("ec-"+alg, alg, ecKey)))
+	}
+	keys = append(keys, mustKey(t)(auth.NewSigningKey("ed", "EdDSA", edKey)))
+
+	keySet, err := auth.NewKeySet(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jwtAuth *auth.JwtAuth = auth.NewJwtAuthWithKeys(keySet, auth.NewPlainClaims)
+
+	for _, key := range keys {
+		t.Run(key.Algorithm, func(t *testing.T) {
+			token, err := auth.SignWithKey(&auth.PlainClaims{RegisteredClaims: registeredClaims(key.ID)}, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := jwtAuth.ParseToken(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if subject, _ := claims.GetSubject(); subject != key.ID {
+				t.Fatalf("got subject %q, want %q", subject, key.ID)
+			}
+		})
+	}
+}
+
+func TestJwtAuthAllowedAlgorithms(t *testing.T) {
+	var key auth.Key = mustKey(t)(auth.NewHMACKey("hmac", "HS256", make([]byte, 32)))
+	keySet, err := auth.NewKeySet(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := keySet.Sign(&auth.PlainClaims{RegisteredClaims: registeredClaims("user")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jwtAuth *auth.JwtAuth = auth.NewJwtAuthWithKeys(keySet, auth.NewPlainClaims, auth.JwtAuthWithAllowedAlgorithms("ES256"))
+	if _, err := jwtAuth.ParseToken(token); err == nil {
+		t.Fatal("token with a disallowed algorithm was accepted")
+	}
+}
+
+func TestJwtAuthRejectsAlgorithmConfusion(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key auth.Key = mustKey(t)(auth.NewVerificationKey("rsa", "RS256", &rsaKey.PublicKey))
+	keySet, err := auth.NewKeySet(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An attacker signs with HS256 using the public key as the secret.
+	token, err := helpers.GenerateJwtTokenWithAlgorithm(&auth.PlainClaims{RegisteredClaims: registeredClaims("attacker")}, "HS256", rsaKey.N.Bytes(), "rsa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.NewJwtAuthWithKeys(keySet, auth.NewPlainClaims).ParseToken(token); err == nil {
+		t.Fatal("HS256 token was accepted for an RSA key")
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	oldSigner, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newSigner, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var now time.Time = time.Now()
+
+	var oldKey auth.Key = mustKey(t)(auth.NewSigningKey("2024-01", "ES256", oldSigner)).ValidBetween(now.Add(-time.Hour), now.Add(time.Hour))
+	var newKey auth.Key = mustKey(t)(auth.NewSigningKey("2024-02", "ES256", newSigner)).ValidBetween(now.Add(-time.Minute), time.Time{})
+	keySet, err := auth.NewKeySet(oldKey, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signing, err := keySet.SigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signing.ID != "2024-02" {
+		t.Fatalf("got signing key %q, want the newest key", signing.ID)
+	}
+
+	// Services that only verify receive the public keys.
+	var verifier *auth.JwtAuth = auth.NewJwtAuthWithKeys(keySet.Public(), auth.NewPlainClaims)
+	for _, key := range keySet.Public().Keys() {
+		if key.CanSign() {
+			t.Fatalf("public key set holds the private key of %q", key.ID)
+		}
+	}
+	oldToken, err := auth.SignWithKey(&auth.PlainClaims{RegisteredClaims: registeredClaims("old")}, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := keySet.Sign(&auth.PlainClaims{RegisteredClaims: registeredClaims("new")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{oldToken, newToken} {
+		if _, err := verifier.ParseToken(token); err != nil {
+			t.Fatalf("token rejected during the overlap: %v", err)
+		}
+	}
+
+	retired, err := auth.NewKeySet(oldKey.Public().ValidBetween(now.Add(-2*time.Hour), now.Add(-time.Hour)), newKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.NewJwtAuthWithKeys(retired, auth.NewPlainClaims).ParseToken(oldToken); err == nil {
+		t.Fatal("token signed with a retired key was accepted")
+	}
+}
+
+func TestKeyValidation(t *testing.T) {
+	weakRSA, _ := rsa.GenerateKey(rand.Reader, 1024)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tests := []struct {
+		name      string
+		algorithm string
+		key       crypto.Signer
+	}{
+		{name: "weak rsa", algorithm: "RS256", key: weakRSA},
+		{name: "wrong curve", algorithm: "ES512", key: p256},
+		{name: "wrong type", algorithm: "EdDSA", key: p256},
+		{name: "none", algorithm: "none", key: p256},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := auth.NewSigningKey("kid", tt.algorithm, tt.key); err == nil {
+				t.Fatal("invalid key was accepted")
+			}
+		})
+	}
+	if _, err := auth.NewHMACKey("kid", "HS512", make([]byte, 32)); err == nil {
+		t.Fatal("short HMAC secret was accepted")
+	}
+}
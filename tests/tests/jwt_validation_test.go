@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/middlewares"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type tenantClaims struct {
+	Tenant string `json:"tenant,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func TestValidationPolicy(t *testing.T) {
+	var key auth.Key = mustKey(t)(auth.NewHMACKey("k1", "HS256", make([]byte, 32)))
+	keySet, err := auth.NewKeySet(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var now time.Time = time.Now()
+	var policy auth.ValidationPolicy = auth.ValidationPolicy{
+		Issuer:            "https://id.example.com",
+		Audiences:         []string{"orders", "billing"},
+		Leeway:            30 * time.Second,
+		MaxAge:            time.Hour,
+		RequiredClaims:    []string{"tenant"},
+		RequireExpiration: true,
+	}
+	var jwtAuth *auth.JwtAuth = auth.NewJwtAuthWithKeys(keySet, func() *tenantClaims { return &tenantClaims{} }, auth.JwtAuthWithValidationPolicy(policy))
+
+	valid := func() *tenantClaims {
+		return &tenantClaims{Tenant: "acme", RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "https://id.example.com",
+			Audience:  jwt.ClaimStrings{"billing"},
+			Subject:   "user",
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		}}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*tenantClaims)
+		reason auth.TokenErrorReason
+	}{
+		{name: "valid", modify: func(c *tenantClaims) {}},
+		{name: "expired within leeway", modify: func(c *tenantClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-10 * time.Second)) }},
+		{name: "expired", modify: func(c *tenantClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute)) }, reason: auth.ReasonExpired},
+		{name: "missing expiration", modify: func(c *tenantClaims) { c.ExpiresAt = nil }, reason: auth.ReasonMissingClaim},
+		{name: "not yet valid", modify: func(c *tenantClaims) { c.NotBefore = jwt.NewNumericDate(now.Add(time.Minute)) }, reason: auth.ReasonNotYetValid},
+		{name: "issued in the future", modify: func(c *tenantClaims) { c.IssuedAt = jwt.NewNumericDate(now.Add(time.Minute)) }, reason: auth.ReasonIssuedInFuture},
+		{name: "too old", modify: func(c *tenantClaims) { c.IssuedAt = jwt.NewNumericDate(now.Add(-2 * time.Hour)) }, reason: auth.ReasonTooOld},
+		{name: "wrong issuer", modify: func(c *tenantClaims) { c.Issuer = "https://evil.example.com" }, reason: auth.ReasonWrongIssuer},
+		{name: "wrong audience", modify: func(c *tenantClaims) { c.Audience = jwt.ClaimStrings{"admin"} }, reason: auth.ReasonWrongAudience},
+		{name: "missing required claim", modify: func(c *tenantClaims) { c.Tenant = "" }, reason: auth.ReasonMissingClaim},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims *tenantClaims = valid()
+			tt.modify(claims)
+			token, err := keySet.Sign(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = jwtAuth.ParseToken(token)
+			if tt.reason == "" {
+				if err != nil {
+					t.Fatalf("valid token rejected: %v", err)
+				}
+				return
+			}
+			reason, ok := auth.TokenErrorReasonOf(err)
+			if !ok || reason != tt.reason {
+				t.Fatalf("got error %v (reason %q), want reason %q", err, reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareBearerChallenge(t *testing.T) {
+	var key auth.Key = mustKey(t)(auth.NewHMACKey("k1", "HS256", make([]byte, 32)))
+	keySet, err := auth.NewKeySet(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jwtAuth *auth.JwtAuth = auth.NewJwtAuthWithKeys(keySet, auth.NewPlainClaims)
+	var handler http.HandlerFunc = middlewares.WithAuthMiddleWare(jwtAuth, func(w http.ResponseWriter, r *http.Request) {})
+
+	expired, err := keySet.Sign(&auth.PlainClaims{RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour))}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+		challenge     string
+	}{
+		{name: "missing token", status: http.StatusUnauthorized, challenge: "Bearer"},
+		{name: "wrong scheme", authorization: "Basic dXNlcjpwYXNz", status: http.StatusBadRequest, challenge: `Bearer error="invalid_request"`},
+		{name: "expired token", authorization: "Bearer " + expired, status: http.StatusUnauthorized, challenge: `Bearer error="invalid_token", error_description="token has expired"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r *http.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			var rec *httptest.ResponseRecorder = httptest.NewRecorder()
+			handler(rec, r)
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("WWW-Authenticate"); !strings.HasPrefix(got, tt.challenge) {
+				t.Fatalf("got challenge %q, want prefix %q", got, tt.challenge)
+			}
+		})
+	}
+}
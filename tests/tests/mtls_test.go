@@ -0,0 +1,207 @@
+package tests
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/middlewares"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func newTestCA(t *testing.T) testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var template *x509.Certificate = &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCA{cert: cert, key: key}
+}
+
+// issue returns a client certificate with the common name and optional
+// SPIFFE ID.
+func (ca testCA) issue(t *testing.T, serial int64, commonName string, spiffeID string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var template *x509.Certificate = &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if spiffeID != "" {
+		uri, _ := url.Parse(spiffeID)
+		template.URIs = []*url.URL{uri}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func (ca testCA) writeCRL(t *testing.T, serials ...int64) string {
+	t.Helper()
+	var entries []x509.RevocationListEntry
+	for _, serial := range serials {
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                time.Now().Add(-time.Minute),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: entries,
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var path string = filepath.Join(t.TempDir(), "ca.crl")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func certificateRequest(certs ...tls.Certificate) *http.Request {
+	var request *http.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	request.TLS = &tls.ConnectionState{}
+	for _, cert := range certs {
+		request.TLS.PeerCertificates = append(request.TLS.PeerCertificates, cert.Leaf)
+	}
+	return request
+}
+
+func TestMTLSAuthMapsIdentities(t *testing.T) {
+	var ca testCA = newTestCA(t)
+	var roots *x509.CertPool = x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	mtlsAuth, err := auth.NewMTLSAuth(roots,
+		auth.MTLSAuthWithCRLFiles(0, ca.writeCRL(t, 3)),
+		auth.MTLSAuthWithRoles(map[string][]string{
+			"spiffe://cluster.local/ns/billing/*": {"billing"},
+			"reporting":                           {"reports"},
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := mtlsAuth.Authenticate(certificateRequest(ca.issue(t, 2, "billing", "spiffe://cluster.local/ns/billing/sa/api")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	principal := user.(*auth.CertificatePrincipal)
+	if principal.Identity != "spiffe://cluster.local/ns/billing/sa/api" || principal.TrustDomain != "cluster.local" {
+		t.Fatalf("unexpected principal %+v", principal)
+	}
+	if !mtlsAuth.RBAC(user, []string{"billing"}) {
+		t.Fatal("expected the SPIFFE prefix roles")
+	}
+
+	user, err = mtlsAuth.Authenticate(certificateRequest(ca.issue(t, 4, "reporting", "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.(*auth.CertificatePrincipal).Identity != "reporting" || !mtlsAuth.RBAC(user, []string{"reports"}) {
+		t.Fatalf("expected the common name identity, got %+v", user)
+	}
+
+	_, err = mtlsAuth.Authenticate(certificateRequest(ca.issue(t, 3, "revoked", "")))
+	if reason, _ := auth.TokenErrorReasonOf(err); reason != auth.ReasonRevoked {
+		t.Fatalf("expected a revoked certificate to be rejected, got %v", err)
+	}
+
+	_, err = mtlsAuth.Authenticate(certificateRequest(newTestCA(t).issue(t, 5, "stranger", "")))
+	if err == nil {
+		t.Fatal("expected a certificate of another CA to be rejected")
+	}
+
+	strict, _ := auth.NewMTLSAuth(roots, auth.MTLSAuthWithTrustDomains("prod.local"))
+	if _, err := strict.Authenticate(certificateRequest(ca.issue(t, 6, "billing", "spiffe://cluster.local/ns/billing/sa/api"))); err == nil {
+		t.Fatal("expected a foreign trust domain to be rejected")
+	}
+}
+
+func TestMTLSAuthOverTLS(t *testing.T) {
+	var ca testCA = newTestCA(t)
+	var roots *x509.CertPool = x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	mtlsAuth, err := auth.NewMTLSAuth(roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(middlewares.WithAuthMiddleWare(mtlsAuth, func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := auth.ClaimsFrom[*auth.CertificatePrincipal](r.Context())
+		subject, _ := principal.GetSubject()
+		w.Write([]byte(subject))
+	}))
+	server.TLS = mtlsAuth.TLSConfig(false)
+	server.StartTLS()
+	defer server.Close()
+
+	response, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a client certificate, got %d", response.StatusCode)
+	}
+
+	var serverRoots *x509.CertPool = x509.NewCertPool()
+	serverRoots.AddCert(server.Certificate())
+	var client *http.Client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      serverRoots,
+		Certificates: []tls.Certificate{ca.issue(t, 2, "worker", "")},
+	}}}
+	defer client.CloseIdleConnections()
+	response, err = client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 with a client certificate, got %d", response.StatusCode)
+	}
+}
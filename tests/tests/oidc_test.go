@@ -0,0 +1,270 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/router"
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/oidc"
+	"github.com/angelbarreiros/Penguin/router/sessions"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type fakeGrant struct {
+	nonce     string
+	challenge string
+}
+
+// fakeProvider is a minimal OpenID provider issuing ID tokens for the code
+// flow with PKCE.
+type fakeProvider struct {
+	server *httptest.Server
+	mu     sync.Mutex
+	grants map[string]fakeGrant
+	claims jwt.MapClaims
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := auth.NewSigningKey("provider-1", "ES256", private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.NewKeySet(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var provider *fakeProvider = &fakeProvider{grants: make(map[string]fakeGrant)}
+
+	var mux *http.ServeMux = http.NewServeMux()
+	mux.HandleFunc(oidc.DiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidc.ProviderMetadata{
+			Issuer:                           provider.server.URL,
+			AuthorizationEndpoint:            provider.server.URL + "/authorize",
+			TokenEndpoint:                    provider.server.URL + "/token",
+			JWKSURI:                          provider.server.URL + "/jwks",
+			EndSessionEndpoint:               provider.server.URL + "/logout",
+			IDTokenSigningAlgValuesSupported: []string{"ES256"},
+		})
+	})
+	mux.HandleFunc("/jwks", auth.JWKSHandler(keys))
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		var query url.Values = r.URL.Query()
+		if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "dashboard" {
+			http.Error(w, "invalid_request", http.StatusBadRequest)
+			return
+		}
+		var code string = "code-" + query.Get("state")[:8]
+		provider.mu.Lock()
+		provider.grants[code] = fakeGrant{nonce: query.Get("nonce"), challenge: query.Get("code_challenge")}
+		provider.mu.Unlock()
+		http.Redirect(w, r, query.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {query.Get("state")}}.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if clientID, secret, ok := r.BasicAuth(); !ok || clientID != "dashboard" || secret != "s3cret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		provider.mu.Lock()
+		grant, ok := provider.grants[r.PostFormValue("code")]
+		delete(provider.grants, r.PostFormValue("code"))
+		provider.mu.Unlock()
+		var verifier [32]byte = sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		var claims jwt.MapClaims = jwt.MapClaims{
+			"iss": provider.server.URL, "aud": "dashboard", "sub": "alice", "nonce": grant.nonce,
+			"iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix(), "groups": []string{"ops"},
+		}
+		for name, value := range provider.claims {
+			claims[name] = value
+		}
+		idToken, err := keys.Sign(claims)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "opaque", "token_type": "Bearer", "id_token": idToken})
+	})
+	provider.server = httptest.NewServer(mux)
+	return provider
+}
+
+func cookieNamed(response *http.Response, name string) *http.Cookie {
+	for _, cookie := range response.Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	var provider *fakeProvider = newFakeProvider(t)
+	defer provider.server.Close()
+	rp, err := oidc.NewRelyingParty(provider.server.URL, "dashboard", "http://app.test/auth/callback",
+		oidc.RelyingPartyWithClientSecret("s3cret"),
+		oidc.RelyingPartyWithPostLogoutRedirectURL("http://app.test/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rp.Close()
+
+	var app *router.Router = router.NewRouter()
+	rp.RegisterRoutes(app)
+	app.NewRoute(router.Route{Path: "/dashboard", Method: router.GET, Handler: rp.RequireLogin(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ := auth.ClaimsFrom[*oidc.Identity](r.Context())
+		w.Write([]byte(identity.Subject + ":" + strings.Join(identity.GetRoles(), ",")))
+	})})
+	send := func(method string, target string, cookies ...*http.Cookie) *http.Response {
+		request := httptest.NewRequest(method, target, nil)
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		recorder := httptest.NewRecorder()
+		app.ServeHTTP(recorder, request)
+		return recorder.Result()
+	}
+	serve := func(target string, cookies ...*http.Cookie) *http.Response {
+		return send(http.MethodGet, target, cookies...)
+	}
+
+	response := serve("/dashboard")
+	if response.StatusCode != http.StatusFound || response.Header.Get("Location") != "/auth/login?return_to=%2Fdashboard" {
+		t.Fatalf("expected a redirect to the login, got %d %q", response.StatusCode, response.Header.Get("Location"))
+	}
+
+	response = serve("/auth/login?return_to=/dashboard")
+	var flowCookie *http.Cookie = cookieNamed(response, "penguin_oidc_flow")
+	if response.StatusCode != http.StatusFound || flowCookie == nil {
+		t.Fatalf("expected a redirect to the provider, got %d", response.StatusCode)
+	}
+	var noRedirects *http.Client = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	authorize, err := noRedirects.Get(response.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorize.Body.Close()
+	callback, err := url.Parse(authorize.Header.Get("Location"))
+	if err != nil || callback.Path != "/auth/callback" {
+		t.Fatalf("unexpected provider redirect %q", authorize.Header.Get("Location"))
+	}
+
+	var tampered url.Values = callback.Query()
+	tampered.Set("state", "forged")
+	if response := serve("/auth/callback?"+tampered.Encode(), flowCookie); response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected a forged state to be rejected, got %d", response.StatusCode)
+	}
+
+	response = serve(callback.RequestURI(), flowCookie)
+	var session *http.Cookie = cookieNamed(response, oidc.DefaultSessionCookieName)
+	if response.StatusCode != http.StatusFound || response.Header.Get("Location") != "/dashboard" || session == nil {
+		t.Fatalf("expected the session and a redirect back, got %d %q", response.StatusCode, response.Header.Get("Location"))
+	}
+
+	response = serve("/dashboard", session)
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK || string(body) != "alice:ops" {
+		t.Fatalf("expected the dashboard, got %d %q", response.StatusCode, body)
+	}
+
+	if response := serve("/auth/logout", session); response.StatusCode != http.StatusMethodNotAllowed || cookieNamed(response, oidc.DefaultSessionCookieName) != nil {
+		t.Fatalf("expected a GET logout to be refused, got %d", response.StatusCode)
+	}
+	response = send(http.MethodPost, "/auth/logout", session)
+	logout, _ := url.Parse(response.Header.Get("Location"))
+	if logout.Path != "/logout" || logout.Query().Get("id_token_hint") == "" || logout.Query().Get("post_logout_redirect_uri") != "http://app.test/" {
+		t.Fatalf("expected a redirect to the end session endpoint, got %q", response.Header.Get("Location"))
+	}
+	if cleared := cookieNamed(response, oidc.DefaultSessionCookieName); cleared == nil || cleared.MaxAge >= 0 {
+		t.Fatal("expected the session cookie to be cleared")
+	}
+
+	for returnTo, expected := range map[string]string{
+		"/orders?page=2":       "/orders?page=2",
+		"/%09/evil.example":    "/",
+		"/%0d%0a/evil.example": "/",
+		"/%5Cevil.example":     "/",
+		"//evil.example":       "/",
+		"///evil.example":      "/",
+		"https://evil.example": "/",
+	} {
+		response := serve("/auth/login?return_to=" + returnTo)
+		authorize, err := noRedirects.Get(response.Header.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		authorize.Body.Close()
+		callback, _ := url.Parse(authorize.Header.Get("Location"))
+		response = serve(callback.RequestURI(), cookieNamed(response, "penguin_oidc_flow"))
+		if response.Header.Get("Location") != expected {
+			t.Errorf("return_to %s: expected a redirect to %q, got %q", returnTo, expected, response.Header.Get("Location"))
+		}
+	}
+}
+
+func TestOIDCSessionsLargerThanACookie(t *testing.T) {
+	var provider *fakeProvider = newFakeProvider(t)
+	defer provider.server.Close()
+	provider.claims = jwt.MapClaims{"groups": strings.Split(strings.Repeat("group,", 1000), ",")}
+	var store *sessions.MemoryStore = sessions.NewMemoryStore()
+	defer store.Close()
+	serverSide, err := sessions.NewManager(store, sessions.ManagerWithCookieName(oidc.DefaultSessionCookieName), sessions.ManagerWithCookieSecure(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, test := range map[string]struct {
+		option func(*oidc.RelyingParty)
+		status int
+	}{
+		"cookie session":      {oidc.RelyingPartyWithSessionTTL(oidc.DefaultSessionTTL), http.StatusInternalServerError},
+		"server side session": {oidc.RelyingPartyWithSessionManager(serverSide), http.StatusFound},
+	} {
+		rp, err := oidc.NewRelyingParty(provider.server.URL, "dashboard", "http://app.test/auth/callback",
+			oidc.RelyingPartyWithClientSecret("s3cret"), test.option)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rp.Close()
+		var app *router.Router = router.NewRouter()
+		rp.RegisterRoutes(app)
+
+		login := httptest.NewRecorder()
+		app.ServeHTTP(login, httptest.NewRequest(http.MethodGet, "/auth/login", nil))
+		var noRedirects *http.Client = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		authorize, err := noRedirects.Get(login.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		authorize.Body.Close()
+
+		request := httptest.NewRequest(http.MethodGet, authorize.Header.Get("Location"), nil)
+		request.AddCookie(cookieNamed(login.Result(), "penguin_oidc_flow"))
+		recorder := httptest.NewRecorder()
+		app.ServeHTTP(recorder, request)
+		var session *http.Cookie = cookieNamed(recorder.Result(), oidc.DefaultSessionCookieName)
+		if recorder.Code != test.status || (session != nil) != (test.status == http.StatusFound) {
+			t.Fatalf("%s: expected %d, got %d with session cookie %v", name, test.status, recorder.Code, session != nil)
+		}
+	}
+}
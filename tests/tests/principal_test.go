@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/middlewares"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type principalClaims struct {
+	Tenant string   `json:"tenant"`
+	Scope  string   `json:"scope"`
+	Roles  []string `json:"roles"`
+	jwt.RegisteredClaims
+}
+
+func (c *principalClaims) GetRoles() []string {
+	return c.Roles
+}
+
+type customUserKey struct{}
+
+func TestPrincipalWithCustomContextKey(t *testing.T) {
+	var key = newTestKey(t)
+	var jwtAuth *auth.JwtAuth = auth.NewJwtAuth(key, func() *principalClaims { return &principalClaims{} },
+		auth.JwtAuthWithCustomContextKey(customUserKey{}))
+	var token string = signTestToken(t, key, &principalClaims{Tenant: "acme", Scope: "read:orders", Roles: []string{"ops"}, RegisteredClaims: registeredClaims("alice")})
+
+	var handler http.HandlerFunc = middlewares.WithAuthMiddleWare(jwtAuth, middlewares.RequireScopes("read:orders")(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := auth.ClaimsFrom[*principalClaims](r.Context())
+		if !ok || r.Context().Value(customUserKey{}) != claims {
+			t.Error("expected the claims under both keys")
+		}
+		if _, ok := auth.ClaimsFrom[*auth.RBACClaims](r.Context()); ok {
+			t.Error("expected claims of another type not to match")
+		}
+		principal, _ := auth.PrincipalFrom(r.Context())
+		w.Write([]byte(strings.Join([]string{principal.Subject, principal.Tenant, strings.Join(principal.Roles, ","), strings.Join(principal.Scopes, ",")}, "|")))
+	}))
+	request := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "alice|acme|ops|read:orders" {
+		t.Fatalf("expected the principal, got %d %q", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestClaimsFromChainResult(t *testing.T) {
+	var fixture chainFixture = newChainFixture(t)
+	var chain *auth.ChainAuth = auth.NewChainAuth(auth.ChainFirstSuccess, fixture.providers)
+	var handler http.HandlerFunc = middlewares.WithAuthMiddleWare(chain, func(w http.ResponseWriter, r *http.Request) {
+		apiKey, ok := auth.ClaimsFrom[*auth.APIKey](r.Context())
+		result, _ := chain.Result(r.Context())
+		if !ok || result.Provider != "apikey" {
+			t.Error("expected the api key of the chain result")
+			return
+		}
+		w.Write([]byte(apiKey.Subject))
+	})
+	recorder := httptest.NewRecorder()
+	handler(recorder, fixture.request(false, true))
+	if recorder.Body.String() != "reporting" {
+		t.Fatalf("unexpected body %q", recorder.Body.String())
+	}
+}
@@ -0,0 +1,168 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/middlewares"
+	"github.com/angelbarreiros/Penguin/router/rbac"
+)
+
+var testRoles map[string]rbac.Role = map[string]rbac.Role{
+	"viewer": {Permissions: []string{"orders:read"}},
+	"editor": {Inherits: []string{"viewer"}, Permissions: []string{"orders:write", "reports:*:read"}},
+	"admin":  {Inherits: []string{"editor"}, Permissions: []string{"users:*"}},
+	"root":   {Permissions: []string{"*"}},
+}
+
+func TestPolicyInheritanceAndWildcards(t *testing.T) {
+	policy, err := rbac.NewPolicy(testRoles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, check := range []struct {
+		role       string
+		permission string
+		allowed    bool
+	}{
+		{"viewer", "orders:read", true},
+		{"viewer", "orders:write", false},
+		{"admin", "orders:read", true},
+		{"admin", "users:delete", true},
+		{"admin", "users:roles:assign", true},
+		{"admin", "users", false},
+		{"editor", "reports:sales:read", true},
+		{"editor", "reports:sales:write", false},
+		{"editor", "reports:sales:q1:read", false},
+		{"root", "anything:at:all", true},
+		{"unknown", "orders:read", false},
+	} {
+		if policy.Can([]string{check.role}, check.permission) != check.allowed {
+			t.Errorf("%s %s: expected %v", check.role, check.permission, check.allowed)
+		}
+	}
+
+	if !policy.HasRole([]string{"admin"}, "viewer") || policy.HasRole([]string{"viewer"}, "editor") {
+		t.Fatal("unexpected role inheritance")
+	}
+	if !slices.Equal(policy.Permissions("editor"), []string{"orders:read", "orders:write", "reports:*:read"}) {
+		t.Fatalf("unexpected effective permissions %v", policy.Permissions("editor"))
+	}
+
+	_, err = rbac.NewPolicy(map[string]rbac.Role{"a": {Inherits: []string{"b"}}, "b": {Inherits: []string{"a"}}})
+	if err == nil {
+		t.Fatal("expected an inheritance cycle to be rejected")
+	}
+	if _, err := rbac.NewPolicy(map[string]rbac.Role{"a": {Inherits: []string{"missing"}}}); err == nil {
+		t.Fatal("expected an unknown parent to be rejected")
+	}
+	if _, err := rbac.NewPolicy(map[string]rbac.Role{"a": {Permissions: []string{"orders:wr*"}}}); err == nil {
+		t.Fatal("expected a partial wildcard to be rejected")
+	}
+}
+
+func TestAuthorizerReloadsPolicyFile(t *testing.T) {
+	var path string = filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte("roles:\n  support:\n    permissions: [orders:read]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	authorizer, err := rbac.NewAuthorizer(rbac.AuthorizerWithPolicyFile(path, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer authorizer.Close()
+	if !authorizer.Can([]string{"support"}, "orders:read") || authorizer.Can([]string{"support"}, "orders:refund") {
+		t.Fatal("unexpected initial policy")
+	}
+
+	if err := os.WriteFile(path, []byte("roles:\n  support:\n    permissions: [orders:read, orders:refund]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := authorizer.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !authorizer.Can([]string{"support"}, "orders:refund") {
+		t.Fatal("expected the reloaded permission")
+	}
+
+	if err := os.WriteFile(path, []byte("roles:\n  support:\n    inherits: [missing]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := authorizer.Reload(); err == nil {
+		t.Fatal("expected an invalid policy to be rejected")
+	}
+	if !authorizer.Can([]string{"support"}, "orders:refund") {
+		t.Fatal("expected the previous policy to be kept")
+	}
+}
+
+func TestWithPermission(t *testing.T) {
+	authorizer, err := rbac.NewAuthorizer(rbac.AuthorizerWithRoles(testRoles))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key = newTestKey(t)
+	var jwtAuth *auth.RBACJwtAuth = auth.NewJwtAuthWithRbac(key, auth.NewRBACClaims, auth.JwtAuthRbacWithRoleHierarchy(authorizer))
+	var handler http.HandlerFunc = middlewares.WithAuthMiddleWare(jwtAuth,
+		middlewares.WithPermission(authorizer, "orders:write", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+
+	serve := func(roles ...string) int {
+		request := httptest.NewRequest(http.MethodPost, "/orders", nil)
+		request.Header.Set("Authorization", "Bearer "+signTestToken(t, key, &auth.RBACClaims{Roles: roles, RegisteredClaims: registeredClaims("user-1")}))
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		return recorder.Code
+	}
+	if code := serve("admin"); code != http.StatusOK {
+		t.Fatalf("expected the inherited permission, got %d", code)
+	}
+	if code := serve("viewer"); code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", code)
+	}
+
+	recorder := httptest.NewRecorder()
+	middlewares.WithPermission(authorizer, "orders:write", func(w http.ResponseWriter, r *http.Request) {})(recorder, httptest.NewRequest(http.MethodPost, "/orders", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a user, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	middlewares.WithPermission(nil, "orders:write", func(w http.ResponseWriter, r *http.Request) {})(recorder, httptest.NewRequest(http.MethodPost, "/orders", nil))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("expected a nil authorizer to deny the request, got %d", recorder.Code)
+	}
+
+	user, err := jwtAuth.ParseToken(signTestToken(t, key, &auth.RBACClaims{Roles: []string{"admin"}, RegisteredClaims: registeredClaims("user-1")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !jwtAuth.RBAC(user, []string{"editor"}) || jwtAuth.RBAC(user, []string{"root"}) {
+		t.Fatal("expected RBAC to follow the role hierarchy")
+	}
+}
+
+func TestAPIKeyRBACRoleHierarchy(t *testing.T) {
+	authorizer, err := rbac.NewAuthorizer(rbac.AuthorizerWithRoles(testRoles))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var admin *auth.APIKey = &auth.APIKey{Roles: []string{"admin"}}
+	if auth.NewAPIKeyAuth(auth.NewMemoryAPIKeyStore()).RBAC(admin, []string{"editor"}) {
+		t.Fatal("expected no inherited roles without a hierarchy")
+	}
+	var apiKeyAuth *auth.APIKeyAuth = auth.NewAPIKeyAuth(auth.NewMemoryAPIKeyStore(), auth.APIKeyAuthWithRoleHierarchy(authorizer))
+	if !apiKeyAuth.RBAC(admin, []string{"editor"}) {
+		t.Fatal("expected admin to inherit editor")
+	}
+	if apiKeyAuth.RBAC(&auth.APIKey{Roles: []string{"viewer"}}, []string{"editor"}) {
+		t.Fatal("expected viewer not to satisfy editor")
+	}
+}
@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/middlewares"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestAuthMiddlewareRejectsRevokedTokens(t *testing.T) {
+	var key = newTestKey(t)
+	var denylist *auth.MemoryDenylist = auth.NewMemoryDenylist()
+	var jwtAuth *auth.JwtAuth = auth.NewJwtAuth(key, auth.NewPlainClaims, auth.JwtAuthWithRevocationChecker(denylist))
+	var handler http.HandlerFunc = middlewares.WithAuthMiddleWare(jwtAuth, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	first := registeredClaims("user-1")
+	first.ID = "token-1"
+	second := registeredClaims("user-1")
+	second.ID = "token-2"
+	second.IssuedAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	var firstToken string = signTestToken(t, key, first)
+	var secondToken string = signTestToken(t, key, second)
+
+	serve := func(token string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler(recorder, bearerRequest(token))
+		return recorder
+	}
+	if code := serve(firstToken).Code; code != http.StatusOK {
+		t.Fatalf("expected 200 before revocation, got %d", code)
+	}
+
+	if err := denylist.RevokeToken("token-1", first.ExpiresAt.Time); err != nil {
+		t.Fatal(err)
+	}
+	recorder := serve(firstToken)
+	if recorder.Code != http.StatusUnauthorized || !strings.Contains(recorder.Header().Get("WWW-Authenticate"), `error="invalid_token"`) {
+		t.Fatalf("expected 401 invalid_token for a revoked jti, got %d %q", recorder.Code, recorder.Header().Get("WWW-Authenticate"))
+	}
+	if code := serve(secondToken).Code; code != http.StatusOK {
+		t.Fatalf("other tokens must stay valid, got %d", code)
+	}
+
+	if err := denylist.RevokeSubject("user-1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if code := serve(secondToken).Code; code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 after the subject was revoked, got %d", code)
+	}
+	if revoked, _ := denylist.IsRevoked("", "user-1", time.Now().Add(2*time.Second)); revoked {
+		t.Fatal("tokens issued after the subject revocation must be accepted")
+	}
+}
+
+func TestMemoryDenylistAcceptsTokensIssuedInTheRevocationSecond(t *testing.T) {
+	var denylist *auth.MemoryDenylist = auth.NewMemoryDenylist()
+	var now time.Time = time.Now()
+	if err := denylist.RevokeSubject("user-1", now); err != nil {
+		t.Fatal(err)
+	}
+	// iat of a token issued right after the revocation, e.g. after a password change.
+	var issuedAt time.Time = jwt.NewNumericDate(now).Time
+	if revoked, _ := denylist.IsRevoked("", "user-1", issuedAt); revoked {
+		t.Fatal("expected a token issued in the revocation second to be accepted")
+	}
+	if revoked, _ := denylist.IsRevoked("", "user-1", issuedAt.Add(-time.Second)); !revoked {
+		t.Fatal("expected a token issued in the previous second to be revoked")
+	}
+}
+
+func TestMemoryDenylistExpiresEntries(t *testing.T) {
+	var denylist *auth.MemoryDenylist = auth.NewMemoryDenylist(auth.DenylistWithMaxTokenLifetime(100 * time.Millisecond))
+	if err := denylist.RevokeToken("short", time.Now().Add(50*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if err := denylist.RevokeSubject("user-1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, _ := denylist.IsRevoked("short", "", time.Time{}); !revoked {
+		t.Fatal("expected the jti to be revoked")
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	var revocations auth.Revocations = denylist.Revocations()
+	if len(revocations.Tokens) != 0 || len(revocations.Subjects) != 0 {
+		t.Fatalf("expected expired entries to be removed, got %+v", revocations)
+	}
+}
+
+func TestRevocationAdminHandler(t *testing.T) {
+	var denylist *auth.MemoryDenylist = auth.NewMemoryDenylist()
+	var handler http.HandlerFunc = auth.RevocationAdminHandler(denylist)
+
+	request := httptest.NewRequest(http.MethodPost, "/admin/revocations", strings.NewReader(`{"subject":"user-1"}`))
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if revoked, _ := denylist.IsRevoked("", "user-1", time.Now().Add(-time.Minute)); !revoked {
+		t.Fatal("expected the subject to be revoked")
+	}
+
+	request = httptest.NewRequest(http.MethodPost, "/admin/revocations", strings.NewReader(`{}`))
+	recorder = httptest.NewRecorder()
+	handler(recorder, request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without jti or subject, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/admin/revocations", nil))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "user-1") {
+		t.Fatalf("expected the revocations listed, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angelbarreiros/Penguin/router"
+	"github.com/angelbarreiros/Penguin/router/middlewares"
+	"github.com/angelbarreiros/Penguin/router/sessions"
+	"github.com/angelbarreiros/Penguin/scheduler"
+)
+
+func newSessionsApp(t *testing.T, manager *sessions.Manager) func(method string, target string, cookie *http.Cookie) (*http.Response, string) {
+	t.Helper()
+	var app *router.Router = router.NewRouter()
+	app.Use(middlewares.SessionsMiddleware(manager))
+	app.NewRoute(router.Route{Path: "/cart", Method: router.POST, Handler: func(w http.ResponseWriter, r *http.Request) {
+		session, _ := sessions.FromContext(r.Context())
+		session.Set("cart", "book")
+	}})
+	app.NewRoute(router.Route{Path: "/login", Method: router.POST, Handler: func(w http.ResponseWriter, r *http.Request) {
+		if err := manager.Login(r, "alice", "admin"); err != nil {
+			t.Error(err)
+		}
+		session, _ := sessions.FromContext(r.Context())
+		session.AddFlash("Welcome back")
+		w.WriteHeader(http.StatusNoContent)
+	}})
+	app.NewRoute(router.Route{Path: "/me", Method: router.GET, Handler: middlewares.WithAuthMiddleWare(manager, func(w http.ResponseWriter, r *http.Request) {
+		session, _ := sessions.FromContext(r.Context())
+		subject, _ := session.GetSubject()
+		cart, _ := session.Get("cart")
+		w.Write([]byte(subject + ":" + cart.(string) + ":" + strings.Join(session.Flashes(), ",")))
+	})})
+	app.NewRoute(router.Route{Path: "/logout", Method: router.POST, Handler: func(w http.ResponseWriter, r *http.Request) {
+		manager.Logout(r)
+	}})
+
+	return func(method string, target string, cookie *http.Cookie) (*http.Response, string) {
+		request := httptest.NewRequest(method, target, nil)
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		recorder := httptest.NewRecorder()
+		app.ServeHTTP(recorder, request)
+		body, _ := io.ReadAll(recorder.Result().Body)
+		return recorder.Result(), string(body)
+	}
+}
+
+func TestSessionsLoginRenewsID(t *testing.T) {
+	var store *sessions.MemoryStore = sessions.NewMemoryStore()
+	defer store.Close()
+	manager, err := sessions.NewManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var serve = newSessionsApp(t, manager)
+
+	if response, _ := serve(http.MethodGet, "/me", nil); response.StatusCode != http.StatusUnauthorized || cookieNamed(response, sessions.DefaultCookieName) != nil {
+		t.Fatalf("expected 401 and no session for an anonymous visitor, got %d", response.StatusCode)
+	}
+	response, _ := serve(http.MethodPost, "/cart", nil)
+	var anonymous *http.Cookie = cookieNamed(response, sessions.DefaultCookieName)
+	if anonymous == nil {
+		t.Fatal("expected a session cookie")
+	}
+	response, _ = serve(http.MethodPost, "/login", anonymous)
+	var loggedIn *http.Cookie = cookieNamed(response, sessions.DefaultCookieName)
+	if loggedIn == nil || loggedIn.Value == anonymous.Value {
+		t.Fatal("expected the session ID to be renewed on login")
+	}
+	if response, _ := serve(http.MethodGet, "/me", anonymous); response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected the pre-login ID to be invalid, got %d", response.StatusCode)
+	}
+	if _, body := serve(http.MethodGet, "/me", loggedIn); body != "alice:book:Welcome back" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	if _, body := serve(http.MethodGet, "/me", loggedIn); body != "alice:book:" {
+		t.Fatalf("expected the flash to be consumed, got %q", body)
+	}
+
+	response, _ = serve(http.MethodPost, "/logout", loggedIn)
+	if cleared := cookieNamed(response, sessions.DefaultCookieName); cleared == nil || cleared.MaxAge >= 0 {
+		t.Fatal("expected the session cookie to be cleared")
+	}
+	if response, _ := serve(http.MethodGet, "/me", loggedIn); response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected the session to be gone after logout, got %d", response.StatusCode)
+	}
+}
+
+func TestSessionsStoresAndTimeouts(t *testing.T) {
+	fileStore, err := sessions.NewFileStore(t.TempDir(), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileStore.Close()
+	var key []byte = []byte("0123456789abcdef0123456789abcdef")
+
+	for name, store := range map[string]sessions.Store{"file": fileStore, "cookie": nil} {
+		manager, err := sessions.NewManager(store,
+			sessions.ManagerWithSigningKey(key),
+			sessions.ManagerWithEncryptionKey(key),
+			sessions.ManagerWithAbsoluteTimeout(200*time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var serve = newSessionsApp(t, manager)
+
+		response, _ := serve(http.MethodPost, "/cart", nil)
+		response, _ = serve(http.MethodPost, "/login", cookieNamed(response, sessions.DefaultCookieName))
+		var cookie *http.Cookie = cookieNamed(response, sessions.DefaultCookieName)
+		if strings.Contains(cookie.Value, "alice") {
+			t.Fatalf("%s: expected an encrypted cookie", name)
+		}
+		if _, body := serve(http.MethodGet, "/me", cookie); body != "alice:book:Welcome back" {
+			t.Fatalf("%s: unexpected body %q", name, body)
+		}
+		var tampered http.Cookie = *cookie
+		var value []byte = []byte(cookie.Value)
+		value[0] ^= 1
+		tampered.Value = string(value)
+		if response, _ := serve(http.MethodGet, "/me", &tampered); response.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("%s: expected a tampered cookie to be rejected, got %d", name, response.StatusCode)
+		}
+		time.Sleep(250 * time.Millisecond)
+		if response, _ := serve(http.MethodGet, "/me", cookie); response.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("%s: expected the session to expire, got %d", name, response.StatusCode)
+		}
+	}
+}
+
+func TestSessionStoresCloseOnlyTheirOwnJobs(t *testing.T) {
+	var shared *scheduler.Scheduler = scheduler.StartScheduler()
+	fileStore, err := sessions.NewFileStore(t.TempDir(), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var memoryStore *sessions.MemoryStore = sessions.NewMemoryStore()
+	if err := memoryStore.Save("abc", []byte("{}"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	fileStore.Close()
+	memoryStore.Close()
+
+	if !shared.IsRunning() || scheduler.StartScheduler() != shared {
+		t.Fatal("expected closing a store to keep the shared scheduler running")
+	}
+	if _, err := memoryStore.Load("abc"); err != sessions.ErrSessionNotFound {
+		t.Fatalf("expected a closed store to be empty, got %v", err)
+	}
+}
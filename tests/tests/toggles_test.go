@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/angelbarreiros/Penguin/router"
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/middlewares"
+	"github.com/angelbarreiros/Penguin/router/toggles"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestToggleResolveMostSpecificStateWins(t *testing.T) {
+	var registry *toggles.Registry = toggles.NewRegistry()
+	registry.SetGlobal(toggles.State{Mode: toggles.ModeMaintenance}, "ops")
+	registry.SetGroup("/api", toggles.State{Mode: toggles.ModeEnabled}, "ops")
+	registry.SetGroup("/api/admin", toggles.State{Mode: toggles.ModeDisabled}, "ops")
+	registry.SetRoute("/api/admin/health", toggles.State{Mode: toggles.ModeEnabled}, "ops")
+
+	for path, mode := range map[string]toggles.Mode{
+		"/static/app.js":    toggles.ModeMaintenance,
+		"/api/orders":       toggles.ModeEnabled,
+		"/api/admin/users":  toggles.ModeDisabled,
+		"/api/admin/health": toggles.ModeEnabled,
+	} {
+		if state := registry.Resolve(router.RouteInfo{Path: path}); state.Mode != mode {
+			t.Errorf("%s: expected %s, got %s", path, mode, state.Mode)
+		}
+	}
+
+	registry.Clear(toggles.ScopeRoute, "/api/admin/health", "ops")
+	if state := registry.Resolve(router.RouteInfo{Path: "/api/admin/health"}); state.Mode != toggles.ModeDisabled {
+		t.Fatalf("expected a cleared route to inherit its group, got %s", state.Mode)
+	}
+
+	var handler http.HandlerFunc = middlewares.WithRouteToggle(registry, func(w http.ResponseWriter, r *http.Request) {})
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/api/admin/users", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected a disabled route to return 404, got %d", recorder.Code)
+	}
+}
+
+func TestToggleAdminHandlerAuditsThePrincipal(t *testing.T) {
+	var registry *toggles.Registry = toggles.NewRegistry()
+	var handler http.HandlerFunc = registry.AdminHandler()
+	var body string = `{"scope": "group", "target": "/api", "mode": "maintenance", "actor": "someone-else"}`
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodPut, "/admin/toggles", strings.NewReader(body)))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected an anonymous change to be rejected, got %d", recorder.Code)
+	}
+
+	request := httptest.NewRequest(http.MethodPut, "/admin/toggles", strings.NewReader(body))
+	var user *auth.RBACClaims = &auth.RBACClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "alice"}}
+	request = request.WithContext(auth.ContextWithUser(request.Context(), user))
+	recorder = httptest.NewRecorder()
+	handler(recorder, request)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected a JSON success response, got %d %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+
+	entries := registry.AuditRecorder().Entries()
+	if len(entries) != 1 || entries[0].Actor != "alice" {
+		t.Fatalf("expected the change to be audited as alice, got %+v", entries)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/admin/toggles", nil))
+	var response struct {
+		States struct {
+			Groups map[string]toggles.State `json:"groups"`
+		} `json:"states"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.States.Groups["/api"].Mode != toggles.ModeMaintenance {
+		t.Fatalf("expected /api to be in maintenance, got %+v", response.States.Groups)
+	}
+}
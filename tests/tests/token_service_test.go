@@ -0,0 +1,182 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/angelbarreiros/Penguin/router"
+	"github.com/angelbarreiros/Penguin/router/auth"
+	"github.com/angelbarreiros/Penguin/router/tokens"
+)
+
+func newTokenService(t *testing.T) (*tokens.Service, *auth.KeySet) {
+	t.Helper()
+	keySet, err := auth.NewKeySet(newSigningKey(t, "access"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens.NewService(keySet, tokens.NewMemoryStore(), tokens.ServiceWithIssuer("penguin")), keySet
+}
+
+func TestTokenServiceRotation(t *testing.T) {
+	service, keySet := newTokenService(t)
+	first, err := service.Issue(tokens.Grant{Subject: "user-1", Roles: []string{"admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var jwtAuth *auth.RBACJwtAuth = auth.NewJwtAuthWithRbacAndKeys(keySet, auth.NewRBACClaims)
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("Authorization", "Bearer "+first.AccessToken)
+	user, err := jwtAuth.Authenticate(request)
+	if err != nil {
+		t.Fatalf("access token rejected: %v", err)
+	}
+	if !jwtAuth.RBAC(user, []string{"admin"}) {
+		t.Fatal("expected the grant roles in the access token")
+	}
+
+	second, err := service.Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+		t.Fatal("expected a new token pair")
+	}
+	if _, err := service.Refresh(second.RefreshToken); err != nil {
+		t.Fatalf("rotated token rejected: %v", err)
+	}
+	if _, err := service.Refresh("unknown.secret"); !errors.Is(err, tokens.ErrInvalidRefreshToken) {
+		t.Fatalf("expected ErrInvalidRefreshToken, got %v", err)
+	}
+}
+
+func TestTokenServiceReuseRevokesFamily(t *testing.T) {
+	service, _ := newTokenService(t)
+	var revoked []string
+	service.OnRevoke(func(token tokens.RefreshToken) {
+		revoked = append(revoked, token.Grant.Subject)
+	})
+
+	first, err := service.Issue(tokens.Grant{Subject: "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := service.Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.Refresh(first.RefreshToken); !errors.Is(err, tokens.ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, err := service.Refresh(second.RefreshToken); !errors.Is(err, tokens.ErrRefreshTokenRevoked) {
+		t.Fatalf("expected the family to be revoked, got %v", err)
+	}
+	if len(revoked) != 1 || revoked[0] != "user-1" {
+		t.Fatalf("expected one revoke notification, got %v", revoked)
+	}
+
+	other, err := service.Issue(tokens.Grant{Subject: "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Refresh(other.RefreshToken); err != nil {
+		t.Fatalf("other families must not be affected: %v", err)
+	}
+
+	if err := service.RevokeSubject("user-1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 2 {
+		t.Fatalf("expected RevokeSubject to notify the only family left, got %v", revoked)
+	}
+}
+
+func TestTokenServiceConcurrentRefresh(t *testing.T) {
+	service, _ := newTokenService(t)
+	pair, err := service.Issue(tokens.Grant{Subject: "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var succeeded int
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := service.Refresh(pair.RefreshToken); err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if succeeded != 1 {
+		t.Fatalf("expected exactly one refresh to succeed, got %d", succeeded)
+	}
+}
+
+func TestTokenHandlers(t *testing.T) {
+	service, _ := newTokenService(t)
+	var r *router.Router = router.NewRouter()
+	service.RegisterRoutes(r)
+
+	pair, err := service.Issue(tokens.Grant{Subject: "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {pair.RefreshToken}}
+	request := httptest.NewRequest(http.MethodPost, tokens.RefreshPath, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder.Header().Get("Cache-Control") != "no-store" {
+		t.Fatal("expected Cache-Control: no-store")
+	}
+	var refreshed tokens.TokenPair
+	if err := json.Unmarshal(recorder.Body.Bytes(), &refreshed); err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.TokenType != "Bearer" || refreshed.AccessToken == "" || refreshed.RefreshToken == "" {
+		t.Fatalf("unexpected token response %+v", refreshed)
+	}
+
+	request = httptest.NewRequest(http.MethodPost, tokens.RefreshPath, strings.NewReader(`{"refresh_token":"`+pair.RefreshToken+`"}`))
+	request.Header.Set("Content-Type", "application/json")
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	var body map[string]string
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	if recorder.Code != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Fatalf("expected invalid_grant for a reused token, got %d %v", recorder.Code, body)
+	}
+
+	request = httptest.NewRequest(http.MethodPost, tokens.RevokePath, strings.NewReader("token=unknown.token"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200 for an unknown token, got %d", recorder.Code)
+	}
+
+	service.RegisterJWKSRoute(r)
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, auth.JWKSPath, nil))
+	if _, err := auth.ParseJWKS(recorder.Body.Bytes()); recorder.Code != http.StatusOK || err != nil {
+		t.Fatalf("expected the service keys at %s, got %d (%v)", auth.JWKSPath, recorder.Code, err)
+	}
+}